@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/nabilulilalbab/nadia/docs" // swagger docs
@@ -9,7 +12,11 @@ import (
 	"github.com/nabilulilalbab/nadia/internal/database"
 	"github.com/nabilulilalbab/nadia/internal/handlers"
 	"github.com/nabilulilalbab/nadia/internal/middleware"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/monitoring"
+	"github.com/nabilulilalbab/nadia/internal/nadia"
 	"github.com/nabilulilalbab/nadia/internal/services"
+	"github.com/nabilulilalbab/nadia/internal/tracing"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
@@ -44,6 +51,10 @@ func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(cfg, os.Args[2:]))
+	}
+
 	// Set Gin mode based on environment
 	if cfg.IsProduction() {
 		gin.SetMode(gin.ReleaseMode)
@@ -52,31 +63,110 @@ func main() {
 	// Configure Swagger host dynamically
 	setupSwaggerHost(cfg)
 
+	// Initialize tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracingExporter, cfg.ServiceName)
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize database
-	db, err := database.InitDatabase(cfg.DBPath)
+	store, err := database.InitDatabase(cfg.DBDriver, cfg.DatabaseDSN())
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
-	defer db.Close()
+	defer store.Close()
 
 	// Initialize services
-	tokenManager := services.NewTokenManager(cfg)
-	nadiaService := services.NewNadiaService(cfg, tokenManager)
-	transactionService, err := services.NewTransactionService(db)
+	upstreamTransport := nadia.NewTransport()
+	tokenManager := nadia.NewTokenManager(cfg, upstreamTransport)
+	nadiaClient := nadia.NewClient(cfg, tokenManager, upstreamTransport)
+	var otpStore services.OTPSessionStore
+	switch cfg.OTPSessionStore {
+	case "memory":
+		otpStore = services.NewMemoryOTPSessionStore()
+	case "database", "sqlite":
+		otpStore = services.NewDatabaseOTPSessionStore(store)
+	default:
+		log.Fatalf("Unknown OTP_SESSION_STORE %q (expected database or memory)", cfg.OTPSessionStore)
+	}
+	otpJanitor := services.NewOTPSessionJanitor(otpStore, time.Minute)
+	otpJanitor.Start()
+	defer otpJanitor.Stop()
+
+	transactionService, err := services.NewTransactionService(store, otpStore)
 	if err != nil {
 		log.Fatalf("Failed to initialize transaction service: %v", err)
 	}
+	walletService := services.NewWalletService(store)
+	transactionService.AddStatusListener(walletService.OnTransactionStatus)
+	walletService.Start()
+	defer walletService.Stop()
+	webhookService := services.NewWebhookService(store, cfg.WebhookTimeout, cfg.WebhookMaxAttempts, cfg.WebhookAllowPrivate)
+	transactionService.AddStatusListener(webhookService.OnTransactionStatus)
+	webhookService.Start()
+	defer webhookService.Stop()
+	eventBroker := services.NewEventBroker(cfg.EventsMetricsInterval)
+	transactionService.AddStatusListener(eventBroker.OnTransactionStatus)
+	transactionService.AddStatusListener(monitoring.ObserveTransactionStatus)
+	eventBroker.Start()
+	defer eventBroker.Stop()
+
+	pricingService, err := services.NewPricingService(store)
+	if err != nil {
+		log.Fatalf("Failed to initialize pricing service: %v", err)
+	}
+	catalogService := services.NewCatalogService(nadiaClient, cfg.CatalogRefreshInterval)
+	catalogService.Start()
+	defer catalogService.Stop()
+
+	reconciler := services.NewReconciler(nadiaClient, transactionService, cfg.ReconcileInterval, cfg.ReconcileMaxAge)
+	reconciler.Start()
+	defer reconciler.Stop()
+
+	if cfg.JWTSecret == config.DefaultJWTSecret {
+		log.Fatal("JWT_SECRET is not set or is the public default, refusing to sign tokens with it: set a random JWT_SECRET")
+	}
+
+	vaultKey := cfg.TokenVaultKey
+	if vaultKey == "" {
+		log.Println("WARNING: TOKEN_VAULT_KEY is not set, deriving the token vault key from JWT_SECRET")
+		vaultKey = cfg.JWTSecret
+	}
+	tokenVault, err := services.NewTokenVault(store, vaultKey, cfg.XLTokenTTL)
+	if err != nil {
+		log.Fatalf("Failed to initialize token vault: %v", err)
+	}
+
+	purchaseValidator := services.NewPurchaseValidator(nadiaClient, transactionService)
+	quoteService := services.NewQuoteService(store, catalogService, pricingService, cfg.QuoteTTL)
+	resellerService := services.NewResellerService(store, pricingService)
+	depositService := services.NewDepositService(store, walletService, resellerService)
+
+	authService, err := services.NewAuthService(store, cfg.JWTSecret, cfg.AdminAPIKey, resellerService, cfg.JWTAccessTTL, cfg.JWTRefreshTTL)
+	if err != nil {
+		log.Fatal("Failed to initialize auth service:", err)
+	}
 
 	// Initialize handlers
-	httpHandler := handlers.NewHTTPHandler(nadiaService, transactionService)
+	httpHandler := handlers.NewHTTPHandler(nadiaClient, transactionService, catalogService, pricingService, purchaseValidator, quoteService, tokenVault, resellerService, authService, walletService, depositService, webhookService, eventBroker)
 
 	// Initialize Gin router
-	r := gin.Default()
+	// gin.Default without its logger, which would write ?access_token= to the log.
+	r := gin.New()
+	r.Use(middleware.RequestLogger(), gin.Recovery())
 
 	// Setup middleware
+	r.Use(tracing.Middleware())
 	r.Use(middleware.CORSMiddleware())
 	r.Use(middleware.MonitoringMiddleware())
 
+	// Prometheus metrics
+	if cfg.MetricsToken == "" && cfg.IsProduction() {
+		log.Println("WARNING: METRICS_TOKEN is not set, /metrics is public")
+	}
+	r.GET("/metrics", middleware.MetricsAuth(cfg.MetricsToken), gin.WrapH(monitoring.MetricsHandler()))
+
 	// API Routes
 	api := r.Group("/api")
 	{
@@ -85,10 +175,11 @@ func main() {
 
 		// Authentication (public)
 		api.POST("/auth/login", httpHandler.AuthLogin)
+		api.POST("/auth/refresh", httpHandler.RefreshAuthToken)
 
-		// Monitoring endpoints (protected)
+		// Monitoring endpoints (protected, admin only)
 		monitoring := api.Group("/monitoring")
-		monitoring.Use(middleware.AuthMiddleware(cfg.AdminAPIKey))
+		monitoring.Use(middleware.AuthMiddleware(authService), middleware.RequireAdmin())
 		{
 			monitoring.GET("/metrics", httpHandler.GetSystemMetrics)
 			monitoring.GET("/metrics/realtime", httpHandler.GetRealtimeMetrics)
@@ -98,71 +189,139 @@ func main() {
 			monitoring.GET("/uptime", httpHandler.GetUptimeStatus)
 		}
 
-		// Main API endpoints (protected)
+		// Main API endpoints (protected). Reseller API keys are limited to the
+		// routes of their scopes; everything else requires the admin key.
+		admin := middleware.RequireAdmin()
+		catalogScope := middleware.RequireScope(models.ScopeCatalog)
+		purchaseScope := middleware.RequireScope(models.ScopePurchase)
+		cardScope := middleware.RequireScope(models.ScopeCard)
+		reportsScope := middleware.RequireScope(models.ScopeReports)
+		loginScope := middleware.RequireScope(models.ScopePurchase, models.ScopeCard)
+
 		protected := api.Group("/")
-		protected.Use(middleware.AuthMiddleware(cfg.AdminAPIKey))
+		protected.Use(middleware.AuthMiddleware(authService))
 		{
+			protected.GET("/auth/me", httpHandler.GetCurrentPrincipal)
+			protected.POST("/auth/logout", httpHandler.Logout)
+			protected.POST("/auth/revoke", admin, httpHandler.RevokeTokens)
+
 			// Packages
-			protected.GET("/packages", httpHandler.GetAllPackages)
-			protected.POST("/packages/search", httpHandler.SearchPackages)
-			protected.GET("/packages/stock", httpHandler.GetPackageStock)
-			protected.POST("/packages/stock/check", httpHandler.CheckSpecificPackageStock)
+			protected.GET("/packages", catalogScope, httpHandler.GetAllPackages)
+			protected.POST("/packages/search", catalogScope, httpHandler.SearchPackages)
+			protected.GET("/packages/stock", catalogScope, httpHandler.GetPackageStock)
+			protected.POST("/packages/stock/check", catalogScope, httpHandler.CheckSpecificPackageStock)
+
+			// Catalog
+			protected.GET("/catalog/status", catalogScope, httpHandler.GetCatalogStatus)
+			protected.POST("/catalog/refresh", admin, httpHandler.RefreshCatalog)
+
+			// Pricing
+			protected.GET("/pricing/tiers", admin, httpHandler.GetPriceTiers)
+			protected.POST("/pricing/tiers", admin, httpHandler.SavePriceTier)
+			protected.DELETE("/pricing/tiers/:name", admin, httpHandler.DeletePriceTier)
+			protected.GET("/pricing/rules", admin, httpHandler.GetPriceRules)
+			protected.POST("/pricing/rules", admin, httpHandler.SavePriceRule)
+			protected.DELETE("/pricing/rules/:id", admin, httpHandler.DeletePriceRule)
+			protected.GET("/pricing/preview", admin, httpHandler.GetPriceQuote)
+
+			// Resellers
+			protected.GET("/resellers", admin, httpHandler.GetResellers)
+			protected.POST("/resellers", admin, httpHandler.CreateReseller)
+			protected.PUT("/resellers/:id", admin, httpHandler.UpdateReseller)
+			protected.POST("/resellers/:id/rotate-key", admin, httpHandler.RotateResellerKey)
+			protected.GET("/resellers/:id/wallet", admin, httpHandler.GetResellerWallet)
+			protected.GET("/resellers/:id/wallet/ledger", admin, httpHandler.GetResellerLedger)
+			protected.POST("/resellers/:id/wallet/deposit", admin, httpHandler.DepositResellerWallet)
+			protected.POST("/resellers/:id/wallet/adjust", admin, httpHandler.AdjustResellerWallet)
+
+			// Deposits (bank transfer top-ups requested by resellers)
+			protected.GET("/deposits", admin, httpHandler.GetDeposits)
+			protected.GET("/deposits/invoices", admin, httpHandler.GetDepositInvoices)
+			protected.POST("/deposits/:id/approve", admin, httpHandler.ApproveDeposit)
+			protected.POST("/deposits/:id/reject", admin, httpHandler.RejectDeposit)
+
+			// Webhooks (transaction events for the caller's own purchases)
+			protected.GET("/webhooks", purchaseScope, httpHandler.GetWebhooks)
+			protected.POST("/webhooks", purchaseScope, httpHandler.CreateWebhook)
+			protected.PUT("/webhooks/:id", purchaseScope, httpHandler.UpdateWebhook)
+			protected.DELETE("/webhooks/:id", purchaseScope, httpHandler.DeleteWebhook)
+			protected.GET("/webhooks/:id/deliveries", purchaseScope, httpHandler.GetWebhookDeliveries)
+			protected.GET("/webhooks/deliveries/:delivery_id", purchaseScope, httpHandler.GetWebhookDelivery)
+			protected.POST("/webhooks/deliveries/:delivery_id/redeliver", purchaseScope, httpHandler.RedeliverWebhook)
 
 			// OTP
-			protected.POST("/otp/request", httpHandler.RequestOTP)
-			protected.POST("/otp/verify", httpHandler.VerifyOTP)
+			protected.POST("/otp/request", loginScope, httpHandler.RequestOTP)
+			protected.POST("/otp/verify", loginScope, httpHandler.VerifyOTP)
+			protected.DELETE("/sessions/:phone", loginScope, httpHandler.RevokeXLSession)
 
 			// Purchase
-			protected.POST("/purchase", httpHandler.PurchasePackage)
+			protected.POST("/purchase", purchaseScope, httpHandler.PurchasePackage)
+			protected.POST("/quotes", purchaseScope, httpHandler.CreateQuote)
 
 			// Card Management
-			protected.POST("/card/status", httpHandler.CheckCardStatus)
-			protected.POST("/card/packages", httpHandler.CheckActivePackages)
+			protected.POST("/card/status", cardScope, httpHandler.CheckCardStatus)
+			protected.POST("/card/packages", cardScope, httpHandler.CheckActivePackages)
 
 			// Wallet
-			protected.GET("/balance", httpHandler.GetBalance)
-			protected.GET("payment-methods", httpHandler.GetPaymentMethods)
+			protected.GET("/balance", admin, httpHandler.GetBalance)
+			protected.GET("payment-methods", catalogScope, httpHandler.GetPaymentMethods)
 
 			// Transaction
-			protected.POST("/transaction/check", httpHandler.CheckTransaction)
-			protected.GET("/transactions", httpHandler.GetTransactions)
-
-			// Invoice
-			protected.GET("/invoices", httpHandler.GetInvoices)
-			protected.GET("/invoices/:id", httpHandler.GetInvoiceDetail)
-			protected.GET("/invoice/stats", httpHandler.GetInvoiceStatsHandler)
+			protected.POST("/transaction/check", admin, httpHandler.CheckTransaction)
+			protected.GET("/transactions", reportsScope, httpHandler.GetTransactions)
+			protected.GET("/transactions/:id", reportsScope, httpHandler.GetTransactionDetail)
+			protected.GET("/transactions/:id/events", reportsScope, httpHandler.GetTransactionEvents)
+			protected.POST("/transactions/:id/resolve", admin, httpHandler.ResolveTransaction)
+			protected.GET("/transactions/:id/qris.png", purchaseScope, httpHandler.GetTransactionQris)
+
+			// Invoice (the invoices of our upstream account are admin only)
+			protected.GET("/invoices", admin, httpHandler.GetInvoices)
+			protected.GET("/invoices/:id", admin, httpHandler.GetInvoiceDetail)
+			protected.GET("/invoice/stats", reportsScope, httpHandler.GetInvoiceStatsHandler)
 
 			// Analytics
-			protected.GET("/stats/daily", httpHandler.GetDailyStats)
+			protected.GET("/stats/daily", reportsScope, httpHandler.GetDailyStats)
+
+			// Dashboard (system-wide stats and recent transactions of every reseller)
+			protected.GET("/dashboard", admin, httpHandler.GetDashboardData)
 
 			// Export
-			protected.GET("/export/transactions", httpHandler.ExportTransactions)
-			protected.GET("/export/invoices", httpHandler.ExportInvoices)
+			protected.GET("/export/transactions", reportsScope, httpHandler.ExportTransactions)
+			protected.GET("/export/invoices", reportsScope, httpHandler.ExportInvoices)
 		}
 
-		// User endpoints (API Key or JWT protected, for end users with manipulated prices)
+		// Live event stream (SSE). EventSource clients may pass their JWT as ?access_token=.
+		events := api.Group("/events")
+		events.Use(middleware.AccessTokenFromQuery(), middleware.AuthMiddleware(authService), reportsScope)
+		{
+			events.GET("/stream", httpHandler.StreamEvents)
+		}
+
+		// User endpoints (API Key or JWT protected, for end users priced with the "user" tier)
 		userGroup := api.Group("/user")
-		userGroup.Use(middleware.HybridAuthMiddleware(cfg.AdminAPIKey, cfg.JWTSecret))
+		userGroup.Use(middleware.HybridAuthMiddleware(authService), catalogScope)
 		{
-			// Products (with manipulated prices +1500)
+			// Products (priced with the "user" tier)
 			userGroup.GET("/products", httpHandler.GetAllProducts)
 			userGroup.POST("/products/search", httpHandler.SearchProducts)
 			userGroup.GET("/products/stock", httpHandler.GetProductStock)
 		}
 
-		// Reseller endpoints (API Key or JWT protected, for resellers with manipulated prices)
+		// Reseller endpoints (admin or reseller API key, priced with the reseller's tier)
 		resellerGroup := api.Group("/reseller")
-		resellerGroup.Use(middleware.HybridAuthMiddleware(cfg.AdminAPIKey, cfg.JWTSecret))
+		resellerGroup.Use(middleware.AuthMiddleware(authService))
 		{
-			// Products (with manipulated prices +500)
-			resellerGroup.GET("/products", httpHandler.GetAllResellerProducts)
-			resellerGroup.POST("/products/search", httpHandler.SearchResellerProducts)
-			resellerGroup.GET("/products/stock", httpHandler.GetResellerProductStock)
+			// Products (priced with the tier of the calling reseller)
+			resellerGroup.GET("/products", catalogScope, httpHandler.GetAllResellerProducts)
+			resellerGroup.POST("/products/search", catalogScope, httpHandler.SearchResellerProducts)
+			resellerGroup.GET("/products/stock", catalogScope, httpHandler.GetResellerProductStock)
+
+			// Wallet and deposits of the calling reseller
+			ownAccount := middleware.RequireReseller()
+			resellerGroup.GET("/balance", ownAccount, httpHandler.GetMyBalance)
+			resellerGroup.GET("/deposits", ownAccount, httpHandler.GetMyDeposits)
+			resellerGroup.POST("/deposits", ownAccount, httpHandler.CreateDeposit)
 		}
-
-		// Dashboard & other data endpoints (publicly accessible data, but might need auth in real life)
-		api.GET("/dashboard", httpHandler.GetDashboardData)
-		api.GET("/transactions/:id", httpHandler.GetTransactionDetail)
 	}
 
 	// Serve Static HTML files & Swagger
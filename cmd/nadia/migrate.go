@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/nabilulilalbab/nadia/internal/config"
+	"github.com/nabilulilalbab/nadia/internal/database"
+)
+
+const migrateUsage = `Usage: nadia migrate <command>
+
+Commands:
+  status  list the schema migrations and whether they are applied
+  up      apply all pending migrations`
+
+// runMigrate implements the "migrate" subcommand and returns the exit code.
+func runMigrate(cfg *config.Config, args []string) int {
+	if len(args) != 1 || (args[0] != "status" && args[0] != "up") {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	store, err := database.OpenDatabase(cfg.DBDriver, cfg.DatabaseDSN())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	if args[0] == "up" {
+		applied, err := store.Migrate(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+	}
+
+	states, err := store.MigrationStatus(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if store.Driver() == database.DriverSQLite {
+		fmt.Printf("Database: %s\n\n", cfg.DBPath)
+	} else {
+		fmt.Printf("Database: %s\n\n", store.Driver())
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	exitCode := 0
+	for _, s := range states {
+		appliedAt := "-"
+		if s.AppliedAt != nil {
+			appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, s.Status, appliedAt)
+		if s.Status == "modified" {
+			exitCode = 1
+		}
+	}
+	w.Flush()
+	return exitCode
+}
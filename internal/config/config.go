@@ -3,9 +3,14 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// DefaultJWTSecret is the JWT_SECRET used when none is set. It is public, so
+// the server refuses to start with it.
+const DefaultJWTSecret = "nadia-jwt-secret-key-2024"
+
 // Config stores all configuration for the application.
 // Values are read from environment variables with fallback to constants.
 type Config struct {
@@ -17,10 +22,48 @@ type Config struct {
 	AdminAPIKey   string
 	JWTSecret     string
 	ServerAddress string
+	DBDriver      string
 	DBPath        string
+	DatabaseURL   string
 	TokenExpiry   time.Duration
 	Environment   string
 	SwaggerHost   string
+
+	CatalogRefreshInterval time.Duration
+	ReconcileInterval      time.Duration
+	ReconcileMaxAge        time.Duration
+	QuoteTTL               time.Duration
+	OTPSessionStore        string
+	TokenVaultKey          string
+	XLTokenTTL             time.Duration
+	JWTAccessTTL           time.Duration
+	JWTRefreshTTL          time.Duration
+	WebhookTimeout         time.Duration
+	WebhookMaxAttempts     int
+	WebhookAllowPrivate    bool
+	EventsMetricsInterval  time.Duration
+	MetricsToken           string
+	TracingExporter        string
+	ServiceName            string
+
+	// UpstreamTimeout is the deadline of a request to the Nadia API, unless
+	// UpstreamEndpointTimeouts has one for its endpoint file name, e.g.
+	// "beli-paket-otp.json".
+	UpstreamTimeout          time.Duration
+	UpstreamEndpointTimeouts map[string]time.Duration
+
+	// The circuit breaker of a group of Nadia API endpoints opens after
+	// BreakerFailureThreshold consecutive failures and rejects requests for
+	// BreakerOpenDuration; BreakerHalfOpenRequests trial requests then decide
+	// whether it closes again.
+	BreakerFailureThreshold int
+	BreakerOpenDuration     time.Duration
+	BreakerHalfOpenRequests int
+
+	// UpstreamMaxConcurrency caps the requests in flight to one Nadia API
+	// endpoint, unless UpstreamEndpointConcurrency has a cap for its file name.
+	UpstreamMaxConcurrency      int
+	UpstreamEndpointConcurrency map[string]int
 }
 
 // LoadConfig loads configuration from environment variables with fallback to constants.
@@ -32,12 +75,40 @@ func LoadConfig() *Config {
 		Username:      getEnv("NADIA_USERNAME", "kortkeks"),
 		Password:      getEnv("NADIA_PASSWORD", "nabilalbab78"),
 		AdminAPIKey:   getEnv("ADMIN_API_KEY", "nadia-admin-2024-secure-key"),
-		JWTSecret:     getEnv("JWT_SECRET", "nadia-jwt-secret-key-2024"),
+		JWTSecret:     getEnv("JWT_SECRET", DefaultJWTSecret),
 		ServerAddress: getEnv("SERVER_ADDRESS", ":8080"),
+		DBDriver:      getEnv("DB_DRIVER", "sqlite"),
 		DBPath:        getEnv("DB_PATH", "./nadia_transactions.db"),
+		DatabaseURL:   getEnv("DATABASE_URL", ""),
 		Environment:   getEnv("ENVIRONMENT", "development"),
 		SwaggerHost:   getEnv("SWAGGER_HOST", ""),
 		TokenExpiry:   8 * time.Hour,
+
+		CatalogRefreshInterval: 5 * time.Minute,
+		ReconcileInterval:      30 * time.Second,
+		ReconcileMaxAge:        48 * time.Hour,
+		QuoteTTL:               5 * time.Minute,
+		OTPSessionStore:        getEnv("OTP_SESSION_STORE", "database"),
+		TokenVaultKey:          getEnv("TOKEN_VAULT_KEY", ""),
+		XLTokenTTL:             24 * time.Hour,
+		JWTAccessTTL:           time.Hour,
+		JWTRefreshTTL:          30 * 24 * time.Hour,
+		WebhookTimeout:         10 * time.Second,
+		WebhookMaxAttempts:     8,
+		EventsMetricsInterval:  5 * time.Second,
+		MetricsToken:           getEnv("METRICS_TOKEN", ""),
+		TracingExporter:        getEnv("TRACING_EXPORTER", "none"),
+		ServiceName:            getEnv("OTEL_SERVICE_NAME", "nadia"),
+
+		UpstreamTimeout:          30 * time.Second,
+		UpstreamEndpointTimeouts: map[string]time.Duration{},
+
+		BreakerFailureThreshold: 5,
+		BreakerOpenDuration:     30 * time.Second,
+		BreakerHalfOpenRequests: 1,
+
+		UpstreamMaxConcurrency:      20,
+		UpstreamEndpointConcurrency: map[string]int{},
 	}
 
 	// Handle PORT environment variable (common in cloud hosting)
@@ -53,6 +124,125 @@ func LoadConfig() *Config {
 		}
 	}
 
+	// Set catalog refresh interval from environment if provided
+	if intervalStr := os.Getenv("CATALOG_REFRESH_SECONDS"); intervalStr != "" {
+		if seconds, err := strconv.Atoi(intervalStr); err == nil && seconds > 0 {
+			config.CatalogRefreshInterval = time.Duration(seconds) * time.Second
+		}
+	}
+
+	// Set reconciliation polling interval and cut-off from environment if provided
+	if intervalStr := os.Getenv("RECONCILE_INTERVAL_SECONDS"); intervalStr != "" {
+		if seconds, err := strconv.Atoi(intervalStr); err == nil && seconds > 0 {
+			config.ReconcileInterval = time.Duration(seconds) * time.Second
+		}
+	}
+	if maxAgeStr := os.Getenv("RECONCILE_MAX_AGE_HOURS"); maxAgeStr != "" {
+		if hours, err := strconv.Atoi(maxAgeStr); err == nil && hours > 0 {
+			config.ReconcileMaxAge = time.Duration(hours) * time.Hour
+		}
+	}
+
+	// Set how long a purchase quote locks its price from environment if provided
+	if ttlStr := os.Getenv("QUOTE_TTL_SECONDS"); ttlStr != "" {
+		if seconds, err := strconv.Atoi(ttlStr); err == nil && seconds > 0 {
+			config.QuoteTTL = time.Duration(seconds) * time.Second
+		}
+	}
+
+	// Set how long a stored XL access token is used from environment if provided
+	if ttlStr := os.Getenv("XL_TOKEN_TTL_HOURS"); ttlStr != "" {
+		if hours, err := strconv.Atoi(ttlStr); err == nil && hours > 0 {
+			config.XLTokenTTL = time.Duration(hours) * time.Hour
+		}
+	}
+
+	// Set JWT access and refresh token lifetimes from environment if provided
+	if ttlStr := os.Getenv("JWT_ACCESS_TTL_MINUTES"); ttlStr != "" {
+		if minutes, err := strconv.Atoi(ttlStr); err == nil && minutes > 0 {
+			config.JWTAccessTTL = time.Duration(minutes) * time.Minute
+		}
+	}
+	if ttlStr := os.Getenv("JWT_REFRESH_TTL_HOURS"); ttlStr != "" {
+		if hours, err := strconv.Atoi(ttlStr); err == nil && hours > 0 {
+			config.JWTRefreshTTL = time.Duration(hours) * time.Hour
+		}
+	}
+
+	// Set webhook request timeout and retry limit from environment if provided
+	if timeoutStr := os.Getenv("WEBHOOK_TIMEOUT_SECONDS"); timeoutStr != "" {
+		if seconds, err := strconv.Atoi(timeoutStr); err == nil && seconds > 0 {
+			config.WebhookTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+	if attemptsStr := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); attemptsStr != "" {
+		if attempts, err := strconv.Atoi(attemptsStr); err == nil && attempts > 0 {
+			config.WebhookMaxAttempts = attempts
+		}
+	}
+	// Allow webhook endpoints on loopback and private networks, for local development
+	config.WebhookAllowPrivate = os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true"
+
+	// Set how often /api/events/stream sends a metrics snapshot from environment if provided
+	if intervalStr := os.Getenv("EVENTS_METRICS_INTERVAL_SECONDS"); intervalStr != "" {
+		if seconds, err := strconv.Atoi(intervalStr); err == nil && seconds > 0 {
+			config.EventsMetricsInterval = time.Duration(seconds) * time.Second
+		}
+	}
+
+	// Set Nadia API request timeouts from environment if provided
+	if timeoutStr := os.Getenv("NADIA_TIMEOUT_SECONDS"); timeoutStr != "" {
+		if seconds, err := strconv.Atoi(timeoutStr); err == nil && seconds > 0 {
+			config.UpstreamTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+	// NADIA_ENDPOINT_TIMEOUTS is a comma-separated list of endpoint=seconds,
+	// e.g. "beli-paket-otp.json=60,check-stock-package.json=5"
+	for _, entry := range strings.Split(os.Getenv("NADIA_ENDPOINT_TIMEOUTS"), ",") {
+		endpoint, secondsStr, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		if seconds, err := strconv.Atoi(strings.TrimSpace(secondsStr)); err == nil && seconds > 0 {
+			config.UpstreamEndpointTimeouts[strings.TrimSpace(endpoint)] = time.Duration(seconds) * time.Second
+		}
+	}
+
+	// Set Nadia API circuit breaker thresholds from environment if provided
+	if failuresStr := os.Getenv("NADIA_BREAKER_FAILURES"); failuresStr != "" {
+		if failures, err := strconv.Atoi(failuresStr); err == nil && failures > 0 {
+			config.BreakerFailureThreshold = failures
+		}
+	}
+	if openStr := os.Getenv("NADIA_BREAKER_OPEN_SECONDS"); openStr != "" {
+		if seconds, err := strconv.Atoi(openStr); err == nil && seconds > 0 {
+			config.BreakerOpenDuration = time.Duration(seconds) * time.Second
+		}
+	}
+	if trialsStr := os.Getenv("NADIA_BREAKER_HALF_OPEN_REQUESTS"); trialsStr != "" {
+		if trials, err := strconv.Atoi(trialsStr); err == nil && trials > 0 {
+			config.BreakerHalfOpenRequests = trials
+		}
+	}
+
+	// Set Nadia API concurrency caps from environment if provided
+	if maxStr := os.Getenv("NADIA_MAX_CONCURRENCY"); maxStr != "" {
+		if max, err := strconv.Atoi(maxStr); err == nil && max > 0 {
+			config.UpstreamMaxConcurrency = max
+		}
+	}
+	// NADIA_ENDPOINT_CONCURRENCY is a comma-separated list of endpoint=requests,
+	// e.g. "beli-paket-otp.json=50,check-stock-package.json=5"
+	for _, entry := range strings.Split(os.Getenv("NADIA_ENDPOINT_CONCURRENCY"), ",") {
+		endpoint, maxStr, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		if max, err := strconv.Atoi(strings.TrimSpace(maxStr)); err == nil && max > 0 {
+			config.UpstreamEndpointConcurrency[strings.TrimSpace(endpoint)] = max
+		}
+	}
+
 	return config
 }
 
@@ -64,6 +254,15 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// DatabaseDSN returns the data source of DB_DRIVER: DATABASE_URL for
+// postgres, DB_PATH for sqlite.
+func (c *Config) DatabaseDSN() string {
+	if c.DBDriver == "postgres" || c.DBDriver == "postgresql" {
+		return c.DatabaseURL
+	}
+	return c.DBPath
+}
+
 // IsProduction returns true if running in production environment
 func (c *Config) IsProduction() bool {
 	return c.Environment == "production"
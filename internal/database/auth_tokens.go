@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// SaveRefreshToken inserts a refresh token. Only the hash of the token is stored.
+func (s *sqlStore) SaveRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	_, err := s.exec(ctx, `INSERT INTO refresh_tokens
+		(id, token_hash, subject, role, reseller_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.TokenHash, t.Subject, t.Role, t.ResellerID, t.CreatedAt, t.ExpiresAt)
+	return err
+}
+
+// GetRefreshToken returns a refresh token by hash, or sql.ErrNoRows.
+func (s *sqlStore) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	t := &models.RefreshToken{}
+	var resellerID sql.NullInt64
+	var revokedAt, rotatedAt sql.NullTime
+
+	err := s.queryRow(ctx, `SELECT id, token_hash, subject, role, reseller_id, created_at, expires_at, revoked_at, rotated_at
+		FROM refresh_tokens WHERE token_hash = ?`, tokenHash).Scan(
+		&t.ID, &t.TokenHash, &t.Subject, &t.Role, &resellerID, &t.CreatedAt, &t.ExpiresAt, &revokedAt, &rotatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	t.ResellerID = resellerID.Int64
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	if rotatedAt.Valid {
+		t.RotatedAt = &rotatedAt.Time
+	}
+	return t, nil
+}
+
+// RevokeRefreshToken marks an unrevoked refresh token as revoked. It returns
+// sql.ErrNoRows if the token does not exist or was already revoked.
+func (s *sqlStore) RevokeRefreshToken(ctx context.Context, id string, revokedAt time.Time) error {
+	res, err := s.exec(ctx, `UPDATE refresh_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, revokedAt, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RotateRefreshToken revokes an unrevoked refresh token that was exchanged
+// for a new one. It returns sql.ErrNoRows if the token does not exist or was
+// already revoked.
+func (s *sqlStore) RotateRefreshToken(ctx context.Context, id string, rotatedAt time.Time) error {
+	res, err := s.exec(ctx, `UPDATE refresh_tokens SET revoked_at = ?, rotated_at = ? WHERE id = ? AND revoked_at IS NULL`, rotatedAt, rotatedAt, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeRefreshTokensBySubject revokes every active refresh token of a subject.
+func (s *sqlStore) RevokeRefreshTokensBySubject(ctx context.Context, subject string, revokedAt time.Time) (int64, error) {
+	res, err := s.exec(ctx, `UPDATE refresh_tokens SET revoked_at = ? WHERE subject = ? AND revoked_at IS NULL`, revokedAt, subject)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RevokeTokenID adds an access token ID to the revocation list.
+func (s *sqlStore) RevokeTokenID(ctx context.Context, jti string, expiresAt, revokedAt time.Time) error {
+	_, err := s.exec(ctx, `INSERT INTO revoked_tokens (jti, expires_at, revoked_at) VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		jti, expiresAt, revokedAt)
+	return err
+}
+
+// GetRevokedTokenIDs returns the revoked access token IDs that have not expired yet.
+func (s *sqlStore) GetRevokedTokenIDs(ctx context.Context, now time.Time) (map[string]time.Time, error) {
+	// expires_at is stored as text in the local zone, so compare in the same zone.
+	rows, err := s.query(ctx, `SELECT jti, expires_at FROM revoked_tokens WHERE expires_at >= ?`, now.In(time.Local))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := make(map[string]time.Time)
+	for rows.Next() {
+		var jti string
+		var expiresAt time.Time
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, err
+		}
+		revoked[jti] = expiresAt
+	}
+	return revoked, rows.Err()
+}
+
+// IsTokenIDRevoked reports whether an access token ID is on the revocation list.
+func (s *sqlStore) IsTokenIDRevoked(ctx context.Context, jti string) (bool, error) {
+	var n int
+	err := s.queryRow(ctx, `SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?`, jti).Scan(&n)
+	return n > 0, err
+}
+
+// DeleteExpiredAuthTokens removes revocation entries and refresh tokens that
+// expired before the given time; expired tokens are rejected anyway.
+func (s *sqlStore) DeleteExpiredAuthTokens(ctx context.Context, before time.Time) (int64, error) {
+	before = before.In(time.Local)
+	res, err := s.exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+
+	res, err = s.exec(ctx, `DELETE FROM refresh_tokens WHERE expires_at < ?`, before)
+	if err != nil {
+		return n, err
+	}
+	m, _ := res.RowsAffected()
+	return n + m, nil
+}
@@ -1,177 +1,283 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/nabilulilalbab/nadia/internal/models"
-	_ "github.com/mattn/go-sqlite3"
 )
 
-// InitDatabase initializes the SQLite database and creates the necessary tables.
-func InitDatabase(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+// upgradeTransactionsTable adds the columns that were introduced before
+// versioned migrations to a transactions table missing them. Only SQLite
+// databases predate the migrations.
+func (s *sqlStore) upgradeTransactionsTable(ctx context.Context) error {
+	columns := []struct{ name, definition string }{
+		{"idempotency_key", "TEXT"},
+		{"request_hash", "TEXT"},
+		{"response_status", "INTEGER DEFAULT 0"},
+		{"response_body", "TEXT"},
+		{"refund_amount", "INTEGER DEFAULT 0"},
+		{"last_checked_at", "DATETIME"},
+		{"is_qris", "INTEGER DEFAULT 0"},
+		{"qr_code", "TEXT"},
+		{"deeplink_url", "TEXT"},
+		{"payment_expired_at", "DATETIME"},
+		{"cost", "INTEGER DEFAULT 0"},
+		{"margin", "INTEGER DEFAULT 0"},
+		{"quote_id", "TEXT"},
+		{"reseller_id", "INTEGER DEFAULT 0"},
+	}
+	for _, col := range columns {
+		if err := s.ensureColumn(ctx, "transactions", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureColumn adds a column to a table if it does not exist yet.
+func (s *sqlStore) ensureColumn(ctx context.Context, table, column, definition string) error {
+	rows, err := s.query(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %v", err)
-	}
-
-	// Create transactions table
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS transactions (
-		id TEXT PRIMARY KEY,
-		phone_number TEXT NOT NULL,
-		package_code TEXT NOT NULL,
-		package_name TEXT,
-		payment_method TEXT NOT NULL,
-		source TEXT,
-		status TEXT NOT NULL,
-		amount INTEGER DEFAULT 0,
-		processing_fee INTEGER DEFAULT 0,
-		trx_id TEXT,
-		created_at DATETIME NOT NULL,
-		completed_at DATETIME,
-		error_message TEXT
-	);
-	
-	CREATE INDEX IF NOT EXISTS idx_phone_number ON transactions(phone_number);
-	CREATE INDEX IF NOT EXISTS idx_status ON transactions(status);
-	CREATE INDEX IF NOT EXISTS idx_source ON transactions(source);
-	CREATE INDEX IF NOT EXISTS idx_created_at ON transactions(created_at);
-	`
-
-	if _, err := db.Exec(createTableSQL); err != nil {
-		return nil, fmt.Errorf("failed to create table: %v", err)
-	}
-
-	log.Println("Database initialized successfully")
-	return db, nil
-}
-
-// SaveTransactionToDB saves or updates a transaction record in the database.
-func SaveTransactionToDB(db *sql.DB, tx *models.TransactionRecord) error {
-	query := `
-	INSERT OR REPLACE INTO transactions 
-	(id, phone_number, package_code, package_name, payment_method, source, status, 
-	 amount, processing_fee, trx_id, created_at, completed_at, error_message)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`
+		return err
+	}
+	defer rows.Close()
 
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = s.exec(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// transactionColumns is the column list shared by all transaction queries; keep it in sync with scanTransaction.
+const transactionColumns = `id, phone_number, package_code, package_name, payment_method, source, status,
+	 amount, processing_fee, trx_id, created_at, completed_at, error_message,
+	 idempotency_key, request_hash, response_status, response_body,
+	 refund_amount, last_checked_at, is_qris, qr_code, deeplink_url, payment_expired_at,
+	 cost, margin, quote_id, reseller_id, submitted_at`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTransaction scans a row selected with transactionColumns.
+func scanTransaction(row rowScanner) (*models.TransactionRecord, error) {
+	tx := &models.TransactionRecord{}
+	var completedAt, lastCheckedAt, paymentExpiredAt, submittedAt sql.NullTime
+	var idempotencyKey, requestHash, responseBody, qrCode, deeplinkURL, quoteID sql.NullString
+	var cost, margin, resellerID sql.NullInt64
+	var isQris sql.NullBool
+	var responseStatus sql.NullInt64
+
+	err := row.Scan(
+		&tx.ID, &tx.PhoneNumber, &tx.PackageCode, &tx.PackageName,
+		&tx.PaymentMethod, &tx.Source, &tx.Status, &tx.Amount,
+		&tx.ProcessingFee, &tx.TrxID, &tx.CreatedAt, &completedAt,
+		&tx.ErrorMessage, &idempotencyKey, &requestHash, &responseStatus, &responseBody,
+		&tx.RefundAmount, &lastCheckedAt, &isQris, &qrCode, &deeplinkURL, &paymentExpiredAt,
+		&cost, &margin, &quoteID, &resellerID, &submittedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if completedAt.Valid {
+		tx.CompletedAt = &completedAt.Time
+	}
+	tx.IdempotencyKey = idempotencyKey.String
+	tx.RequestHash = requestHash.String
+	tx.ResponseStatus = int(responseStatus.Int64)
+	tx.ResponseBody = responseBody.String
+	if lastCheckedAt.Valid {
+		tx.LastCheckedAt = &lastCheckedAt.Time
+	}
+	tx.IsQris = isQris.Bool
+	tx.QrCode = qrCode.String
+	tx.DeeplinkURL = deeplinkURL.String
+	if paymentExpiredAt.Valid {
+		tx.PaymentExpiredAt = &paymentExpiredAt.Time
+	}
+	tx.Cost = int(cost.Int64)
+	tx.Margin = int(margin.Int64)
+	tx.QuoteID = quoteID.String
+	tx.ResellerID = resellerID.Int64
+	if submittedAt.Valid {
+		tx.SubmittedAt = &submittedAt.Time
+	}
+	return tx, nil
+}
+
+// transactionArgs returns the values for transactionColumns in order.
+func transactionArgs(tx *models.TransactionRecord) []interface{} {
 	var completedAt *time.Time
 	if tx.CompletedAt != nil {
 		completedAt = tx.CompletedAt
 	}
 
-	_, err := db.Exec(query,
+	return []interface{}{
 		tx.ID, tx.PhoneNumber, tx.PackageCode, tx.PackageName, tx.PaymentMethod,
 		tx.Source, tx.Status, tx.Amount, tx.ProcessingFee, tx.TrxID,
-		tx.CreatedAt, completedAt, tx.ErrorMessage)
+		tx.CreatedAt, completedAt, tx.ErrorMessage,
+		nullIfEmpty(tx.IdempotencyKey), tx.RequestHash, tx.ResponseStatus, tx.ResponseBody,
+		tx.RefundAmount, tx.LastCheckedAt, boolToInt(tx.IsQris), tx.QrCode, tx.DeeplinkURL, tx.PaymentExpiredAt,
+		tx.Cost, tx.Margin, tx.QuoteID, tx.ResellerID, tx.SubmittedAt,
+	}
+}
 
-	if err != nil {
-		log.Printf("Failed to save transaction to DB: %v", err)
-		return err
+// boolToInt stores a flag in an INTEGER column, which PostgreSQL does not
+// accept a boolean for.
+func boolToInt(b bool) int {
+	if b {
+		return 1
 	}
+	return 0
+}
 
-	return nil
+// nullIfEmpty maps an empty string to NULL so partial unique indexes ignore it.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
 }
 
-// LoadTransactionsFromDB loads all transactions from the database into a map.
-func LoadTransactionsFromDB(db *sql.DB) (map[string]*models.TransactionRecord, error) {
-	query := `
-	SELECT id, phone_number, package_code, package_name, payment_method, source, 
-	       status, amount, processing_fee, trx_id, created_at, completed_at, error_message
-	FROM transactions
-	ORDER BY created_at DESC
-	`
+// upsertTransactionSQL inserts a transaction or updates it in place. Unlike
+// INSERT OR REPLACE it never deletes the existing row.
+var upsertTransactionSQL = insertTransactionSQL + `
+	ON CONFLICT(id) DO UPDATE SET ` + excludedAssignments(transactionColumns, "id", "created_at")
 
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query transactions: %v", err)
-	}
-	defer rows.Close()
+// insertTransactionSQL inserts a new transaction, failing if the ID or idempotency key exists.
+var insertTransactionSQL = `INSERT INTO transactions (` + transactionColumns + `)
+	VALUES (` + placeholders(len(splitColumns(transactionColumns))) + `)`
 
-	transactions := make(map[string]*models.TransactionRecord)
-	count := 0
-	for rows.Next() {
-		tx := &models.TransactionRecord{}
-		var completedAt sql.NullTime
+// splitColumns splits a comma-separated column list.
+func splitColumns(columns string) []string {
+	fields := strings.Split(columns, ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields
+}
 
-		err := rows.Scan(
-			&tx.ID, &tx.PhoneNumber, &tx.PackageCode, &tx.PackageName,
-			&tx.PaymentMethod, &tx.Source, &tx.Status, &tx.Amount,
-			&tx.ProcessingFee, &tx.TrxID, &tx.CreatedAt, &completedAt,
-			&tx.ErrorMessage)
+// placeholders returns n comma-separated "?" placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
 
-		if err != nil {
-			log.Printf("Failed to scan transaction: %v", err)
-			continue
+// excludedAssignments builds "col = excluded.col" assignments for an upsert,
+// skipping the given immutable columns.
+func excludedAssignments(columns string, skip ...string) string {
+	var assignments []string
+next:
+	for _, col := range splitColumns(columns) {
+		for _, s := range skip {
+			if col == s {
+				continue next
+			}
 		}
+		assignments = append(assignments, col+" = excluded."+col)
+	}
+	return strings.Join(assignments, ", ")
+}
 
-		if completedAt.Valid {
-			tx.CompletedAt = &completedAt.Time
-		}
+// SaveTransaction saves or updates a transaction record in the database.
+func (s *sqlStore) SaveTransaction(ctx context.Context, tx *models.TransactionRecord) error {
+	_, err := s.exec(ctx, upsertTransactionSQL, transactionArgs(tx)...)
 
-		transactions[tx.ID] = tx
-		count++
+	if err != nil {
+		log.Printf("Failed to save transaction to DB: %v", err)
+		return err
 	}
 
-	log.Printf("Loaded %d transactions from database", count)
-	return transactions, nil
+	return nil
 }
 
-// GetAllTransactionsFromDB retrieves all transactions from the database as a slice.
-func GetAllTransactionsFromDB(db *sql.DB, limit int) ([]*models.TransactionRecord, error) {
-	query := `
-	SELECT id, phone_number, package_code, package_name, payment_method, source, 
-	       status, amount, processing_fee, trx_id, created_at, completed_at, error_message
-	FROM transactions
-	ORDER BY created_at DESC
-	`
+// InsertTransaction inserts a new transaction record, failing if the ID or
+// idempotency key already exists.
+func (s *sqlStore) InsertTransaction(ctx context.Context, tx *models.TransactionRecord) error {
+	_, err := s.exec(ctx, insertTransactionSQL, transactionArgs(tx)...)
+	return err
+}
+
+// GetTransactionByIdempotencyKey returns the transaction a reseller created
+// with the given key, or sql.ErrNoRows.
+func (s *sqlStore) GetTransactionByIdempotencyKey(ctx context.Context, resellerID int64, key string) (*models.TransactionRecord, error) {
+	row := s.queryRow(ctx, `SELECT `+transactionColumns+` FROM transactions WHERE reseller_id = ? AND idempotency_key = ?`, resellerID, key)
+	return scanTransaction(row)
+}
 
-	if limit > 0 {
-		query += fmt.Sprintf(" LIMIT %d", limit)
+// GetUnsettledTransactions returns transactions that have an upstream trx_id
+// but have not reached a final status, created after the given time.
+func (s *sqlStore) GetUnsettledTransactions(ctx context.Context, statuses []string, since time.Time) ([]*models.TransactionRecord, error) {
+	if len(statuses) == 0 {
+		return nil, nil
 	}
 
-	rows, err := db.Query(query)
+	query := `SELECT ` + transactionColumns + ` FROM transactions
+	WHERE trx_id IS NOT NULL AND trx_id != '' AND status IN (` + placeholders(len(statuses)) + `) AND created_at >= ?
+	ORDER BY created_at ASC`
+
+	args := make([]interface{}, 0, len(statuses)+1)
+	for _, status := range statuses {
+		args = append(args, status)
+	}
+	args = append(args, since)
+
+	rows, err := s.query(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query transactions: %v", err)
+		return nil, fmt.Errorf("failed to query unsettled transactions: %v", err)
 	}
 	defer rows.Close()
 
 	var result []*models.TransactionRecord
 	for rows.Next() {
-		tx := &models.TransactionRecord{}
-		var completedAt sql.NullTime
-
-		err := rows.Scan(
-			&tx.ID, &tx.PhoneNumber, &tx.PackageCode, &tx.PackageName,
-			&tx.PaymentMethod, &tx.Source, &tx.Status, &tx.Amount,
-			&tx.ProcessingFee, &tx.TrxID, &tx.CreatedAt, &completedAt,
-			&tx.ErrorMessage)
-
+		tx, err := scanTransaction(rows)
 		if err != nil {
 			log.Printf("Failed to scan transaction: %v", err)
 			continue
 		}
-
-		if completedAt.Valid {
-			tx.CompletedAt = &completedAt.Time
-		}
-
 		result = append(result, tx)
 	}
+	return result, rows.Err()
+}
 
-	return result, nil
+// CountPackagePurchasesSince counts purchases of a package created at or after
+// since, ignoring those that failed or expired.
+func (s *sqlStore) CountPackagePurchasesSince(ctx context.Context, packageCode string, since time.Time) (int, error) {
+	var count int
+	// created_at is stored as text in the local zone, so compare in the same zone.
+	err := s.queryRow(ctx, `SELECT COUNT(*) FROM transactions
+	WHERE package_code = ? AND created_at >= ? AND status NOT IN (?, ?)`,
+		packageCode, since.In(time.Local), models.TxStatusFailed, models.TxStatusExpired).Scan(&count)
+	return count, err
 }
 
 // GetDatabaseStatus checks the current status of the database connection.
-func GetDatabaseStatus(db *sql.DB) string {
-	if db == nil {
+func GetDatabaseStatus(store Store) string {
+	if store == nil {
 		return "DISCONNECTED"
 	}
 
-	if err := db.Ping(); err != nil {
+	if err := store.Ping(); err != nil {
 		return "ERROR"
 	}
 
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+const depositColumns = `id, reseller_id, amount, unique_code, transfer_amount, proof_note, status,
+	review_note, reviewed_by, created_at, reviewed_at`
+
+func scanDeposit(row rowScanner) (*models.Deposit, error) {
+	d := &models.Deposit{}
+	var proofNote, reviewNote, reviewedBy sql.NullString
+	var reviewedAt sql.NullTime
+	if err := row.Scan(&d.ID, &d.ResellerID, &d.Amount, &d.UniqueCode, &d.TransferAmount, &proofNote,
+		&d.Status, &reviewNote, &reviewedBy, &d.CreatedAt, &reviewedAt); err != nil {
+		return nil, err
+	}
+	d.ProofNote = proofNote.String
+	d.ReviewNote = reviewNote.String
+	d.ReviewedBy = reviewedBy.String
+	if reviewedAt.Valid {
+		d.ReviewedAt = &reviewedAt.Time
+	}
+	return d, nil
+}
+
+// CreateDeposit inserts a new deposit.
+func (s *sqlStore) CreateDeposit(ctx context.Context, d *models.Deposit) error {
+	_, err := s.exec(ctx, `INSERT INTO deposits
+		(id, reseller_id, amount, unique_code, transfer_amount, proof_note, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.ID, d.ResellerID, d.Amount, d.UniqueCode, d.TransferAmount, nullIfEmpty(d.ProofNote), d.Status, d.CreatedAt)
+	return err
+}
+
+// GetDeposit returns a deposit by ID, or sql.ErrNoRows.
+func (s *sqlStore) GetDeposit(ctx context.Context, id string) (*models.Deposit, error) {
+	return scanDeposit(s.queryRow(ctx, `SELECT `+depositColumns+` FROM deposits WHERE id = ?`, id))
+}
+
+// GetDeposits returns deposits newest first. A zero resellerID or an empty
+// status matches all deposits.
+func (s *sqlStore) GetDeposits(ctx context.Context, resellerID int64, status string, limit int) ([]models.Deposit, error) {
+	rows, err := s.query(ctx, `SELECT `+depositColumns+` FROM deposits
+		WHERE (? = 0 OR reseller_id = ?) AND (? = '' OR status = ?)
+		ORDER BY created_at DESC, id DESC LIMIT ?`,
+		resellerID, resellerID, status, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deposits := []models.Deposit{}
+	for rows.Next() {
+		d, err := scanDeposit(rows)
+		if err != nil {
+			return nil, err
+		}
+		deposits = append(deposits, *d)
+	}
+	return deposits, rows.Err()
+}
+
+// PendingTransferAmountExists reports whether a pending deposit already
+// expects a transfer of the given amount.
+func (s *sqlStore) PendingTransferAmountExists(ctx context.Context, transferAmount int) (bool, error) {
+	var exists bool
+	err := s.queryRow(ctx, `SELECT EXISTS(SELECT 1 FROM deposits WHERE status = ? AND transfer_amount = ?)`,
+		models.DepositPending, transferAmount).Scan(&exists)
+	return exists, err
+}
+
+// ReviewDeposit moves a pending deposit to its final status. It returns
+// sql.ErrNoRows if the deposit does not exist or is no longer pending.
+func (s *sqlStore) ReviewDeposit(ctx context.Context, id, status, note, reviewedBy string, reviewedAt time.Time) error {
+	res, err := s.exec(ctx, `UPDATE deposits SET status = ?, review_note = ?, reviewed_by = ?, reviewed_at = ?
+		WHERE id = ? AND status = ?`,
+		status, nullIfEmpty(note), reviewedBy, reviewedAt, id, models.DepositPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
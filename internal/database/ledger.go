@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// ErrOverdrawn is returned by PostCoveredJournal when a journal would make
+// the covered account negative.
+var ErrOverdrawn = errors.New("account would be overdrawn")
+
+// PostJournal inserts a journal and its entries atomically and sets their IDs.
+// The entries must sum to zero.
+func (s *sqlStore) PostJournal(ctx context.Context, j *models.LedgerJournal) error {
+	if err := checkJournal(j); err != nil {
+		return err
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertJournal(tx, j); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PostCoveredJournal posts a journal like PostJournal, but only if the balance
+// of account stays at least zero; otherwise it returns ErrOverdrawn. The
+// balance is checked after the insert in the same transaction, and concurrent
+// covered postings to the account wait for it, so instances sharing the
+// database cannot overdraw the account between the check and the insert.
+func (s *sqlStore) PostCoveredJournal(ctx context.Context, j *models.LedgerJournal, account string) error {
+	if err := checkJournal(j); err != nil {
+		return err
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.dialect.lockAccount(tx, account); err != nil {
+		return err
+	}
+	if err := insertJournal(tx, j); err != nil {
+		return err
+	}
+	var balance int
+	if err := tx.QueryRow(`SELECT COALESCE(SUM(amount), 0) FROM ledger_entries WHERE account = ?`, account).Scan(&balance); err != nil {
+		return err
+	}
+	if balance < 0 {
+		return fmt.Errorf("%w: %s would be %d", ErrOverdrawn, account, balance)
+	}
+	return tx.Commit()
+}
+
+func checkJournal(j *models.LedgerJournal) error {
+	sum := 0
+	for _, e := range j.Entries {
+		sum += e.Amount
+	}
+	if len(j.Entries) < 2 || sum != 0 {
+		return fmt.Errorf("unbalanced journal: %d entries summing to %d", len(j.Entries), sum)
+	}
+	return nil
+}
+
+func insertJournal(tx *storeTx, j *models.LedgerJournal) error {
+	if err := tx.QueryRow(`INSERT INTO ledger_journals (reseller_id, kind, reference, memo, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		j.ResellerID, j.Kind, nullIfEmpty(j.Reference), j.Memo, j.CreatedBy, j.CreatedAt).Scan(&j.ID); err != nil {
+		return err
+	}
+
+	for i := range j.Entries {
+		e := &j.Entries[i]
+		e.JournalID = j.ID
+		e.CreatedAt = j.CreatedAt
+		if err := tx.QueryRow(`INSERT INTO ledger_entries (journal_id, account, amount, created_at)
+			VALUES (?, ?, ?, ?) RETURNING id`,
+			e.JournalID, e.Account, e.Amount, e.CreatedAt).Scan(&e.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JournalExists reports whether a journal of the given kind was posted for a reference.
+func (s *sqlStore) JournalExists(ctx context.Context, kind, reference string) (bool, error) {
+	var n int
+	err := s.queryRow(ctx, `SELECT COUNT(*) FROM ledger_journals WHERE kind = ? AND reference = ?`, kind, reference).Scan(&n)
+	return n > 0, err
+}
+
+// AccountBalance returns the sum of all entries of an account.
+func (s *sqlStore) AccountBalance(ctx context.Context, account string) (int, error) {
+	var balance int
+	err := s.queryRow(ctx, `SELECT COALESCE(SUM(amount), 0) FROM ledger_entries WHERE account = ?`, account).Scan(&balance)
+	return balance, err
+}
+
+// ReferenceBalance returns the sum of the entries of an account posted by
+// journals with the given reference, e.g. what is still held for a transaction.
+func (s *sqlStore) ReferenceBalance(ctx context.Context, account, reference string) (int, error) {
+	var balance int
+	err := s.queryRow(ctx, `SELECT COALESCE(SUM(e.amount), 0) FROM ledger_entries e
+		JOIN ledger_journals j ON j.id = e.journal_id
+		WHERE e.account = ? AND j.reference = ?`, account, reference).Scan(&balance)
+	return balance, err
+}
+
+// GetTransactionsAwaitingSettlement returns reseller purchases with a final
+// status whose hold was never settled: SUCCESS, FAILED and EXPIRED ones
+// without a capture or release, and REFUNDED ones without a release or refund.
+func (s *sqlStore) GetTransactionsAwaitingSettlement(ctx context.Context) ([]*models.TransactionRecord, error) {
+	journal := func(kinds ...string) string {
+		return `EXISTS (SELECT 1 FROM ledger_journals j WHERE j.reference = transactions.id AND j.kind IN (` +
+			placeholders(len(kinds)) + `))`
+	}
+	query := `SELECT ` + transactionColumns + ` FROM transactions
+	WHERE reseller_id != 0 AND ` + journal(models.LedgerHold) + ` AND (
+		(status IN (?, ?, ?) AND NOT ` + journal(models.LedgerCapture, models.LedgerRelease) + `) OR
+		(status = ? AND NOT ` + journal(models.LedgerRelease, models.LedgerRefund) + `))
+	ORDER BY created_at ASC`
+
+	rows, err := s.query(ctx, query,
+		models.LedgerHold,
+		models.TxStatusSuccess, models.TxStatusFailed, models.TxStatusExpired,
+		models.LedgerCapture, models.LedgerRelease,
+		models.TxStatusRefunded,
+		models.LedgerRelease, models.LedgerRefund)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*models.TransactionRecord
+	for rows.Next() {
+		tx, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, tx)
+	}
+	return result, rows.Err()
+}
+
+// GetLedgerJournals returns the most recent journals of a reseller with their entries, newest first.
+func (s *sqlStore) GetLedgerJournals(ctx context.Context, resellerID int64, limit int) ([]models.LedgerJournal, error) {
+	rows, err := s.query(ctx, `SELECT id, reseller_id, kind, reference, memo, created_by, created_at
+		FROM ledger_journals WHERE reseller_id = ? ORDER BY id DESC LIMIT ?`, resellerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	journals := []models.LedgerJournal{}
+	index := make(map[int64]int)
+	for rows.Next() {
+		var j models.LedgerJournal
+		var reference, memo, createdBy sql.NullString
+		if err := rows.Scan(&j.ID, &j.ResellerID, &j.Kind, &reference, &memo, &createdBy, &j.CreatedAt); err != nil {
+			return nil, err
+		}
+		j.Reference = reference.String
+		j.Memo = memo.String
+		j.CreatedBy = createdBy.String
+		j.Entries = []models.LedgerEntry{}
+		index[j.ID] = len(journals)
+		journals = append(journals, j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(journals) == 0 {
+		return journals, nil
+	}
+
+	entries, err := s.query(ctx, `SELECT id, journal_id, account, amount, created_at FROM ledger_entries
+		WHERE journal_id BETWEEN ? AND ? ORDER BY id`, journals[len(journals)-1].ID, journals[0].ID)
+	if err != nil {
+		return nil, err
+	}
+	defer entries.Close()
+
+	for entries.Next() {
+		var e models.LedgerEntry
+		if err := entries.Scan(&e.ID, &e.JournalID, &e.Account, &e.Amount, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if i, ok := index[e.JournalID]; ok {
+			journals[i].Entries = append(journals[i].Entries, e)
+		}
+	}
+	return journals, entries.Err()
+}
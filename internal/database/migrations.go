@@ -0,0 +1,255 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema migrations, named <version>_<name>.up.sql.
+// Applied migrations must never be edited; add a new one instead.
+//
+//go:embed migrations/*.up.sql
+var migrationFiles embed.FS
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version  int
+	Name     string
+	Checksum string
+	SQL      string
+}
+
+// MigrationState is the state of a migration in a database.
+type MigrationState struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt *time.Time
+	// Status is "applied", "pending", "modified" when the embedded file no
+	// longer matches the applied checksum, or "unknown" when the database was
+	// migrated by a newer build.
+	Status string
+}
+
+const createMigrationsTableSQL = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	applied_at DATETIME NOT NULL
+)`
+
+// Migrations returns the embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		base := strings.TrimSuffix(entry.Name(), ".up.sql")
+		versionText, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionText)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		if other, exists := seen[version]; exists {
+			return nil, fmt.Errorf("migrations %q and %q share version %d", other, entry.Name(), version)
+		}
+		seen[version] = entry.Name()
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(content)
+		migrations = append(migrations, Migration{
+			Version:  version,
+			Name:     name,
+			Checksum: hex.EncodeToString(sum[:]),
+			SQL:      string(content),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrationStatus compares the embedded migrations with those applied to the
+// database.
+func (s *sqlStore) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	if _, err := s.exec(ctx, s.dialect.translateDDL(createMigrationsTableSQL)); err != nil {
+		return nil, err
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []MigrationState
+	for _, m := range migrations {
+		state := MigrationState{Version: m.Version, Name: m.Name, Checksum: m.Checksum, Status: "pending"}
+		if a, ok := applied[m.Version]; ok {
+			state.AppliedAt = a.AppliedAt
+			state.Status = "applied"
+			if a.Checksum != m.Checksum {
+				state.Status = "modified"
+			}
+			delete(applied, m.Version)
+		}
+		states = append(states, state)
+	}
+	for _, a := range applied {
+		a.Status = "unknown"
+		states = append(states, a)
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, nil
+}
+
+func (s *sqlStore) appliedMigrations(ctx context.Context) (map[int]MigrationState, error) {
+	rows, err := s.query(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationState)
+	for rows.Next() {
+		var state MigrationState
+		var appliedAt time.Time
+		if err := rows.Scan(&state.Version, &state.Name, &state.Checksum, &appliedAt); err != nil {
+			return nil, err
+		}
+		state.AppliedAt = &appliedAt
+		applied[state.Version] = state
+	}
+	return applied, rows.Err()
+}
+
+// Migrate applies the pending migrations in order, each in its own
+// transaction together with its schema_migrations row. It refuses to run when
+// an applied migration was modified, and returns the versions it applied.
+// Instances migrating the same database at once take turns.
+func (s *sqlStore) Migrate(ctx context.Context) ([]int, error) {
+	unlock, err := s.dialect.lockMigrations(ctx, s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock migrations: %v", err)
+	}
+	defer unlock()
+
+	states, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range states {
+		switch state.Status {
+		case "modified":
+			return nil, fmt.Errorf("migration %04d_%s was modified after it was applied", state.Version, state.Name)
+		case "unknown":
+			log.Printf("Database has migration %04d_%s that this build does not know", state.Version, state.Name)
+		}
+	}
+
+	if len(states) > 0 && states[0].Status == "pending" {
+		if err := s.dialect.adoptLegacySchema(ctx, s); err != nil {
+			return nil, fmt.Errorf("failed to upgrade legacy schema: %v", err)
+		}
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	pending := make(map[int]bool)
+	for _, state := range states {
+		pending[state.Version] = state.Status == "pending"
+	}
+
+	var applied []int
+	for _, m := range migrations {
+		if !pending[m.Version] {
+			continue
+		}
+		if err := s.applyMigration(ctx, m); err != nil {
+			return applied, fmt.Errorf("migration %04d_%s failed: %v", m.Version, m.Name, err)
+		}
+		log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		applied = append(applied, m.Version)
+	}
+	return applied, nil
+}
+
+func (s *sqlStore) applyMigration(ctx context.Context, m Migration) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range splitStatements(s.dialect.translateDDL(m.SQL)) {
+		if _, err := tx.Tx.ExecContext(tx.ctx, statement); err != nil {
+			return fmt.Errorf("%v in %q", err, firstLine(statement))
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+		m.Version, m.Name, m.Checksum, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// splitStatements splits a migration into its statements, dropping "--"
+// comments. Statements end with ";" outside string literals.
+func splitStatements(script string) []string {
+	var statements []string
+	var b strings.Builder
+	quoted := false
+	for _, line := range strings.Split(script, "\n") {
+		if !quoted {
+			if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "--") {
+				continue
+			}
+		}
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case c == '\'':
+				quoted = !quoted
+			case c == ';' && !quoted:
+				if statement := strings.TrimSpace(b.String()); statement != "" {
+					statements = append(statements, statement)
+				}
+				b.Reset()
+				continue
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('\n')
+	}
+	if statement := strings.TrimSpace(b.String()); statement != "" {
+		statements = append(statements, statement)
+	}
+	return statements
+}
+
+func firstLine(statement string) string {
+	line, _, _ := strings.Cut(statement, "\n")
+	return line
+}
@@ -0,0 +1,228 @@
+-- Baseline schema. Statements are idempotent so that databases created before
+-- versioned migrations can adopt it; later changes go into new migrations.
+
+CREATE TABLE IF NOT EXISTS transactions (
+	id TEXT PRIMARY KEY,
+	phone_number TEXT NOT NULL,
+	package_code TEXT NOT NULL,
+	package_name TEXT,
+	payment_method TEXT NOT NULL,
+	source TEXT,
+	status TEXT NOT NULL,
+	amount INTEGER DEFAULT 0,
+	processing_fee INTEGER DEFAULT 0,
+	trx_id TEXT,
+	created_at DATETIME NOT NULL,
+	completed_at DATETIME,
+	error_message TEXT,
+	idempotency_key TEXT,
+	request_hash TEXT,
+	response_status INTEGER DEFAULT 0,
+	response_body TEXT,
+	refund_amount INTEGER DEFAULT 0,
+	last_checked_at DATETIME,
+	is_qris INTEGER DEFAULT 0,
+	qr_code TEXT,
+	deeplink_url TEXT,
+	payment_expired_at DATETIME,
+	cost INTEGER DEFAULT 0,
+	margin INTEGER DEFAULT 0,
+	quote_id TEXT,
+	reseller_id INTEGER DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_phone_number ON transactions(phone_number);
+CREATE INDEX IF NOT EXISTS idx_status ON transactions(status);
+CREATE INDEX IF NOT EXISTS idx_source ON transactions(source);
+CREATE INDEX IF NOT EXISTS idx_created_at ON transactions(created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_key
+	ON transactions(idempotency_key) WHERE idempotency_key IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_transactions_created ON transactions(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_transactions_status ON transactions(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_transactions_source ON transactions(source, created_at);
+CREATE INDEX IF NOT EXISTS idx_transactions_reseller ON transactions(reseller_id, created_at);
+
+CREATE TABLE IF NOT EXISTS price_tiers (
+	name TEXT PRIMARY KEY,
+	description TEXT,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS price_rules (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	tier TEXT NOT NULL REFERENCES price_tiers(name) ON DELETE CASCADE,
+	scope TEXT NOT NULL,
+	target TEXT NOT NULL DEFAULT '',
+	base_field TEXT NOT NULL DEFAULT 'price',
+	markup_type TEXT NOT NULL,
+	markup_value REAL NOT NULL DEFAULT 0,
+	round_to INTEGER DEFAULT 0,
+	min_price INTEGER DEFAULT 0,
+	max_price INTEGER DEFAULT 0,
+	updated_at DATETIME NOT NULL,
+	UNIQUE(tier, scope, target)
+);
+
+CREATE TABLE IF NOT EXISTS transaction_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	transaction_id TEXT NOT NULL,
+	from_status TEXT NOT NULL DEFAULT '',
+	to_status TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	reason TEXT,
+	payload TEXT,
+	created_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_transaction_events_tx ON transaction_events(transaction_id, id);
+
+CREATE TABLE IF NOT EXISTS price_quotes (
+	id TEXT PRIMARY KEY,
+	tier TEXT NOT NULL,
+	package_code TEXT NOT NULL,
+	package_name TEXT,
+	payment_method TEXT NOT NULL,
+	price INTEGER NOT NULL,
+	cost INTEGER NOT NULL,
+	catalog_version INTEGER DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	used_at DATETIME,
+	transaction_id TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_price_quotes_expires_at ON price_quotes(expires_at);
+
+CREATE TABLE IF NOT EXISTS otp_sessions (
+	request_id TEXT PRIMARY KEY,
+	phone_number TEXT NOT NULL,
+	auth_id TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_otp_sessions_phone ON otp_sessions(phone_number, created_at);
+
+CREATE TABLE IF NOT EXISTS xl_tokens (
+	phone_number TEXT PRIMARY KEY,
+	nonce BLOB NOT NULL,
+	ciphertext BLOB NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS resellers (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	key_prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	tier TEXT NOT NULL,
+	scopes TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'active',
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id TEXT PRIMARY KEY,
+	token_hash TEXT NOT NULL UNIQUE,
+	subject TEXT NOT NULL,
+	role TEXT NOT NULL,
+	reseller_id INTEGER,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	revoked_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	jti TEXT PRIMARY KEY,
+	expires_at DATETIME NOT NULL,
+	revoked_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS ledger_journals (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	reseller_id INTEGER NOT NULL,
+	kind TEXT NOT NULL,
+	reference TEXT,
+	memo TEXT,
+	created_by TEXT,
+	created_at DATETIME NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_journals_reference
+	ON ledger_journals(kind, reference) WHERE reference IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_ledger_journals_reseller ON ledger_journals(reseller_id, id);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	journal_id INTEGER NOT NULL REFERENCES ledger_journals(id),
+	account TEXT NOT NULL,
+	amount INTEGER NOT NULL,
+	created_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_account ON ledger_entries(account);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_journal ON ledger_entries(journal_id);
+
+CREATE TABLE IF NOT EXISTS deposits (
+	id TEXT PRIMARY KEY,
+	reseller_id INTEGER NOT NULL,
+	amount INTEGER NOT NULL,
+	unique_code INTEGER NOT NULL,
+	transfer_amount INTEGER NOT NULL,
+	proof_note TEXT,
+	status TEXT NOT NULL DEFAULT 'pending',
+	review_note TEXT,
+	reviewed_by TEXT,
+	created_at DATETIME NOT NULL,
+	reviewed_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_deposits_reseller ON deposits(reseller_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_deposits_status ON deposits(status, transfer_amount);
+
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	reseller_id INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	events TEXT NOT NULL DEFAULT '',
+	active BOOLEAN NOT NULL DEFAULT 1,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_reseller ON webhook_endpoints(reseller_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	endpoint_id INTEGER NOT NULL REFERENCES webhook_endpoints(id),
+	event_id TEXT NOT NULL,
+	event_type TEXT NOT NULL,
+	transaction_id TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at DATETIME,
+	last_status_code INTEGER,
+	last_error TEXT,
+	created_at DATETIME NOT NULL,
+	delivered_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_endpoint ON webhook_deliveries(endpoint_id, id);
+
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	delivery_id INTEGER NOT NULL REFERENCES webhook_deliveries(id),
+	attempt INTEGER NOT NULL,
+	status_code INTEGER,
+	error TEXT,
+	duration_ms INTEGER NOT NULL,
+	created_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery ON webhook_attempts(delivery_id);
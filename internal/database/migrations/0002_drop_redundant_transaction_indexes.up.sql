@@ -0,0 +1,6 @@
+-- The composite indexes on (status, created_at), (source, created_at) and
+-- (created_at, id) cover every query the single-column indexes served.
+
+DROP INDEX IF EXISTS idx_status;
+DROP INDEX IF EXISTS idx_source;
+DROP INDEX IF EXISTS idx_created_at;
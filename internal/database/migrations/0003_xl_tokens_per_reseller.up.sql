@@ -0,0 +1,19 @@
+-- XL access tokens belong to the reseller that verified the OTP, so that a
+-- reseller cannot act on the sessions of another one's customers. Existing
+-- tokens were stored without an owner and stay with the admin (reseller 0).
+
+CREATE TABLE xl_tokens_owned (
+	reseller_id INTEGER NOT NULL DEFAULT 0,
+	phone_number TEXT NOT NULL,
+	nonce BLOB NOT NULL,
+	ciphertext BLOB NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	PRIMARY KEY (reseller_id, phone_number)
+);
+
+INSERT INTO xl_tokens_owned (reseller_id, phone_number, nonce, ciphertext, created_at, expires_at)
+	SELECT 0, phone_number, nonce, ciphertext, created_at, expires_at FROM xl_tokens;
+
+DROP TABLE xl_tokens;
+ALTER TABLE xl_tokens_owned RENAME TO xl_tokens;
@@ -0,0 +1,6 @@
+-- Idempotency keys are chosen by clients, so they are only unique per
+-- reseller; admin purchases have reseller 0.
+
+DROP INDEX IF EXISTS idx_idempotency_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_idempotency_key
+	ON transactions(reseller_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
@@ -0,0 +1,5 @@
+-- submitted_at is set just before a purchase is sent upstream. A transaction
+-- abandoned after that point may have been bought, so its idempotency key is
+-- not reclaimed and the reconciler moves it to PROCESSING instead.
+
+ALTER TABLE transactions ADD COLUMN submitted_at DATETIME;
@@ -0,0 +1,5 @@
+-- Quotes belong to the reseller that created them, so that a quote ID cannot
+-- be redeemed by another caller at the creator's tier price. Existing quotes
+-- stay with the admin (reseller 0).
+
+ALTER TABLE price_quotes ADD COLUMN reseller_id INTEGER NOT NULL DEFAULT 0;
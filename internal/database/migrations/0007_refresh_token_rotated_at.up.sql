@@ -0,0 +1,5 @@
+-- rotated_at is set when a refresh token is exchanged for a new one. Only a
+-- rotated token presented again is treated as reuse and revokes its subject;
+-- tokens revoked by logout, key rotation or an admin are merely rejected.
+
+ALTER TABLE refresh_tokens ADD COLUMN rotated_at DATETIME;
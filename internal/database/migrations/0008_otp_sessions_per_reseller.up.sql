@@ -0,0 +1,8 @@
+-- OTP sessions belong to the reseller that requested the OTP, so that a
+-- reseller cannot verify or discard an OTP requested by another one for the
+-- same phone. Existing sessions stay with the admin (reseller 0).
+
+ALTER TABLE otp_sessions ADD COLUMN reseller_id INTEGER NOT NULL DEFAULT 0;
+
+DROP INDEX IF EXISTS idx_otp_sessions_phone;
+CREATE INDEX IF NOT EXISTS idx_otp_sessions_reseller_phone ON otp_sessions(reseller_id, phone_number, created_at);
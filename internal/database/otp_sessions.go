@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// SaveOTPSession inserts an OTP session, replacing one with the same request ID.
+func (s *sqlStore) SaveOTPSession(ctx context.Context, session *models.OTPSession) error {
+	_, err := s.exec(ctx, `INSERT INTO otp_sessions
+		(request_id, reseller_id, phone_number, auth_id, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(request_id) DO UPDATE SET reseller_id = excluded.reseller_id, phone_number = excluded.phone_number,
+		auth_id = excluded.auth_id, created_at = excluded.created_at, expires_at = excluded.expires_at`,
+		session.RequestID, session.ResellerID, session.PhoneNumber, session.AuthID, session.CreatedAt, session.ExpiresAt)
+	return err
+}
+
+// GetOTPSession returns the session with the given request ID a reseller
+// requested for a phone, or its most recent session of the phone when
+// requestID is empty. It returns sql.ErrNoRows if there is none.
+func (s *sqlStore) GetOTPSession(ctx context.Context, resellerID int64, phone, requestID string) (*models.OTPSession, error) {
+	query := `SELECT request_id, reseller_id, phone_number, auth_id, created_at, expires_at
+		FROM otp_sessions WHERE reseller_id = ? AND phone_number = ?`
+	args := []interface{}{resellerID, phone}
+	if requestID != "" {
+		query += ` AND request_id = ?`
+		args = append(args, requestID)
+	}
+	query += ` ORDER BY created_at DESC LIMIT 1`
+
+	session := &models.OTPSession{}
+	err := s.queryRow(ctx, query, args...).Scan(
+		&session.RequestID, &session.ResellerID, &session.PhoneNumber, &session.AuthID, &session.CreatedAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// DeleteOTPSessions removes one session a reseller requested for a phone, or
+// all of them when requestID is empty.
+func (s *sqlStore) DeleteOTPSessions(ctx context.Context, resellerID int64, phone, requestID string) error {
+	if requestID != "" {
+		_, err := s.exec(ctx, `DELETE FROM otp_sessions WHERE reseller_id = ? AND phone_number = ? AND request_id = ?`,
+			resellerID, phone, requestID)
+		return err
+	}
+	_, err := s.exec(ctx, `DELETE FROM otp_sessions WHERE reseller_id = ? AND phone_number = ?`, resellerID, phone)
+	return err
+}
+
+// DeleteExpiredOTPSessions removes sessions that expired before the given time.
+func (s *sqlStore) DeleteExpiredOTPSessions(ctx context.Context, before time.Time) (int64, error) {
+	// expires_at is stored as text in the local zone, so compare in the same zone.
+	res, err := s.exec(ctx, `DELETE FROM otp_sessions WHERE expires_at < ?`, before.In(time.Local))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"regexp"
+
+	"github.com/lib/pq"
+)
+
+// postgresDialect stores data in PostgreSQL. Timestamps are TIMESTAMPTZ, and
+// daily statistics are grouped in the session time zone, so set "timezone" in
+// DATABASE_URL when it differs from the server default.
+type postgresDialect struct{}
+
+func (postgresDialect) name() string   { return DriverPostgres }
+func (postgresDialect) driver() string { return "postgres" }
+
+func (postgresDialect) rebind(query string) string { return numberedPlaceholders(query) }
+
+// postgresTypes maps the SQLite column types used by the migrations, in order.
+var postgresTypes = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`\bINTEGER PRIMARY KEY AUTOINCREMENT\b`), "BIGSERIAL PRIMARY KEY"},
+	{regexp.MustCompile(`\bINTEGER\b`), "BIGINT"},
+	{regexp.MustCompile(`\bDATETIME\b`), "TIMESTAMPTZ"},
+	{regexp.MustCompile(`\bBLOB\b`), "BYTEA"},
+	{regexp.MustCompile(`\bREAL\b`), "DOUBLE PRECISION"},
+	{regexp.MustCompile(`\b(BOOLEAN[A-Z ]*DEFAULT) 1\b`), "$1 TRUE"},
+	{regexp.MustCompile(`\b(BOOLEAN[A-Z ]*DEFAULT) 0\b`), "$1 FALSE"},
+}
+
+func (postgresDialect) translateDDL(ddl string) string {
+	for _, t := range postgresTypes {
+		ddl = t.pattern.ReplaceAllString(ddl, t.replacement)
+	}
+	return ddl
+}
+
+func (postgresDialect) dayKey(column string) string {
+	return `to_char(` + column + `, 'YYYY-MM-DD')`
+}
+
+// adoptLegacySchema does nothing: PostgreSQL support was added after
+// versioned migrations, so every database starts from the baseline.
+func (postgresDialect) adoptLegacySchema(ctx context.Context, s *sqlStore) error { return nil }
+
+// migrationLockKey is the pg_advisory_lock key held while migrating.
+const migrationLockKey int64 = 0x6e61646961 // "nadia"
+
+// lockMigrations takes a session-level advisory lock on a connection of its
+// own, so that instances starting together apply each migration once.
+func (postgresDialect) lockMigrations(ctx context.Context, s *sqlStore) (func(), error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey); err != nil {
+			log.Printf("Failed to release migration lock: %v", err)
+			// Discard the connection rather than return it to the pool
+			// still holding the lock; closing the session releases it.
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, nil
+}
+
+// lockAccount takes a transaction-level advisory lock keyed by the account.
+func (postgresDialect) lockAccount(tx *storeTx, account string) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext(?))`, account)
+	return err
+}
+
+func (postgresDialect) isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
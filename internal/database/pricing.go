@@ -0,0 +1,169 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// seedPriceTiers inserts the built-in user and reseller tiers with the
+// markups that used to be hard-coded (+1500 and +500), if they do not exist yet.
+func (s *sqlStore) seedPriceTiers(ctx context.Context) error {
+	now := time.Now()
+	defaults := []struct {
+		tier        string
+		description string
+		markup      float64
+	}{
+		{models.PriceTierUser, "Harga untuk end user", 1500},
+		{models.PriceTierReseller, "Harga untuk reseller", 500},
+	}
+
+	for _, d := range defaults {
+		res, err := s.exec(ctx, `INSERT INTO price_tiers (name, description, created_at, updated_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT DO NOTHING`,
+			d.tier, d.description, now, now)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		if _, err := s.exec(ctx, `INSERT INTO price_rules
+			(tier, scope, target, base_field, markup_type, markup_value, updated_at)
+			VALUES (?, ?, '', 'price', 'flat', ?, ?) ON CONFLICT DO NOTHING`,
+			d.tier, models.PriceScopeDefault, d.markup, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPriceTiers returns all configured price tiers.
+func (s *sqlStore) GetPriceTiers(ctx context.Context) ([]models.PriceTier, error) {
+	rows, err := s.query(ctx, `SELECT name, COALESCE(description, ''), created_at, updated_at FROM price_tiers ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query price tiers: %v", err)
+	}
+	defer rows.Close()
+
+	var tiers []models.PriceTier
+	for rows.Next() {
+		var t models.PriceTier
+		if err := rows.Scan(&t.Name, &t.Description, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan price tier: %v", err)
+		}
+		tiers = append(tiers, t)
+	}
+	return tiers, rows.Err()
+}
+
+// SavePriceTier creates a price tier or updates its description.
+func (s *sqlStore) SavePriceTier(ctx context.Context, tier *models.PriceTier) error {
+	now := time.Now()
+	_, err := s.exec(ctx, `
+	INSERT INTO price_tiers (name, description, created_at, updated_at) VALUES (?, ?, ?, ?)
+	ON CONFLICT(name) DO UPDATE SET description = excluded.description, updated_at = excluded.updated_at`,
+		tier.Name, tier.Description, now, now)
+	return err
+}
+
+// ErrTierInUse is returned by DeletePriceTier while resellers are assigned the tier.
+var ErrTierInUse = errors.New("price tier is assigned to resellers")
+
+// DeletePriceTier removes a price tier together with its rules. It returns
+// ErrTierInUse if any reseller is assigned the tier.
+func (s *sqlStore) DeletePriceTier(ctx context.Context, name string) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var resellers int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM resellers WHERE tier = ?`, name).Scan(&resellers); err != nil {
+		return err
+	}
+	if resellers > 0 {
+		return fmt.Errorf("%w: %d resellers use %q", ErrTierInUse, resellers, name)
+	}
+	if _, err := tx.Exec(`DELETE FROM price_rules WHERE tier = ?`, name); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM price_tiers WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// GetPriceRules returns all price rules, optionally restricted to one tier.
+func (s *sqlStore) GetPriceRules(ctx context.Context, tier string) ([]models.PriceRule, error) {
+	query := `
+	SELECT id, tier, scope, target, base_field, markup_type, markup_value,
+	       round_to, min_price, max_price, updated_at
+	FROM price_rules`
+	var args []interface{}
+	if tier != "" {
+		query += ` WHERE tier = ?`
+		args = append(args, tier)
+	}
+	query += ` ORDER BY tier, scope, target`
+
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query price rules: %v", err)
+	}
+	defer rows.Close()
+
+	var rules []models.PriceRule
+	for rows.Next() {
+		var r models.PriceRule
+		if err := rows.Scan(&r.ID, &r.Tier, &r.Scope, &r.Target, &r.BaseField, &r.MarkupType,
+			&r.MarkupValue, &r.RoundTo, &r.MinPrice, &r.MaxPrice, &r.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan price rule: %v", err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// SavePriceRule inserts a rule, replacing any existing rule for the same tier, scope and target.
+func (s *sqlStore) SavePriceRule(ctx context.Context, rule *models.PriceRule) error {
+	rule.UpdatedAt = time.Now()
+	err := s.queryRow(ctx, `
+	INSERT INTO price_rules
+	(tier, scope, target, base_field, markup_type, markup_value, round_to, min_price, max_price, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(tier, scope, target) DO UPDATE SET
+		base_field = excluded.base_field,
+		markup_type = excluded.markup_type,
+		markup_value = excluded.markup_value,
+		round_to = excluded.round_to,
+		min_price = excluded.min_price,
+		max_price = excluded.max_price,
+		updated_at = excluded.updated_at
+	RETURNING id`,
+		rule.Tier, rule.Scope, rule.Target, rule.BaseField, rule.MarkupType, rule.MarkupValue,
+		rule.RoundTo, rule.MinPrice, rule.MaxPrice, rule.UpdatedAt).Scan(&rule.ID)
+	return err
+}
+
+// DeletePriceRule removes a single price rule by ID.
+func (s *sqlStore) DeletePriceRule(ctx context.Context, id int64) error {
+	res, err := s.exec(ctx, `DELETE FROM price_rules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// SaveQuote inserts a new price quote.
+func (s *sqlStore) SaveQuote(ctx context.Context, q *models.PurchaseQuote) error {
+	_, err := s.exec(ctx, `INSERT INTO price_quotes
+		(id, reseller_id, tier, package_code, package_name, payment_method, price, cost, catalog_version, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		q.ID, q.ResellerID, q.Tier, q.PackageCode, q.PackageName, q.PaymentMethod, q.Price, q.Cost,
+		q.CatalogVersion, q.CreatedAt, q.ExpiresAt)
+	return err
+}
+
+// GetQuote returns a quote by ID, or sql.ErrNoRows.
+func (s *sqlStore) GetQuote(ctx context.Context, id string) (*models.PurchaseQuote, error) {
+	q := &models.PurchaseQuote{}
+	var packageName, transactionID sql.NullString
+	var usedAt sql.NullTime
+
+	err := s.queryRow(ctx, `SELECT id, reseller_id, tier, package_code, package_name, payment_method, price, cost,
+		catalog_version, created_at, expires_at, used_at, transaction_id
+		FROM price_quotes WHERE id = ?`, id).Scan(
+		&q.ID, &q.ResellerID, &q.Tier, &q.PackageCode, &packageName, &q.PaymentMethod, &q.Price, &q.Cost,
+		&q.CatalogVersion, &q.CreatedAt, &q.ExpiresAt, &usedAt, &transactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	q.PackageName = packageName.String
+	q.TransactionID = transactionID.String
+	if usedAt.Valid {
+		q.UsedAt = &usedAt.Time
+	}
+	return q, nil
+}
+
+// RedeemQuote marks an unused quote as used by a transaction. It returns
+// sql.ErrNoRows if the quote does not exist or was already used.
+func (s *sqlStore) RedeemQuote(ctx context.Context, id, transactionID string, usedAt time.Time) error {
+	res, err := s.exec(ctx, `UPDATE price_quotes SET used_at = ?, transaction_id = ?
+		WHERE id = ? AND used_at IS NULL`, usedAt, transactionID, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteExpiredQuotes removes unused quotes that expired before the given time.
+func (s *sqlStore) DeleteExpiredQuotes(ctx context.Context, before time.Time) (int64, error) {
+	// expires_at is stored as text in the local zone, so compare in the same zone.
+	res, err := s.exec(ctx, `DELETE FROM price_quotes WHERE used_at IS NULL AND expires_at < ?`, before.In(time.Local))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
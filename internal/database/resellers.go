@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+const resellerColumns = `id, name, key_prefix, key_hash, tier, scopes, status, created_at, updated_at`
+
+func scanReseller(row rowScanner) (*models.Reseller, error) {
+	r := &models.Reseller{}
+	var scopes string
+	if err := row.Scan(&r.ID, &r.Name, &r.KeyPrefix, &r.KeyHash, &r.Tier, &scopes,
+		&r.Status, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	r.Scopes = []string{}
+	if scopes != "" {
+		r.Scopes = strings.Split(scopes, ",")
+	}
+	return r, nil
+}
+
+// CreateReseller inserts a reseller and sets its ID.
+func (s *sqlStore) CreateReseller(ctx context.Context, r *models.Reseller) error {
+	return s.queryRow(ctx, `INSERT INTO resellers
+		(name, key_prefix, key_hash, tier, scopes, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		r.Name, r.KeyPrefix, r.KeyHash, r.Tier, strings.Join(r.Scopes, ","), r.Status, r.CreatedAt, r.UpdatedAt).
+		Scan(&r.ID)
+}
+
+// UpdateReseller stores all mutable fields of a reseller, including its key hash.
+// It returns sql.ErrNoRows if the reseller does not exist.
+func (s *sqlStore) UpdateReseller(ctx context.Context, r *models.Reseller) error {
+	res, err := s.exec(ctx, `UPDATE resellers SET name = ?, key_prefix = ?, key_hash = ?, tier = ?,
+		scopes = ?, status = ?, updated_at = ? WHERE id = ?`,
+		r.Name, r.KeyPrefix, r.KeyHash, r.Tier, strings.Join(r.Scopes, ","), r.Status, r.UpdatedAt, r.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetReseller returns a reseller by ID, or sql.ErrNoRows.
+func (s *sqlStore) GetReseller(ctx context.Context, id int64) (*models.Reseller, error) {
+	return scanReseller(s.queryRow(ctx, `SELECT `+resellerColumns+` FROM resellers WHERE id = ?`, id))
+}
+
+// GetResellerByKeyHash returns the reseller owning an API key hash, or sql.ErrNoRows.
+func (s *sqlStore) GetResellerByKeyHash(ctx context.Context, keyHash string) (*models.Reseller, error) {
+	return scanReseller(s.queryRow(ctx, `SELECT `+resellerColumns+` FROM resellers WHERE key_hash = ?`, keyHash))
+}
+
+// GetResellers returns all resellers ordered by ID.
+func (s *sqlStore) GetResellers(ctx context.Context) ([]models.Reseller, error) {
+	rows, err := s.query(ctx, `SELECT `+resellerColumns+` FROM resellers ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	resellers := []models.Reseller{}
+	for rows.Next() {
+		r, err := scanReseller(rows)
+		if err != nil {
+			return nil, err
+		}
+		resellers = append(resellers, *r)
+	}
+	return resellers, rows.Err()
+}
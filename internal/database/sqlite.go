@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDialect is the default backend, a single database file.
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string   { return DriverSQLite }
+func (sqliteDialect) driver() string { return "sqlite3" }
+
+func (sqliteDialect) rebind(query string) string { return query }
+
+func (sqliteDialect) translateDDL(ddl string) string { return ddl }
+
+func (sqliteDialect) dayKey(column string) string {
+	// Timestamps are local-zone text; date() would convert them to UTC.
+	return `substr(` + column + `, 1, 10)`
+}
+
+// adoptLegacySchema brings a transactions table created before versioned
+// migrations up to the columns of the baseline migration, whose statements
+// are otherwise skipped for existing tables.
+func (sqliteDialect) adoptLegacySchema(ctx context.Context, s *sqlStore) error {
+	var exists int
+	if err := s.queryRow(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'transactions'`).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return nil
+	}
+	return s.upgradeTransactionsTable(ctx)
+}
+
+// lockMigrations does nothing: a SQLite file is served by a single instance,
+// which migrates it once on startup.
+func (sqliteDialect) lockMigrations(ctx context.Context, s *sqlStore) (func(), error) {
+	return func() {}, nil
+}
+
+// lockAccount does nothing: a SQLite file is served by a single instance,
+// whose WalletService serializes its postings.
+func (sqliteDialect) lockAccount(tx *storeTx, account string) error { return nil }
+
+// isUniqueViolation matches unique indexes and primary keys, which SQLite
+// reports with a code of their own.
+func (sqliteDialect) isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
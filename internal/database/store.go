@@ -0,0 +1,365 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Store is the persistence layer of the service. Every table is reached
+// through one of the embedded interfaces; add a new one for a new table.
+// Lookups of a single row return sql.ErrNoRows when it does not exist.
+type Store interface {
+	TransactionStore
+	OTPStore
+	XLTokenStore
+	PricingStore
+	QuoteStore
+	ResellerStore
+	AuthTokenStore
+	LedgerStore
+	DepositStore
+	WebhookStore
+
+	// Driver returns the name of the backend, "sqlite" or "postgres".
+	Driver() string
+	// Migrate applies the pending schema migrations and returns their versions.
+	Migrate(ctx context.Context) ([]int, error)
+	// MigrationStatus compares the embedded migrations with the applied ones.
+	MigrationStatus(ctx context.Context) ([]MigrationState, error)
+	Ping() error
+	Close() error
+}
+
+// TransactionStore persists purchases and their status history.
+type TransactionStore interface {
+	SaveTransaction(ctx context.Context, tx *models.TransactionRecord) error
+	InsertTransaction(ctx context.Context, tx *models.TransactionRecord) error
+	SaveTransactionWithEvent(ctx context.Context, tx *models.TransactionRecord, event *models.TransactionEvent) error
+	InsertTransactionWithEvent(ctx context.Context, tx *models.TransactionRecord, event *models.TransactionEvent) error
+	GetTransaction(ctx context.Context, id string) (*models.TransactionRecord, error)
+	GetTransactionByIdempotencyKey(ctx context.Context, resellerID int64, key string) (*models.TransactionRecord, error)
+	GetTransactionEvents(ctx context.Context, transactionID string) ([]models.TransactionEvent, error)
+	GetUnsettledTransactions(ctx context.Context, statuses []string, since time.Time) ([]*models.TransactionRecord, error)
+	CountPackagePurchasesSince(ctx context.Context, packageCode string, since time.Time) (int, error)
+	QueryTransactions(ctx context.Context, q *models.TransactionQuery) ([]models.TransactionRecord, string, error)
+	ForEachTransaction(ctx context.Context, q *models.TransactionQuery, fn func(*models.TransactionRecord) error) error
+	SumTransactions(ctx context.Context, q *models.TransactionQuery) (models.TransactionTotals, error)
+	SumTransactionsBySource(ctx context.Context, q *models.TransactionQuery) ([]models.TransactionTotals, error)
+	SumTransactionsByDay(ctx context.Context, q *models.TransactionQuery) ([]models.TransactionTotals, error)
+}
+
+// OTPStore persists the auth_ids between OTP request and verification.
+type OTPStore interface {
+	SaveOTPSession(ctx context.Context, session *models.OTPSession) error
+	GetOTPSession(ctx context.Context, resellerID int64, phone, requestID string) (*models.OTPSession, error)
+	DeleteOTPSessions(ctx context.Context, resellerID int64, phone, requestID string) error
+	DeleteExpiredOTPSessions(ctx context.Context, before time.Time) (int64, error)
+}
+
+// XLTokenStore persists encrypted XL access tokens per reseller and phone.
+type XLTokenStore interface {
+	SaveXLToken(ctx context.Context, resellerID int64, phone string, nonce, ciphertext []byte, createdAt, expiresAt time.Time) error
+	GetXLToken(ctx context.Context, resellerID int64, phone string) (nonce, ciphertext []byte, status models.XLTokenStatus, err error)
+	DeleteXLToken(ctx context.Context, resellerID int64, phone string) error
+}
+
+// PricingStore persists price tiers and their markup rules.
+type PricingStore interface {
+	GetPriceTiers(ctx context.Context) ([]models.PriceTier, error)
+	SavePriceTier(ctx context.Context, tier *models.PriceTier) error
+	DeletePriceTier(ctx context.Context, name string) error
+	GetPriceRules(ctx context.Context, tier string) ([]models.PriceRule, error)
+	SavePriceRule(ctx context.Context, rule *models.PriceRule) error
+	DeletePriceRule(ctx context.Context, id int64) error
+}
+
+// QuoteStore persists price quotes.
+type QuoteStore interface {
+	SaveQuote(ctx context.Context, q *models.PurchaseQuote) error
+	GetQuote(ctx context.Context, id string) (*models.PurchaseQuote, error)
+	RedeemQuote(ctx context.Context, id, transactionID string, usedAt time.Time) error
+	DeleteExpiredQuotes(ctx context.Context, before time.Time) (int64, error)
+}
+
+// ResellerStore persists reseller accounts.
+type ResellerStore interface {
+	CreateReseller(ctx context.Context, r *models.Reseller) error
+	UpdateReseller(ctx context.Context, r *models.Reseller) error
+	GetReseller(ctx context.Context, id int64) (*models.Reseller, error)
+	GetResellerByKeyHash(ctx context.Context, keyHash string) (*models.Reseller, error)
+	GetResellers(ctx context.Context) ([]models.Reseller, error)
+}
+
+// AuthTokenStore persists refresh tokens and revoked access token IDs.
+type AuthTokenStore interface {
+	SaveRefreshToken(ctx context.Context, t *models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, id string, revokedAt time.Time) error
+	RotateRefreshToken(ctx context.Context, id string, rotatedAt time.Time) error
+	RevokeRefreshTokensBySubject(ctx context.Context, subject string, revokedAt time.Time) (int64, error)
+	RevokeTokenID(ctx context.Context, jti string, expiresAt, revokedAt time.Time) error
+	GetRevokedTokenIDs(ctx context.Context, now time.Time) (map[string]time.Time, error)
+	IsTokenIDRevoked(ctx context.Context, jti string) (bool, error)
+	DeleteExpiredAuthTokens(ctx context.Context, before time.Time) (int64, error)
+}
+
+// LedgerStore persists the double-entry ledger of reseller wallets.
+type LedgerStore interface {
+	PostJournal(ctx context.Context, j *models.LedgerJournal) error
+	PostCoveredJournal(ctx context.Context, j *models.LedgerJournal, account string) error
+	JournalExists(ctx context.Context, kind, reference string) (bool, error)
+	AccountBalance(ctx context.Context, account string) (int, error)
+	ReferenceBalance(ctx context.Context, account, reference string) (int, error)
+	GetLedgerJournals(ctx context.Context, resellerID int64, limit int) ([]models.LedgerJournal, error)
+	GetTransactionsAwaitingSettlement(ctx context.Context) ([]*models.TransactionRecord, error)
+}
+
+// DepositStore persists reseller deposit requests.
+type DepositStore interface {
+	CreateDeposit(ctx context.Context, d *models.Deposit) error
+	GetDeposit(ctx context.Context, id string) (*models.Deposit, error)
+	GetDeposits(ctx context.Context, resellerID int64, status string, limit int) ([]models.Deposit, error)
+	PendingTransferAmountExists(ctx context.Context, transferAmount int) (bool, error)
+	ReviewDeposit(ctx context.Context, id, status, note, reviewedBy string, reviewedAt time.Time) error
+}
+
+// WebhookStore persists webhook endpoints and their delivery log.
+type WebhookStore interface {
+	CreateWebhookEndpoint(ctx context.Context, e *models.WebhookEndpoint) error
+	UpdateWebhookEndpoint(ctx context.Context, e *models.WebhookEndpoint) error
+	DeleteWebhookEndpoint(ctx context.Context, id int64) error
+	GetWebhookEndpoint(ctx context.Context, id int64) (*models.WebhookEndpoint, error)
+	GetWebhookEndpoints(ctx context.Context, resellerID int64, activeOnly bool) ([]models.WebhookEndpoint, error)
+	EnqueueWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error
+	GetWebhookDelivery(ctx context.Context, id int64) (*models.WebhookDelivery, error)
+	GetWebhookDeliveries(ctx context.Context, endpointID int64, limit int) ([]models.WebhookDelivery, error)
+	GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error)
+	RecordWebhookAttempt(ctx context.Context, d *models.WebhookDelivery, a *models.WebhookAttempt) error
+	ResetWebhookDelivery(ctx context.Context, id int64, dueAt time.Time) error
+}
+
+// dialect holds what differs between the SQL backends. Queries are written
+// with "?" placeholders and in the subset of SQL both backends understand.
+type dialect interface {
+	// name is the DB_DRIVER value of the backend.
+	name() string
+	// driver is the database/sql driver of the backend.
+	driver() string
+	// rebind rewrites the "?" placeholders of a query.
+	rebind(query string) string
+	// translateDDL maps the column types of a migration, which are written
+	// for SQLite, to the types of the backend.
+	translateDDL(ddl string) string
+	// dayKey returns an expression formatting a timestamp column as YYYY-MM-DD.
+	dayKey(column string) string
+	// adoptLegacySchema prepares a database created before versioned
+	// migrations for the baseline migration.
+	adoptLegacySchema(ctx context.Context, s *sqlStore) error
+	// lockMigrations blocks until no other instance migrates the database
+	// and keeps it that way until unlock is called.
+	lockMigrations(ctx context.Context, s *sqlStore) (unlock func(), err error)
+	// lockAccount makes other transactions that lock the same ledger account
+	// wait until tx ends.
+	lockAccount(tx *storeTx, account string) error
+	isUniqueViolation(err error) bool
+}
+
+// sqlStore implements Store on database/sql for every dialect.
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+var _ Store = (*sqlStore)(nil)
+
+// Supported values of DB_DRIVER.
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// OpenDatabase connects to the database without touching its schema. dsn is
+// a file path for SQLite and a connection URL for PostgreSQL.
+func OpenDatabase(driver, dsn string) (Store, error) {
+	var d dialect
+	switch driver {
+	case DriverSQLite, "sqlite3":
+		d = sqliteDialect{}
+	case DriverPostgres, "postgresql":
+		d = postgresDialect{}
+	default:
+		return nil, fmt.Errorf("unknown database driver %q (expected %s or %s)", driver, DriverSQLite, DriverPostgres)
+	}
+
+	db, err := sql.Open(d.driver(), dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	return &sqlStore{db: db, dialect: d}, nil
+}
+
+// InitDatabase connects to the database and applies pending schema migrations.
+func InitDatabase(driver, dsn string) (Store, error) {
+	store, err := OpenDatabase(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	s := store.(*sqlStore)
+
+	ctx := context.Background()
+	if _, err := s.Migrate(ctx); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+
+	if err := s.seedPriceTiers(ctx); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to seed price tiers: %v", err)
+	}
+
+	log.Printf("Database initialized successfully (%s)", s.Driver())
+	return s, nil
+}
+
+// IsUniqueViolation reports whether err was caused by a unique constraint,
+// e.g. a concurrent insert of the same idempotency key.
+func IsUniqueViolation(err error) bool {
+	return err != nil && (sqliteDialect{}.isUniqueViolation(err) || postgresDialect{}.isUniqueViolation(err))
+}
+
+func (s *sqlStore) Driver() string { return s.dialect.name() }
+
+func (s *sqlStore) Ping() error { return s.db.Ping() }
+
+func (s *sqlStore) Close() error { return s.db.Close() }
+
+// startSpan starts a span for one statement. Only the first line of the
+// query is recorded, which names the statement without its column lists.
+func (s *sqlStore) startSpan(ctx context.Context, operation, query string) (context.Context, trace.Span) {
+	statement, _, _ := strings.Cut(strings.TrimSpace(query), "\n")
+	return tracing.StartClient(ctx, "db."+operation,
+		semconv.DBSystemKey.String(s.dialect.name()),
+		semconv.DBQueryText(statement))
+}
+
+func (s *sqlStore) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := s.startSpan(ctx, "exec", query)
+	res, err := s.db.ExecContext(ctx, s.dialect.rebind(query), args...)
+	tracing.End(span, err)
+	return res, err
+}
+
+// query runs a query whose span ends when the rows are closed, since most of
+// the time of a query is spent reading them.
+func (s *sqlStore) query(ctx context.Context, query string, args ...interface{}) (*storeRows, error) {
+	ctx, span := s.startSpan(ctx, "query", query)
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	return &storeRows{Rows: rows, span: span}, nil
+}
+
+// storeRows are the rows of a query; Close ends the span of the query.
+type storeRows struct {
+	*sql.Rows
+	span trace.Span
+}
+
+func (r *storeRows) Close() error {
+	err := r.Rows.Close()
+	if r.span != nil {
+		spanErr := err
+		if spanErr == nil {
+			spanErr = r.Rows.Err()
+		}
+		tracing.End(r.span, spanErr)
+		r.span = nil
+	}
+	return err
+}
+
+func (s *sqlStore) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := s.startSpan(ctx, "query", query)
+	defer span.End()
+	return s.db.QueryRowContext(ctx, s.dialect.rebind(query), args...)
+}
+
+// storeTx is a database transaction that rebinds placeholders like sqlStore.
+// Its span covers all statements until Commit or Rollback.
+type storeTx struct {
+	*sql.Tx
+	ctx     context.Context
+	span    trace.Span
+	dialect dialect
+}
+
+func (s *sqlStore) begin(ctx context.Context) (*storeTx, error) {
+	ctx, span := tracing.StartClient(ctx, "db.transaction", semconv.DBSystemKey.String(s.dialect.name()))
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	return &storeTx{Tx: tx, ctx: ctx, span: span, dialect: s.dialect}, nil
+}
+
+func (tx *storeTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.ExecContext(tx.ctx, tx.dialect.rebind(query), args...)
+}
+
+func (tx *storeTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRowContext(tx.ctx, tx.dialect.rebind(query), args...)
+}
+
+func (tx *storeTx) Commit() error {
+	err := tx.Tx.Commit()
+	tracing.End(tx.span, err)
+	return err
+}
+
+// Rollback aborts the transaction. It is deferred after Commit as well, in
+// which case it does nothing.
+func (tx *storeTx) Rollback() error {
+	err := tx.Tx.Rollback()
+	if err != sql.ErrTxDone {
+		tracing.End(tx.span, nil)
+	}
+	return err
+}
+
+// numberedPlaceholders rewrites "?" placeholders outside string literals to
+// $1, $2, ... as PostgreSQL expects.
+func numberedPlaceholders(query string) string {
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,751 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// postgresTestURL names the variable with the URL of a PostgreSQL database
+// for the conformance tests. Each test works in a schema of its own, which is
+// dropped afterwards. Without it only SQLite is tested.
+const postgresTestURL = "NADIA_TEST_DATABASE_URL"
+
+// backend opens empty, unmigrated stores of one driver.
+type backend struct {
+	driver string
+	open   func(t *testing.T) func() *sqlStore
+}
+
+// backends returns the backends to run the conformance tests against.
+func backends(t *testing.T) []backend {
+	list := []backend{{driver: DriverSQLite, open: openSQLite}}
+	if os.Getenv(postgresTestURL) != "" {
+		list = append(list, backend{driver: DriverPostgres, open: openPostgres})
+	} else {
+		t.Logf("%s is not set, skipping PostgreSQL", postgresTestURL)
+	}
+	return list
+}
+
+// forEachBackend runs test against a fresh, migrated store of every backend.
+func forEachBackend(t *testing.T, test func(t *testing.T, s *sqlStore)) {
+	for _, b := range backends(t) {
+		t.Run(b.driver, func(t *testing.T) {
+			s := b.open(t)()
+			if _, err := s.Migrate(context.Background()); err != nil {
+				t.Fatalf("Migrate: %v", err)
+			}
+			test(t, s)
+		})
+	}
+}
+
+// openSQLite returns a func opening stores on one new database file.
+func openSQLite(t *testing.T) func() *sqlStore {
+	path := filepath.Join(t.TempDir(), "nadia.db")
+	return func() *sqlStore { return mustOpen(t, DriverSQLite, path) }
+}
+
+// openPostgres returns a func opening stores on one new schema.
+func openPostgres(t *testing.T) func() *sqlStore {
+	dsn := os.Getenv(postgresTestURL)
+	admin := mustOpen(t, DriverPostgres, dsn)
+	schema := fmt.Sprintf("nadia_test_%d", time.Now().UnixNano())
+	if _, err := admin.db.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.db.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatalf("parse %s: %v", postgresTestURL, err)
+		}
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + schema
+	}
+	return func() *sqlStore { return mustOpen(t, DriverPostgres, dsn) }
+}
+
+func mustOpen(t *testing.T, driver, dsn string) *sqlStore {
+	store, err := OpenDatabase(driver, dsn)
+	if err != nil {
+		t.Fatalf("OpenDatabase(%s): %v", driver, err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store.(*sqlStore)
+}
+
+// testTime is a fixed instant with the microsecond precision of PostgreSQL.
+var testTime = time.Date(2025, 3, 14, 9, 30, 0, 123456000, time.Local)
+
+func newTestTransaction(id string, createdAt time.Time) *models.TransactionRecord {
+	return &models.TransactionRecord{
+		ID:            id,
+		PhoneNumber:   "6287786388052",
+		PackageCode:   "XL_MASTIF_30D",
+		PackageName:   "Mastif 30 Hari",
+		PaymentMethod: "BALANCE",
+		Source:        "api",
+		Status:        models.TxStatusPending,
+		Amount:        25000,
+		CreatedAt:     createdAt,
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+
+	for _, b := range backends(t) {
+		t.Run(b.driver, func(t *testing.T) {
+			s := b.open(t)()
+			ctx := context.Background()
+
+			applied, err := s.Migrate(ctx)
+			if err != nil {
+				t.Fatalf("Migrate: %v", err)
+			}
+			if len(applied) != len(migrations) {
+				t.Errorf("first Migrate applied %v, want all %d migrations", applied, len(migrations))
+			}
+
+			applied, err = s.Migrate(ctx)
+			if err != nil {
+				t.Fatalf("second Migrate: %v", err)
+			}
+			if len(applied) != 0 {
+				t.Errorf("second Migrate applied %v, want none", applied)
+			}
+
+			states, err := s.MigrationStatus(ctx)
+			if err != nil {
+				t.Fatalf("MigrationStatus: %v", err)
+			}
+			for _, state := range states {
+				if state.Status != "applied" || state.AppliedAt == nil {
+					t.Errorf("migration %04d_%s is %s", state.Version, state.Name, state.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateConcurrently(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+
+	for _, b := range backends(t) {
+		if b.driver == DriverSQLite {
+			// A SQLite file is migrated by the single instance serving it.
+			continue
+		}
+		t.Run(b.driver, func(t *testing.T) {
+			open := b.open(t)
+			stores := []*sqlStore{open(), open(), open()}
+
+			var wg sync.WaitGroup
+			applied := make([][]int, len(stores))
+			errs := make([]error, len(stores))
+			for i, s := range stores {
+				wg.Add(1)
+				go func(i int, s *sqlStore) {
+					defer wg.Done()
+					applied[i], errs[i] = s.Migrate(context.Background())
+				}(i, s)
+			}
+			wg.Wait()
+
+			total := 0
+			for i := range stores {
+				if errs[i] != nil {
+					t.Errorf("Migrate %d: %v", i, errs[i])
+				}
+				total += len(applied[i])
+			}
+			if total != len(migrations) {
+				t.Errorf("instances applied %v together, want each of the %d migrations once", applied, len(migrations))
+			}
+		})
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"empty", "-- nothing here\n\n", nil},
+		{"comments dropped", "-- header\nCREATE TABLE a (id TEXT);\n-- next\nDROP INDEX b;\n", []string{"CREATE TABLE a (id TEXT)", "DROP INDEX b"}},
+		{"multi-line", "CREATE INDEX i\n\tON t(a);", []string{"CREATE INDEX i\n\tON t(a)"}},
+		{"no trailing semicolon", "DROP INDEX a;\nDROP INDEX b", []string{"DROP INDEX a", "DROP INDEX b"}},
+		{"semicolon in literal", "INSERT INTO t VALUES ('a;b');", []string{"INSERT INTO t VALUES ('a;b')"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitStatements(tt.script)
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("splitStatements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransactionUpsert(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		tx := newTestTransaction("tx-1", testTime)
+		tx.IdempotencyKey = "key-1"
+
+		if err := s.InsertTransaction(ctx, tx); err != nil {
+			t.Fatalf("InsertTransaction: %v", err)
+		}
+		if err := s.InsertTransaction(ctx, tx); !IsUniqueViolation(err) {
+			t.Errorf("InsertTransaction of an existing ID = %v, want a unique violation", err)
+		}
+		other := newTestTransaction("tx-2", testTime)
+		other.IdempotencyKey = tx.IdempotencyKey
+		if err := s.InsertTransaction(ctx, other); !IsUniqueViolation(err) {
+			t.Errorf("InsertTransaction of an existing idempotency key = %v, want a unique violation", err)
+		}
+		// Keys are unique per reseller only.
+		other.ResellerID = 9
+		if err := s.InsertTransaction(ctx, other); err != nil {
+			t.Errorf("InsertTransaction of another reseller's idempotency key: %v", err)
+		}
+
+		// ON CONFLICT updates the row in place and keeps its creation time.
+		completedAt := testTime.Add(time.Minute)
+		tx.Status = models.TxStatusSuccess
+		tx.TrxID = "trx-1"
+		tx.CompletedAt = &completedAt
+		tx.IsQris = true
+		submittedAt := testTime.Add(time.Second)
+		tx.SubmittedAt = &submittedAt
+		tx.CreatedAt = testTime.Add(time.Hour)
+		if err := s.SaveTransaction(ctx, tx); err != nil {
+			t.Fatalf("SaveTransaction: %v", err)
+		}
+
+		got, err := s.GetTransaction(ctx, "tx-1")
+		if err != nil {
+			t.Fatalf("GetTransaction: %v", err)
+		}
+		if got.Status != models.TxStatusSuccess || got.TrxID != "trx-1" || !got.IsQris {
+			t.Errorf("GetTransaction = %+v, want the saved status, trx_id and QRIS flag", got)
+		}
+		if !got.CreatedAt.Equal(testTime) {
+			t.Errorf("created_at = %v, want %v kept from the insert", got.CreatedAt, testTime)
+		}
+		if got.CompletedAt == nil || !got.CompletedAt.Equal(completedAt) {
+			t.Errorf("completed_at = %v, want %v", got.CompletedAt, completedAt)
+		}
+		if got.SubmittedAt == nil || !got.SubmittedAt.Equal(submittedAt) {
+			t.Errorf("submitted_at = %v, want %v", got.SubmittedAt, submittedAt)
+		}
+
+		for reseller, want := range map[int64]string{0: "tx-1", 9: "tx-2"} {
+			byKey, err := s.GetTransactionByIdempotencyKey(ctx, reseller, "key-1")
+			if err != nil || byKey.ID != want {
+				t.Errorf("GetTransactionByIdempotencyKey(%d) = %v, %v, want %s", reseller, byKey, err, want)
+			}
+		}
+		if _, err := s.GetTransactionByIdempotencyKey(ctx, 3, "key-1"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetTransactionByIdempotencyKey of a reseller without the key = %v, want sql.ErrNoRows", err)
+		}
+		if _, err := s.GetTransaction(ctx, "missing"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetTransaction of a missing ID = %v, want sql.ErrNoRows", err)
+		}
+	})
+}
+
+func TestTransactionEventsReturning(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		tx := newTestTransaction("tx-1", testTime)
+
+		created := &models.TransactionEvent{TransactionID: tx.ID, ToStatus: tx.Status, Actor: "api", CreatedAt: testTime}
+		if err := s.InsertTransactionWithEvent(ctx, tx, created); err != nil {
+			t.Fatalf("InsertTransactionWithEvent: %v", err)
+		}
+		tx.Status = models.TxStatusSuccess
+		settled := &models.TransactionEvent{TransactionID: tx.ID, FromStatus: models.TxStatusPending, ToStatus: tx.Status,
+			Actor: "reconciler", Payload: []byte(`{"status":"SUCCESS"}`), CreatedAt: testTime.Add(time.Second)}
+		if err := s.SaveTransactionWithEvent(ctx, tx, settled); err != nil {
+			t.Fatalf("SaveTransactionWithEvent: %v", err)
+		}
+		if created.ID == 0 || settled.ID <= created.ID {
+			t.Errorf("RETURNING gave event IDs %d and %d, want increasing IDs", created.ID, settled.ID)
+		}
+
+		// A failed insert rolls the event back with the transaction.
+		duplicate := &models.TransactionEvent{TransactionID: tx.ID, ToStatus: tx.Status, Actor: "api", CreatedAt: testTime}
+		if err := s.InsertTransactionWithEvent(ctx, tx, duplicate); !IsUniqueViolation(err) {
+			t.Errorf("InsertTransactionWithEvent of an existing ID = %v, want a unique violation", err)
+		}
+
+		events, err := s.GetTransactionEvents(ctx, tx.ID)
+		if err != nil {
+			t.Fatalf("GetTransactionEvents: %v", err)
+		}
+		if len(events) != 2 {
+			t.Fatalf("got %d events, want 2", len(events))
+		}
+		if events[1].ID != settled.ID || events[1].FromStatus != models.TxStatusPending || string(events[1].Payload) != `{"status":"SUCCESS"}` {
+			t.Errorf("second event = %+v", events[1])
+		}
+	})
+}
+
+func TestQueryTransactionsPagination(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		// tx-b and tx-c share a creation time, so the ID breaks the tie.
+		offsets := map[string]time.Duration{"tx-a": 0, "tx-b": time.Second, "tx-c": time.Second, "tx-d": 2 * time.Second, "tx-e": 3 * time.Second}
+		for id, offset := range offsets {
+			tx := newTestTransaction(id, testTime.Add(offset))
+			if id == "tx-d" {
+				tx.Status = models.TxStatusFailed
+			}
+			if err := s.InsertTransaction(ctx, tx); err != nil {
+				t.Fatalf("InsertTransaction(%s): %v", id, err)
+			}
+		}
+
+		pages := func(q models.TransactionQuery) []string {
+			var ids []string
+			for page := 0; page < 10; page++ {
+				result, next, err := s.QueryTransactions(ctx, &q)
+				if err != nil {
+					t.Fatalf("QueryTransactions: %v", err)
+				}
+				for _, tx := range result {
+					ids = append(ids, tx.ID)
+				}
+				if next == "" {
+					return ids
+				}
+				q.Cursor = next
+			}
+			t.Fatal("QueryTransactions never returned the last page")
+			return nil
+		}
+
+		tests := []struct {
+			name  string
+			query models.TransactionQuery
+			want  string
+		}{
+			{"newest first", models.TransactionQuery{Limit: 2}, "tx-e tx-d tx-c tx-b tx-a"},
+			{"oldest first", models.TransactionQuery{Limit: 2, Ascending: true}, "tx-a tx-b tx-c tx-d tx-e"},
+			{"page size one", models.TransactionQuery{Limit: 1, Ascending: true}, "tx-a tx-b tx-c tx-d tx-e"},
+			{"single page", models.TransactionQuery{Limit: 10}, "tx-e tx-d tx-c tx-b tx-a"},
+			{"filtered", models.TransactionQuery{Limit: 2, Statuses: []string{models.TxStatusPending}}, "tx-e tx-c tx-b tx-a"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if got := strings.Join(pages(tt.query), " "); got != tt.want {
+					t.Errorf("pages = %s, want %s", got, tt.want)
+				}
+			})
+		}
+
+		if _, _, err := s.QueryTransactions(ctx, &models.TransactionQuery{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("QueryTransactions with a bad cursor = %v, want ErrInvalidCursor", err)
+		}
+
+		totals, err := s.SumTransactions(ctx, &models.TransactionQuery{})
+		if err != nil {
+			t.Fatalf("SumTransactions: %v", err)
+		}
+		if totals.Total != 5 || totals.Failed != 1 || totals.Pending != 4 {
+			t.Errorf("SumTransactions = %+v, want 5 in total, 1 failed and 4 pending", totals)
+		}
+		days, err := s.SumTransactionsByDay(ctx, &models.TransactionQuery{})
+		if err != nil {
+			t.Fatalf("SumTransactionsByDay: %v", err)
+		}
+		if len(days) != 1 || days[0].Key != testTime.Format("2006-01-02") || days[0].Total != 5 {
+			t.Errorf("SumTransactionsByDay = %+v, want one day %s", days, testTime.Format("2006-01-02"))
+		}
+	})
+}
+
+func TestTransactionCursor(t *testing.T) {
+	createdAt := time.Date(2025, 3, 14, 9, 30, 0, 123456789, time.FixedZone("WIB", 7*3600))
+	cursor := encodeTransactionCursor(&models.TransactionRecord{ID: "TXN_1|a", CreatedAt: createdAt})
+	gotTime, gotID, err := decodeTransactionCursor(cursor)
+	if err != nil || !gotTime.Equal(createdAt) || gotID != "TXN_1|a" {
+		t.Errorf("decodeTransactionCursor(encodeTransactionCursor) = %v, %q, %v, want %v, TXN_1|a", gotTime, gotID, err, createdAt)
+	}
+
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"empty", ""},
+		{"not base64", "not a cursor!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("2025-03-14T09:30:00Z|TXN_1"))},
+		{"no separator", encode("2025-03-14T09:30:00Z")},
+		{"no id", encode("2025-03-14T09:30:00Z|")},
+		{"bad time", encode("yesterday|TXN_1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeTransactionCursor(tt.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeTransactionCursor(%q) = %v, want ErrInvalidCursor", tt.cursor, err)
+			}
+		})
+	}
+}
+
+func TestLedger(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		post := func(kind, reference string, entries ...models.LedgerEntry) (*models.LedgerJournal, error) {
+			j := &models.LedgerJournal{ResellerID: 7, Kind: kind, Reference: reference, CreatedBy: "test", CreatedAt: testTime, Entries: entries}
+			return j, s.PostJournal(ctx, j)
+		}
+
+		deposit, err := post("deposit", "dep-1",
+			models.LedgerEntry{Account: "bank", Amount: -100000},
+			models.LedgerEntry{Account: "wallet:7", Amount: 100000})
+		if err != nil {
+			t.Fatalf("PostJournal(deposit): %v", err)
+		}
+		if deposit.ID == 0 || deposit.Entries[0].ID == 0 || deposit.Entries[1].JournalID != deposit.ID {
+			t.Errorf("PostJournal did not set the IDs returned by the database: %+v", deposit)
+		}
+
+		if _, err := post("hold", "tx-1",
+			models.LedgerEntry{Account: "wallet:7", Amount: -25000},
+			models.LedgerEntry{Account: "held:7", Amount: 25000}); err != nil {
+			t.Fatalf("PostJournal(hold): %v", err)
+		}
+		if _, err := post("hold", "tx-1",
+			models.LedgerEntry{Account: "wallet:7", Amount: -25000},
+			models.LedgerEntry{Account: "held:7", Amount: 25000}); !IsUniqueViolation(err) {
+			t.Errorf("PostJournal of a posted reference = %v, want a unique violation", err)
+		}
+		if _, err := post("hold", "tx-2",
+			models.LedgerEntry{Account: "wallet:7", Amount: -25000},
+			models.LedgerEntry{Account: "held:7", Amount: 20000}); err == nil {
+			t.Error("PostJournal of an unbalanced journal succeeded")
+		}
+
+		balances := map[string]int{"wallet:7": 75000, "held:7": 25000, "bank": -100000, "wallet:8": 0}
+		for account, want := range balances {
+			if got, err := s.AccountBalance(ctx, account); err != nil || got != want {
+				t.Errorf("AccountBalance(%s) = %d, %v, want %d", account, got, err, want)
+			}
+		}
+		if got, err := s.ReferenceBalance(ctx, "held:7", "tx-1"); err != nil || got != 25000 {
+			t.Errorf("ReferenceBalance(held:7, tx-1) = %d, %v, want 25000", got, err)
+		}
+		if ok, err := s.JournalExists(ctx, "hold", "tx-1"); err != nil || !ok {
+			t.Errorf("JournalExists(hold, tx-1) = %v, %v, want true", ok, err)
+		}
+		if ok, err := s.JournalExists(ctx, "release", "tx-1"); err != nil || ok {
+			t.Errorf("JournalExists(release, tx-1) = %v, %v, want false", ok, err)
+		}
+
+		journals, err := s.GetLedgerJournals(ctx, 7, 10)
+		if err != nil {
+			t.Fatalf("GetLedgerJournals: %v", err)
+		}
+		if len(journals) != 2 || journals[0].Kind != "hold" || journals[1].Kind != "deposit" {
+			t.Fatalf("GetLedgerJournals = %+v, want the hold and the deposit, newest first", journals)
+		}
+		for _, j := range journals {
+			if len(j.Entries) != 2 {
+				t.Errorf("journal %d has %d entries, want 2", j.ID, len(j.Entries))
+			}
+		}
+	})
+}
+
+func TestTransactionsAwaitingSettlement(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		post := func(kind, reference string) {
+			j := &models.LedgerJournal{ResellerID: 7, Kind: kind, Reference: reference, CreatedBy: "test", CreatedAt: testTime,
+				Entries: []models.LedgerEntry{{Account: "a", Amount: -100}, {Account: "b", Amount: 100}}}
+			if err := s.PostJournal(ctx, j); err != nil {
+				t.Fatalf("PostJournal(%s, %s): %v", kind, reference, err)
+			}
+		}
+
+		transactions := []struct {
+			id, status string
+			resellerID int64
+			journals   []string
+			want       bool
+		}{
+			{"success-open", models.TxStatusSuccess, 7, []string{"hold"}, true},
+			{"success-captured", models.TxStatusSuccess, 7, []string{"hold", "capture"}, false},
+			{"failed-open", models.TxStatusFailed, 7, []string{"hold"}, true},
+			{"expired-released", models.TxStatusExpired, 7, []string{"hold", "release"}, false},
+			{"processing-open", models.TxStatusProcessing, 7, []string{"hold"}, false},
+			{"refunded-captured", models.TxStatusRefunded, 7, []string{"hold", "capture"}, true},
+			{"refunded-refunded", models.TxStatusRefunded, 7, []string{"hold", "capture", "refund"}, false},
+			{"refunded-released", models.TxStatusRefunded, 7, []string{"hold", "release"}, false},
+			{"success-unheld", models.TxStatusSuccess, 7, nil, false},
+			{"admin", models.TxStatusSuccess, 0, []string{"hold"}, false},
+		}
+		want := map[string]bool{}
+		for i, tc := range transactions {
+			tx := newTestTransaction(tc.id, testTime.Add(time.Duration(i)*time.Second))
+			tx.Status = tc.status
+			tx.ResellerID = tc.resellerID
+			if err := s.InsertTransaction(ctx, tx); err != nil {
+				t.Fatalf("InsertTransaction(%s): %v", tc.id, err)
+			}
+			for _, kind := range tc.journals {
+				post(kind, tc.id)
+			}
+			want[tc.id] = tc.want
+		}
+
+		got, err := s.GetTransactionsAwaitingSettlement(ctx)
+		if err != nil {
+			t.Fatalf("GetTransactionsAwaitingSettlement: %v", err)
+		}
+		found := map[string]bool{}
+		for _, tx := range got {
+			found[tx.ID] = true
+		}
+		for id, w := range want {
+			if found[id] != w {
+				t.Errorf("GetTransactionsAwaitingSettlement returned %s = %v, want %v", id, found[id], w)
+			}
+		}
+	})
+}
+
+func TestPostCoveredJournal(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		journal := func(reference, account string, amount int) *models.LedgerJournal {
+			return &models.LedgerJournal{ResellerID: 9, Kind: "hold", Reference: reference, CreatedBy: "test", CreatedAt: testTime,
+				Entries: []models.LedgerEntry{{Account: account, Amount: -amount}, {Account: "held:9", Amount: amount}}}
+		}
+		deposit := func(account string, amount int) {
+			j := &models.LedgerJournal{ResellerID: 9, Kind: "deposit", CreatedBy: "test", CreatedAt: testTime,
+				Entries: []models.LedgerEntry{{Account: "bank", Amount: -amount}, {Account: account, Amount: amount}}}
+			if err := s.PostJournal(ctx, j); err != nil {
+				t.Fatalf("PostJournal(deposit): %v", err)
+			}
+		}
+
+		deposit("wallet:9", 100)
+		for i := 1; i <= 3; i++ {
+			if err := s.PostCoveredJournal(ctx, journal(fmt.Sprintf("tx-%d", i), "wallet:9", 30), "wallet:9"); err != nil {
+				t.Fatalf("PostCoveredJournal(tx-%d): %v", i, err)
+			}
+		}
+		if err := s.PostCoveredJournal(ctx, journal("tx-4", "wallet:9", 30), "wallet:9"); !errors.Is(err, ErrOverdrawn) {
+			t.Errorf("PostCoveredJournal beyond the balance = %v, want ErrOverdrawn", err)
+		}
+		if got, err := s.AccountBalance(ctx, "wallet:9"); err != nil || got != 10 {
+			t.Errorf("AccountBalance(wallet:9) = %d, %v, want 10", got, err)
+		}
+		if ok, err := s.JournalExists(ctx, "hold", "tx-4"); err != nil || ok {
+			t.Errorf("JournalExists(hold, tx-4) = %v, %v, want the rejected journal rolled back", ok, err)
+		}
+
+		// Concurrent postings from several instances need the database lock;
+		// a SQLite file is only written by one instance.
+		if s.Driver() != DriverPostgres {
+			return
+		}
+		deposit("wallet:10", 100)
+		var wg sync.WaitGroup
+		errs := make([]error, 10)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = s.PostCoveredJournal(ctx, journal(fmt.Sprintf("concurrent-%d", i), "wallet:10", 30), "wallet:10")
+			}(i)
+		}
+		wg.Wait()
+		posted := 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				posted++
+			case !errors.Is(err, ErrOverdrawn):
+				t.Errorf("concurrent PostCoveredJournal: %v", err)
+			}
+		}
+		if posted != 3 {
+			t.Errorf("%d concurrent postings of 30 from 100 succeeded, want 3", posted)
+		}
+		if got, err := s.AccountBalance(ctx, "wallet:10"); err != nil || got != 10 {
+			t.Errorf("AccountBalance(wallet:10) = %d, %v, want 10", got, err)
+		}
+	})
+}
+
+func TestRevokedTokenIDs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		if err := s.RevokeTokenID(ctx, "jti-1", testTime.Add(time.Hour), testTime); err != nil {
+			t.Fatalf("RevokeTokenID: %v", err)
+		}
+		// Revoking twice, as on a repeated logout, is not an error.
+		if err := s.RevokeTokenID(ctx, "jti-1", testTime.Add(time.Hour), testTime); err != nil {
+			t.Fatalf("RevokeTokenID again: %v", err)
+		}
+		for jti, want := range map[string]bool{"jti-1": true, "jti-2": false} {
+			if got, err := s.IsTokenIDRevoked(ctx, jti); err != nil || got != want {
+				t.Errorf("IsTokenIDRevoked(%s) = %v, %v, want %v", jti, got, err, want)
+			}
+		}
+	})
+}
+
+func TestXLTokensPerReseller(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		phone := "87786388052"
+		expiresAt := testTime.Add(time.Hour)
+		if err := s.SaveXLToken(ctx, 1, phone, []byte("n1"), []byte("c1"), testTime, expiresAt); err != nil {
+			t.Fatalf("SaveXLToken(1): %v", err)
+		}
+		if err := s.SaveXLToken(ctx, 2, phone, []byte("n2"), []byte("c2"), testTime, expiresAt); err != nil {
+			t.Fatalf("SaveXLToken(2): %v", err)
+		}
+		// Saving again replaces the token of that reseller only.
+		if err := s.SaveXLToken(ctx, 1, phone, []byte("n3"), []byte("c3"), testTime, expiresAt); err != nil {
+			t.Fatalf("SaveXLToken(1) again: %v", err)
+		}
+
+		for reseller, want := range map[int64]string{1: "c3", 2: "c2"} {
+			_, ciphertext, status, err := s.GetXLToken(ctx, reseller, phone)
+			if err != nil || string(ciphertext) != want || !status.ExpiresAt.Equal(expiresAt) {
+				t.Errorf("GetXLToken(%d) = %q, %+v, %v, want %q", reseller, ciphertext, status, err, want)
+			}
+		}
+		if _, _, _, err := s.GetXLToken(ctx, 3, phone); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetXLToken of another reseller = %v, want sql.ErrNoRows", err)
+		}
+		if err := s.DeleteXLToken(ctx, 3, phone); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("DeleteXLToken of another reseller = %v, want sql.ErrNoRows", err)
+		}
+		if err := s.DeleteXLToken(ctx, 1, phone); err != nil {
+			t.Errorf("DeleteXLToken(1): %v", err)
+		}
+		if _, _, _, err := s.GetXLToken(ctx, 2, phone); err != nil {
+			t.Errorf("GetXLToken(2) after deleting the token of 1: %v", err)
+		}
+	})
+}
+
+func TestDeletePriceTierInUse(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		if err := s.SavePriceTier(ctx, &models.PriceTier{Name: "gold"}); err != nil {
+			t.Fatalf("SavePriceTier: %v", err)
+		}
+		reseller := &models.Reseller{Name: "shop", KeyPrefix: "rk_1", KeyHash: "hash-1", Tier: "gold",
+			Status: "active", CreatedAt: testTime, UpdatedAt: testTime}
+		if err := s.CreateReseller(ctx, reseller); err != nil {
+			t.Fatalf("CreateReseller: %v", err)
+		}
+
+		if err := s.DeletePriceTier(ctx, "gold"); !errors.Is(err, ErrTierInUse) {
+			t.Errorf("DeletePriceTier of an assigned tier = %v, want ErrTierInUse", err)
+		}
+		reseller.Tier = models.PriceTierReseller
+		if err := s.UpdateReseller(ctx, reseller); err != nil {
+			t.Fatalf("UpdateReseller: %v", err)
+		}
+		if err := s.DeletePriceTier(ctx, "gold"); err != nil {
+			t.Errorf("DeletePriceTier of an unassigned tier: %v", err)
+		}
+		if err := s.DeletePriceTier(ctx, "gold"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("DeletePriceTier of a missing tier = %v, want sql.ErrNoRows", err)
+		}
+	})
+}
+
+func TestQuoteOwner(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		quote := &models.PurchaseQuote{ID: "QUO_1", ResellerID: 7, Tier: "gold", PackageCode: "XL_MASTIF_30D",
+			PaymentMethod: "BALANCE", Price: 26000, Cost: 25000, CreatedAt: testTime, ExpiresAt: testTime.Add(5 * time.Minute)}
+		if err := s.SaveQuote(ctx, quote); err != nil {
+			t.Fatalf("SaveQuote: %v", err)
+		}
+		got, err := s.GetQuote(ctx, "QUO_1")
+		if err != nil {
+			t.Fatalf("GetQuote: %v", err)
+		}
+		if got.ResellerID != 7 || got.Tier != "gold" || got.Price != 26000 {
+			t.Errorf("GetQuote = %+v, want reseller 7 at the gold price", got)
+		}
+	})
+}
+
+func TestOTPSessionsPerReseller(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *sqlStore) {
+		ctx := context.Background()
+		phone := "87786388052"
+		for i, resellerID := range []int64{1, 2} {
+			session := &models.OTPSession{
+				RequestID:   fmt.Sprintf("OTP_%d", resellerID),
+				ResellerID:  resellerID,
+				PhoneNumber: phone,
+				AuthID:      fmt.Sprintf("auth-%d", resellerID),
+				CreatedAt:   testTime.Add(time.Duration(i) * time.Minute),
+				ExpiresAt:   testTime.Add(time.Hour),
+			}
+			if err := s.SaveOTPSession(ctx, session); err != nil {
+				t.Fatalf("SaveOTPSession(%d): %v", resellerID, err)
+			}
+		}
+
+		// Reseller 1 gets its own session, not the newer one of reseller 2.
+		got, err := s.GetOTPSession(ctx, 1, phone, "")
+		if err != nil || got.AuthID != "auth-1" || got.ResellerID != 1 {
+			t.Errorf("GetOTPSession(1) = %+v, %v, want auth-1", got, err)
+		}
+		if _, err := s.GetOTPSession(ctx, 1, phone, "OTP_2"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetOTPSession of another reseller's request = %v, want sql.ErrNoRows", err)
+		}
+
+		if err := s.DeleteOTPSessions(ctx, 1, phone, ""); err != nil {
+			t.Fatalf("DeleteOTPSessions(1): %v", err)
+		}
+		if got, err := s.GetOTPSession(ctx, 2, phone, ""); err != nil || got.AuthID != "auth-2" {
+			t.Errorf("GetOTPSession(2) after deleting reseller 1's = %+v, %v, want auth-2", got, err)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// SaveTransactionWithEvent upserts a transaction and appends a history event in
+// a single database transaction, so the row never changes status without a record.
+func (s *sqlStore) SaveTransactionWithEvent(ctx context.Context, tx *models.TransactionRecord, event *models.TransactionEvent) error {
+	return s.execTransactionWithEvent(ctx, upsertTransactionSQL, tx, event)
+}
+
+// InsertTransactionWithEvent inserts a new transaction together with its creation event.
+// It fails if the ID or idempotency key already exists.
+func (s *sqlStore) InsertTransactionWithEvent(ctx context.Context, tx *models.TransactionRecord, event *models.TransactionEvent) error {
+	return s.execTransactionWithEvent(ctx, insertTransactionSQL, tx, event)
+}
+
+func (s *sqlStore) execTransactionWithEvent(ctx context.Context, query string, tx *models.TransactionRecord, event *models.TransactionEvent) error {
+	dbTx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+
+	if _, err := dbTx.Exec(query, transactionArgs(tx)...); err != nil {
+		return err
+	}
+
+	var payload interface{}
+	if len(event.Payload) > 0 {
+		payload = string(event.Payload)
+	}
+	err = dbTx.QueryRow(`INSERT INTO transaction_events
+		(transaction_id, from_status, to_status, actor, reason, payload, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		event.TransactionID, event.FromStatus, event.ToStatus, event.Actor,
+		event.Reason, payload, event.CreatedAt).Scan(&event.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record transaction event: %v", err)
+	}
+
+	return dbTx.Commit()
+}
+
+// GetTransactionEvents returns the status history of a transaction, oldest first.
+func (s *sqlStore) GetTransactionEvents(ctx context.Context, transactionID string) ([]models.TransactionEvent, error) {
+	rows, err := s.query(ctx, `SELECT id, transaction_id, from_status, to_status, actor, reason, payload, created_at
+		FROM transaction_events WHERE transaction_id = ? ORDER BY id ASC`, transactionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transaction events: %v", err)
+	}
+	defer rows.Close()
+
+	events := []models.TransactionEvent{}
+	for rows.Next() {
+		var e models.TransactionEvent
+		var reason, payload sql.NullString
+		if err := rows.Scan(&e.ID, &e.TransactionID, &e.FromStatus, &e.ToStatus,
+			&e.Actor, &reason, &payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Reason = reason.String
+		if payload.Valid && payload.String != "" {
+			e.Payload = []byte(payload.String)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,236 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/utils"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// maxTransactionPageSize caps the page size of QueryTransactions.
+const maxTransactionPageSize = 1000
+
+// transactionTotalsColumns aggregates the rows of a group in the order scanned
+// by sumTransactions. Pending counts the statuses that are not final yet.
+const transactionTotalsColumns = `COUNT(*),
+	COALESCE(SUM(CASE WHEN status = '` + models.TxStatusSuccess + `' THEN 1 ELSE 0 END), 0),
+	COALESCE(SUM(CASE WHEN status = '` + models.TxStatusFailed + `' THEN 1 ELSE 0 END), 0),
+	COALESCE(SUM(CASE WHEN status IN ('` + models.TxStatusPending + `', '` + models.TxStatusPendingPayment + `', '` +
+	models.TxStatusProcessing + `') THEN 1 ELSE 0 END), 0),
+	COALESCE(SUM(CASE WHEN status = '` + models.TxStatusSuccess + `' THEN amount ELSE 0 END), 0)`
+
+// GetTransaction returns a transaction by ID, or sql.ErrNoRows.
+func (s *sqlStore) GetTransaction(ctx context.Context, id string) (*models.TransactionRecord, error) {
+	return scanTransaction(s.queryRow(ctx, `SELECT `+transactionColumns+` FROM transactions WHERE id = ?`, id))
+}
+
+// transactionFilter builds the WHERE clause of q without its cursor.
+func transactionFilter(q *models.TransactionQuery) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if len(q.Statuses) > 0 {
+		conditions = append(conditions, `status IN (`+placeholders(len(q.Statuses))+`)`)
+		for _, status := range q.Statuses {
+			args = append(args, status)
+		}
+	}
+	if q.Source != "" {
+		conditions = append(conditions, `source = ?`)
+		args = append(args, q.Source)
+	}
+	if q.PhoneNumber != "" {
+		// Phone numbers are stored as entered, so match on the national number.
+		conditions = append(conditions, `phone_number LIKE ?`)
+		args = append(args, "%"+utils.NormalizePhoneNumber(q.PhoneNumber))
+	}
+	if q.PackageCode != "" {
+		conditions = append(conditions, `package_code = ?`)
+		args = append(args, q.PackageCode)
+	}
+	if q.ResellerID != nil {
+		conditions = append(conditions, `reseller_id = ?`)
+		args = append(args, *q.ResellerID)
+	}
+	// created_at is stored as text in the local zone, so compare in the same zone.
+	if q.From != nil {
+		conditions = append(conditions, `created_at >= ?`)
+		args = append(args, q.From.In(time.Local))
+	}
+	if q.To != nil {
+		conditions = append(conditions, `created_at < ?`)
+		args = append(args, q.To.In(time.Local))
+	}
+	if q.MinAmount != nil {
+		conditions = append(conditions, `amount >= ?`)
+		args = append(args, *q.MinAmount)
+	}
+	if q.MaxAmount != nil {
+		conditions = append(conditions, `amount <= ?`)
+		args = append(args, *q.MaxAmount)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// encodeTransactionCursor returns the cursor of the page following tx.
+func encodeTransactionCursor(tx *models.TransactionRecord) string {
+	raw := tx.CreatedAt.Format(time.RFC3339Nano) + "|" + tx.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeTransactionCursor returns the creation time and ID encoded in a cursor.
+func decodeTransactionCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return createdAt, parts[1], nil
+}
+
+// QueryTransactions returns one page of the transactions matching q, ordered
+// by created_at and ID. The returned cursor fetches the next page and is empty
+// on the last page. A limit outside 1..1000 is clamped.
+func (s *sqlStore) QueryTransactions(ctx context.Context, q *models.TransactionQuery) ([]models.TransactionRecord, string, error) {
+	where, args := transactionFilter(q)
+
+	comparison, order := "<", "DESC"
+	if q.Ascending {
+		comparison, order = ">", "ASC"
+	}
+	if q.Cursor != "" {
+		createdAt, id, err := decodeTransactionCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		keyset := `(created_at ` + comparison + ` ? OR (created_at = ? AND id ` + comparison + ` ?))`
+		if where == "" {
+			where = " WHERE " + keyset
+		} else {
+			where += " AND " + keyset
+		}
+		args = append(args, createdAt.In(time.Local), createdAt.In(time.Local), id)
+	}
+
+	limit := q.Limit
+	if limit <= 0 || limit > maxTransactionPageSize {
+		limit = maxTransactionPageSize
+	}
+	// Fetch one extra row to learn whether another page follows.
+	args = append(args, limit+1)
+
+	rows, err := s.query(ctx, `SELECT `+transactionColumns+` FROM transactions`+where+
+		` ORDER BY created_at `+order+`, id `+order+` LIMIT ?`, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	result := []models.TransactionRecord{}
+	for rows.Next() {
+		tx, err := scanTransaction(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		result = append(result, *tx)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(result) > limit {
+		result = result[:limit]
+		next = encodeTransactionCursor(&result[limit-1])
+	}
+	return result, next, nil
+}
+
+// ForEachTransaction calls fn for every transaction matching q in the order of
+// QueryTransactions, ignoring its cursor and limit. Rows are streamed, so fn
+// must not query the database itself. Iteration stops at the first error.
+func (s *sqlStore) ForEachTransaction(ctx context.Context, q *models.TransactionQuery, fn func(*models.TransactionRecord) error) error {
+	where, args := transactionFilter(q)
+	order := "DESC"
+	if q.Ascending {
+		order = "ASC"
+	}
+
+	rows, err := s.query(ctx, `SELECT `+transactionColumns+` FROM transactions`+where+
+		` ORDER BY created_at `+order+`, id `+order, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tx, err := scanTransaction(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// SumTransactions aggregates all transactions matching q. The cursor, limit
+// and order of q are ignored.
+func (s *sqlStore) SumTransactions(ctx context.Context, q *models.TransactionQuery) (models.TransactionTotals, error) {
+	totals, err := s.sumTransactions(ctx, q, "''", "")
+	if err != nil || len(totals) == 0 {
+		return models.TransactionTotals{}, err
+	}
+	return totals[0], nil
+}
+
+// SumTransactionsBySource aggregates the transactions matching q per source,
+// busiest first. Transactions without a source are grouped as "unknown".
+func (s *sqlStore) SumTransactionsBySource(ctx context.Context, q *models.TransactionQuery) ([]models.TransactionTotals, error) {
+	key := `COALESCE(NULLIF(source, ''), 'unknown')`
+	return s.sumTransactions(ctx, q, key, ` GROUP BY 1 ORDER BY 2 DESC, 1`)
+}
+
+// SumTransactionsByDay aggregates the transactions matching q per day in the
+// server's zone, newest day first. Keys are formatted as YYYY-MM-DD.
+func (s *sqlStore) SumTransactionsByDay(ctx context.Context, q *models.TransactionQuery) ([]models.TransactionTotals, error) {
+	return s.sumTransactions(ctx, q, s.dialect.dayKey("created_at"), ` GROUP BY 1 ORDER BY 1 DESC`)
+}
+
+func (s *sqlStore) sumTransactions(ctx context.Context, q *models.TransactionQuery, key, grouping string) ([]models.TransactionTotals, error) {
+	where, args := transactionFilter(q)
+	rows, err := s.query(ctx, `SELECT `+key+`, `+transactionTotalsColumns+` FROM transactions`+where+grouping, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []models.TransactionTotals{}
+	for rows.Next() {
+		var t models.TransactionTotals
+		if err := rows.Scan(&t.Key, &t.Total, &t.Successful, &t.Failed, &t.Pending, &t.Revenue); err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,237 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+const webhookEndpointColumns = `id, reseller_id, url, secret, events, active, created_at, updated_at`
+
+const webhookDeliveryColumns = `id, endpoint_id, event_id, event_type, transaction_id, payload, status,
+	attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at`
+
+func scanWebhookEndpoint(row rowScanner) (*models.WebhookEndpoint, error) {
+	e := &models.WebhookEndpoint{}
+	var events string
+	if err := row.Scan(&e.ID, &e.ResellerID, &e.URL, &e.Secret, &events, &e.Active,
+		&e.CreatedAt, &e.UpdatedAt); err != nil {
+		return nil, err
+	}
+	e.Events = []string{}
+	if events != "" {
+		e.Events = strings.Split(events, ",")
+	}
+	return e, nil
+}
+
+func scanWebhookDelivery(row rowScanner) (*models.WebhookDelivery, error) {
+	d := &models.WebhookDelivery{}
+	var nextAttemptAt, deliveredAt sql.NullTime
+	var lastStatusCode sql.NullInt64
+	var lastError sql.NullString
+	if err := row.Scan(&d.ID, &d.EndpointID, &d.EventID, &d.EventType, &d.TransactionID, &d.Payload,
+		&d.Status, &d.Attempts, &nextAttemptAt, &lastStatusCode, &lastError, &d.CreatedAt, &deliveredAt); err != nil {
+		return nil, err
+	}
+	if nextAttemptAt.Valid {
+		d.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.Time
+	}
+	d.LastStatusCode = int(lastStatusCode.Int64)
+	d.LastError = lastError.String
+	return d, nil
+}
+
+// CreateWebhookEndpoint inserts a webhook endpoint and sets its ID.
+func (s *sqlStore) CreateWebhookEndpoint(ctx context.Context, e *models.WebhookEndpoint) error {
+	return s.queryRow(ctx, `INSERT INTO webhook_endpoints
+		(reseller_id, url, secret, events, active, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		e.ResellerID, e.URL, e.Secret, strings.Join(e.Events, ","), e.Active, e.CreatedAt, e.UpdatedAt).
+		Scan(&e.ID)
+}
+
+// UpdateWebhookEndpoint stores the URL, events and active flag of an endpoint.
+// It returns sql.ErrNoRows if the endpoint does not exist.
+func (s *sqlStore) UpdateWebhookEndpoint(ctx context.Context, e *models.WebhookEndpoint) error {
+	res, err := s.exec(ctx, `UPDATE webhook_endpoints SET url = ?, events = ?, active = ?, updated_at = ? WHERE id = ?`,
+		e.URL, strings.Join(e.Events, ","), e.Active, e.UpdatedAt, e.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteWebhookEndpoint removes an endpoint together with its deliveries and
+// their attempt log. It returns sql.ErrNoRows if the endpoint does not exist.
+func (s *sqlStore) DeleteWebhookEndpoint(ctx context.Context, id int64) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM webhook_attempts WHERE delivery_id IN
+		(SELECT id FROM webhook_deliveries WHERE endpoint_id = ?)`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE endpoint_id = ?`, id); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM webhook_endpoints WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// GetWebhookEndpoint returns an endpoint by ID, or sql.ErrNoRows.
+func (s *sqlStore) GetWebhookEndpoint(ctx context.Context, id int64) (*models.WebhookEndpoint, error) {
+	return scanWebhookEndpoint(s.queryRow(ctx, `SELECT `+webhookEndpointColumns+` FROM webhook_endpoints WHERE id = ?`, id))
+}
+
+// GetWebhookEndpoints returns endpoints ordered by ID. With activeOnly only
+// active endpoints are returned; a negative resellerID matches all resellers.
+func (s *sqlStore) GetWebhookEndpoints(ctx context.Context, resellerID int64, activeOnly bool) ([]models.WebhookEndpoint, error) {
+	rows, err := s.query(ctx, `SELECT `+webhookEndpointColumns+` FROM webhook_endpoints
+		WHERE (? < 0 OR reseller_id = ?) AND (? = FALSE OR active = TRUE) ORDER BY id`,
+		resellerID, resellerID, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []models.WebhookEndpoint{}
+	for rows.Next() {
+		e, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, *e)
+	}
+	return endpoints, rows.Err()
+}
+
+// EnqueueWebhookDelivery inserts a pending delivery due immediately and sets its ID.
+func (s *sqlStore) EnqueueWebhookDelivery(ctx context.Context, d *models.WebhookDelivery) error {
+	return s.queryRow(ctx, `INSERT INTO webhook_deliveries
+		(endpoint_id, event_id, event_type, transaction_id, payload, status, attempts, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?) RETURNING id`,
+		d.EndpointID, d.EventID, d.EventType, d.TransactionID, d.Payload, d.Status, d.NextAttemptAt, d.CreatedAt).
+		Scan(&d.ID)
+}
+
+// GetWebhookDelivery returns a delivery with its attempt log, or sql.ErrNoRows.
+func (s *sqlStore) GetWebhookDelivery(ctx context.Context, id int64) (*models.WebhookDelivery, error) {
+	d, err := scanWebhookDelivery(s.queryRow(ctx, `SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.query(ctx, `SELECT id, delivery_id, attempt, status_code, error, duration_ms, created_at
+		FROM webhook_attempts WHERE delivery_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	d.AttemptLog = []models.WebhookAttempt{}
+	for rows.Next() {
+		var a models.WebhookAttempt
+		var statusCode sql.NullInt64
+		var errorMessage sql.NullString
+		if err := rows.Scan(&a.ID, &a.DeliveryID, &a.Attempt, &statusCode, &errorMessage,
+			&a.DurationMs, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.StatusCode = int(statusCode.Int64)
+		a.Error = errorMessage.String
+		d.AttemptLog = append(d.AttemptLog, a)
+	}
+	return d, rows.Err()
+}
+
+// GetWebhookDeliveries returns the deliveries of an endpoint, newest first.
+func (s *sqlStore) GetWebhookDeliveries(ctx context.Context, endpointID int64, limit int) ([]models.WebhookDelivery, error) {
+	return s.queryWebhookDeliveries(ctx, `SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE endpoint_id = ? ORDER BY id DESC LIMIT ?`, endpointID, limit)
+}
+
+// GetDueWebhookDeliveries returns pending deliveries of active endpoints whose
+// next attempt is due, oldest first.
+func (s *sqlStore) GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	// next_attempt_at is stored as text in the local zone, so compare in the same zone.
+	return s.queryWebhookDeliveries(ctx, `SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE status = ? AND next_attempt_at <= ?
+		AND endpoint_id IN (SELECT id FROM webhook_endpoints WHERE active = TRUE)
+		ORDER BY next_attempt_at, id LIMIT ?`, models.WebhookDeliveryPending, now.In(time.Local), limit)
+}
+
+func (s *sqlStore) queryWebhookDeliveries(ctx context.Context, query string, args ...interface{}) ([]models.WebhookDelivery, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+// RecordWebhookAttempt logs an attempt and stores the resulting state of its
+// delivery atomically.
+func (s *sqlStore) RecordWebhookAttempt(ctx context.Context, d *models.WebhookDelivery, a *models.WebhookAttempt) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(`INSERT INTO webhook_attempts
+		(delivery_id, attempt, status_code, error, duration_ms, created_at)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		a.DeliveryID, a.Attempt, a.StatusCode, nullIfEmpty(a.Error), a.DurationMs, a.CreatedAt).Scan(&a.ID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?,
+		last_status_code = ?, last_error = ?, delivered_at = ? WHERE id = ?`,
+		d.Status, d.Attempts, d.NextAttemptAt, d.LastStatusCode, nullIfEmpty(d.LastError), d.DeliveredAt, d.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ResetWebhookDelivery makes a delivery pending again with a fresh set of
+// attempts, due at the given time. It returns sql.ErrNoRows if the delivery
+// does not exist.
+func (s *sqlStore) ResetWebhookDelivery(ctx context.Context, id int64, dueAt time.Time) error {
+	res, err := s.exec(ctx, `UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ?,
+		delivered_at = NULL WHERE id = ?`, models.WebhookDeliveryPending, dueAt, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
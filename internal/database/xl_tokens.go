@@ -0,0 +1,41 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// SaveXLToken stores the encrypted access token of a phone for a reseller,
+// replacing any previous one. Reseller 0 is the admin.
+func (s *sqlStore) SaveXLToken(ctx context.Context, resellerID int64, phone string, nonce, ciphertext []byte, createdAt, expiresAt time.Time) error {
+	_, err := s.exec(ctx, `INSERT INTO xl_tokens (reseller_id, phone_number, nonce, ciphertext, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(reseller_id, phone_number) DO UPDATE SET nonce = excluded.nonce, ciphertext = excluded.ciphertext,
+		created_at = excluded.created_at, expires_at = excluded.expires_at`, resellerID, phone, nonce, ciphertext, createdAt, expiresAt)
+	return err
+}
+
+// GetXLToken returns the encrypted access token a reseller stored for a phone,
+// or sql.ErrNoRows.
+func (s *sqlStore) GetXLToken(ctx context.Context, resellerID int64, phone string) (nonce, ciphertext []byte, status models.XLTokenStatus, err error) {
+	status.PhoneNumber = phone
+	err = s.queryRow(ctx, `SELECT nonce, ciphertext, created_at, expires_at FROM xl_tokens WHERE reseller_id = ? AND phone_number = ?`,
+		resellerID, phone).Scan(&nonce, &ciphertext, &status.CreatedAt, &status.ExpiresAt)
+	return nonce, ciphertext, status, err
+}
+
+// DeleteXLToken removes the token a reseller stored for a phone. It returns
+// sql.ErrNoRows if there was none.
+func (s *sqlStore) DeleteXLToken(ctx context.Context, resellerID int64, phone string) error {
+	res, err := s.exec(ctx, `DELETE FROM xl_tokens WHERE reseller_id = ? AND phone_number = ?`, resellerID, phone)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
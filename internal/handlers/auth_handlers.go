@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nabilulilalbab/nadia/internal/middleware"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/services"
+)
+
+// authErrorResponse maps AuthService errors to a status code and response.
+// invalidMessage is used for unknown credentials and invalid tokens.
+func authErrorResponse(err error, invalidMessage string) (int, models.APIResponse) {
+	status, message, code := http.StatusUnauthorized, invalidMessage, ""
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials), errors.Is(err, services.ErrInvalidToken):
+	case errors.Is(err, services.ErrTokenExpired):
+		message, code = "Token has expired, log in again", models.ErrCodeTokenExpired
+	case errors.Is(err, services.ErrTokenRevoked):
+		message, code = "Token has been revoked, log in again", models.ErrCodeTokenRevoked
+	case errors.Is(err, services.ErrResellerSuspended):
+		status, message, code = http.StatusForbidden, "Forbidden: "+err.Error(), models.ErrCodeResellerSuspended
+	default:
+		status, message = http.StatusInternalServerError, "Authentication failed: "+err.Error()
+	}
+	return status, models.APIResponse{StatusCode: status, Message: message, Success: false, ErrorCode: code}
+}
+
+// RefreshAuthToken godoc
+// @Summary Refresh an access token
+// @Description Exchange a refresh token for a new access token and refresh token. The old refresh token stops working; reusing it revokes all refresh tokens of the account.
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param request body models.RefreshRequest true "Refresh request"
+// @Success 200 {object} models.APIResponse{data=models.AuthResponse}
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Router /api/auth/refresh [post]
+func (h *HTTPHandler) RefreshAuthToken(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+
+	tokens, err := h.authService.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		c.JSON(authErrorResponse(err, "Invalid refresh token"))
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Token refreshed successfully", Success: true, Data: tokens})
+}
+
+// Logout godoc
+// @Summary Log out
+// @Description Revoke the access token used for this request and, if given, the refresh token issued with it
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param request body models.LogoutRequest false "Logout request"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Router /api/auth/logout [post]
+// @Security BearerAuth
+func (h *HTTPHandler) Logout(c *gin.Context) {
+	var req models.LogoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+			return
+		}
+	}
+
+	if err := h.authService.Logout(c.Request.Context(), middleware.CurrentPrincipal(c), req.RefreshToken); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidToken) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.APIResponse{StatusCode: status, Message: "Failed to log out: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Logged out successfully", Success: true})
+}
+
+// RevokeTokens godoc
+// @Summary Revoke tokens
+// @Description Revoke an access token by its jti claim, and/or all refresh tokens of a subject ("admin" or "reseller:<id>")
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Param request body models.RevokeTokensRequest true "Revoke request"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Router /api/auth/revoke [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) RevokeTokens(c *gin.Context) {
+	var req models.RevokeTokensRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+	if req.TokenID == "" && req.Subject == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: jti or subject is required", Success: false})
+		return
+	}
+
+	result := gin.H{}
+	if req.TokenID != "" {
+		if err := h.authService.RevokeTokenID(c.Request.Context(), req.TokenID, req.ExpiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to revoke token: " + err.Error(), Success: false})
+			return
+		}
+		result["revoked_jti"] = req.TokenID
+	}
+	if req.Subject != "" {
+		n, err := h.authService.RevokeSubject(c.Request.Context(), req.Subject)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to revoke refresh tokens: " + err.Error(), Success: false})
+			return
+		}
+		result["revoked_refresh_tokens"] = n
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Tokens revoked successfully", Success: true, Data: result})
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/services"
+)
+
+// catalogSnapshot returns the current catalog snapshot, writing an error
+// response and returning false if no snapshot is available.
+func (h *HTTPHandler) catalogSnapshot(c *gin.Context) (*services.CatalogSnapshot, bool) {
+	snapshot, err := h.catalogService.Snapshot(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, models.APIResponse{
+			StatusCode: http.StatusServiceUnavailable,
+			Message:    "Catalog unavailable: " + err.Error(),
+			Success:    false,
+		})
+		return nil, false
+	}
+
+	c.Header("X-Catalog-Version", strconv.FormatInt(snapshot.Version, 10))
+	c.Header("X-Catalog-Age", strconv.Itoa(int(time.Since(snapshot.FetchedAt).Seconds())))
+	return snapshot, true
+}
+
+// GetCatalogStatus godoc
+// @Summary Get catalog snapshot status
+// @Description Get version, age and last refresh error of the cached package/price catalog
+// @Tags packages
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=models.CatalogStatus}
+// @Failure 401 {object} models.APIResponse
+// @Router /api/catalog/status [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetCatalogStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Catalog status retrieved successfully",
+		Success:    true,
+		Data:       h.catalogService.Status(),
+	})
+}
+
+// RefreshCatalog godoc
+// @Summary Force a catalog refresh
+// @Description Download the package and price lists immediately instead of waiting for the next refresh interval
+// @Tags packages
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=models.CatalogStatus}
+// @Failure 502 {object} models.APIResponse
+// @Router /api/catalog/refresh [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) RefreshCatalog(c *gin.Context) {
+	if err := h.catalogService.Refresh(c.Request.Context()); err != nil {
+		c.JSON(http.StatusBadGateway, models.APIResponse{
+			StatusCode: http.StatusBadGateway,
+			Message:    "Failed to refresh catalog: " + err.Error(),
+			Success:    false,
+			Data:       h.catalogService.Status(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Catalog refreshed successfully",
+		Success:    true,
+		Data:       h.catalogService.Status(),
+	})
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nabilulilalbab/nadia/internal/middleware"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/services"
+)
+
+func depositErrorResponse(err error, message string) (int, models.APIResponse) {
+	status, errorCode := http.StatusInternalServerError, ""
+	switch {
+	case errors.Is(err, services.ErrDepositNotFound):
+		status, errorCode = http.StatusNotFound, models.ErrCodeDepositNotFound
+	case errors.Is(err, services.ErrDepositReviewed):
+		status, errorCode = http.StatusConflict, models.ErrCodeDepositReviewed
+	}
+	return status, models.APIResponse{StatusCode: status, Message: message + ": " + err.Error(), Success: false, ErrorCode: errorCode}
+}
+
+// CreateDeposit godoc
+// @Summary Request a wallet deposit
+// @Description Create a pending top-up of the calling reseller's wallet. Transfer exactly transfer_amount (the amount plus the unique code) so the transfer can be matched; the wallet is credited once an admin approves the deposit.
+// @Tags deposits
+// @Accept json
+// @Produce json
+// @Param request body models.DepositRequest true "Deposit request"
+// @Success 201 {object} models.APIResponse{data=models.Deposit}
+// @Failure 400 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Router /api/reseller/deposits [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) CreateDeposit(c *gin.Context) {
+	var req models.DepositRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+
+	deposit, err := h.depositService.Create(c.Request.Context(), middleware.CurrentPrincipal(c).ResellerID, req.Amount, req.ProofNote)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Failed to create deposit: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusCreated, models.APIResponse{StatusCode: http.StatusCreated, Message: "Deposit created, transfer the exact transfer_amount", Success: true, Data: deposit})
+}
+
+// GetMyDeposits godoc
+// @Summary List own deposits
+// @Description List the deposits of the calling reseller, newest first
+// @Tags deposits
+// @Accept json
+// @Produce json
+// @Param status query string false "Filter by status (pending, approved, rejected)"
+// @Param limit query int false "Limit number of deposits returned" default(100)
+// @Success 200 {object} models.APIResponse{data=[]models.Deposit}
+// @Failure 403 {object} models.APIResponse
+// @Router /api/reseller/deposits [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetMyDeposits(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	deposits, err := h.depositService.List(c.Request.Context(), middleware.CurrentPrincipal(c).ResellerID, c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to retrieve deposits: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Deposits retrieved successfully", Success: true, Data: deposits})
+}
+
+// GetMyBalance godoc
+// @Summary Get own wallet balance
+// @Description Get the available and held balance of the calling reseller's wallet
+// @Tags deposits
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=models.WalletBalance}
+// @Failure 403 {object} models.APIResponse
+// @Router /api/reseller/balance [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetMyBalance(c *gin.Context) {
+	balance, err := h.walletService.Balance(c.Request.Context(), middleware.CurrentPrincipal(c).ResellerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to retrieve wallet balance: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Wallet balance retrieved successfully", Success: true, Data: balance})
+}
+
+// GetDeposits godoc
+// @Summary List deposits
+// @Description List reseller deposits of all resellers, newest first. Use status=pending for the approval queue.
+// @Tags deposits
+// @Accept json
+// @Produce json
+// @Param status query string false "Filter by status (pending, approved, rejected)"
+// @Param reseller_id query int false "Filter by reseller ID"
+// @Param limit query int false "Limit number of deposits returned" default(100)
+// @Success 200 {object} models.APIResponse{data=[]models.Deposit}
+// @Router /api/deposits [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetDeposits(c *gin.Context) {
+	resellerID, _ := strconv.ParseInt(c.Query("reseller_id"), 10, 64)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	deposits, err := h.depositService.List(c.Request.Context(), resellerID, c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to retrieve deposits: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Deposits retrieved successfully", Success: true, Data: deposits})
+}
+
+// GetDepositInvoices godoc
+// @Summary List deposits as invoices
+// @Description List reseller deposits in the invoice format of /api/invoices, so they can be shown next to upstream invoices. Approved deposits have invoice_status_id "paid".
+// @Tags deposits
+// @Accept json
+// @Produce json
+// @Param status query string false "Filter by deposit status (pending, approved, rejected)"
+// @Param reseller_id query int false "Filter by reseller ID"
+// @Param limit query int false "Limit number of invoices returned" default(100)
+// @Success 200 {object} models.APIResponse{data=[]models.InvoiceRecord}
+// @Router /api/deposits/invoices [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetDepositInvoices(c *gin.Context) {
+	resellerID, _ := strconv.ParseInt(c.Query("reseller_id"), 10, 64)
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	invoices, err := h.depositService.Invoices(c.Request.Context(), resellerID, c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to retrieve deposit invoices: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Deposit invoices retrieved successfully", Success: true, Data: invoices})
+}
+
+// ApproveDeposit godoc
+// @Summary Approve a deposit
+// @Description Approve a pending deposit after matching its transfer amount on the bank statement. The transfer amount is credited to the reseller wallet.
+// @Tags deposits
+// @Accept json
+// @Produce json
+// @Param id path string true "Deposit ID"
+// @Param request body models.DepositReviewRequest false "Review note"
+// @Success 200 {object} models.APIResponse{data=models.Deposit}
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Router /api/deposits/{id}/approve [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) ApproveDeposit(c *gin.Context) {
+	h.reviewDeposit(c, h.depositService.Approve, "Deposit approved, wallet credited")
+}
+
+// RejectDeposit godoc
+// @Summary Reject a deposit
+// @Description Reject a pending deposit, e.g. when no matching transfer arrived. The wallet is not changed.
+// @Tags deposits
+// @Accept json
+// @Produce json
+// @Param id path string true "Deposit ID"
+// @Param request body models.DepositReviewRequest false "Review note"
+// @Success 200 {object} models.APIResponse{data=models.Deposit}
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Router /api/deposits/{id}/reject [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) RejectDeposit(c *gin.Context) {
+	h.reviewDeposit(c, h.depositService.Reject, "Deposit rejected")
+}
+
+func (h *HTTPHandler) reviewDeposit(c *gin.Context, review func(ctx context.Context, id, note, reviewedBy string) (*models.Deposit, error), message string) {
+	var req models.DepositReviewRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+			return
+		}
+	}
+
+	deposit, err := review(c.Request.Context(), c.Param("id"), req.Note, middleware.CurrentPrincipal(c).Name)
+	if err != nil {
+		c.JSON(depositErrorResponse(err, "Failed to review deposit"))
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: message, Success: true, Data: deposit})
+}
@@ -1,47 +1,128 @@
 package handlers
 
 import (
-	"encoding/json"
+	"encoding/csv"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nabilulilalbab/nadia/internal/middleware"
 	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/nadia"
+	"github.com/nabilulilalbab/nadia/internal/services"
 )
 
+// parseTransactionQuery reads the filters shared by the transaction listing,
+// statistics and export endpoints. Callers other than the admin only see the
+// transactions of their own reseller account.
+func parseTransactionQuery(c *gin.Context) (*models.TransactionQuery, error) {
+	q := &models.TransactionQuery{
+		Source:      c.Query("source"),
+		PhoneNumber: c.Query("phone"),
+		PackageCode: c.Query("package_code"),
+		Cursor:      c.Query("cursor"),
+	}
+	for status := range services.ParseFilterSet(c.Query("status"), true) {
+		q.Statuses = append(q.Statuses, status)
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from date %q, expected YYYY-MM-DD", from)
+		}
+		q.From = &t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to date %q, expected YYYY-MM-DD", to)
+		}
+		// The to date is inclusive.
+		t = t.AddDate(0, 0, 1)
+		q.To = &t
+	}
+	var err error
+	if q.MinAmount, err = optionalIntQuery(c, "min_amount"); err != nil {
+		return nil, err
+	}
+	if q.MaxAmount, err = optionalIntQuery(c, "max_amount"); err != nil {
+		return nil, err
+	}
+
+	switch order := strings.ToLower(c.DefaultQuery("order", "desc")); order {
+	case "asc":
+		q.Ascending = true
+	case "desc":
+	default:
+		return nil, fmt.Errorf("invalid order %q, expected asc or desc", order)
+	}
+
+	if p := middleware.CurrentPrincipal(c); !p.IsAdmin() {
+		resellerID := p.ResellerID
+		q.ResellerID = &resellerID
+	}
+	return q, nil
+}
+
+// optionalIntQuery parses an integer query parameter, returning nil if it is absent.
+func optionalIntQuery(c *gin.Context, name string) (*int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return &n, nil
+}
+
 // GetTransactions godoc
 // @Summary Get transactions with filters
-// @Description Get transactions with optional filters like status, source, date range, and limit
+// @Description Get one page of transactions, newest first, filtered by status, source, phone number, package, date range and amount. When more transactions match, the cursor of the next page is returned in the X-Next-Cursor header; pass it as cursor with the same filters. Resellers only see their own transactions.
 // @Tags transactions
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
 // @Security BearerAuth
-// @Param limit query int false "Limit number of results" default(100)
-// @Param status query string false "Filter by status (SUCCESS, FAILED, PENDING)"
+// @Param limit query int false "Limit number of results (max 1000)" default(100)
+// @Param status query string false "Comma-separated statuses, e.g. SUCCESS,FAILED"
 // @Param source query string false "Filter by source (whatsapp_bot, etc)"
+// @Param phone query string false "Filter by phone number"
+// @Param package_code query string false "Filter by package code"
 // @Param from query string false "Start date (YYYY-MM-DD)"
-// @Param to query string false "End date (YYYY-MM-DD)"
+// @Param to query string false "End date, inclusive (YYYY-MM-DD)"
+// @Param min_amount query int false "Minimum amount"
+// @Param max_amount query int false "Maximum amount"
+// @Param order query string false "Sort order by creation time (asc or desc)" default(desc)
+// @Param cursor query string false "Cursor from the X-Next-Cursor header of the previous page"
 // @Success 200 {object} models.APIResponse{data=[]models.TransactionRecord}
+// @Header 200 {string} X-Next-Cursor "Cursor of the next page, absent on the last page"
+// @Failure 400 {object} models.APIResponse
 // @Failure 401 {object} models.APIResponse
 // @Router /api/transactions [get]
 func (h *HTTPHandler) GetTransactions(c *gin.Context) {
-	// Parse query parameters
-	limitStr := c.DefaultQuery("limit", "100")
-	limit, err := strconv.Atoi(limitStr)
+	q, err := parseTransactionQuery(c)
 	if err != nil {
-		limit = 100
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+	q.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil {
+		q.Limit = 100
 	}
 
-	status := c.Query("status")
-	source := c.Query("source")
-	from := c.Query("from")
-	to := c.Query("to")
-
-	// Get transactions from service
-	transactions, err := h.transactionService.GetTransactionsWithFilters(limit, status, source, from, to)
+	transactions, next, err := h.transactionService.QueryTransactions(c.Request.Context(), q)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			StatusCode: http.StatusInternalServerError,
@@ -51,6 +132,12 @@ func (h *HTTPHandler) GetTransactions(c *gin.Context) {
 		return
 	}
 
+	if next != "" {
+		c.Header("X-Next-Cursor", next)
+	}
+	for i, tx := range transactions {
+		transactions[i] = transactionForCaller(c, tx)
+	}
 	c.JSON(http.StatusOK, models.APIResponse{
 		StatusCode: http.StatusOK,
 		Message:    "Transactions retrieved successfully",
@@ -61,17 +148,28 @@ func (h *HTTPHandler) GetTransactions(c *gin.Context) {
 
 // GetDailyStats godoc
 // @Summary Get daily transaction statistics
-// @Description Get daily statistics for transactions including counts and amounts
+// @Description Get daily statistics for transactions including counts and amounts, newest day first. Accepts the filters of /api/transactions. Resellers only see their own transactions.
 // @Tags analytics
 // @Accept json
 // @Produce json
 // @Security ApiKeyAuth
 // @Security BearerAuth
+// @Param status query string false "Comma-separated statuses, e.g. SUCCESS,FAILED"
+// @Param source query string false "Filter by source"
+// @Param from query string false "Start date (YYYY-MM-DD)"
+// @Param to query string false "End date, inclusive (YYYY-MM-DD)"
 // @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
 // @Failure 401 {object} models.APIResponse
 // @Router /api/stats/daily [get]
 func (h *HTTPHandler) GetDailyStats(c *gin.Context) {
-	stats, err := h.transactionService.GetDailyStats()
+	q, err := parseTransactionQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+
+	stats, err := h.transactionService.GetDailyStats(c.Request.Context(), q)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			StatusCode: http.StatusInternalServerError,
@@ -85,91 +183,108 @@ func (h *HTTPHandler) GetDailyStats(c *gin.Context) {
 		StatusCode: http.StatusOK,
 		Message:    "Daily statistics retrieved successfully",
 		Success:    true,
-		Data:       stats,
+		Data: map[string]interface{}{
+			"daily_stats": stats,
+			"total_days":  len(stats),
+		},
 	})
 }
 
 // ExportTransactions godoc
 // @Summary Export transactions to CSV
-// @Description Export transactions data to CSV format
+// @Description Export transactions data to CSV format. Accepts the filters of /api/transactions; rows are streamed, newest first.
 // @Tags export
 // @Accept json
 // @Produce text/csv
 // @Security ApiKeyAuth
 // @Security BearerAuth
+// @Param status query string false "Comma-separated statuses, e.g. SUCCESS,FAILED"
+// @Param source query string false "Filter by source"
+// @Param from query string false "Start date (YYYY-MM-DD)"
+// @Param to query string false "End date, inclusive (YYYY-MM-DD)"
 // @Success 200 {file} string "CSV file"
+// @Failure 400 {object} models.APIResponse
 // @Failure 401 {object} models.APIResponse
 // @Router /api/export/transactions [get]
 func (h *HTTPHandler) ExportTransactions(c *gin.Context) {
-	transactions, err := h.transactionService.GetAllTransactions()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to retrieve transactions for export",
-			Success:    false,
+	h.exportTransactionsCSV(c, "transactions.csv", []string{"ID", "Phone", "Package", "Amount", "Status", "Source", "Created At"},
+		func(tx *models.TransactionRecord) []string {
+			return []string{tx.ID, tx.PhoneNumber, tx.PackageName, strconv.Itoa(tx.Amount), tx.Status, tx.Source,
+				tx.CreatedAt.Format("2006-01-02 15:04:05")}
 		})
-		return
-	}
-
-	// Set CSV headers
-	c.Header("Content-Type", "text/csv")
-	c.Header("Content-Disposition", "attachment; filename=transactions.csv")
-
-	// Write CSV header
-	csvData := "ID,Phone,Package,Amount,Status,Source,Created At\n"
-	
-	// Write transaction data
-	for _, tx := range transactions {
-		csvData += fmt.Sprintf("%s,%s,%s,%d,%s,%s,%s\n",
-			tx.ID, tx.PhoneNumber, tx.PackageName, tx.Amount, tx.Status, tx.Source,
-			tx.CreatedAt.Format("2006-01-02 15:04:05"))
-	}
-
-	c.String(http.StatusOK, csvData)
 }
 
 // ExportInvoices godoc
 // @Summary Export invoices to CSV
-// @Description Export invoices data to CSV format
+// @Description Export invoices data to CSV format. Accepts the filters of /api/transactions; rows are streamed, newest first.
 // @Tags export
 // @Accept json
 // @Produce text/csv
 // @Security ApiKeyAuth
 // @Security BearerAuth
+// @Param status query string false "Comma-separated statuses, e.g. SUCCESS,FAILED"
+// @Param from query string false "Start date (YYYY-MM-DD)"
+// @Param to query string false "End date, inclusive (YYYY-MM-DD)"
 // @Success 200 {file} string "CSV file"
+// @Failure 400 {object} models.APIResponse
 // @Failure 401 {object} models.APIResponse
 // @Router /api/export/invoices [get]
 func (h *HTTPHandler) ExportInvoices(c *gin.Context) {
-	invoices, err := h.transactionService.GetAllInvoices()
+	// Invoices are the same as transactions for now.
+	h.exportTransactionsCSV(c, "invoices.csv", []string{"ID", "Phone", "Package", "Amount", "Status", "Payment Method", "Created At"},
+		func(inv *models.TransactionRecord) []string {
+			return []string{inv.ID, inv.PhoneNumber, inv.PackageName, strconv.Itoa(inv.Amount), inv.Status, inv.PaymentMethod,
+				inv.CreatedAt.Format("2006-01-02 15:04:05")}
+		})
+}
+
+// exportTransactionsCSV streams the transactions matching the request filters
+// as a CSV attachment with one row per transaction.
+func (h *HTTPHandler) exportTransactionsCSV(c *gin.Context, filename string, header []string, row func(*models.TransactionRecord) []string) {
+	q, err := parseTransactionQuery(c)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+
+	var w *csv.Writer
+	err = h.transactionService.ForEachTransaction(c.Request.Context(), q, func(tx *models.TransactionRecord) error {
+		if w == nil {
+			w = startCSV(c, filename, header)
+		}
+		return w.Write(row(tx))
+	})
+	if err != nil && w == nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to retrieve invoices for export",
+			Message:    "Failed to retrieve transactions for export",
 			Success:    false,
 		})
 		return
 	}
-
-	// Set CSV headers
-	c.Header("Content-Type", "text/csv")
-	c.Header("Content-Disposition", "attachment; filename=invoices.csv")
-
-	// Write CSV header
-	csvData := "ID,Phone,Package,Amount,Status,Payment Method,Created At\n"
-	
-	// Write invoice data
-	for _, inv := range invoices {
-		csvData += fmt.Sprintf("%s,%s,%s,%d,%s,%s,%s\n",
-			inv.ID, inv.PhoneNumber, inv.PackageName, inv.Amount, inv.Status, inv.PaymentMethod,
-			inv.CreatedAt.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		// The response is already under way; a truncated file is all we can do.
+		log.Printf("Failed to export %s: %v", filename, err)
+	}
+	if w == nil {
+		w = startCSV(c, filename, header)
 	}
+	w.Flush()
+}
 
-	c.String(http.StatusOK, csvData)
+// startCSV writes the attachment headers and the CSV header row.
+func startCSV(c *gin.Context, filename string, header []string) *csv.Writer {
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	w.Write(header)
+	return w
 }
 
 // CheckActivePackages godoc
 // @Summary Check active packages on XL card
-// @Description Check all active packages/quotas on an XL card using access token from login verification
+// @Description Check all active packages/quotas on an XL card using the access token from login verification, or the token stored for phone_number
 // @Tags card
 // @Accept json
 // @Produce json
@@ -187,20 +302,16 @@ func (h *HTTPHandler) CheckActivePackages(c *gin.Context) {
 		return
 	}
 
-	payload := map[string]string{"access_token": req.AccessToken}
-
-	resp, err := h.nadiaService.MakeRequest("POST", "/limited/xl/package-active-list.json", payload)
+	accessToken, ok := h.resolveAccessToken(c, req.PhoneNumber, req.AccessToken)
+	if !ok {
+		return
+	}
+	resp, err := h.nadiaClient.ActivePackages(c.Request.Context(), accessToken)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to check active packages: " + err.Error(), Success: false})
+		c.JSON(upstreamErrorResponse(c, err, "Failed to check active packages"))
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
-
-	c.JSON(resp.StatusCode, nadiaResp)
+	respondUpstream(c, resp)
 }
 
 // GetBalance godoc
@@ -215,23 +326,17 @@ func (h *HTTPHandler) CheckActivePackages(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Security BearerAuth
 func (h *HTTPHandler) GetBalance(c *gin.Context) {
-	resp, err := h.nadiaService.MakeRequest("GET", "/wallet/balance.json", nil)
+	resp, err := h.nadiaClient.Balance(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to get balance: " + err.Error(), Success: false})
+		c.JSON(upstreamErrorResponse(c, err, "Failed to get balance"))
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
-
-	c.JSON(resp.StatusCode, nadiaResp)
+	respondUpstream(c, resp)
 }
 
 // CheckTransaction godoc
 // @Summary Check transaction status
-// @Description Check the status of a transaction by transaction ID
+// @Description Check the status of a transaction by transaction ID on our upstream account. Admin only; resellers use /api/transactions/{id}.
 // @Tags transaction
 // @Accept json
 // @Produce json
@@ -249,19 +354,12 @@ func (h *HTTPHandler) CheckTransaction(c *gin.Context) {
 		return
 	}
 
-	payload := map[string]string{"trx_id": req.TransactionID}
-	resp, err := h.nadiaService.MakeRequest("POST", "/limited/xl/check-transaction.json", payload)
+	resp, err := h.nadiaClient.CheckTransaction(c.Request.Context(), req.TransactionID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to check transaction: " + err.Error(), Success: false})
+		c.JSON(upstreamErrorResponse(c, err, "Failed to check transaction"))
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
-
-	c.JSON(resp.StatusCode, nadiaResp)
+	respondUpstream(c, resp)
 }
 
 // GetPaymentMethods godoc
@@ -276,18 +374,12 @@ func (h *HTTPHandler) CheckTransaction(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Security BearerAuth
 func (h *HTTPHandler) GetPaymentMethods(c *gin.Context) {
-	resp, err := h.nadiaService.MakeRequest("GET", "/wallet/payment-methods.json", nil)
+	resp, err := h.nadiaClient.PaymentMethods(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to get payment methods: " + err.Error(), Success: false})
+		c.JSON(upstreamErrorResponse(c, err, "Failed to get payment methods"))
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
-
-	c.JSON(resp.StatusCode, nadiaResp)
+	respondUpstream(c, resp)
 }
 
 // GetPackageStock godoc
@@ -302,18 +394,12 @@ func (h *HTTPHandler) GetPaymentMethods(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Security BearerAuth
 func (h *HTTPHandler) GetPackageStock(c *gin.Context) {
-	resp, err := h.nadiaService.MakeRequest("GET", "/limited/xl/check-stock-package-global.json", nil)
+	resp, err := h.nadiaClient.GlobalStock(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to get package stock: " + err.Error(), Success: false})
+		c.JSON(upstreamErrorResponse(c, err, "Failed to get package stock"))
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
-
-	c.JSON(resp.StatusCode, nadiaResp)
+	respondUpstream(c, resp)
 }
 
 // CheckSpecificPackageStock godoc
@@ -336,42 +422,59 @@ func (h *HTTPHandler) CheckSpecificPackageStock(c *gin.Context) {
 		return
 	}
 
-	payload := map[string]string{"package_code": req.PackageCode}
-	resp, err := h.nadiaService.MakeRequest("POST", "/limited/xl/check-stock-package.json", payload)
+	resp, err := h.nadiaClient.CheckPackageStock(c.Request.Context(), req.PackageCode)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to check package stock: " + err.Error(), Success: false})
+		c.JSON(upstreamErrorResponse(c, err, "Failed to check package stock"))
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
-
-	c.JSON(resp.StatusCode, nadiaResp)
+	respondUpstream(c, resp)
 }
 
 // GetInvoices godoc
 // @Summary Get invoice list
-// @Description Retrieve list of invoices with pagination
+// @Description Retrieve list of invoices of our upstream account with pagination. Admin only.
 // @Tags invoice
 // @Accept json
 // @Produce json
 // @Param search query string false "Search term"
 // @Param limit query int false "Limit per page" default(20)
-// @Success 200 {object} models.APIResponse
+// @Param last_created_at query int false "last_created_at of the previous page's pagination, to fetch the next page"
+// @Success 200 {object} models.APIResponse{data=models.InvoiceList}
+// @Failure 400 {object} models.APIResponse
 // @Failure 500 {object} models.APIResponse
 // @Router /api/invoices [get]
 // @Security ApiKeyAuth
 // @Security BearerAuth
 func (h *HTTPHandler) GetInvoices(c *gin.Context) {
-	// This is a simplified handler. A full implementation would exist in NadiaService.
-	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Invoice endpoint not fully implemented in this refactor.", Success: true})
+	query := nadia.InvoiceQuery{Search: c.Query("search"), Limit: 20}
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid limit", Success: false})
+			return
+		}
+		query.Limit = limit
+	}
+	if cursor := c.Query("last_created_at"); cursor != "" {
+		lastCreatedAt, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid last_created_at", Success: false})
+			return
+		}
+		query.LastCreatedAt = lastCreatedAt
+	}
+
+	resp, err := h.nadiaClient.ListInvoices(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(upstreamErrorResponse(c, err, "Failed to get invoices"))
+		return
+	}
+	respondUpstream(c, resp)
 }
 
 // GetInvoiceDetail godoc
 // @Summary Get invoice detail
-// @Description Get detailed information about a specific invoice
+// @Description Get detailed information about a specific invoice. Admin only.
 // @Tags invoice
 // @Accept json
 // @Produce json
@@ -387,7 +490,7 @@ func (h *HTTPHandler) GetInvoiceDetail(c *gin.Context) {
 
 // GetInvoiceStatsHandler godoc
 // @Summary Get invoice statistics
-// @Description Get comprehensive invoice statistics including payment rates and revenue
+// @Description Get comprehensive invoice statistics including payment rates and revenue. Accepts the filters of /api/transactions. Resellers only see their own transactions.
 // @Tags invoice
 // @Accept json
 // @Produce json
@@ -396,8 +499,14 @@ func (h *HTTPHandler) GetInvoiceDetail(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Security BearerAuth
 func (h *HTTPHandler) GetInvoiceStatsHandler(c *gin.Context) {
+	q, err := parseTransactionQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+
 	// Get invoice stats from transaction service (using transactions as invoices)
-	stats, err := h.transactionService.GetInvoiceStats()
+	stats, err := h.transactionService.GetInvoiceStats(c.Request.Context(), q)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			StatusCode: http.StatusInternalServerError,
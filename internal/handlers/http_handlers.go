@@ -1,83 +1,90 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	qrcode "github.com/skip2/go-qrcode"
 
+	"github.com/nabilulilalbab/nadia/internal/middleware"
 	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/monitoring"
+	"github.com/nabilulilalbab/nadia/internal/nadia"
 	"github.com/nabilulilalbab/nadia/internal/services"
+	"github.com/nabilulilalbab/nadia/internal/tracing"
 	"github.com/nabilulilalbab/nadia/internal/utils"
 )
 
-// Helper function to format price in Indonesian Rupiah
-func formatRupiah(amount int) string {
-	if amount == 0 {
-		return "Rp. 0,00"
-	}
-
-	// Convert to string and add thousand separators
-	str := strconv.Itoa(amount)
-	n := len(str)
-	if n <= 3 {
-		return fmt.Sprintf("Rp. %s,00", str)
-	}
-
-	// Add dots for thousands
-	var result strings.Builder
-	for i, digit := range str {
-		if i > 0 && (n-i)%3 == 0 {
-			result.WriteString(".")
-		}
-		result.WriteRune(digit)
-	}
-
-	return fmt.Sprintf("Rp. %s,00", result.String())
-}
-
 // HTTPHandler holds all dependencies for the handlers.
 type HTTPHandler struct {
-	nadiaService       *services.NadiaService
+	nadiaClient        *nadia.Client
 	transactionService *services.TransactionService
+	catalogService     *services.CatalogService
+	pricingService     *services.PricingService
+	purchaseValidator  *services.PurchaseValidator
+	quoteService       *services.QuoteService
+	tokenVault         *services.TokenVault
+	resellerService    *services.ResellerService
+	authService        *services.AuthService
+	walletService      *services.WalletService
+	depositService     *services.DepositService
+	webhookService     *services.WebhookService
+	eventBroker        *services.EventBroker
 }
 
 // NewHTTPHandler creates a new HTTPHandler.
-func NewHTTPHandler(ns *services.NadiaService, ts *services.TransactionService) *HTTPHandler {
+func NewHTTPHandler(nc *nadia.Client, ts *services.TransactionService, cs *services.CatalogService, ps *services.PricingService, pv *services.PurchaseValidator, qs *services.QuoteService, tv *services.TokenVault, rs *services.ResellerService, as *services.AuthService, ws *services.WalletService, ds *services.DepositService, whs *services.WebhookService, eb *services.EventBroker) *HTTPHandler {
 	return &HTTPHandler{
-		nadiaService:       ns,
+		nadiaClient:        nc,
 		transactionService: ts,
+		catalogService:     cs,
+		pricingService:     ps,
+		purchaseValidator:  pv,
+		quoteService:       qs,
+		tokenVault:         tv,
+		resellerService:    rs,
+		authService:        as,
+		walletService:      ws,
+		depositService:     ds,
+		webhookService:     whs,
+		eventBroker:        eb,
 	}
 }
 
 // HealthCheck godoc
 // @Summary Health check
-// @Description Check if the API is running properly
+// @Description Check if the API is running properly. upstream lists the circuit breakers of the Nadia API endpoint groups; upstream_status is "degraded" while any of them is not closed.
 // @Tags system
 // @Accept json
 // @Produce json
 // @Success 200 {object} models.APIResponse
 // @Router /api/health [get]
 func (h *HTTPHandler) HealthCheck(c *gin.Context) {
+	circuits := h.nadiaClient.CircuitStatus()
 	c.JSON(http.StatusOK, models.APIResponse{
 		StatusCode: http.StatusOK,
 		Message:    "API is running successfully",
 		Success:    true,
 		Data: map[string]interface{}{
-			"timestamp": time.Now().Format(time.RFC3339),
-			"version":   "2.0.0",
+			"timestamp":       time.Now().Format(time.RFC3339),
+			"version":         "2.0.0",
+			"upstream_status": upstreamStatus(circuits),
+			"upstream":        circuits,
 		},
 	})
 }
 
 // AuthLogin godoc
 // @Summary Authenticate with API key
-// @Description Login with API key to get access token
+// @Description Login with the admin or a reseller API key to get a JWT access token (HS256) and a refresh token. Use the access token as "Authorization: Bearer <token>" and renew it with /api/auth/refresh.
 // @Tags authentication
 // @Accept json
 // @Produce json
@@ -85,6 +92,7 @@ func (h *HTTPHandler) HealthCheck(c *gin.Context) {
 // @Success 200 {object} models.APIResponse{data=models.AuthResponse}
 // @Failure 400 {object} models.APIResponse
 // @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
 // @Router /api/auth/login [post]
 func (h *HTTPHandler) AuthLogin(c *gin.Context) {
 	var req models.AuthRequest
@@ -97,27 +105,17 @@ func (h *HTTPHandler) AuthLogin(c *gin.Context) {
 		return
 	}
 
-	// In a real app, you'd compare against a configured value
-	if req.APIKey != "nadia-admin-2024-secure-key" {
-		c.JSON(http.StatusUnauthorized, models.APIResponse{
-			StatusCode: http.StatusUnauthorized,
-			Message:    "Invalid API key",
-			Success:    false,
-		})
+	tokens, err := h.authService.Login(c.Request.Context(), req.APIKey)
+	if err != nil {
+		c.JSON(authErrorResponse(err, "Invalid API key"))
 		return
 	}
 
-	token := fmt.Sprintf("nadia-token-%d", time.Now().Unix())
-	expiresAt := time.Now().Add(24 * time.Hour)
-
 	c.JSON(http.StatusOK, models.APIResponse{
 		StatusCode: http.StatusOK,
 		Message:    "Authentication successful",
 		Success:    true,
-		Data: models.AuthResponse{
-			Token:     token,
-			ExpiresAt: expiresAt,
-		},
+		Data:       tokens,
 	})
 }
 
@@ -137,36 +135,12 @@ func (h *HTTPHandler) GetAllPackages(c *gin.Context) {
 	limitStr := c.DefaultQuery("limit", "100")
 	limit, _ := strconv.Atoi(limitStr)
 
-	// ✅ POST + body kosong
-	resp, err := h.nadiaService.MakeRequest(
-		"POST",
-		"/limited/xl/package-list-all.json",
-		map[string]interface{}{},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to fetch packages: " + err.Error(),
-			Success:    false,
-		})
+	snapshot, ok := h.catalogSnapshot(c)
+	if !ok {
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp struct {
-		Data []models.Package `json:"data"`
-	}
-	json.Unmarshal(body, &nadiaResp)
-
-	// Update packages with actual prices from price-list-all.json
-	for i := range nadiaResp.Data {
-		actualPrice := h.nadiaService.GetPackagePrice(nadiaResp.Data[i].PackageCode)
-		nadiaResp.Data[i].PackagePrice = actualPrice
-		nadiaResp.Data[i].PackagePriceFormatted = formatRupiah(actualPrice)
-	}
 
-	packages := nadiaResp.Data
+	packages := snapshot.Packages
 	if limit > 0 && len(packages) > limit {
 		packages = packages[:limit]
 	}
@@ -203,44 +177,13 @@ func (h *HTTPHandler) SearchPackages(c *gin.Context) {
 		return
 	}
 
-	// Panggil Nadia API dengan POST + body {}
-	resp, err := h.nadiaService.MakeRequest(
-		"POST",
-		"/limited/xl/package-list-all.json",
-		map[string]interface{}{},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to fetch packages: " + err.Error(),
-			Success:    false,
-		})
-		return
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp struct {
-		Data []models.Package `json:"data"`
-	}
-	if err := json.Unmarshal(body, &nadiaResp); err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to parse Nadia API response: " + err.Error(),
-			Success:    false,
-		})
+	snapshot, ok := h.catalogSnapshot(c)
+	if !ok {
 		return
 	}
 
-	// Update packages with actual prices from price-list-all.json
-	for i := range nadiaResp.Data {
-		actualPrice := h.nadiaService.GetPackagePrice(nadiaResp.Data[i].PackageCode)
-		nadiaResp.Data[i].PackagePrice = actualPrice
-		nadiaResp.Data[i].PackagePriceFormatted = formatRupiah(actualPrice)
-	}
-
 	var filteredPackages []models.Package
-	for _, pkg := range nadiaResp.Data {
+	for _, pkg := range snapshot.Packages {
 		// Filter query (case-insensitive)
 		if searchReq.Query != "" {
 			query := strings.ToLower(searchReq.Query)
@@ -290,7 +233,7 @@ func (h *HTTPHandler) SearchPackages(c *gin.Context) {
 
 // RequestOTP godoc
 // @Summary Request OTP for phone number
-// @Description Request OTP code to be sent to the specified phone number.
+// @Description Request OTP code to be sent to the specified phone number. The response data carries a request_id identifying the OTP session.
 // @Tags otp
 // @Accept json
 // @Produce json
@@ -308,32 +251,32 @@ func (h *HTTPHandler) RequestOTP(c *gin.Context) {
 		return
 	}
 
-	payload := map[string]string{"phone": req.PhoneNumber}
-	resp, err := h.nadiaService.MakeRequest("POST", "/limited/xl/request-otp.json", payload)
+	resp, err := h.nadiaClient.RequestOTP(c.Request.Context(), req.PhoneNumber)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to request OTP: " + err.Error(), Success: false})
+		monitoring.RecordOTP(monitoring.OTPStageRequest, otpResult(err))
+		c.JSON(upstreamErrorResponse(c, err, "Failed to request OTP"))
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
 
-	if nadiaResp.Success {
-		if dataMap, ok := nadiaResp.Data.(map[string]interface{}); ok {
-			if authID, ok := dataMap["auth_id"].(string); ok {
-				h.transactionService.CreateOTPSession(req.PhoneNumber, authID)
-			}
+	if resp.Data.AuthID != "" {
+		session, err := h.transactionService.CreateOTPSession(c.Request.Context(), callerResellerID(c), req.PhoneNumber, resp.Data.AuthID)
+		if err != nil {
+			monitoring.RecordOTP(monitoring.OTPStageRequest, monitoring.OTPResultError)
+			c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to store OTP session: " + err.Error(), Success: false})
+			return
 		}
+		// Clients pass request_id to /otp/verify to pick this session
+		// when several OTPs were requested for the same phone.
+		resp.Data.RequestID = session.RequestID
 	}
 
-	c.JSON(resp.StatusCode, nadiaResp)
+	monitoring.RecordOTP(monitoring.OTPStageRequest, monitoring.OTPResultSuccess)
+	respondUpstream(c, resp)
 }
 
 // VerifyOTP godoc
 // @Summary Verify OTP and get access token
-// @Description Verify OTP code and get access token for purchasing packages
+// @Description Verify OTP code and get access token for purchasing packages. request_id selects the OTP session; without it the most recent session the caller requested for the phone is used. The access token is stored for the caller and phone number, so later calls by the same caller may send phone_number instead of access_token.
 // @Tags otp
 // @Accept json
 // @Produce json
@@ -351,37 +294,62 @@ func (h *HTTPHandler) VerifyOTP(c *gin.Context) {
 		return
 	}
 
-	session, err := h.transactionService.GetOTPSession(req.PhoneNumber)
+	session, err := h.transactionService.GetOTPSession(c.Request.Context(), callerResellerID(c), req.PhoneNumber, req.RequestID)
 	if err != nil {
+		monitoring.RecordOTP(monitoring.OTPStageVerify, monitoring.OTPResultNoSession)
 		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: err.Error(), Success: false})
 		return
 	}
 
-	payload := map[string]string{"phone": req.PhoneNumber, "auth_id": session.AuthID, "otp": req.OTPCode}
-	resp, err := h.nadiaService.MakeRequest("POST", "/limited/xl/request-login.json", payload)
+	resp, err := h.nadiaClient.Login(c.Request.Context(), req.PhoneNumber, session.AuthID, req.OTPCode)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to verify OTP: " + err.Error(), Success: false})
+		monitoring.RecordOTP(monitoring.OTPStageVerify, otpResult(err))
+		c.JSON(upstreamErrorResponse(c, err, "Failed to verify OTP"))
 		return
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
+	if resp.Data.AccessToken != "" {
+		status, err := h.tokenVault.Store(c.Request.Context(), callerResellerID(c), req.PhoneNumber, resp.Data.AccessToken)
+		if err != nil {
+			monitoring.RecordOTP(monitoring.OTPStageVerify, monitoring.OTPResultError)
+			c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to store XL session: " + err.Error(), Success: false})
+			return
+		}
+		resp.Data.TokenExpiresAt = &status.ExpiresAt
+	}
+
+	monitoring.RecordOTP(monitoring.OTPStageVerify, monitoring.OTPResultSuccess)
+	respondUpstream(c, resp)
+}
 
-	c.JSON(resp.StatusCode, nadiaResp)
+// otpResult maps an error of an upstream OTP call to a funnel result: upstream
+// refusing the OTP request or code is a rejection, anything else an error.
+func otpResult(err error) string {
+	var apiErr *nadia.Error
+	if errors.As(err, &apiErr) {
+		return monitoring.OTPResultRejected
+	}
+	return monitoring.OTPResultError
 }
 
 // PurchasePackage godoc
 // @Summary Purchase a package with access token
-// @Description Purchase a package using phone number, package code, payment method, and access token
+// @Description Purchase a package using phone number, package code, payment method, and access token. The access token may be omitted if one is stored for the phone number. Pass quote_id to buy at a price locked with POST /api/quotes. Reseller callers pay their quote or tier price from their wallet; the price is held until the purchase succeeds or fails.
 // @Tags purchase
 // @Accept json
 // @Produce json
 // @Param request body models.SimplePurchaseRequest true "Purchase request"
+// @Param Idempotency-Key header string false "Client-supplied key, unique per caller; retries with the same key return the original result"
 // @Success 200 {object} models.APIResponse
+// @Success 202 {object} models.APIResponse
 // @Failure 400 {object} models.APIResponse
+// @Failure 402 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Failure 410 {object} models.APIResponse
+// @Failure 422 {object} models.APIResponse
 // @Failure 500 {object} models.APIResponse
+// @Failure 503 {object} models.APIResponse
 // @Router /api/purchase [post]
 // @Security ApiKeyAuth
 // @Security BearerAuth
@@ -392,57 +360,235 @@ func (h *HTTPHandler) PurchasePackage(c *gin.Context) {
 		return
 	}
 
+	ctx := c.Request.Context()
+	tracing.SetAttributes(ctx, tracing.AttrPackageCode.String(req.PackageCode))
+
 	source := req.Source
 	if source == "" {
 		source = "api_direct"
 	}
-	txRecord := h.transactionService.RecordTransaction(req.PhoneNumber, req.PackageCode, "", req.PaymentMethod, source)
 
-	payload := map[string]interface{}{
-		"package_code":   req.PackageCode,
-		"phone":          utils.FormatPhoneNumber(req.PhoneNumber, true),
-		"access_token":   req.AccessToken,
-		"payment_method": req.PaymentMethod,
+	// Idempotency keys are scoped to the caller. Replays of an idempotent
+	// request skip validation: the original result is returned even if, for
+	// example, the daily limit has been reached since.
+	resellerID := callerResellerID(c)
+	key := idempotencyKey(c, req)
+	requestHash := purchaseRequestHash(req)
+	var existing *models.TransactionRecord
+	if key != "" {
+		var err error
+		if existing, err = h.transactionService.FindIdempotentTransaction(ctx, resellerID, key, requestHash); err != nil {
+			h.respondIdempotencyError(c, err)
+			return
+		}
+	}
+	var quote *models.PurchaseQuote
+	accessToken := req.AccessToken
+	if existing == nil {
+		var ok bool
+		if accessToken, ok = h.resolveAccessToken(c, req.PhoneNumber, req.AccessToken); !ok {
+			return
+		}
+		if req.QuoteID != "" {
+			q, err := h.quoteService.CheckQuote(ctx, resellerID, req.QuoteID, req.PackageCode, req.PaymentMethod)
+			if err != nil {
+				c.JSON(validationErrorResponse(err))
+				return
+			}
+			quote = q
+		}
+		if !h.validatePurchase(c, req) {
+			return
+		}
 	}
 
-	resp, err := h.nadiaService.MakeRequest("POST", "/limited/xl/beli-paket-otp.json", payload)
-	if err != nil {
-		h.transactionService.UpdateTransactionStatus(txRecord.ID, "FAILED", "", 0, 0, err.Error())
-		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to purchase package: " + err.Error(), Success: false})
+	// Once the transaction is recorded its bookkeeping is finished even if the
+	// client disconnects; only the upstream call is canceled with the request.
+	storeCtx := context.WithoutCancel(ctx)
+	var txRecord *models.TransactionRecord
+	if key != "" {
+		record, replay, err := h.transactionService.RecordIdempotentTransaction(storeCtx,
+			key, requestHash, req.PhoneNumber, req.PackageCode, "", req.PaymentMethod, source, resellerID)
+		if err != nil {
+			h.respondIdempotencyError(c, err)
+			return
+		}
+		if replay {
+			replayPurchaseResponse(c, record)
+			return
+		}
+		txRecord = record
+	} else {
+		txRecord = h.transactionService.RecordTransaction(storeCtx, req.PhoneNumber, req.PackageCode, "", req.PaymentMethod, source, resellerID)
+	}
+	tracing.SetAttributes(ctx, tracing.AttrTransactionID.String(txRecord.ID))
+
+	// Resellers pay from their wallet: hold the price before going upstream.
+	var charge int
+	if resellerID != 0 {
+		var ok bool
+		if charge, ok = h.holdPurchase(storeCtx, c, txRecord, quote); !ok {
+			return
+		}
+	}
+
+	if quote != nil {
+		if err := h.quoteService.RedeemQuote(storeCtx, quote.ID, txRecord.ID); err != nil {
+			h.transactionService.UpdateTransactionStatus(storeCtx, txRecord.ID, models.TxStatusFailed, "", 0, 0, err.Error(), models.TxActorAPI, nil)
+			status, resp := validationErrorResponse(err)
+			h.respondPurchase(c, txRecord, status, resp)
+			return
+		}
+	}
+
+	// A purchase that may have reached upstream keeps its idempotency key, so
+	// refuse to send it if that cannot be recorded.
+	if err := h.transactionService.MarkTransactionSubmitted(storeCtx, txRecord.ID); err != nil {
+		h.transactionService.UpdateTransactionStatus(storeCtx, txRecord.ID, models.TxStatusFailed, "", 0, 0, "failed to record submission: "+err.Error(), models.TxActorAPI, nil)
+		h.respondPurchase(c, txRecord, http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to record purchase", Success: false})
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
-
-	if nadiaResp.Success {
-		amount := h.nadiaService.GetPackagePrice(req.PackageCode)
-		var trxID, packageName string
-		var processingFee int
-		if dataMap, ok := nadiaResp.Data.(map[string]interface{}); ok {
-			trxID, _ = dataMap["trx_id"].(string)
-			packageName, _ = dataMap["package_name"].(string)
-			if fee, ok := dataMap["package_processing_fee"].(float64); ok {
-				processingFee = int(fee)
+
+	resp, err := h.nadiaClient.BuyPackage(ctx, nadia.PurchaseRequest{
+		PackageCode:   req.PackageCode,
+		Phone:         utils.FormatPhoneNumber(req.PhoneNumber, true),
+		AccessToken:   accessToken,
+		PaymentMethod: req.PaymentMethod,
+	})
+	malformed := errors.Is(err, nadia.ErrMalformedResponse)
+	var apiErr *nadia.Error
+	errors.As(err, &apiErr)
+	switch {
+	case malformed:
+		log.Printf("Failed to parse purchase response data: %v", err)
+	case purchaseRefused(err):
+		reason, payload := err.Error(), []byte(nil)
+		if apiErr != nil {
+			payload = apiErr.Body
+			if apiErr.Message != "" {
+				reason = apiErr.Message
 			}
 		}
-		h.transactionService.UpdateTransactionStatus(txRecord.ID, "SUCCESS", trxID, amount, processingFee, "")
-		if packageName != "" {
-			h.transactionService.UpdateTransactionPackageName(txRecord.ID, packageName)
+		h.transactionService.UpdateTransactionStatus(storeCtx, txRecord.ID, models.TxStatusFailed, "", 0, 0, reason, models.TxActorAPI, payload)
+		status, response := upstreamErrorResponse(c, err, "Failed to purchase package")
+		h.respondPurchase(c, txRecord, status, response)
+		return
+	case err != nil:
+		// The request was canceled, timed out, lost or failed upstream with
+		// a 5xx after it may have been processed, so the package may have
+		// been bought. The transaction keeps its wallet hold until it is
+		// checked upstream.
+		reason, payload := "purchase outcome unknown: "+err.Error(), []byte(nil)
+		if apiErr != nil {
+			payload = apiErr.Body
 		}
-		h.transactionService.DeleteOTPSession(req.PhoneNumber)
-	} else {
-		h.transactionService.UpdateTransactionStatus(txRecord.ID, "FAILED", "", 0, 0, nadiaResp.Message)
+		log.Printf("Transaction %s: %s, check it upstream and resolve it", txRecord.ID, reason)
+		h.transactionService.UpdateTransactionStatus(storeCtx, txRecord.ID, models.TxStatusProcessing, "", 0, 0, reason, models.TxActorAPI, payload)
+		h.respondPurchase(c, txRecord, http.StatusAccepted, models.APIResponse{
+			StatusCode: http.StatusAccepted,
+			Message:    "Purchase was sent but its outcome is unknown, check the transaction for its outcome",
+			Success:    true,
+			Data:       gin.H{"transaction_id": txRecord.ID, "status": models.TxStatusProcessing},
+		})
+		return
+	}
+
+	// Quoted purchases are charged the locked price and resellers their tier
+	// price held on their wallet; others are sold at cost.
+	var amount, cost int
+	if quote != nil {
+		amount, cost = quote.Price, quote.Cost
+	} else if snapshot, err := h.catalogService.Snapshot(storeCtx); err == nil {
+		cost = snapshot.Cost(req.PackageCode)
+		amount = cost
+	}
+	if charge > 0 {
+		amount = charge
+	}
+	data := &resp.Data
+
+	// With a trx_id the purchase is only accepted at this point; the reconciler
+	// moves it to a final status once check-transaction.json reports an outcome.
+	// Upstream accepted a purchase whose data could not be read, so its outcome
+	// is unknown: it stays PROCESSING until the reconciler finds it by trx_id.
+	status, reason := models.TxStatusSuccess, ""
+	switch {
+	case malformed:
+		status, reason = models.TxStatusProcessing, "purchase response data could not be read"
+		data.TrxID = purchaseTrxID(resp.Body)
+	case data.TrxID != "":
+		status = models.TxStatusProcessing
+		if isAwaitingPayment(req.PaymentMethod, data) {
+			status = models.TxStatusPendingPayment
+		}
+	}
+	result := services.PurchaseResult{Status: status, Amount: amount, Cost: cost, Reason: reason, Data: data, Payload: resp.Body}
+	if quote != nil {
+		result.QuoteID = quote.ID
+	}
+	h.transactionService.RecordPurchaseResult(storeCtx, txRecord.ID, result)
+	h.transactionService.DeleteOTPSession(storeCtx, callerResellerID(c), req.PhoneNumber)
+
+	if data.IsQris && data.QrisData != nil && data.QrisData.QrCode != "" {
+		data.QrisImageURL = "/api/transactions/" + txRecord.ID + "/qris.png"
+	}
+
+	if malformed {
+		h.respondPurchase(c, txRecord, http.StatusAccepted, models.APIResponse{
+			StatusCode: http.StatusAccepted,
+			Message:    "Purchase was accepted but its details could not be read, check the transaction for its outcome",
+			Success:    true,
+			Data:       gin.H{"transaction_id": txRecord.ID, "trx_id": data.TrxID, "status": status},
+		})
+		return
+	}
+	h.respondPurchase(c, txRecord, http.StatusOK, upstreamResponse(resp))
+}
+
+// purchaseRefused reports whether a purchase error means the package was
+// certainly not bought: upstream answered with a 4xx or "success": false, or
+// the request was never sent. A 5xx, such as a gateway error, may come after
+// the purchase went through.
+func purchaseRefused(err error) bool {
+	var apiErr *nadia.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatus < http.StatusInternalServerError
+	}
+	var unavailable *nadia.UnavailableError
+	return errors.As(err, &unavailable)
+}
+
+// purchaseTrxID reads the trx_id of a purchase response whose data did not
+// decode, so the reconciler can still settle the purchase.
+func purchaseTrxID(body []byte) string {
+	var envelope struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return ""
 	}
+	var trxID string
+	if err := json.Unmarshal(envelope.Data["trx_id"], &trxID); err != nil {
+		var number json.Number
+		if json.Unmarshal(envelope.Data["trx_id"], &number) == nil {
+			trxID = number.String()
+		}
+	}
+	return trxID
+}
 
-	c.JSON(resp.StatusCode, nadiaResp)
+// isAwaitingPayment reports whether a purchase response asks the customer to pay
+// via QRIS or an e-wallet deeplink before the package is processed.
+func isAwaitingPayment(paymentMethod string, data *models.PurchaseResponseData) bool {
+	if data.IsQris || data.HaveDeeplink {
+		return true
+	}
+	return !strings.EqualFold(paymentMethod, "BALANCE")
 }
 
 // GetDashboardData godoc
 // @Summary Get comprehensive dashboard data
-// @Description Get dashboard data including stats, recent transactions, balance, and real-time monitoring metrics
+// @Description Get dashboard data including stats, recent transactions, balance, and real-time monitoring metrics. The data covers every reseller, so this is admin only.
 // @Tags dashboard
 // @Accept json
 // @Produce json
@@ -453,12 +599,16 @@ func (h *HTTPHandler) PurchasePackage(c *gin.Context) {
 // @Security BearerAuth
 func (h *HTTPHandler) GetDashboardData(c *gin.Context) {
 	// This is a simplified version. A full implementation would fetch balance, invoice stats etc.
+	ctx := c.Request.Context()
 	dashboardData := models.DashboardData{
-		Stats:              h.transactionService.GetSystemStats(),
-		RecentTransactions: h.transactionService.GetRecentTransactions(10),
-		SourceBreakdown:    h.transactionService.GetSourceStats(),
+		Stats:              h.transactionService.GetSystemStats(ctx),
+		RecentTransactions: h.transactionService.GetRecentTransactions(ctx, 10),
+		SourceBreakdown:    h.transactionService.GetSourceStats(ctx),
 		SystemStatus:       "OPERATIONAL", // Simplified
 	}
+	for i, tx := range dashboardData.RecentTransactions {
+		dashboardData.RecentTransactions[i] = transactionForCaller(c, tx)
+	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
 		StatusCode: http.StatusOK,
@@ -468,9 +618,33 @@ func (h *HTTPHandler) GetDashboardData(c *gin.Context) {
 	})
 }
 
+// callerTransaction loads the transaction of the :id path parameter. Transactions
+// of other resellers are answered with the same 404 as missing ones, so their IDs
+// cannot be probed.
+func (h *HTTPHandler) callerTransaction(c *gin.Context) (*models.TransactionRecord, bool) {
+	tx, exists := h.transactionService.GetTransactionDetail(c.Request.Context(), c.Param("id"))
+	if exists && !middleware.CurrentPrincipal(c).IsAdmin() && tx.ResellerID != callerResellerID(c) {
+		exists = false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, models.APIResponse{StatusCode: http.StatusNotFound, Message: "Transaction not found", Success: false})
+		return nil, false
+	}
+	return tx, true
+}
+
+// transactionForCaller hides the internal cost and margin of a transaction
+// from every caller but the admin.
+func transactionForCaller(c *gin.Context, tx models.TransactionRecord) models.TransactionRecord {
+	if middleware.CurrentPrincipal(c).IsAdmin() {
+		return tx
+	}
+	return tx.Redacted()
+}
+
 // GetTransactionDetail godoc
 // @Summary Get transaction detail
-// @Description Get detailed information about a specific transaction
+// @Description Get detailed information about a specific transaction. Resellers only see their own transactions.
 // @Tags dashboard
 // @Accept json
 // @Produce json
@@ -481,18 +655,134 @@ func (h *HTTPHandler) GetDashboardData(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Security BearerAuth
 func (h *HTTPHandler) GetTransactionDetail(c *gin.Context) {
-	id := c.Param("id")
-	tx, exists := h.transactionService.GetTransactionDetail(id)
-	if !exists {
-		c.JSON(http.StatusNotFound, models.APIResponse{StatusCode: http.StatusNotFound, Message: "Transaction not found", Success: false})
+	tx, ok := h.callerTransaction(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Transaction detail retrieved successfully", Success: true, Data: transactionForCaller(c, *tx)})
+}
+
+// GetTransactionEvents godoc
+// @Summary Get transaction status history
+// @Description Get every status transition of a transaction with its actor, reason and upstream payload. Resellers only see their own transactions.
+// @Tags transactions
+// @Accept json
+// @Produce json
+// @Param id path string true "Transaction ID"
+// @Success 200 {object} models.APIResponse{data=[]models.TransactionEvent}
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/transactions/{id}/events [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetTransactionEvents(c *gin.Context) {
+	tx, ok := h.callerTransaction(c)
+	if !ok {
+		return
+	}
+
+	events, err := h.transactionService.GetTransactionEvents(c.Request.Context(), tx.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to get transaction events: " + err.Error(), Success: false})
 		return
 	}
-	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Transaction detail retrieved successfully", Success: true, Data: tx})
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Transaction events retrieved successfully", Success: true, Data: events})
+}
+
+// ResolveTransaction godoc
+// @Summary Resolve a transaction with an unknown outcome
+// @Description Settle a PROCESSING transaction that has no trx_id, e.g. after upstream answered with a 5xx or the request was cut off after the purchase was sent. Give the trx_id found upstream so the reconciler polls it, or the final status after checking upstream; SUCCESS captures and FAILED releases the wallet hold.
+// @Tags transactions
+// @Accept json
+// @Produce json
+// @Param id path string true "Transaction ID"
+// @Param request body models.TransactionResolveRequest true "Resolution"
+// @Success 200 {object} models.APIResponse{data=models.TransactionRecord}
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Router /api/transactions/{id}/resolve [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) ResolveTransaction(c *gin.Context) {
+	var req models.TransactionResolveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+	if req.Status == "" && req.TrxID == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: status or trx_id is required", Success: false})
+		return
+	}
+
+	tx, err := h.transactionService.ResolveTransaction(c.Request.Context(), c.Param("id"), req, middleware.CurrentPrincipal(c).Name)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrTransactionNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, services.ErrTransactionNotUnresolved), errors.Is(err, services.ErrInvalidTransition):
+			status = http.StatusConflict
+		}
+		c.JSON(status, models.APIResponse{StatusCode: status, Message: "Failed to resolve transaction: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Transaction resolved", Success: true, Data: tx})
+}
+
+// GetTransactionQris godoc
+// @Summary Get QRIS code of a transaction
+// @Description Render the QRIS payload of an unpaid transaction as a PNG image. Resellers only see their own transactions.
+// @Tags transactions
+// @Produce png
+// @Param id path string true "Transaction ID"
+// @Param size query int false "Image size in pixels (128-1024)" default(256)
+// @Success 200 {file} binary
+// @Failure 404 {object} models.APIResponse
+// @Failure 410 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/transactions/{id}/qris.png [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetTransactionQris(c *gin.Context) {
+	tx, ok := h.callerTransaction(c)
+	if !ok {
+		return
+	}
+	if !tx.IsQris || tx.QrCode == "" {
+		c.JSON(http.StatusNotFound, models.APIResponse{StatusCode: http.StatusNotFound, Message: "Transaction has no QRIS payment", Success: false})
+		return
+	}
+	if tx.Status != models.TxStatusPendingPayment || (tx.PaymentExpiredAt != nil && time.Now().After(*tx.PaymentExpiredAt)) {
+		c.JSON(http.StatusGone, models.APIResponse{StatusCode: http.StatusGone, Message: "QRIS payment is no longer payable (status " + tx.Status + ")", Success: false})
+		return
+	}
+
+	size := 256
+	if sizeStr := c.Query("size"); sizeStr != "" {
+		if parsed, err := strconv.Atoi(sizeStr); err == nil {
+			size = parsed
+		}
+	}
+	if size < 128 {
+		size = 128
+	} else if size > 1024 {
+		size = 1024
+	}
+
+	png, err := qrcode.Encode(tx.QrCode, qrcode.Medium, size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to render QRIS code: " + err.Error(), Success: false})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", png)
 }
 
 // CheckCardStatus godoc
 // @Summary Check XL card status and balance
-// @Description Check the status, balance, and active period of an XL card using access token from login verification
+// @Description Check the status, balance, and active period of an XL card using the access token from login verification, or the token stored for phone_number
 // @Tags card
 // @Accept json
 // @Produce json
@@ -510,18 +800,15 @@ func (h *HTTPHandler) CheckCardStatus(c *gin.Context) {
 		return
 	}
 
-	payload := map[string]string{"access_token": req.AccessToken}
+	accessToken, ok := h.resolveAccessToken(c, req.PhoneNumber, req.AccessToken)
+	if !ok {
+		return
+	}
 
-	resp, err := h.nadiaService.MakeRequest("POST", "/limited/xl/status-kartu.json", payload)
+	resp, err := h.nadiaClient.CardStatus(c.Request.Context(), accessToken)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to check card status: " + err.Error(), Success: false})
+		c.JSON(upstreamErrorResponse(c, err, "Failed to check card status"))
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
-
-	c.JSON(resp.StatusCode, nadiaResp)
+	respondUpstream(c, resp)
 }
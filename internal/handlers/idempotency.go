@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/services"
+	"github.com/nabilulilalbab/nadia/internal/utils"
+)
+
+// idempotencyKey returns the Idempotency-Key header, falling back to client_ref.
+func idempotencyKey(c *gin.Context, req models.SimplePurchaseRequest) string {
+	if key := strings.TrimSpace(c.GetHeader("Idempotency-Key")); key != "" {
+		return key
+	}
+	return strings.TrimSpace(req.ClientRef)
+}
+
+// purchaseRequestHash fingerprints the parts of a purchase that must match on replay.
+// The access token is excluded because clients may refresh it between retries.
+func purchaseRequestHash(req models.SimplePurchaseRequest) string {
+	parts := []string{
+		utils.NormalizePhoneNumber(req.PhoneNumber),
+		req.PackageCode,
+		strings.ToUpper(req.PaymentMethod),
+	}
+	if req.QuoteID != "" {
+		parts = append(parts, req.QuoteID)
+	}
+	fingerprint := strings.Join(parts, "|")
+	sum := sha256.Sum256([]byte(fingerprint))
+	return hex.EncodeToString(sum[:])
+}
+
+// respondIdempotencyError maps errors from RecordIdempotentTransaction to HTTP responses.
+func (h *HTTPHandler) respondIdempotencyError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrIdempotencyKeyConflict) {
+		c.JSON(http.StatusConflict, models.APIResponse{StatusCode: http.StatusConflict, Message: "Idempotency key was already used with a different request", Success: false})
+		return
+	}
+	if errors.Is(err, services.ErrIdempotencyKeyInUse) {
+		c.JSON(http.StatusConflict, models.APIResponse{StatusCode: http.StatusConflict, Message: "A request with this idempotency key is still being processed", Success: false})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to record transaction: " + err.Error(), Success: false})
+}
+
+// replayPurchaseResponse writes the stored response of an earlier request with
+// the same key. Without one, the request is either still in flight or was cut
+// off after upstream answered, in which case the transaction is the outcome.
+func replayPurchaseResponse(c *gin.Context, record *models.TransactionRecord) {
+	c.Header("Idempotent-Replayed", "true")
+	switch {
+	case record.ResponseBody != "":
+		c.Data(record.ResponseStatus, "application/json; charset=utf-8", []byte(record.ResponseBody))
+	case record.Status == models.TxStatusPending:
+		c.JSON(http.StatusConflict, models.APIResponse{
+			StatusCode: http.StatusConflict,
+			Message:    "A request with this idempotency key is still being processed",
+			Success:    false,
+			Data:       transactionForCaller(c, *record),
+		})
+	default:
+		c.JSON(http.StatusOK, models.APIResponse{
+			StatusCode: http.StatusOK,
+			Message:    "Purchase was already processed, its original response is unavailable",
+			Success:    record.Status != models.TxStatusFailed && record.Status != models.TxStatusExpired,
+			Data:       transactionForCaller(c, *record),
+		})
+	}
+}
+
+// respondPurchase writes a purchase response and stores it on the transaction for idempotent replays.
+func (h *HTTPHandler) respondPurchase(c *gin.Context, record *models.TransactionRecord, statusCode int, response models.APIResponse) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		c.JSON(statusCode, response)
+		return
+	}
+	if record.IdempotencyKey != "" {
+		// Stored even if the client is gone, so its retry gets the outcome.
+		h.transactionService.SaveTransactionResponse(context.WithoutCancel(c.Request.Context()), record.ID, statusCode, body)
+	}
+	c.Data(statusCode, "application/json; charset=utf-8", body)
+}
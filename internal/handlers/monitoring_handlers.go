@@ -128,7 +128,7 @@ func (h *HTTPHandler) GetPerformanceMetrics(c *gin.Context) {
 
 // GetUptimeStatus godoc
 // @Summary Get service uptime status
-// @Description Get service health status, uptime information, and availability metrics
+// @Description Get service health status, uptime information, and availability metrics. upstream_circuits lists the circuit breakers of the Nadia API endpoint groups; status is "degraded" while any of them is not closed.
 // @Tags monitoring
 // @Accept json
 // @Produce json
@@ -139,6 +139,12 @@ func (h *HTTPHandler) GetPerformanceMetrics(c *gin.Context) {
 // @Router /api/monitoring/uptime [get]
 func (h *HTTPHandler) GetUptimeStatus(c *gin.Context) {
 	status := monitoring.GetUptimeStatus()
+	circuits := h.nadiaClient.CircuitStatus()
+	status["upstream_status"] = upstreamStatus(circuits)
+	status["upstream_circuits"] = circuits
+	if upstreamStatus(circuits) != "ok" {
+		status["status"] = "degraded"
+	}
 	c.JSON(http.StatusOK, models.APIResponse{
 		StatusCode: http.StatusOK,
 		Message:    "Uptime status retrieved successfully",
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nabilulilalbab/nadia/internal/database"
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// GetPriceTiers godoc
+// @Summary List price tiers
+// @Description List all configured price tiers
+// @Tags pricing
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=[]models.PriceTier}
+// @Failure 500 {object} models.APIResponse
+// @Router /api/pricing/tiers [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetPriceTiers(c *gin.Context) {
+	tiers, err := h.pricingService.Tiers(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to retrieve price tiers: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Price tiers retrieved successfully", Success: true, Data: tiers})
+}
+
+// SavePriceTier godoc
+// @Summary Create or update a price tier
+// @Description Create a new price tier or update the description of an existing one
+// @Tags pricing
+// @Accept json
+// @Produce json
+// @Param request body models.PriceTier true "Price tier"
+// @Success 200 {object} models.APIResponse{data=models.PriceTier}
+// @Failure 400 {object} models.APIResponse
+// @Router /api/pricing/tiers [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) SavePriceTier(c *gin.Context) {
+	var req models.PriceTier
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+
+	if err := h.pricingService.SaveTier(c.Request.Context(), &req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Failed to save price tier: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Price tier saved successfully", Success: true, Data: req})
+}
+
+// DeletePriceTier godoc
+// @Summary Delete a price tier
+// @Description Delete a custom price tier together with all of its rules. Tiers assigned to resellers cannot be deleted.
+// @Tags pricing
+// @Accept json
+// @Produce json
+// @Param name path string true "Tier name"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Router /api/pricing/tiers/{name} [delete]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) DeletePriceTier(c *gin.Context) {
+	if err := h.pricingService.DeleteTier(c.Request.Context(), c.Param("name")); err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			status = http.StatusNotFound
+		case errors.Is(err, database.ErrTierInUse):
+			status = http.StatusConflict
+		}
+		c.JSON(status, models.APIResponse{StatusCode: status, Message: "Failed to delete price tier: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Price tier deleted successfully", Success: true})
+}
+
+// GetPriceRules godoc
+// @Summary List price rules
+// @Description List pricing rules, optionally filtered by tier
+// @Tags pricing
+// @Accept json
+// @Produce json
+// @Param tier query string false "Filter by tier name"
+// @Success 200 {object} models.APIResponse{data=[]models.PriceRule}
+// @Failure 500 {object} models.APIResponse
+// @Router /api/pricing/rules [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetPriceRules(c *gin.Context) {
+	rules, err := h.pricingService.Rules(c.Request.Context(), c.Query("tier"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to retrieve price rules: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Price rules retrieved successfully", Success: true, Data: rules})
+}
+
+// SavePriceRule godoc
+// @Summary Create or replace a price rule
+// @Description Create a rule for a tier. Scope is default, category (akrab, circle, ewallet, pulsa) or package (package code). A rule with the same tier, scope and target is replaced.
+// @Tags pricing
+// @Accept json
+// @Produce json
+// @Param request body models.PriceRule true "Price rule"
+// @Success 200 {object} models.APIResponse{data=models.PriceRule}
+// @Failure 400 {object} models.APIResponse
+// @Router /api/pricing/rules [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) SavePriceRule(c *gin.Context) {
+	var req models.PriceRule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+
+	if err := h.pricingService.SaveRule(c.Request.Context(), &req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Failed to save price rule: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Price rule saved successfully", Success: true, Data: req})
+}
+
+// DeletePriceRule godoc
+// @Summary Delete a price rule
+// @Description Delete a pricing rule by ID
+// @Tags pricing
+// @Accept json
+// @Produce json
+// @Param id path int true "Rule ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/pricing/rules/{id} [delete]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) DeletePriceRule(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid rule ID", Success: false})
+		return
+	}
+
+	if err := h.pricingService.DeleteRule(c.Request.Context(), id); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, sql.ErrNoRows) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, models.APIResponse{StatusCode: status, Message: "Failed to delete price rule: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Price rule deleted successfully", Success: true})
+}
+
+// GetPriceQuote godoc
+// @Summary Preview the price of a package for a tier
+// @Description Resolve the tier price of a package using the current catalog and pricing rules
+// @Tags pricing
+// @Accept json
+// @Produce json
+// @Param tier query string true "Tier name"
+// @Param package_code query string true "Package code"
+// @Success 200 {object} models.APIResponse{data=models.PriceQuote}
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/pricing/preview [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetPriceQuote(c *gin.Context) {
+	snapshot, ok := h.catalogSnapshot(c)
+	if !ok {
+		return
+	}
+
+	pkg, found := snapshot.Package(c.Query("package_code"))
+	if !found {
+		c.JSON(http.StatusNotFound, models.APIResponse{StatusCode: http.StatusNotFound, Message: "Package not found", Success: false})
+		return
+	}
+
+	quote, err := h.pricingService.Quote(c.Query("tier"), pkg, snapshot.Prices[pkg.PackageCode])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Price resolved successfully", Success: true, Data: quote})
+}
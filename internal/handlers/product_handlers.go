@@ -1,9 +1,8 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -12,44 +11,18 @@ import (
 
 	"github.com/nabilulilalbab/nadia/internal/models"
 	"github.com/nabilulilalbab/nadia/internal/services"
+	"github.com/nabilulilalbab/nadia/internal/utils"
 )
 
-// Build a map[package_code]price from price-list-all.json
-func (h *HTTPHandler) fetchPriceMap() (map[string]int, error) {
-	resp, err := h.nadiaService.MakeRequest("POST", "/limited/xl/price-list-all.json", nil)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var raw struct {
-		Data []struct {
-			PackageCode string `json:"package_code"`
-			Price       int    `json:"price"`
-		} `json:"data"`
-	}
-	if err := json.Unmarshal(body, &raw); err != nil {
-		return nil, err
-	}
-	m := make(map[string]int, len(raw.Data))
-	for _, it := range raw.Data {
-		m[it.PackageCode] = it.Price
-	}
-	return m, nil
-}
-
-// Convert Package to Product using a provided price map and markup
-func packageToProductWithPriceMap(pkg models.Package, priceMap map[string]int, markup int, ns *services.NadiaService) models.Product {
-	price := priceMap[pkg.PackageCode]
-	finalPrice := price + markup
+// Convert Package to Product using a tier price from the pricing engine
+func packageToProduct(pkg models.Package, finalPrice int) models.Product {
 	return models.Product{
 		PackageCode:             pkg.PackageCode,
 		PackageName:             pkg.PackageName,
 		PackageNameShort:        pkg.PackageNameShort,
 		PackageDescription:      pkg.PackageDescription,
 		PackagePrice:            finalPrice,
-		PackagePriceFormatted:   formatRupiah(finalPrice),
+		PackagePriceFormatted:   utils.FormatRupiah(finalPrice),
 		HaveDailyLimit:          pkg.HaveDailyLimit,
 		DailyLimitDetails:       pkg.DailyLimitDetails,
 		NoNeedLogin:             pkg.NoNeedLogin,
@@ -66,12 +39,20 @@ func packageToProductWithPriceMap(pkg models.Package, priceMap map[string]int, m
 	}
 }
 
-
-
+// respondPricingError answers a product listing whose tier could not be priced,
+// e.g. because a reseller's tier no longer exists.
+func respondPricingError(c *gin.Context, err error) {
+	c.JSON(http.StatusInternalServerError, models.APIResponse{
+		StatusCode: http.StatusInternalServerError,
+		Message:    "Failed to price products: " + err.Error(),
+		Success:    false,
+		ErrorCode:  models.ErrCodePriceUnavailable,
+	})
+}
 
 // GetAllProducts godoc
 // @Summary Get all available products for users
-// @Description Retrieve all available products from Nadia API with manipulated prices (+1500 rupiah)
+// @Description Retrieve all available products from Nadia API with prices from the "user" pricing tier
 // @Tags products
 // @Accept json
 // @Produce json
@@ -84,41 +65,22 @@ func (h *HTTPHandler) GetAllProducts(c *gin.Context) {
 	limitStr := c.DefaultQuery("limit", "100")
 	limit, _ := strconv.Atoi(limitStr)
 
-	// Fetch packages information
-	resp, err := h.nadiaService.MakeRequest("POST", "/limited/xl/package-list-all.json", nil)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to fetch products: " + err.Error(),
-			Success:    false,
-		})
-		return
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
-
-	// Convert packages to products with actual price + 1500
-	var packages []models.Package
-	packagesData, _ := json.Marshal(nadiaResp.Data)
-	json.Unmarshal(packagesData, &packages)
-
-	// Build price map once per request
-	priceMap, err := h.fetchPriceMap()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to fetch price data: " + err.Error(),
-			Success:    false,
-		})
+	snapshot, ok := h.catalogSnapshot(c)
+	if !ok {
 		return
 	}
 
 	var products []models.Product
-	for _, pkg := range packages {
-		products = append(products, packageToProductWithPriceMap(pkg, priceMap, 1500, h.nadiaService))
+	for _, pkg := range snapshot.Packages {
+		price, err := h.pricingService.Price(models.PriceTierUser, pkg, snapshot.Prices[pkg.PackageCode])
+		if errors.Is(err, services.ErrNoUpstreamPrice) {
+			continue
+		}
+		if err != nil {
+			respondPricingError(c, err)
+			return
+		}
+		products = append(products, packageToProduct(pkg, price))
 	}
 
 	// Apply limit if specified
@@ -136,7 +98,7 @@ func (h *HTTPHandler) GetAllProducts(c *gin.Context) {
 
 // SearchProducts godoc
 // @Summary Search products with filters for users
-// @Description Search and filter products by name, price, payment method, etc. with manipulated prices (+1500 rupiah)
+// @Description Search and filter products by name, price, payment method, etc. with prices from the "user" pricing tier
 // @Tags products
 // @Accept json
 // @Produce json
@@ -157,40 +119,22 @@ func (h *HTTPHandler) SearchProducts(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.nadiaService.MakeRequest("POST", "/limited/xl/package-list-all.json", nil)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to fetch products: " + err.Error(),
-			Success:    false,
-		})
-		return
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
-
-	// Convert packages to products with prices from new endpoint
-	var packages []models.Package
-	packagesData, _ := json.Marshal(nadiaResp.Data)
-	json.Unmarshal(packagesData, &packages)
-
-	// Build price map once per request
-	priceMap, err := h.fetchPriceMap()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to fetch price data: " + err.Error(),
-			Success:    false,
-		})
+	snapshot, ok := h.catalogSnapshot(c)
+	if !ok {
 		return
 	}
 
 	var filteredProducts []models.Product
-	for _, pkg := range packages {
-		product := packageToProductWithPriceMap(pkg, priceMap, 1500, h.nadiaService)
+	for _, pkg := range snapshot.Packages {
+		price, err := h.pricingService.Price(models.PriceTierUser, pkg, snapshot.Prices[pkg.PackageCode])
+		if errors.Is(err, services.ErrNoUpstreamPrice) {
+			continue
+		}
+		if err != nil {
+			respondPricingError(c, err)
+			return
+		}
+		product := packageToProduct(pkg, price)
 
 		// Apply filters
 		if searchReq.Query != "" {
@@ -201,7 +145,7 @@ func (h *HTTPHandler) SearchProducts(c *gin.Context) {
 			}
 		}
 
-		// Filter by price from new endpoint + 1500
+		// Filter by tier price
 		if searchReq.MaxPrice > 0 && product.PackagePrice > searchReq.MaxPrice {
 			continue
 		}
@@ -245,28 +189,18 @@ func (h *HTTPHandler) SearchProducts(c *gin.Context) {
 // @Router /api/user/products/stock [get]
 // @Security ApiKeyAuth || BearerAuth
 func (h *HTTPHandler) GetProductStock(c *gin.Context) {
-	resp, err := h.nadiaService.MakeRequest("GET", "/limited/xl/check-stock-package-global.json", nil)
+	resp, err := h.nadiaClient.GlobalStock(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to get product stock: " + err.Error(),
-			Success:    false,
-		})
+		c.JSON(upstreamErrorResponse(c, err, "Failed to get product stock"))
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
-
-	c.JSON(resp.StatusCode, nadiaResp)
+	respondUpstream(c, resp)
 }
 
 
 // GetAllResellerProducts godoc
 // @Summary Get all available products for resellers
-// @Description Retrieve all available products from Nadia API with prices from new price endpoint (reseller_price + 500 rupiah)
+// @Description Retrieve all available products from Nadia API with prices from the caller's reseller pricing tier (the "reseller" tier for the admin key)
 // @Tags reseller-products
 // @Accept json
 // @Produce json
@@ -279,41 +213,23 @@ func (h *HTTPHandler) GetAllResellerProducts(c *gin.Context) {
 	limitStr := c.DefaultQuery("limit", "100")
 	limit, _ := strconv.Atoi(limitStr)
 
-	// Fetch packages information
-	resp, err := h.nadiaService.MakeRequest("POST", "/limited/xl/package-list-all.json", nil)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to fetch products: " + err.Error(),
-			Success:    false,
-		})
-		return
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
-
-	// Convert packages to products with actual price + 500
-	var packages []models.Package
-	packagesData, _ := json.Marshal(nadiaResp.Data)
-	json.Unmarshal(packagesData, &packages)
-
-	// Build price map once per request (SAME AS USER ENDPOINT)
-	priceMap, err := h.fetchPriceMap()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to fetch price data: " + err.Error(),
-			Success:    false,
-		})
+	snapshot, ok := h.catalogSnapshot(c)
+	if !ok {
 		return
 	}
 
 	var products []models.Product
-	for _, pkg := range packages {
-		products = append(products, packageToProductWithPriceMap(pkg, priceMap, 500, h.nadiaService))
+	tier := callerTier(c, models.PriceTierReseller)
+	for _, pkg := range snapshot.Packages {
+		price, err := h.pricingService.Price(tier, pkg, snapshot.Prices[pkg.PackageCode])
+		if errors.Is(err, services.ErrNoUpstreamPrice) {
+			continue
+		}
+		if err != nil {
+			respondPricingError(c, err)
+			return
+		}
+		products = append(products, packageToProduct(pkg, price))
 	}
 
 	// Apply limit if specified
@@ -331,7 +247,7 @@ func (h *HTTPHandler) GetAllResellerProducts(c *gin.Context) {
 
 // SearchResellerProducts godoc
 // @Summary Search products with filters for resellers
-// @Description Search and filter products by name, price, payment method, etc. with prices from new price endpoint (reseller_price + 500 rupiah)
+// @Description Search and filter products by name, price, payment method, etc. with prices from the caller's reseller pricing tier (the "reseller" tier for the admin key)
 // @Tags reseller-products
 // @Accept json
 // @Produce json
@@ -352,40 +268,23 @@ func (h *HTTPHandler) SearchResellerProducts(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.nadiaService.MakeRequest("POST", "/limited/xl/package-list-all.json", nil)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to fetch products: " + err.Error(),
-			Success:    false,
-		})
-		return
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
-
-	// Convert packages to products with prices from new endpoint
-	var packages []models.Package
-	packagesData, _ := json.Marshal(nadiaResp.Data)
-	json.Unmarshal(packagesData, &packages)
-
-	// Build price map once per request (SAME AS USER ENDPOINT)
-	priceMap, err := h.fetchPriceMap()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to fetch price data: " + err.Error(),
-			Success:    false,
-		})
+	snapshot, ok := h.catalogSnapshot(c)
+	if !ok {
 		return
 	}
 
 	var filteredProducts []models.Product
-	for _, pkg := range packages {
-		product := packageToProductWithPriceMap(pkg, priceMap, 500, h.nadiaService)
+	tier := callerTier(c, models.PriceTierReseller)
+	for _, pkg := range snapshot.Packages {
+		price, err := h.pricingService.Price(tier, pkg, snapshot.Prices[pkg.PackageCode])
+		if errors.Is(err, services.ErrNoUpstreamPrice) {
+			continue
+		}
+		if err != nil {
+			respondPricingError(c, err)
+			return
+		}
+		product := packageToProduct(pkg, price)
 
 		// Apply filters
 		if searchReq.Query != "" {
@@ -396,7 +295,7 @@ func (h *HTTPHandler) SearchResellerProducts(c *gin.Context) {
 			}
 		}
 
-		// Filter by price from new endpoint + 500
+		// Filter by tier price
 		if searchReq.MaxPrice > 0 && product.PackagePrice > searchReq.MaxPrice {
 			continue
 		}
@@ -440,20 +339,10 @@ func (h *HTTPHandler) SearchResellerProducts(c *gin.Context) {
 // @Router /api/reseller/products/stock [get]
 // @Security ApiKeyAuth || BearerAuth
 func (h *HTTPHandler) GetResellerProductStock(c *gin.Context) {
-	resp, err := h.nadiaService.MakeRequest("GET", "/limited/xl/check-stock-package-global.json", nil)
+	resp, err := h.nadiaClient.GlobalStock(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			StatusCode: http.StatusInternalServerError,
-			Message:    "Failed to get product stock: " + err.Error(),
-			Success:    false,
-		})
+		c.JSON(upstreamErrorResponse(c, err, "Failed to get product stock"))
 		return
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	var nadiaResp models.APIResponse
-	json.Unmarshal(body, &nadiaResp)
-
-	c.JSON(resp.StatusCode, nadiaResp)
+	respondUpstream(c, resp)
 }
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/nabilulilalbab/nadia/internal/config"
+	"github.com/nabilulilalbab/nadia/internal/database"
+	"github.com/nabilulilalbab/nadia/internal/middleware"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/nadia"
+	"github.com/nabilulilalbab/nadia/internal/services"
+)
+
+// purchaseTestEnv is an HTTPHandler backed by a SQLite store and a fake Nadia
+// API whose beli-paket-otp.json answers with a fixed status and body.
+type purchaseTestEnv struct {
+	router       *gin.Engine
+	transactions *services.TransactionService
+	pricing      *services.PricingService
+	wallet       *services.WalletService
+	resellerID   int64
+}
+
+func newPurchaseTestEnv(t *testing.T, purchaseStatus int, purchaseBody string) *purchaseTestEnv {
+	t.Helper()
+	ctx := context.Background()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/oauth/request_token"):
+			w.Write([]byte(`{"success":true,"access_token":"session"}`))
+		case strings.HasSuffix(r.URL.Path, "/user/login"):
+			w.Write([]byte(`{"success":true,"token":"jwt"}`))
+		case strings.HasSuffix(r.URL.Path, "/package-list-all.json"):
+			w.Write([]byte(`{"statusCode":200,"success":true,"data":[{"package_code":"XL_TEST","package_name":"Test"},{"package_code":"XL_NOPRICE","package_name":"No price"}]}`))
+		case strings.HasSuffix(r.URL.Path, "/price-list-all.json"):
+			w.Write([]byte(`{"statusCode":200,"success":true,"data":[{"package_code":"XL_TEST","price":25000}]}`))
+		case strings.HasSuffix(r.URL.Path, "/beli-paket-otp.json"):
+			w.WriteHeader(purchaseStatus)
+			w.Write([]byte(purchaseBody))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	cfg := &config.Config{NadiaApiURL: upstream.URL, SSOApiBaseURL: upstream.URL, UpstreamTimeout: time.Second, TokenExpiry: time.Hour}
+	client := nadia.NewClient(cfg, nadia.NewTokenManager(cfg, http.DefaultTransport), http.DefaultTransport)
+
+	store, err := database.InitDatabase(database.DriverSQLite, filepath.Join(t.TempDir(), "nadia.db"))
+	if err != nil {
+		t.Fatalf("InitDatabase: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	transactions, err := services.NewTransactionService(store, services.NewMemoryOTPSessionStore())
+	if err != nil {
+		t.Fatalf("NewTransactionService: %v", err)
+	}
+	wallet := services.NewWalletService(store)
+	transactions.AddStatusListener(wallet.OnTransactionStatus)
+	pricing, err := services.NewPricingService(store)
+	if err != nil {
+		t.Fatalf("NewPricingService: %v", err)
+	}
+	resellers := services.NewResellerService(store, pricing)
+	catalog := services.NewCatalogService(client, time.Hour)
+	validator := services.NewPurchaseValidator(client, transactions)
+	quotes := services.NewQuoteService(store, catalog, pricing, time.Minute)
+
+	created, err := resellers.Create(ctx, models.ResellerRequest{Name: "shop"})
+	if err != nil {
+		t.Fatalf("Create reseller: %v", err)
+	}
+	if _, err := wallet.Deposit(ctx, created.Reseller.ID, 100000, "", "test", "test"); err != nil {
+		t.Fatalf("Deposit: %v", err)
+	}
+
+	h := NewHTTPHandler(client, transactions, catalog, pricing, validator, quotes, nil, resellers, nil, wallet, nil, nil, nil)
+	principal := &models.Principal{Kind: models.PrincipalReseller, ResellerID: created.Reseller.ID, Tier: created.Reseller.Tier, Scopes: models.AllScopes}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/purchase", func(c *gin.Context) { c.Set(middleware.PrincipalKey, principal) }, h.PurchasePackage)
+	router.POST("/api/quotes", func(c *gin.Context) { c.Set(middleware.PrincipalKey, principal) }, h.CreateQuote)
+	admin := &models.Principal{Kind: models.PrincipalAdmin, Name: "admin", Scopes: models.AllScopes}
+	router.POST("/api/transactions/:id/resolve", func(c *gin.Context) { c.Set(middleware.PrincipalKey, admin) }, h.ResolveTransaction)
+
+	return &purchaseTestEnv{router: router, transactions: transactions, pricing: pricing, wallet: wallet, resellerID: created.Reseller.ID}
+}
+
+// TestPurchaseUpstreamErrors checks that only refusals fail a purchase and
+// release its hold; a 5xx may come after the package was bought.
+func TestPurchaseUpstreamErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantCode   int
+		wantStatus string
+		wantHeld   bool
+	}{
+		{"bad gateway", http.StatusBadGateway, `<html>502 Bad Gateway</html>`, http.StatusAccepted, models.TxStatusProcessing, true},
+		{"gateway timeout", http.StatusGatewayTimeout, ``, http.StatusAccepted, models.TxStatusProcessing, true},
+		{"refused", http.StatusBadRequest, `{"statusCode":400,"success":false,"message":"Pulsa tidak cukup"}`, http.StatusBadRequest, models.TxStatusFailed, false},
+		{"success false", http.StatusOK, `{"statusCode":400,"success":false,"message":"Nomor tidak valid"}`, http.StatusOK, models.TxStatusFailed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newPurchaseTestEnv(t, tt.status, tt.body)
+			ctx := context.Background()
+
+			body, _ := json.Marshal(models.SimplePurchaseRequest{PhoneNumber: "087786388052", PackageCode: "XL_TEST", PaymentMethod: "BALANCE", AccessToken: "xl-token"})
+			w := httptest.NewRecorder()
+			env.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/purchase", bytes.NewReader(body)))
+			if w.Code != tt.wantCode {
+				t.Errorf("response status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+
+			recent := env.transactions.GetRecentTransactions(ctx, 1)
+			if len(recent) != 1 {
+				t.Fatalf("GetRecentTransactions = %d transactions, want 1", len(recent))
+			}
+			if recent[0].Status != tt.wantStatus {
+				t.Errorf("transaction status = %s, want %s", recent[0].Status, tt.wantStatus)
+			}
+			balance, err := env.wallet.Balance(ctx, env.resellerID)
+			if err != nil {
+				t.Fatalf("Balance: %v", err)
+			}
+			if held := balance.Held > 0; held != tt.wantHeld {
+				t.Errorf("held = %d, want held %v", balance.Held, tt.wantHeld)
+			}
+		})
+	}
+}
+
+// TestQuoteHidesCost checks that resellers do not learn our upstream cost
+// from a quote.
+func TestQuoteHidesCost(t *testing.T) {
+	env := newPurchaseTestEnv(t, http.StatusOK, `{}`)
+
+	body, _ := json.Marshal(models.QuoteRequest{PackageCode: "XL_TEST", PaymentMethod: "BALANCE"})
+	w := httptest.NewRecorder()
+	env.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/quotes", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("response status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), `"cost"`) {
+		t.Errorf("quote response exposes the cost: %s", w.Body.String())
+	}
+}
+
+// TestPurchaseWithoutUpstreamPrice checks that a package missing from the
+// price list is not sold at the markup alone.
+func TestPurchaseWithoutUpstreamPrice(t *testing.T) {
+	env := newPurchaseTestEnv(t, http.StatusOK, `{}`)
+	ctx := context.Background()
+	rule := &models.PriceRule{Tier: models.PriceTierReseller, Scope: models.PriceScopeDefault, MarkupType: "flat", MarkupValue: 2000}
+	if err := env.pricing.SaveRule(ctx, rule); err != nil {
+		t.Fatalf("SaveRule: %v", err)
+	}
+
+	body, _ := json.Marshal(models.SimplePurchaseRequest{PhoneNumber: "087786388052", PackageCode: "XL_NOPRICE", PaymentMethod: "BALANCE", AccessToken: "xl-token"})
+	w := httptest.NewRecorder()
+	env.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/purchase", bytes.NewReader(body)))
+	if !strings.Contains(w.Body.String(), models.ErrCodePriceUnavailable) {
+		t.Errorf("response = %d %s, want %s", w.Code, w.Body.String(), models.ErrCodePriceUnavailable)
+	}
+	balance, err := env.wallet.Balance(ctx, env.resellerID)
+	if err != nil {
+		t.Fatalf("Balance: %v", err)
+	}
+	if balance.Held != 0 {
+		t.Errorf("held = %d, want 0", balance.Held)
+	}
+}
+
+// TestResolveUnknownPurchase checks that a purchase with an unknown outcome
+// keeps its hold until an admin resolves it, and only once.
+func TestResolveUnknownPurchase(t *testing.T) {
+	env := newPurchaseTestEnv(t, http.StatusBadGateway, `<html>502 Bad Gateway</html>`)
+	ctx := context.Background()
+
+	body, _ := json.Marshal(models.SimplePurchaseRequest{PhoneNumber: "087786388052", PackageCode: "XL_TEST", PaymentMethod: "BALANCE", AccessToken: "xl-token"})
+	env.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/purchase", bytes.NewReader(body)))
+	recent := env.transactions.GetRecentTransactions(ctx, 1)
+	if len(recent) != 1 || recent[0].Status != models.TxStatusProcessing {
+		t.Fatalf("GetRecentTransactions = %+v, want one PROCESSING transaction", recent)
+	}
+	path := "/api/transactions/" + recent[0].ID + "/resolve"
+
+	resolve := func(req models.TransactionResolveRequest) int {
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		env.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+		return w.Code
+	}
+	if code := resolve(models.TransactionResolveRequest{Note: "nothing"}); code != http.StatusBadRequest {
+		t.Errorf("resolve without status or trx_id = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := resolve(models.TransactionResolveRequest{Status: models.TxStatusFailed, Note: "not bought upstream"}); code != http.StatusOK {
+		t.Fatalf("resolve = %d, want %d", code, http.StatusOK)
+	}
+	if code := resolve(models.TransactionResolveRequest{Status: models.TxStatusSuccess, Note: "again"}); code != http.StatusConflict {
+		t.Errorf("second resolve = %d, want %d", code, http.StatusConflict)
+	}
+
+	tx, _ := env.transactions.GetTransactionDetail(ctx, recent[0].ID)
+	if tx.Status != models.TxStatusFailed {
+		t.Errorf("transaction status = %s, want %s", tx.Status, models.TxStatusFailed)
+	}
+	balance, err := env.wallet.Balance(ctx, env.resellerID)
+	if err != nil {
+		t.Fatalf("Balance: %v", err)
+	}
+	if balance.Held != 0 || balance.Available != 100000 {
+		t.Errorf("balance = %+v, want the hold released", balance)
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/services"
+)
+
+// validatePurchase runs the pre-purchase checks and writes an error response
+// with a machine-readable error_code if the purchase must be rejected.
+func (h *HTTPHandler) validatePurchase(c *gin.Context, req models.SimplePurchaseRequest) bool {
+	snapshot, err := h.catalogService.Snapshot(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, models.APIResponse{StatusCode: http.StatusServiceUnavailable, Message: err.Error(), Success: false, ErrorCode: models.ErrCodeCatalogUnavailable})
+		return false
+	}
+
+	if err := h.purchaseValidator.Validate(c.Request.Context(), snapshot, req.PackageCode, req.PaymentMethod); err != nil {
+		c.JSON(validationErrorResponse(err))
+		return false
+	}
+	return true
+}
+
+// validationErrorResponse converts a *services.PurchaseValidationError into an
+// API response carrying its error code; other errors become a 500.
+func validationErrorResponse(err error) (int, models.APIResponse) {
+	var validationErr *services.PurchaseValidationError
+	if !errors.As(err, &validationErr) {
+		return http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: err.Error(), Success: false}
+	}
+
+	status := validationStatus(validationErr.Code)
+	resp := models.APIResponse{StatusCode: status, Message: validationErr.Message, Success: false, ErrorCode: validationErr.Code}
+	if len(validationErr.Details) > 0 {
+		resp.Data = validationErr.Details
+	}
+	return status, resp
+}
+
+// validationStatus maps a validation error code to an HTTP status.
+func validationStatus(code string) int {
+	switch code {
+	case models.ErrCodePackageNotFound, models.ErrCodeQuoteNotFound:
+		return http.StatusNotFound
+	case models.ErrCodePaymentMethodInvalid:
+		return http.StatusBadRequest
+	case models.ErrCodeQuoteUsed:
+		return http.StatusConflict
+	case models.ErrCodeQuoteExpired:
+		return http.StatusGone
+	case models.ErrCodeStockCheckFailed, models.ErrCodeCatalogUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nabilulilalbab/nadia/internal/middleware"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/services"
+)
+
+// CreateQuote godoc
+// @Summary Create a purchase quote
+// @Description Lock the price of a package and payment method for a short window. Pass the returned id as quote_id to /api/purchase to buy at exactly this price. The tier defaults to reseller; reseller API keys always get the tier of their account. Only the caller that created a quote can redeem it. The upstream cost is only shown to the admin.
+// @Tags purchase
+// @Accept json
+// @Produce json
+// @Param request body models.QuoteRequest true "Quote request"
+// @Success 200 {object} models.APIResponse{data=models.PurchaseQuote}
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 503 {object} models.APIResponse
+// @Router /api/quotes [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) CreateQuote(c *gin.Context) {
+	var req models.QuoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+
+	tier := strings.ToLower(strings.TrimSpace(req.Tier))
+	if tier == "" {
+		tier = models.PriceTierReseller
+	}
+	if p := middleware.CurrentPrincipal(c); p != nil && p.Kind == models.PrincipalReseller {
+		// Resellers always buy at their own tier.
+		tier = p.Tier
+	}
+	if !h.pricingService.HasTier(tier) {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Unknown price tier: " + tier, Success: false})
+		return
+	}
+
+	quote, err := h.quoteService.CreateQuote(c.Request.Context(), callerResellerID(c), tier, req.PackageCode, req.PaymentMethod)
+	if err != nil {
+		var validationErr *services.PurchaseValidationError
+		if errors.As(err, &validationErr) {
+			c.JSON(validationErrorResponse(err))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to create quote: " + err.Error(), Success: false})
+		return
+	}
+
+	if !middleware.CurrentPrincipal(c).IsAdmin() {
+		redacted := quote.Redacted()
+		quote = &redacted
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Quote created successfully", Success: true, Data: quote})
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nabilulilalbab/nadia/internal/middleware"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/services"
+)
+
+// callerTier returns the price tier of the calling reseller, or fallback for
+// admins and other callers.
+func callerTier(c *gin.Context, fallback string) string {
+	if p := middleware.CurrentPrincipal(c); p != nil && p.Kind == models.PrincipalReseller {
+		return p.Tier
+	}
+	return fallback
+}
+
+// callerResellerID returns the reseller ID of the caller, or 0 for admins and
+// other callers, which own what they create jointly.
+func callerResellerID(c *gin.Context) int64 {
+	if p := middleware.CurrentPrincipal(c); p != nil && p.Kind == models.PrincipalReseller {
+		return p.ResellerID
+	}
+	return 0
+}
+
+// resellerID parses the :id path parameter, writing a 400 response if it is invalid.
+func resellerID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid reseller ID", Success: false})
+		return 0, false
+	}
+	return id, true
+}
+
+func resellerErrorStatus(err error) int {
+	if errors.Is(err, services.ErrResellerNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+// GetResellers godoc
+// @Summary List resellers
+// @Description List all reseller accounts. API keys are never returned, only their prefix.
+// @Tags resellers
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=[]models.Reseller}
+// @Failure 403 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/resellers [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetResellers(c *gin.Context) {
+	resellers, err := h.resellerService.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to retrieve resellers: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Resellers retrieved successfully", Success: true, Data: resellers})
+}
+
+// CreateReseller godoc
+// @Summary Create a reseller
+// @Description Create a reseller account with its own API key, price tier and scopes (catalog, purchase, card, reports). The API key is only returned once.
+// @Tags resellers
+// @Accept json
+// @Produce json
+// @Param request body models.ResellerRequest true "Reseller"
+// @Success 201 {object} models.APIResponse{data=models.ResellerKeyResponse}
+// @Failure 400 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Router /api/resellers [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) CreateReseller(c *gin.Context) {
+	var req models.ResellerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+
+	created, err := h.resellerService.Create(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Failed to create reseller: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusCreated, models.APIResponse{StatusCode: http.StatusCreated, Message: "Reseller created successfully, store the API key now", Success: true, Data: created})
+}
+
+// UpdateReseller godoc
+// @Summary Update a reseller
+// @Description Change the name, price tier, scopes or status (active/suspended) of a reseller. Omitted fields are left unchanged.
+// @Tags resellers
+// @Accept json
+// @Produce json
+// @Param id path int true "Reseller ID"
+// @Param request body models.ResellerRequest true "Reseller"
+// @Success 200 {object} models.APIResponse{data=models.Reseller}
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/resellers/{id} [put]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) UpdateReseller(c *gin.Context) {
+	id, ok := resellerID(c)
+	if !ok {
+		return
+	}
+	var req models.ResellerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+
+	reseller, err := h.resellerService.Update(c.Request.Context(), id, req)
+	if err != nil {
+		status := resellerErrorStatus(err)
+		c.JSON(status, models.APIResponse{StatusCode: status, Message: "Failed to update reseller: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Reseller updated successfully", Success: true, Data: reseller})
+}
+
+// RotateResellerKey godoc
+// @Summary Rotate a reseller API key
+// @Description Issue a new API key for a reseller. The previous key and the refresh tokens obtained with it stop working immediately.
+// @Tags resellers
+// @Accept json
+// @Produce json
+// @Param id path int true "Reseller ID"
+// @Success 200 {object} models.APIResponse{data=models.ResellerKeyResponse}
+// @Failure 404 {object} models.APIResponse
+// @Router /api/resellers/{id}/rotate-key [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) RotateResellerKey(c *gin.Context) {
+	id, ok := resellerID(c)
+	if !ok {
+		return
+	}
+
+	rotated, err := h.authService.RotateResellerKey(c.Request.Context(), id)
+	if err != nil {
+		status := resellerErrorStatus(err)
+		if status == http.StatusBadRequest {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, models.APIResponse{StatusCode: status, Message: "Failed to rotate reseller key: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Reseller API key rotated, store the new key now", Success: true, Data: rotated})
+}
+
+// GetCurrentPrincipal godoc
+// @Summary Show the authenticated caller
+// @Description Return the identity, price tier and scopes resolved from the API key or token of the request
+// @Tags authentication
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=models.Principal}
+// @Failure 401 {object} models.APIResponse
+// @Router /api/auth/me [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetCurrentPrincipal(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Caller identity retrieved successfully", Success: true, Data: middleware.CurrentPrincipal(c)})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/services"
+)
+
+// resolveAccessToken returns the access token given by the caller or, if none
+// was given, the one the caller stored in the token vault for the phone number. It writes
+// an error response and returns false if no token is available.
+func (h *HTTPHandler) resolveAccessToken(c *gin.Context, phone, accessToken string) (string, bool) {
+	if accessToken != "" {
+		return accessToken, true
+	}
+	if phone == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: phone_number or access_token is required", Success: false})
+		return "", false
+	}
+
+	token, err := h.tokenVault.Lookup(c.Request.Context(), callerResellerID(c), phone)
+	switch {
+	case err == nil:
+		return token, true
+	case errors.Is(err, services.ErrXLTokenNotFound):
+		c.JSON(http.StatusNotFound, models.APIResponse{StatusCode: http.StatusNotFound, Message: err.Error(), Success: false, ErrorCode: models.ErrCodeXLSessionNotFound})
+	case errors.Is(err, services.ErrXLTokenExpired):
+		c.JSON(http.StatusGone, models.APIResponse{StatusCode: http.StatusGone, Message: err.Error(), Success: false, ErrorCode: models.ErrCodeXLSessionExpired})
+	default:
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to load XL session: " + err.Error(), Success: false})
+	}
+	return "", false
+}
+
+// RevokeXLSession godoc
+// @Summary Revoke a stored XL session
+// @Description Delete the XL access token the caller stored for a phone number after OTP verification
+// @Tags otp
+// @Accept json
+// @Produce json
+// @Param phone path string true "Phone number"
+// @Success 200 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 500 {object} models.APIResponse
+// @Router /api/sessions/{phone} [delete]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) RevokeXLSession(c *gin.Context) {
+	err := h.tokenVault.Revoke(c.Request.Context(), callerResellerID(c), c.Param("phone"))
+	if errors.Is(err, services.ErrXLTokenNotFound) {
+		c.JSON(http.StatusNotFound, models.APIResponse{StatusCode: http.StatusNotFound, Message: err.Error(), Success: false, ErrorCode: models.ErrCodeXLSessionNotFound})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to revoke XL session: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "XL session revoked successfully", Success: true})
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nabilulilalbab/nadia/internal/middleware"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/services"
+)
+
+// streamHeartbeat is how often a comment line is sent on idle streams, so
+// proxies keep the connection open.
+const streamHeartbeat = 15 * time.Second
+
+// StreamEvents godoc
+// @Summary Stream live events
+// @Description Server-Sent Events stream of transaction.created and transaction.updated events and, for the admin, a metrics snapshot every few seconds in the format of /api/monitoring/performance. Resellers only receive their own transactions. Each event carries an id; reconnect with the Last-Event-ID header (or last_event_id) to receive missed events. Browsers using EventSource can pass a JWT as access_token.
+// @Tags events
+// @Produce text/event-stream
+// @Param types query string false "Comma-separated event types or groups, e.g. transaction,metrics or transaction.updated"
+// @Param status query string false "Comma-separated transaction statuses, e.g. SUCCESS,FAILED"
+// @Param source query string false "Comma-separated transaction sources, e.g. telegram,whatsapp"
+// @Param transaction_id query string false "Comma-separated transaction IDs"
+// @Param last_event_id query int false "Resume after this event ID"
+// @Param access_token query string false "JWT access token for clients that cannot set headers"
+// @Success 200 {string} string "text/event-stream"
+// @Failure 401 {object} models.APIResponse
+// @Router /api/events/stream [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) StreamEvents(c *gin.Context) {
+	principal := middleware.CurrentPrincipal(c)
+	filter := services.EventFilter{
+		Types:          services.ParseFilterSet(c.Query("types"), false),
+		Statuses:       services.ParseFilterSet(c.Query("status"), true),
+		Sources:        services.ParseFilterSet(c.Query("source"), false),
+		TransactionIDs: services.ParseFilterSet(c.Query("transaction_id"), false),
+	}
+	if !principal.IsAdmin() {
+		filter.OwnOnly = true
+		filter.ResellerID = principal.ResellerID
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	after, _ := strconv.ParseInt(lastEventID, 10, 64)
+
+	sub := h.eventBroker.Subscribe(filter, after)
+	defer h.eventBroker.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				// Dropped for lagging behind; the client reconnects with Last-Event-ID.
+				return
+			}
+			if !principal.IsAdmin() {
+				event = redactStreamEvent(event)
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+		case <-c.Request.Context().Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// redactStreamEvent hides the internal cost and margin of a transaction from resellers.
+func redactStreamEvent(event models.StreamEvent) models.StreamEvent {
+	if change, ok := event.Data.(models.TransactionChange); ok {
+		change.Transaction = change.Transaction.Redacted()
+		event.Data = change
+	}
+	return event
+}
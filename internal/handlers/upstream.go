@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/nadia"
+)
+
+// respondUpstream writes a successful Nadia API response with its upstream
+// status code and message.
+func respondUpstream[T any](c *gin.Context, resp *nadia.Response[T]) {
+	c.JSON(http.StatusOK, upstreamResponse(resp))
+}
+
+func upstreamResponse[T any](resp *nadia.Response[T]) models.APIResponse {
+	return models.APIResponse{StatusCode: resp.StatusCode, Message: resp.Message, Success: true, Data: resp.Data}
+}
+
+// upstreamStatus summarizes the circuit breakers of the Nadia API: "ok" when
+// all are closed, "degraded" otherwise.
+func upstreamStatus(circuits []nadia.CircuitStatus) string {
+	for _, circuit := range circuits {
+		if circuit.State != nadia.StateClosed {
+			return "degraded"
+		}
+	}
+	return "ok"
+}
+
+// upstreamErrorResponse maps an error of the Nadia client to a response. A
+// failure answered by upstream keeps its HTTP status and message. A request
+// failed fast by the circuit breaker or concurrency cap is a 503 with a
+// Retry-After header; any other error is a 500 whose message starts with
+// action, such as "Failed to get balance".
+func upstreamErrorResponse(c *gin.Context, err error, action string) (int, models.APIResponse) {
+	var apiErr *nadia.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatus, models.APIResponse{StatusCode: apiErr.StatusCode, Message: apiErr.Message, Success: false}
+	}
+
+	var unavailable *nadia.UnavailableError
+	if errors.As(err, &unavailable) {
+		seconds := int(math.Ceil(unavailable.RetryAfter.Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		return http.StatusServiceUnavailable, models.APIResponse{
+			StatusCode: http.StatusServiceUnavailable,
+			Message:    fmt.Sprintf("%s: Nadia API is temporarily unavailable, retry in %d seconds", action, seconds),
+			Success:    false,
+			ErrorCode:  models.ErrCodeUpstreamUnavailable,
+		}
+	}
+
+	return http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: action + ": " + err.Error(), Success: false}
+}
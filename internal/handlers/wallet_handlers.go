@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nabilulilalbab/nadia/internal/middleware"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/services"
+)
+
+// holdPurchase holds the price of a reseller purchase on the reseller's
+// wallet: the quote price if the purchase is quoted, otherwise the price of
+// the reseller's tier. On failure the transaction is marked FAILED, a
+// response is written and false is returned.
+func (h *HTTPHandler) holdPurchase(ctx context.Context, c *gin.Context, record *models.TransactionRecord, quote *models.PurchaseQuote) (int, bool) {
+	principal := middleware.CurrentPrincipal(c)
+
+	var price int
+	if quote != nil {
+		price = quote.Price
+	} else {
+		snapshot, err := h.catalogService.Snapshot(ctx)
+		if err != nil {
+			h.transactionService.UpdateTransactionStatus(ctx, record.ID, models.TxStatusFailed, "", 0, 0, err.Error(), models.TxActorAPI, nil)
+			h.respondPurchase(c, record, http.StatusServiceUnavailable, models.APIResponse{StatusCode: http.StatusServiceUnavailable, Message: "Catalog unavailable: " + err.Error(), Success: false, ErrorCode: models.ErrCodeCatalogUnavailable})
+			return 0, false
+		}
+		pkg, ok := snapshot.Package(record.PackageCode)
+		if !ok {
+			return 0, h.rejectHold(ctx, c, record, &services.PurchaseValidationError{
+				Code:    models.ErrCodePackageNotFound,
+				Message: fmt.Sprintf("Package %s not found", record.PackageCode),
+			})
+		}
+		if price, err = h.pricingService.Price(principal.Tier, pkg, snapshot.Prices[record.PackageCode]); err != nil {
+			return 0, h.rejectHold(ctx, c, record, &services.PurchaseValidationError{
+				Code:    models.ErrCodePriceUnavailable,
+				Message: fmt.Sprintf("Package %s has no price: %v", record.PackageCode, err),
+			})
+		}
+	}
+	if price <= 0 {
+		return 0, h.rejectHold(ctx, c, record, &services.PurchaseValidationError{
+			Code:    models.ErrCodePriceUnavailable,
+			Message: fmt.Sprintf("Package %s has no price for tier %s", record.PackageCode, principal.Tier),
+		})
+	}
+
+	if err := h.walletService.Hold(ctx, principal.ResellerID, record.ID, price); err != nil {
+		h.transactionService.UpdateTransactionStatus(ctx, record.ID, models.TxStatusFailed, "", 0, 0, err.Error(), models.TxActorAPI, nil)
+		if errors.Is(err, services.ErrInsufficientBalance) {
+			h.respondPurchase(c, record, http.StatusPaymentRequired, models.APIResponse{StatusCode: http.StatusPaymentRequired, Message: "Purchase rejected: " + err.Error(), Success: false, ErrorCode: models.ErrCodeInsufficientBalance})
+		} else {
+			h.respondPurchase(c, record, http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to hold wallet balance: " + err.Error(), Success: false})
+		}
+		return 0, false
+	}
+	return price, true
+}
+
+// rejectHold marks a purchase FAILED before anything is held and writes the
+// response for its validation error. It returns false for holdPurchase.
+func (h *HTTPHandler) rejectHold(ctx context.Context, c *gin.Context, record *models.TransactionRecord, err *services.PurchaseValidationError) bool {
+	h.transactionService.UpdateTransactionStatus(ctx, record.ID, models.TxStatusFailed, "", 0, 0, err.Message, models.TxActorAPI, nil)
+	status, resp := validationErrorResponse(err)
+	h.respondPurchase(c, record, status, resp)
+	return false
+}
+
+// GetResellerWallet godoc
+// @Summary Get a reseller wallet balance
+// @Description Get the available and held balance of a reseller wallet. Held money is reserved for purchases that are not final yet.
+// @Tags wallet
+// @Accept json
+// @Produce json
+// @Param id path int true "Reseller ID"
+// @Success 200 {object} models.APIResponse{data=models.WalletBalance}
+// @Failure 404 {object} models.APIResponse
+// @Router /api/resellers/{id}/wallet [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetResellerWallet(c *gin.Context) {
+	id, ok := h.existingReseller(c)
+	if !ok {
+		return
+	}
+	balance, err := h.walletService.Balance(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to retrieve wallet balance: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Wallet balance retrieved successfully", Success: true, Data: balance})
+}
+
+// GetResellerLedger godoc
+// @Summary Get a reseller wallet ledger
+// @Description List the ledger journals of a reseller wallet with their double-entry lines, newest first
+// @Tags wallet
+// @Accept json
+// @Produce json
+// @Param id path int true "Reseller ID"
+// @Param limit query int false "Limit number of journals returned" default(100)
+// @Success 200 {object} models.APIResponse{data=[]models.LedgerJournal}
+// @Failure 404 {object} models.APIResponse
+// @Router /api/resellers/{id}/wallet/ledger [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetResellerLedger(c *gin.Context) {
+	id, ok := h.existingReseller(c)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	journals, err := h.walletService.Ledger(c.Request.Context(), id, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to retrieve wallet ledger: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Wallet ledger retrieved successfully", Success: true, Data: journals})
+}
+
+// DepositResellerWallet godoc
+// @Summary Deposit to a reseller wallet
+// @Description Credit a reseller wallet, e.g. after a confirmed bank transfer. A reference can only be deposited once.
+// @Tags wallet
+// @Accept json
+// @Produce json
+// @Param id path int true "Reseller ID"
+// @Param request body models.WalletDepositRequest true "Deposit"
+// @Success 200 {object} models.APIResponse{data=models.LedgerJournal}
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Router /api/resellers/{id}/wallet/deposit [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) DepositResellerWallet(c *gin.Context) {
+	id, ok := h.existingReseller(c)
+	if !ok {
+		return
+	}
+	var req models.WalletDepositRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+
+	journal, err := h.walletService.Deposit(c.Request.Context(), id, req.Amount, req.Reference, req.Memo, middleware.CurrentPrincipal(c).Name)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrDuplicateReference) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, models.APIResponse{StatusCode: status, Message: "Failed to deposit: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Deposit recorded successfully", Success: true, Data: journal})
+}
+
+// AdjustResellerWallet godoc
+// @Summary Adjust a reseller wallet
+// @Description Correct a reseller wallet by a positive or negative amount with a memo. The available balance cannot become negative.
+// @Tags wallet
+// @Accept json
+// @Produce json
+// @Param id path int true "Reseller ID"
+// @Param request body models.WalletAdjustmentRequest true "Adjustment"
+// @Success 200 {object} models.APIResponse{data=models.LedgerJournal}
+// @Failure 400 {object} models.APIResponse
+// @Failure 402 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/resellers/{id}/wallet/adjust [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) AdjustResellerWallet(c *gin.Context) {
+	id, ok := h.existingReseller(c)
+	if !ok {
+		return
+	}
+	var req models.WalletAdjustmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+
+	journal, err := h.walletService.Adjust(c.Request.Context(), id, req.Amount, req.Memo, middleware.CurrentPrincipal(c).Name)
+	if err != nil {
+		status := http.StatusInternalServerError
+		errorCode := ""
+		if errors.Is(err, services.ErrInsufficientBalance) {
+			status, errorCode = http.StatusPaymentRequired, models.ErrCodeInsufficientBalance
+		}
+		c.JSON(status, models.APIResponse{StatusCode: status, Message: "Failed to adjust wallet: " + err.Error(), Success: false, ErrorCode: errorCode})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Wallet adjusted successfully", Success: true, Data: journal})
+}
+
+// existingReseller parses the :id path parameter and checks that the reseller
+// exists, writing an error response otherwise.
+func (h *HTTPHandler) existingReseller(c *gin.Context) (int64, bool) {
+	id, ok := resellerID(c)
+	if !ok {
+		return 0, false
+	}
+	if _, err := h.resellerService.Get(c.Request.Context(), id); err != nil {
+		status := resellerErrorStatus(err)
+		if status == http.StatusBadRequest {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, models.APIResponse{StatusCode: status, Message: "Failed to load reseller: " + err.Error(), Success: false})
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nabilulilalbab/nadia/internal/middleware"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/services"
+)
+
+func webhookErrorResponse(err error, message string) (int, models.APIResponse) {
+	status, errorCode := http.StatusInternalServerError, ""
+	switch {
+	case errors.Is(err, services.ErrWebhookNotFound):
+		status, errorCode = http.StatusNotFound, models.ErrCodeWebhookNotFound
+	case errors.Is(err, services.ErrInvalidWebhook):
+		status = http.StatusBadRequest
+	}
+	return status, models.APIResponse{StatusCode: status, Message: message + ": " + err.Error(), Success: false, ErrorCode: errorCode}
+}
+
+// ownedWebhook loads the endpoint of the :id path parameter. Resellers only
+// see their own endpoints; other endpoints are reported as not found.
+func (h *HTTPHandler) ownedWebhook(c *gin.Context) (*models.WebhookEndpoint, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid webhook ID", Success: false})
+		return nil, false
+	}
+	endpoint, err := h.webhookService.Get(c.Request.Context(), id)
+	if err == nil && !ownsWebhook(c, endpoint.ResellerID) {
+		err = services.ErrWebhookNotFound
+	}
+	if err != nil {
+		c.JSON(webhookErrorResponse(err, "Failed to load webhook"))
+		return nil, false
+	}
+	return endpoint, true
+}
+
+// ownedDelivery loads the delivery of the :delivery_id path parameter if the
+// caller owns its endpoint.
+func (h *HTTPHandler) ownedDelivery(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid delivery ID", Success: false})
+		return 0, false
+	}
+	delivery, err := h.webhookService.Delivery(c.Request.Context(), id)
+	if err == nil {
+		var endpoint *models.WebhookEndpoint
+		if endpoint, err = h.webhookService.Get(c.Request.Context(), delivery.EndpointID); err == nil && !ownsWebhook(c, endpoint.ResellerID) {
+			err = services.ErrWebhookNotFound
+		}
+	}
+	if err != nil {
+		c.JSON(webhookErrorResponse(err, "Failed to load delivery"))
+		return 0, false
+	}
+	return id, true
+}
+
+func ownsWebhook(c *gin.Context, resellerID int64) bool {
+	p := middleware.CurrentPrincipal(c)
+	return p.IsAdmin() || p.ResellerID == resellerID
+}
+
+// GetWebhooks godoc
+// @Summary List webhook endpoints
+// @Description List the webhook endpoints of the calling reseller. The admin sees the endpoints of all resellers.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=[]models.WebhookEndpoint}
+// @Router /api/webhooks [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetWebhooks(c *gin.Context) {
+	owner := int64(-1)
+	if p := middleware.CurrentPrincipal(c); !p.IsAdmin() {
+		owner = p.ResellerID
+	}
+	endpoints, err := h.webhookService.List(c.Request.Context(), owner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to retrieve webhooks: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Webhooks retrieved successfully", Success: true, Data: endpoints})
+}
+
+// CreateWebhook godoc
+// @Summary Register a webhook endpoint
+// @Description Register a URL that receives a signed POST for every status change of the caller's transactions. Events: transaction.pending, transaction.pending_payment, transaction.processing, transaction.success, transaction.failed, transaction.refunded, transaction.expired; leave events empty for all. Verify X-Nadia-Signature "t=<ts>,v1=<sig>" as hex HMAC-SHA256 of "<ts>.<body>" with the secret, which is only returned here.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param request body models.WebhookEndpointRequest true "Webhook endpoint"
+// @Success 201 {object} models.APIResponse{data=models.WebhookSecretResponse}
+// @Failure 400 {object} models.APIResponse
+// @Router /api/webhooks [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) CreateWebhook(c *gin.Context) {
+	var req models.WebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+
+	created, err := h.webhookService.Create(c.Request.Context(), middleware.CurrentPrincipal(c).ResellerID, req)
+	if err != nil {
+		c.JSON(webhookErrorResponse(err, "Failed to create webhook"))
+		return
+	}
+	c.JSON(http.StatusCreated, models.APIResponse{StatusCode: http.StatusCreated, Message: "Webhook created, store the secret now as it is not shown again", Success: true, Data: created})
+}
+
+// UpdateWebhook godoc
+// @Summary Update a webhook endpoint
+// @Description Change the URL, events or active flag of a webhook endpoint. Events are not queued while an endpoint is inactive; deliveries queued before are sent once it is active again.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Param request body models.WebhookEndpointRequest true "Webhook endpoint"
+// @Success 200 {object} models.APIResponse{data=models.WebhookEndpoint}
+// @Failure 400 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/webhooks/{id} [put]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) UpdateWebhook(c *gin.Context) {
+	endpoint, ok := h.ownedWebhook(c)
+	if !ok {
+		return
+	}
+	var req models.WebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{StatusCode: http.StatusBadRequest, Message: "Invalid request: " + err.Error(), Success: false})
+		return
+	}
+
+	updated, err := h.webhookService.Update(c.Request.Context(), endpoint.ID, req)
+	if err != nil {
+		c.JSON(webhookErrorResponse(err, "Failed to update webhook"))
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Webhook updated successfully", Success: true, Data: updated})
+}
+
+// DeleteWebhook godoc
+// @Summary Delete a webhook endpoint
+// @Description Delete a webhook endpoint together with its delivery log
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /api/webhooks/{id} [delete]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) DeleteWebhook(c *gin.Context) {
+	endpoint, ok := h.ownedWebhook(c)
+	if !ok {
+		return
+	}
+	if err := h.webhookService.Delete(c.Request.Context(), endpoint.ID); err != nil {
+		c.JSON(webhookErrorResponse(err, "Failed to delete webhook"))
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Webhook deleted successfully", Success: true})
+}
+
+// GetWebhookDeliveries godoc
+// @Summary List webhook deliveries
+// @Description List the delivery log of a webhook endpoint, newest first
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Param limit query int false "Limit number of deliveries returned" default(100)
+// @Success 200 {object} models.APIResponse{data=[]models.WebhookDelivery}
+// @Failure 404 {object} models.APIResponse
+// @Router /api/webhooks/{id}/deliveries [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetWebhookDeliveries(c *gin.Context) {
+	endpoint, ok := h.ownedWebhook(c)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	deliveries, err := h.webhookService.Deliveries(c.Request.Context(), endpoint.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to retrieve deliveries: " + err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Deliveries retrieved successfully", Success: true, Data: deliveries})
+}
+
+// GetWebhookDelivery godoc
+// @Summary Get a webhook delivery
+// @Description Get a webhook delivery with the log of every attempt
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param delivery_id path int true "Delivery ID"
+// @Success 200 {object} models.APIResponse{data=models.WebhookDelivery}
+// @Failure 404 {object} models.APIResponse
+// @Router /api/webhooks/deliveries/{delivery_id} [get]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) GetWebhookDelivery(c *gin.Context) {
+	id, ok := h.ownedDelivery(c)
+	if !ok {
+		return
+	}
+	delivery, err := h.webhookService.Delivery(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(webhookErrorResponse(err, "Failed to retrieve delivery"))
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Delivery retrieved successfully", Success: true, Data: delivery})
+}
+
+// RedeliverWebhook godoc
+// @Summary Redeliver a webhook
+// @Description Queue a delivery again with a fresh set of attempts, e.g. after fixing the receiving endpoint. The event ID stays the same.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param delivery_id path int true "Delivery ID"
+// @Success 200 {object} models.APIResponse{data=models.WebhookDelivery}
+// @Failure 404 {object} models.APIResponse
+// @Router /api/webhooks/deliveries/{delivery_id}/redeliver [post]
+// @Security ApiKeyAuth
+// @Security BearerAuth
+func (h *HTTPHandler) RedeliverWebhook(c *gin.Context) {
+	id, ok := h.ownedDelivery(c)
+	if !ok {
+		return
+	}
+	delivery, err := h.webhookService.Redeliver(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(webhookErrorResponse(err, "Failed to redeliver"))
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{StatusCode: http.StatusOK, Message: "Delivery queued", Success: true, Data: delivery})
+}
@@ -2,83 +2,149 @@ package middleware
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/nabilulilalbab/nadia/internal/models"
 	"github.com/nabilulilalbab/nadia/internal/monitoring"
+	"github.com/nabilulilalbab/nadia/internal/services"
 )
 
-// AuthMiddleware creates a middleware for API key authentication.
-// It supports both static API Key (X-API-Key) and Bearer Token (Authorization header).
-func AuthMiddleware(apiKey string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Skip auth for health check, swagger, and other public endpoints
-		if isPublicPath(c.Request.URL.Path) {
-			c.Next()
-			return
-		}
+// PrincipalKey is the gin context key holding the *models.Principal of the caller.
+const PrincipalKey = "principal"
 
-		// 1. Check for static API Key in X-API-Key header
-		providedKey := c.GetHeader("X-API-Key")
-		if providedKey == apiKey {
-			c.Next()
-			return
+// CurrentPrincipal returns the authenticated caller of a request, or nil if
+// the request was not authenticated (e.g. on public paths).
+func CurrentPrincipal(c *gin.Context) *models.Principal {
+	if v, ok := c.Get(PrincipalKey); ok {
+		if p, ok := v.(*models.Principal); ok {
+			return p
 		}
+	}
+	return nil
+}
 
-		// 2. Check for Bearer Token in Authorization header
-		authHeader := c.GetHeader("Authorization")
-		if authHeader != "" {
-			parts := strings.Split(authHeader, " ")
-			// Check if the format is "Bearer <token>"
-			if len(parts) == 2 && parts[0] == "Bearer" {
-				token := parts[1]
-				// Simple validation for the generated token. In a real-world scenario, this should be a proper JWT validation.
-				if strings.HasPrefix(token, "nadia-token-") {
-					c.Next()
-					return
-				}
-			}
+// authenticate resolves the X-API-Key header or the Bearer token of a request
+// to a principal. It writes an error response and returns nil if neither is
+// valid. User tokens are only accepted if allowUsers is set.
+func authenticate(c *gin.Context, auth *services.AuthService, allowUsers bool) *models.Principal {
+	// 1. Check for the admin or a reseller API Key in X-API-Key header
+	providedKey := c.GetHeader("X-API-Key")
+	if providedKey != "" {
+		principal, err := auth.AuthenticateAPIKey(c.Request.Context(), providedKey)
+		if err == nil {
+			return principal
+		}
+		if !errors.Is(err, services.ErrInvalidCredentials) {
+			rejectAuthError(c, err)
+			return nil
 		}
+	}
 
-		// 3. Fallback for Swagger UI: Check if the generated token was mistakenly put in the X-API-Key field.
-		// This improves user experience when testing with Swagger.
-		if strings.HasPrefix(providedKey, "nadia-token-") {
-			c.Next()
-			return
+	// 2. Check for a JWT Bearer Token in Authorization header
+	token := ""
+	if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		// Check if the format is "Bearer <token>"
+		if len(parts) == 2 && parts[0] == "Bearer" {
+			token = parts[1]
 		}
+	}
+
+	// 3. Fallback for Swagger UI: Check if the issued token was mistakenly put in the X-API-Key field.
+	// This improves user experience when testing with Swagger.
+	if token == "" && strings.Count(providedKey, ".") == 2 {
+		token = providedKey
+	}
 
-		// If all checks fail, deny access.
-		monitoring.LogSecurityThreat(c.ClientIP(), "unauthorized_access", "medium",
-			fmt.Sprintf("Invalid API key or token attempt from %s", c.ClientIP()))
-		monitoring.IncrementUnauthorizedCount()
+	if token == "" {
+		rejectAuthError(c, services.ErrInvalidCredentials)
+		return nil
+	}
 
-		c.JSON(401, models.APIResponse{
-			StatusCode: 401,
-			Message:    "Unauthorized: Invalid API key or token",
+	principal, err := auth.ParseAccessToken(c.Request.Context(), token)
+	if err != nil {
+		rejectAuthError(c, err)
+		return nil
+	}
+	if principal.Kind == models.PrincipalUser && !allowUsers {
+		rejectAuthError(c, services.ErrInvalidToken)
+		return nil
+	}
+	return principal
+}
+
+// rejectAuthError logs a failed authentication and aborts with 401, or 403 for suspended resellers.
+func rejectAuthError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrResellerSuspended) {
+		monitoring.LogSecurityThreat(c.ClientIP(), "suspended_reseller", "low",
+			fmt.Sprintf("Suspended reseller credentials used from %s", c.ClientIP()))
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			StatusCode: http.StatusForbidden,
+			Message:    "Forbidden: " + err.Error(),
 			Success:    false,
+			ErrorCode:  models.ErrCodeResellerSuspended,
 		})
 		c.Abort()
+		return
+	}
+
+	message := "Unauthorized: Invalid API key or token"
+	errorCode := ""
+	switch {
+	case errors.Is(err, services.ErrTokenExpired):
+		message, errorCode = "Unauthorized: token has expired", models.ErrCodeTokenExpired
+	case errors.Is(err, services.ErrTokenRevoked):
+		message, errorCode = "Unauthorized: token has been revoked", models.ErrCodeTokenRevoked
+	case !errors.Is(err, services.ErrInvalidCredentials) && !errors.Is(err, services.ErrInvalidToken):
+		log.Printf("Authentication failed: %v", err)
 	}
+
+	monitoring.LogSecurityThreat(c.ClientIP(), "unauthorized_access", "medium",
+		fmt.Sprintf("Invalid API key or token attempt from %s", c.ClientIP()))
+	monitoring.IncrementUnauthorizedCount()
+
+	c.JSON(http.StatusUnauthorized, models.APIResponse{
+		StatusCode: http.StatusUnauthorized,
+		Message:    message,
+		Success:    false,
+		ErrorCode:  errorCode,
+	})
+	c.Abort()
 }
 
-// JWTClaims represents the JWT token claims
-type JWTClaims struct {
-	Username string `json:"username"`
-	Exp      int64  `json:"exp"`
+// AuthMiddleware creates a middleware for API key authentication.
+// It supports the admin API Key and reseller API keys (X-API-Key) and JWT
+// Bearer Tokens issued by /api/auth/login (Authorization header), and stores
+// the caller in the context under PrincipalKey.
+func AuthMiddleware(auth *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Skip auth for health check, swagger, and other public endpoints
+		if isPublicPath(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		principal := authenticate(c, auth, false)
+		if principal == nil {
+			return
+		}
+		c.Set(PrincipalKey, principal)
+		c.Next()
+	}
 }
 
 // JWTMiddleware creates a middleware for JWT token authentication for user endpoints
-func JWTMiddleware(jwtSecret string) gin.HandlerFunc {
+func JWTMiddleware(auth *services.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -103,73 +169,20 @@ func JWTMiddleware(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
-		token := parts[1]
-
-		// Simple JWT validation (in production, use proper JWT library)
-		if !validateJWT(token, jwtSecret) {
-			monitoring.LogSecurityThreat(c.ClientIP(), "invalid_jwt_token", "medium",
-				fmt.Sprintf("Invalid JWT token attempt from %s", c.ClientIP()))
-			monitoring.IncrementUnauthorizedCount()
-
-			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				StatusCode: http.StatusUnauthorized,
-				Message:    "Invalid or expired token",
-				Success:    false,
-			})
-			c.Abort()
+		principal, err := auth.ParseAccessToken(c.Request.Context(), parts[1])
+		if err != nil {
+			rejectAuthError(c, err)
 			return
 		}
 
+		c.Set(PrincipalKey, principal)
 		c.Next()
 	}
 }
 
-// validateJWT validates a simple JWT token (basic implementation)
-func validateJWT(token, secret string) bool {
-	parts := strings.Split(token, ".")
-	if len(parts) != 3 {
-		return false
-	}
-
-	// Decode header and payload
-	_, err := base64.RawURLEncoding.DecodeString(parts[0])
-	if err != nil {
-		return false
-	}
-
-	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
-	if err != nil {
-		return false
-	}
-
-	// Verify signature
-	expectedSignature := generateJWTSignature(parts[0]+"."+parts[1], secret)
-	if parts[2] != expectedSignature {
-		return false
-	}
-
-	// Check expiration
-	var claims JWTClaims
-	if err := json.Unmarshal(payloadBytes, &claims); err != nil {
-		return false
-	}
-
-	if time.Now().Unix() > claims.Exp {
-		return false
-	}
-
-	return true
-}
-
-// generateJWTSignature generates HMAC-SHA256 signature for JWT
-func generateJWTSignature(data, secret string) string {
-	h := hmac.New(sha256.New, []byte(secret))
-	h.Write([]byte(data))
-	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
-}
-
-// HybridAuthMiddleware creates a middleware that accepts both API Key and JWT authentication
-func HybridAuthMiddleware(apiKey, jwtSecret string) gin.HandlerFunc {
+// HybridAuthMiddleware creates a middleware that accepts both API Key and JWT
+// authentication, including tokens of end users.
+func HybridAuthMiddleware(auth *services.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Skip auth for health check, swagger, and other public endpoints
 		if isPublicPath(c.Request.URL.Path) {
@@ -177,55 +190,119 @@ func HybridAuthMiddleware(apiKey, jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
-		// 1. Check for static API Key in X-API-Key header
-		providedKey := c.GetHeader("X-API-Key")
-		if providedKey == apiKey {
-			c.Next()
+		principal := authenticate(c, auth, true)
+		if principal == nil {
 			return
 		}
+		c.Set(PrincipalKey, principal)
+		c.Next()
+	}
+}
 
-		// 2. Check for Bearer Token in Authorization header (JWT)
-		authHeader := c.GetHeader("Authorization")
-		if authHeader != "" {
-			parts := strings.Split(authHeader, " ")
-			// Check if the format is "Bearer <token>"
-			if len(parts) == 2 && parts[0] == "Bearer" {
-				token := parts[1]
-
-				// First try simple token validation (legacy tokens)
-				if strings.HasPrefix(token, "nadia-token-") {
-					c.Next()
-					return
-				}
-
-				// Then try JWT validation
-				if validateJWT(token, jwtSecret) {
-					c.Next()
-					return
-				}
+// RequireScope creates a middleware that only lets callers through that hold
+// at least one of the given scopes. Admins hold every scope.
+func RequireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := CurrentPrincipal(c)
+		for _, scope := range scopes {
+			if principal.HasScope(scope) {
+				c.Next()
+				return
 			}
 		}
+		rejectForbidden(c, fmt.Sprintf("Forbidden: requires scope %s", strings.Join(scopes, " or ")))
+	}
+}
 
-		// 3. Fallback for Swagger UI: Check if the generated token was mistakenly put in the X-API-Key field.
-		if strings.HasPrefix(providedKey, "nadia-token-") {
-			c.Next()
+// RequireAdmin creates a middleware that only lets the admin through.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !CurrentPrincipal(c).IsAdmin() {
+			rejectForbidden(c, "Forbidden: admin access required")
 			return
 		}
+		c.Next()
+	}
+}
+
+// AccessTokenFromQuery lets clients that cannot set headers, such as the
+// browser EventSource API, pass a JWT access token as ?access_token=. It must
+// run before the auth middleware. API keys are not accepted this way because
+// URLs end up in logs; RequestLogger masks the access token.
+func AccessTokenFromQuery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.Query("access_token"); token != "" &&
+			c.GetHeader("Authorization") == "" && c.GetHeader("X-API-Key") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		c.Next()
+	}
+}
+
+// RequestLogger is gin's request logger with the access_token query
+// parameter masked, so tokens passed to AccessTokenFromQuery stay out of logs.
+func RequestLogger() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+		var statusColor, methodColor, resetColor string
+		if param.IsOutputColor() {
+			statusColor = param.StatusCodeColor()
+			methodColor = param.MethodColor()
+			resetColor = param.ResetColor()
+		}
+		if param.Latency > time.Minute {
+			param.Latency = param.Latency.Truncate(time.Second)
+		}
+		return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			statusColor, param.StatusCode, resetColor,
+			param.Latency,
+			param.ClientIP,
+			methodColor, param.Method, resetColor,
+			redactAccessToken(param.Path),
+			param.ErrorMessage,
+		)
+	})
+}
 
-		// If all checks fail, deny access.
-		monitoring.LogSecurityThreat(c.ClientIP(), "unauthorized_access", "medium",
-			fmt.Sprintf("Invalid API key or token attempt from %s", c.ClientIP()))
-		monitoring.IncrementUnauthorizedCount()
+// redactAccessToken masks the access_token query parameter of a request path.
+func redactAccessToken(path string) string {
+	base, rawQuery, ok := strings.Cut(path, "?")
+	if !ok || !strings.Contains(rawQuery, "access_token") {
+		return path
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return base + "?[unparsable query]"
+	}
+	if _, ok := query["access_token"]; !ok {
+		return path
+	}
+	query.Set("access_token", "REDACTED")
+	return base + "?" + query.Encode()
+}
 
-		c.JSON(http.StatusUnauthorized, models.APIResponse{
-			StatusCode: http.StatusUnauthorized,
-			Message:    "Unauthorized: Invalid API key or token",
-			Success:    false,
-		})
-		c.Abort()
+// RequireReseller only lets reseller principals through, for routes that act
+// on the caller's own reseller account.
+func RequireReseller() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p := CurrentPrincipal(c); p == nil || p.Kind != models.PrincipalReseller {
+			rejectForbidden(c, "Forbidden: reseller API key or token required")
+			return
+		}
+		c.Next()
 	}
 }
 
+func rejectForbidden(c *gin.Context, message string) {
+	c.JSON(http.StatusForbidden, models.APIResponse{
+		StatusCode: http.StatusForbidden,
+		Message:    message,
+		Success:    false,
+		ErrorCode:  models.ErrCodeInsufficientScope,
+	})
+	c.Abort()
+}
+
 // MonitoringMiddleware records metrics for each request.
 func MonitoringMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -233,12 +310,13 @@ func MonitoringMiddleware() gin.HandlerFunc {
 
 		c.Next()
 
-		// Don't log metrics for swagger assets
-		if strings.HasPrefix(c.Request.URL.Path, "/swagger/") {
+		// Don't log metrics for swagger assets and long-lived event streams
+		if strings.HasPrefix(c.Request.URL.Path, "/swagger/") || c.Request.URL.Path == "/api/events/stream" {
 			return
 		}
 
 		endTime := time.Now()
+		monitoring.ObserveHTTPRequest(c.FullPath(), c.Request.Method, c.Writer.Status(), endTime.Sub(startTime))
 		responseTime := float64(endTime.Sub(startTime).Nanoseconds()) / 1e6 // in ms
 
 		metric := models.RequestMetrics{
@@ -277,12 +355,30 @@ func MonitoringMiddleware() gin.HandlerFunc {
 	}
 }
 
+// MetricsAuth protects the Prometheus endpoint with a static bearer token.
+// Without a token the endpoint is public.
+func MetricsAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.Next()
+			return
+		}
+		provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			monitoring.IncrementUnauthorizedCount()
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
+
 // CORSMiddleware sets the CORS headers for the API.
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, Idempotency-Key")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -1,15 +1,49 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
 
 // APIResponse structure
 type APIResponse struct {
 	StatusCode int         `json:"statusCode"`
 	Message    string      `json:"message"`
 	Success    bool        `json:"success"`
+	ErrorCode  string      `json:"error_code,omitempty"`
 	Data       interface{} `json:"data,omitempty"`
 }
 
+// Error codes returned in APIResponse.ErrorCode when a request is rejected
+// before it reaches upstream, so bots can react without parsing messages.
+const (
+	ErrCodeCatalogUnavailable   = "CATALOG_UNAVAILABLE"
+	ErrCodePackageNotFound      = "PACKAGE_NOT_FOUND"
+	ErrCodePriceUnavailable     = "PRICE_UNAVAILABLE"
+	ErrCodePackageDisrupted     = "PACKAGE_DISRUPTED"
+	ErrCodePaymentMethodInvalid = "PAYMENT_METHOD_NOT_AVAILABLE"
+	ErrCodeCutOffTime           = "CUT_OFF_TIME"
+	ErrCodeDailyLimitReached    = "DAILY_LIMIT_REACHED"
+	ErrCodeOutOfStock           = "OUT_OF_STOCK"
+	ErrCodeStockCheckFailed     = "STOCK_CHECK_FAILED"
+	ErrCodeQuoteNotFound        = "QUOTE_NOT_FOUND"
+	ErrCodeQuoteExpired         = "QUOTE_EXPIRED"
+	ErrCodeQuoteUsed            = "QUOTE_ALREADY_USED"
+	ErrCodeQuoteMismatch        = "QUOTE_MISMATCH"
+	ErrCodeXLSessionNotFound    = "XL_SESSION_NOT_FOUND"
+	ErrCodeXLSessionExpired     = "XL_SESSION_EXPIRED"
+	ErrCodeInsufficientScope    = "INSUFFICIENT_SCOPE"
+	ErrCodeResellerSuspended    = "RESELLER_SUSPENDED"
+	ErrCodeTokenExpired         = "TOKEN_EXPIRED"
+	ErrCodeTokenRevoked         = "TOKEN_REVOKED"
+	ErrCodeInsufficientBalance  = "INSUFFICIENT_BALANCE"
+	ErrCodeDepositNotFound      = "DEPOSIT_NOT_FOUND"
+	ErrCodeDepositReviewed      = "DEPOSIT_ALREADY_REVIEWED"
+	ErrCodeWebhookNotFound      = "WEBHOOK_NOT_FOUND"
+	ErrCodeUpstreamUnavailable  = "UPSTREAM_UNAVAILABLE"
+)
+
 // Package structure
 type Package struct {
 	PackageCode             string          `json:"package_code"`
@@ -183,8 +217,43 @@ type AuthRequest struct {
 }
 
 type AuthResponse struct {
-	Token     string    `json:"token"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Token            string    `json:"token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// RefreshRequest exchanges a refresh token for a new token pair.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LogoutRequest revokes the access token of the request and, if given, a refresh token.
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// RevokeTokensRequest revokes an access token by ID or all refresh tokens of a subject.
+type RevokeTokensRequest struct {
+	TokenID   string    `json:"jti,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Subject   string    `json:"subject,omitempty" example:"reseller:1"`
+}
+
+// RefreshToken is a stored refresh token. Only a hash of the token is kept.
+type RefreshToken struct {
+	ID         string
+	TokenHash  string
+	Subject    string
+	Role       string
+	ResellerID int64
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+	// RotatedAt is set when the token was exchanged for a new one, as
+	// opposed to revoked by logout or in bulk.
+	RotatedAt *time.Time
 }
 
 // Simple request structures for easier use
@@ -195,16 +264,39 @@ type SimpleOTPRequest struct {
 type SimpleVerifyOTPRequest struct {
 	PhoneNumber string `json:"phone_number" binding:"required" example:"087786388052"`
 	OTPCode     string `json:"otp_code" binding:"required" example:"123456"`
+	RequestID   string `json:"request_id,omitempty" example:"OTP_1756113836620000000"`
 }
 
 type SimplePurchaseRequest struct {
 	PhoneNumber   string `json:"phone_number" binding:"required" example:"087786388052"`
 	PackageCode   string `json:"package_code" binding:"required" example:"XL_MASTIF_30D_P_V1"`
 	PaymentMethod string `json:"payment_method" binding:"required" example:"BALANCE"`
-	AccessToken   string `json:"access_token" binding:"required" example:"1097690:ec321a89-6593-4b01-9a9e-383744301283"`
+	AccessToken   string `json:"access_token,omitempty" example:"1097690:ec321a89-6593-4b01-9a9e-383744301283"`
 	Source        string `json:"source,omitempty" example:"telegram_bot"`
+	ClientRef     string `json:"client_ref,omitempty" example:"order-20250825-0001"`
+	QuoteID       string `json:"quote_id,omitempty" example:"QUO_1756113836620000000"`
 }
 
+// Transaction statuses. PENDING_PAYMENT and PROCESSING are reconciled against
+// check-transaction.json until the transaction reaches a final status.
+const (
+	TxStatusPending        = "PENDING"
+	TxStatusPendingPayment = "PENDING_PAYMENT"
+	TxStatusProcessing     = "PROCESSING"
+	TxStatusSuccess        = "SUCCESS"
+	TxStatusFailed         = "FAILED"
+	TxStatusRefunded       = "REFUNDED"
+	TxStatusExpired        = "EXPIRED"
+)
+
+// Actors recorded on transaction events.
+const (
+	TxActorAPI        = "api"
+	TxActorReconciler = "reconciler"
+	TxActorSystem     = "system"
+	TxActorAdmin      = "admin"
+)
+
 // Monitoring and Tracking Structures
 type TransactionRecord struct {
 	ID            string     `json:"id"`
@@ -220,6 +312,62 @@ type TransactionRecord struct {
 	CreatedAt     time.Time  `json:"created_at"`
 	CompletedAt   *time.Time `json:"completed_at,omitempty"`
 	ErrorMessage  string     `json:"error_message,omitempty"`
+	RefundAmount  int        `json:"refund_amount,omitempty"`
+	LastCheckedAt *time.Time `json:"last_checked_at,omitempty"`
+	// SubmittedAt is when the purchase was sent upstream; from then on it
+	// may have been bought even if no response was recorded.
+	SubmittedAt *time.Time `json:"submitted_at,omitempty"`
+
+	// Payment instructions for QRIS and e-wallet purchases.
+	IsQris           bool       `json:"is_qris,omitempty"`
+	QrCode           string     `json:"qr_code,omitempty"`
+	DeeplinkURL      string     `json:"deeplink_url,omitempty"`
+	PaymentExpiredAt *time.Time `json:"payment_expired_at,omitempty"`
+
+	// Pricing: Amount is what the customer was charged, Cost what upstream
+	// charges us and Margin the difference. QuoteID is set for quoted purchases.
+	Cost    int    `json:"cost,omitempty"`
+	Margin  int    `json:"margin,omitempty"`
+	QuoteID string `json:"quote_id,omitempty"`
+
+	// ResellerID is set for purchases made with a reseller API key or token;
+	// their price is held on and settled against the reseller's wallet.
+	ResellerID int64 `json:"reseller_id,omitempty"`
+
+	// Idempotency: the client-supplied key, a hash of the request it was first
+	// used with, and the response returned so replays get the same answer.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	RequestHash    string `json:"-"`
+	ResponseStatus int    `json:"-"`
+	ResponseBody   string `json:"-"`
+}
+
+// Redacted returns a copy of the record without our internal cost and margin,
+// for everyone but the admin.
+func (t TransactionRecord) Redacted() TransactionRecord {
+	t.Cost, t.Margin = 0, 0
+	return t
+}
+
+// TransactionEvent records a single status transition of a transaction.
+type TransactionEvent struct {
+	ID            int64           `json:"id"`
+	TransactionID string          `json:"transaction_id"`
+	FromStatus    string          `json:"from_status"`
+	ToStatus      string          `json:"to_status"`
+	Actor         string          `json:"actor"`
+	Reason        string          `json:"reason,omitempty"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// TransactionResolveRequest settles a PROCESSING transaction whose purchase
+// outcome is unknown and that has no trx_id to poll upstream. Either give the
+// trx_id found upstream so the reconciler takes over, or the final status.
+type TransactionResolveRequest struct {
+	Status string `json:"status,omitempty" binding:"omitempty,oneof=SUCCESS FAILED" example:"SUCCESS"`
+	TrxID  string `json:"trx_id,omitempty" example:"TRX123456"`
+	Note   string `json:"note" binding:"required" example:"Paket masuk, cek riwayat upstream"`
 }
 
 type SystemStats struct {
@@ -300,20 +448,186 @@ type SimpleCheckStatusRequest struct {
 	OTPCode     string `json:"otp_code" binding:"required" example:"123456"`
 }
 
+// SimpleAccessTokenRequest identifies an XL account either by its access token
+// or by a phone number whose token is kept in the token vault.
 type SimpleAccessTokenRequest struct {
-	AccessToken string `json:"access_token" binding:"required" example:"1146047:e22f5d5d-9172-4400-8ef5-15b353c204f7"`
+	AccessToken string `json:"access_token,omitempty" example:"1146047:e22f5d5d-9172-4400-8ef5-15b353c204f7"`
+	PhoneNumber string `json:"phone_number,omitempty" example:"087786388052"`
+}
+
+// XLTokenStatus describes a stored XL access token without revealing it.
+type XLTokenStatus struct {
+	PhoneNumber string    `json:"phone_number"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// TransactionCheckData is the data of a check-transaction.json response.
+// Status is 0 while in progress, 1 on success and 2 on failure.
+type TransactionCheckData struct {
+	TrxID             string    `json:"trx_id"`
+	Name              string    `json:"name"`
+	Code              string    `json:"code"`
+	Price             int       `json:"price"`
+	AdminFee          int       `json:"admin_fee"`
+	TotalPrice        int       `json:"total_price"`
+	DestinationMsisdn string    `json:"destination_msisdn"`
+	TimeDate          string    `json:"time_date"`
+	Status            int       `json:"status"`
+	IsRefunded        int       `json:"is_refunded"`
+	HasParsialRefund  bool      `json:"has_parsial_refund"`
+	RefundAmount      int       `json:"refund_amount"`
+	RefundReason      string    `json:"refund_reason"`
+	SnOnly            string    `json:"sn_only"`
+	SnAndInfo         string    `json:"sn_and_info"`
+	BalanceBefore     int       `json:"balance_before_transaction"`
+	BalanceAfter      int       `json:"balance_after_transaction"`
+	RC                string    `json:"rc"`
+	RCMessage         string    `json:"rc_message"`
+	Channel           string    `json:"channel"`
+	ChannelTrxCode    string    `json:"channel_transaction_code"`
+	HaveDeeplink      bool      `json:"have_deeplink"`
+	DeeplinkURL       string    `json:"deeplink_url"`
+	IsQris            bool      `json:"is_qris"`
+	QrisData          *QrisData `json:"qris_data,omitempty"`
+}
+
+// PurchaseResponseData is the data of a successful beli-paket-otp.json response.
+// QRIS purchases carry a QR payload to pay before payment_expired_at; e-wallet
+// purchases such as DANA carry a deeplink instead.
+type PurchaseResponseData struct {
+	Msisdn               string        `json:"msisdn"`
+	PackageCode          string        `json:"package_code"`
+	PackageName          string        `json:"package_name"`
+	PackageProcessingFee int           `json:"package_processing_fee"`
+	TrxID                string        `json:"trx_id"`
+	HaveDeeplink         bool          `json:"have_deeplink"`
+	DeeplinkData         *DeeplinkData `json:"deeplink_data,omitempty"`
+	IsQris               bool          `json:"is_qris"`
+	QrisData             *QrisData     `json:"qris_data,omitempty"`
+	QrisImageURL         string        `json:"qris_image_url,omitempty"`
+}
+
+// DeeplinkData holds the e-wallet checkout link of a purchase.
+type DeeplinkData struct {
+	PaymentMethod string `json:"payment_method"`
+	DeeplinkURL   string `json:"deeplink_url"`
+}
+
+// QrisData holds the QRIS payload of a purchase.
+type QrisData struct {
+	QrCode           string `json:"qr_code"`
+	PaymentExpiredAt int64  `json:"payment_expired_at"`
+	RemainingTime    int    `json:"remaining_time"`
+}
+
+// UnmarshalJSON accepts the empty array upstream sends instead of an object
+// when a purchase is not paid with QRIS.
+func (q *QrisData) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '[' {
+		*q = QrisData{}
+		return nil
+	}
+	type plain QrisData
+	return json.Unmarshal(data, (*plain)(q))
+}
+
+// ExpiresAt returns payment_expired_at as a time, or nil if it is not set.
+func (q *QrisData) ExpiresAt() *time.Time {
+	if q == nil || q.PaymentExpiredAt <= 0 {
+		return nil
+	}
+	t := time.Unix(q.PaymentExpiredAt, 0)
+	return &t
 }
 
 type SimpleTransactionCheckRequest struct {
 	TransactionID string `json:"transaction_id" binding:"required" example:"a1e3b046-3dda-4eb5-93c2-f0eb01a1a453"`
 }
 
+// PackageStock is an entry of check-stock-package.json and check-stock-package-global.json.
+type PackageStock struct {
+	PackageID string `json:"package_id"`
+	Name      string `json:"name"`
+	Stok      int    `json:"stok"`
+}
+
+// OTPRequestData is the data of a request-otp.json response. RequestID is not
+// sent upstream: it identifies our OTP session for /api/otp/verify.
+type OTPRequestData struct {
+	AuthID      string `json:"auth_id"`
+	CanResendIn int    `json:"can_resend_in"`
+	RequestID   string `json:"request_id,omitempty"`
+}
+
+// OTPLoginData is the data of a request-login.json response. TokenExpiresAt is
+// set once the access token has been stored in the token vault.
+type OTPLoginData struct {
+	AccessToken    string     `json:"access_token"`
+	TokenExpiresAt *time.Time `json:"token_expires_at,omitempty"`
+}
+
+// CardStatusData is the data of a status-kartu.json response.
+type CardStatusData struct {
+	Msisdn             string `json:"msisdn"`
+	SubscriptionStatus string `json:"subscription_status"`
+	Pulsa              int    `json:"pulsa"`
+	PulsaReal          string `json:"pulsa_real"`
+	ActiveUntil        string `json:"active_until"`
+	Location           string `json:"location"`
+}
+
+// ActivePackagesData is the data of a package-active-list.json response.
+type ActivePackagesData struct {
+	Msisdn string        `json:"msisdn"`
+	Text   string        `json:"text"`
+	Quotas []ActiveQuota `json:"quotas"`
+}
+
+// ActiveQuota is a package active on an XL card.
+type ActiveQuota struct {
+	EncryptedPackageCode string            `json:"encrypted_package_code"`
+	Name                 string            `json:"name"`
+	ExpiredAt            string            `json:"expired_at"`
+	Benefits             []json.RawMessage `json:"benefits"`
+}
+
+// AccountBalance is the data of a wallet/balance.json response: the balance of
+// our upstream account as a decimal string such as "25000.00".
+type AccountBalance struct {
+	Balance string `json:"balance"`
+}
+
+// UpstreamPaymentMethod is an entry of wallet/payment-methods.json, a way to
+// top up the upstream account.
+type UpstreamPaymentMethod struct {
+	ID       string `json:"id"`
+	Label    string `json:"label"`
+	ImageURL string `json:"image_url"`
+}
+
+// InvoiceList is a page of invoice/list.json. Pass Pagination.LastCreatedAt
+// to fetch the next page while Pagination.Loadmore is set.
+type InvoiceList struct {
+	Invoices   []InvoiceRecord   `json:"invoices"`
+	Pagination InvoicePagination `json:"pagination"`
+}
+
+type InvoicePagination struct {
+	TotalRecord   int   `json:"totalRecord"`
+	Limit         int   `json:"limit"`
+	Loadmore      bool  `json:"loadmore"`
+	LastCreatedAt int64 `json:"last_created_at"`
+}
+
 type PackageStockRequest struct {
 	PackageCode string `json:"package_code" binding:"required" example:"CIRCLE10GB"`
 }
 
-// PriceData structure for the new price endpoint
+// PriceData structure for the new price endpoint.
+// Older responses carry base/reseller/member prices, newer ones only "price".
 type PriceData struct {
+	Price         int    `json:"price"`
 	BasePrice     int    `json:"base_price"`
 	ResellerPrice int    `json:"reseller_price"`
 	MemberPrice   int    `json:"member_price"`
@@ -330,10 +644,500 @@ type PackageSearchRequest struct {
 	MinPrice      int    `json:"min_price,omitempty" example:"1000"`
 }
 
-// OTPSession management
+// CatalogStatus describes the in-memory catalog snapshot served to handlers.
+type CatalogStatus struct {
+	Version      int64     `json:"version"`
+	PackageCount int       `json:"package_count"`
+	PriceCount   int       `json:"price_count"`
+	FetchedAt    time.Time `json:"fetched_at"`
+	AgeSeconds   float64   `json:"age_seconds"`
+	Stale        bool      `json:"stale"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastAttempt  time.Time `json:"last_attempt"`
+}
+
+// Built-in price tiers used by the product endpoints.
+const (
+	PriceTierUser     = "user"
+	PriceTierReseller = "reseller"
+)
+
+// Price rule scopes, from least to most specific.
+const (
+	PriceScopeDefault  = "default"
+	PriceScopeCategory = "category"
+	PriceScopePackage  = "package"
+)
+
+// Package categories a category-scoped price rule can target.
+const (
+	CategoryAkrab   = "akrab"
+	CategoryCircle  = "circle"
+	CategoryEWallet = "ewallet"
+	CategoryPulsa   = "pulsa"
+)
+
+// PriceTier is a named price list, e.g. "user" or "reseller".
+type PriceTier struct {
+	Name        string    `json:"name" binding:"required" example:"reseller"`
+	Description string    `json:"description" example:"Harga untuk reseller"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// PriceRule describes how a tier price is derived from the upstream price list.
+// BaseField selects which upstream price is marked up: "price" (our cost),
+// "base_price", "reseller_price" or "member_price".
+type PriceRule struct {
+	ID          int64     `json:"id"`
+	Tier        string    `json:"tier" binding:"required" example:"reseller"`
+	Scope       string    `json:"scope" binding:"required" example:"category"`
+	Target      string    `json:"target,omitempty" example:"akrab"`
+	BaseField   string    `json:"base_field" example:"price"`
+	MarkupType  string    `json:"markup_type" binding:"required" example:"flat"`
+	MarkupValue float64   `json:"markup_value" example:"500"`
+	RoundTo     int       `json:"round_to,omitempty" example:"500"`
+	MinPrice    int       `json:"min_price,omitempty" example:"0"`
+	MaxPrice    int       `json:"max_price,omitempty" example:"0"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// QuoteRequest asks for a price locked for a short window.
+type QuoteRequest struct {
+	PackageCode   string `json:"package_code" binding:"required" example:"XL_MASTIF_30D_P_V1"`
+	PaymentMethod string `json:"payment_method" binding:"required" example:"BALANCE"`
+	Tier          string `json:"tier,omitempty" example:"reseller"`
+}
+
+// PurchaseQuote is a price locked for a package and payment method until ExpiresAt.
+// A quote can be redeemed by a single purchase.
+type PurchaseQuote struct {
+	ID             string     `json:"id"`
+	ResellerID     int64      `json:"reseller_id,omitempty"`
+	Tier           string     `json:"tier"`
+	PackageCode    string     `json:"package_code"`
+	PackageName    string     `json:"package_name"`
+	PaymentMethod  string     `json:"payment_method"`
+	Price          int        `json:"price"`
+	Cost           int        `json:"cost,omitempty"`
+	CatalogVersion int64      `json:"catalog_version"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	UsedAt         *time.Time `json:"used_at,omitempty"`
+	TransactionID  string     `json:"transaction_id,omitempty"`
+}
+
+// Redacted returns a copy of the quote without our upstream cost, for everyone
+// but the admin.
+func (q PurchaseQuote) Redacted() PurchaseQuote {
+	q.Cost = 0
+	return q
+}
+
+// PriceQuote is the resolved price of a package for a tier.
+type PriceQuote struct {
+	Tier        string `json:"tier"`
+	PackageCode string `json:"package_code"`
+	Cost        int    `json:"cost"`
+	Price       int    `json:"price"`
+	RuleID      int64  `json:"rule_id"`
+	RuleScope   string `json:"rule_scope"`
+}
+
+// OTPSession management. A phone may have several sessions at once; each
+// RequestOTP call creates one identified by RequestID.
 type OTPSession struct {
+	RequestID   string    `json:"request_id"`
+	ResellerID  int64     `json:"reseller_id,omitempty"`
 	PhoneNumber string    `json:"phone_number"`
 	AuthID      string    `json:"auth_id"`
 	CreatedAt   time.Time `json:"created_at"`
 	ExpiresAt   time.Time `json:"expires_at"`
 }
+
+// Reseller statuses. Suspended resellers keep their data but cannot authenticate.
+const (
+	ResellerStatusActive    = "active"
+	ResellerStatusSuspended = "suspended"
+)
+
+// Scopes a reseller API key can be granted.
+const (
+	ScopeCatalog  = "catalog"
+	ScopePurchase = "purchase"
+	ScopeCard     = "card"
+	ScopeReports  = "reports"
+)
+
+// AllScopes lists every scope a reseller can be granted.
+var AllScopes = []string{ScopeCatalog, ScopePurchase, ScopeCard, ScopeReports}
+
+// Reseller is an account authenticating with its own API key. Only a hash of
+// the key is stored; KeyPrefix identifies the key without revealing it.
+type Reseller struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	KeyPrefix string    `json:"key_prefix"`
+	KeyHash   string    `json:"-"`
+	Tier      string    `json:"tier"`
+	Scopes    []string  `json:"scopes"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ResellerRequest creates or updates a reseller. Empty fields keep their
+// current value on update; on create tier defaults to "reseller" and scopes
+// to all scopes.
+type ResellerRequest struct {
+	Name   string   `json:"name" example:"Toko Pulsa Jaya"`
+	Tier   string   `json:"tier,omitempty" example:"reseller"`
+	Scopes []string `json:"scopes,omitempty" example:"catalog,purchase"`
+	Status string   `json:"status,omitempty" example:"active"`
+}
+
+// ResellerKeyResponse returns a newly issued API key. The key is shown only once.
+type ResellerKeyResponse struct {
+	Reseller *Reseller `json:"reseller"`
+	APIKey   string    `json:"api_key"`
+}
+
+// Principal kinds.
+const (
+	PrincipalAdmin    = "admin"
+	PrincipalReseller = "reseller"
+	PrincipalUser     = "user"
+)
+
+// Principal identifies the caller of an authenticated request. Admins may use
+// every endpoint; resellers and users are limited to their scopes.
+type Principal struct {
+	Kind       string   `json:"kind"`
+	ResellerID int64    `json:"reseller_id,omitempty"`
+	Name       string   `json:"name"`
+	Tier       string   `json:"tier"`
+	Scopes     []string `json:"scopes"`
+
+	// TokenID and TokenExpiresAt are set when the caller used a JWT.
+	TokenID        string     `json:"token_id,omitempty"`
+	TokenExpiresAt *time.Time `json:"token_expires_at,omitempty"`
+}
+
+// IsAdmin reports whether the principal authenticated with the admin key.
+func (p *Principal) IsAdmin() bool {
+	return p != nil && p.Kind == PrincipalAdmin
+}
+
+// HasScope reports whether the principal may use endpoints of the given scope.
+func (p *Principal) HasScope(scope string) bool {
+	if p == nil {
+		return false
+	}
+	if p.IsAdmin() {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Ledger journal kinds. Every journal moves money between accounts and its
+// entries sum to zero.
+const (
+	LedgerDeposit    = "deposit"
+	LedgerHold       = "hold"
+	LedgerCapture    = "capture"
+	LedgerRelease    = "release"
+	LedgerRefund     = "refund"
+	LedgerAdjustment = "adjustment"
+)
+
+// LedgerJournal is one balanced posting to the ledger, e.g. the hold of a
+// purchase. Reference is the transaction ID for purchase journals.
+type LedgerJournal struct {
+	ID         int64         `json:"id"`
+	ResellerID int64         `json:"reseller_id"`
+	Kind       string        `json:"kind"`
+	Reference  string        `json:"reference,omitempty"`
+	Memo       string        `json:"memo,omitempty"`
+	CreatedBy  string        `json:"created_by,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	Entries    []LedgerEntry `json:"entries"`
+}
+
+// LedgerEntry is one side of a journal. The balance of an account is the sum
+// of its entries.
+type LedgerEntry struct {
+	ID        int64     `json:"id"`
+	JournalID int64     `json:"journal_id"`
+	Account   string    `json:"account"`
+	Amount    int       `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WalletBalance is the state of a reseller wallet. Held money is reserved for
+// purchases that have not reached a final status yet.
+type WalletBalance struct {
+	ResellerID int64 `json:"reseller_id"`
+	Available  int   `json:"available"`
+	Held       int   `json:"held"`
+}
+
+// WalletDepositRequest credits a reseller wallet.
+type WalletDepositRequest struct {
+	Amount    int    `json:"amount" binding:"required,gt=0" example:"100000"`
+	Reference string `json:"reference,omitempty" example:"BCA-20240101-001"`
+	Memo      string `json:"memo,omitempty" example:"Transfer BCA"`
+}
+
+// WalletAdjustmentRequest corrects a reseller wallet by a positive or negative amount.
+type WalletAdjustmentRequest struct {
+	Amount int    `json:"amount" binding:"required" example:"-5000"`
+	Memo   string `json:"memo" binding:"required" example:"Koreksi transaksi ganda"`
+}
+
+// Deposit statuses. A deposit is pending until an admin has matched the bank
+// transfer and approved or rejected it.
+const (
+	DepositPending  = "pending"
+	DepositApproved = "approved"
+	DepositRejected = "rejected"
+)
+
+// Deposit is a reseller's request to top up its wallet by bank transfer. The
+// reseller transfers TransferAmount, the requested amount plus a unique code,
+// so the admin can match the transfer on the bank statement. On approval the
+// transfer amount is credited to the wallet.
+type Deposit struct {
+	ID             string     `json:"id"`
+	ResellerID     int64      `json:"reseller_id"`
+	Amount         int        `json:"amount"`
+	UniqueCode     int        `json:"unique_code"`
+	TransferAmount int        `json:"transfer_amount"`
+	ProofNote      string     `json:"proof_note,omitempty"`
+	Status         string     `json:"status"`
+	ReviewNote     string     `json:"review_note,omitempty"`
+	ReviewedBy     string     `json:"reviewed_by,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ReviewedAt     *time.Time `json:"reviewed_at,omitempty"`
+}
+
+// Invoice returns the deposit in the shape of an upstream invoice, so deposits
+// and upstream invoices can be listed side by side.
+func (d *Deposit) Invoice(reseller *Reseller) InvoiceRecord {
+	status := d.Status
+	if status == DepositApproved {
+		status = "paid"
+	}
+
+	invoice := InvoiceRecord{
+		ID:              d.ID,
+		InvoiceID:       d.ID,
+		Title:           "Deposit Saldo Reseller",
+		Amount:          d.TransferAmount,
+		InvoiceStatusID: status,
+		CreatedAt:       strconv.FormatInt(d.CreatedAt.UnixMilli(), 10),
+		CreatedAtMonth:  d.CreatedAt.Month().String(),
+		CreatedAtYear:   d.CreatedAt.Year(),
+		Metadata: map[string]interface{}{
+			"source":      "reseller_deposit",
+			"reseller_id": d.ResellerID,
+			"amount":      d.Amount,
+			"unique_code": d.UniqueCode,
+			"proof_note":  d.ProofNote,
+			"review_note": d.ReviewNote,
+		},
+		Payments: []PaymentRecord{},
+	}
+	if d.ReviewedAt != nil {
+		invoice.ModifiedAt = strconv.FormatInt(d.ReviewedAt.UnixMilli(), 10)
+		if d.Status == DepositApproved {
+			invoice.PaidAt = invoice.ModifiedAt
+		}
+	}
+	if reseller != nil {
+		invoice.Username = reseller.KeyPrefix
+		invoice.Fullname = reseller.Name
+	}
+	return invoice
+}
+
+// DepositRequest creates a pending deposit for the calling reseller.
+type DepositRequest struct {
+	Amount    int    `json:"amount" binding:"required,gt=0" example:"100000"`
+	ProofNote string `json:"proof_note,omitempty" example:"Transfer BCA a.n. Budi 10:15"`
+}
+
+// DepositReviewRequest approves or rejects a pending deposit.
+type DepositReviewRequest struct {
+	Note string `json:"note,omitempty" example:"Mutasi BCA 10:17 cocok"`
+}
+
+// WebhookEventPrefix prefixes the lower-cased transaction status in webhook
+// event types, e.g. "transaction.success".
+const WebhookEventPrefix = "transaction."
+
+// WebhookEventTypes lists the event types an endpoint can subscribe to, one
+// per transaction status.
+var WebhookEventTypes = []string{
+	WebhookEventPrefix + "pending",
+	WebhookEventPrefix + "pending_payment",
+	WebhookEventPrefix + "processing",
+	WebhookEventPrefix + "success",
+	WebhookEventPrefix + "failed",
+	WebhookEventPrefix + "refunded",
+	WebhookEventPrefix + "expired",
+}
+
+// Webhook delivery statuses.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookEndpoint receives the transaction events of one reseller (or of the
+// admin for ResellerID 0). An empty Events list subscribes to all events.
+type WebhookEndpoint struct {
+	ID         int64     `json:"id"`
+	ResellerID int64     `json:"reseller_id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"-"`
+	Events     []string  `json:"events"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Subscribes reports whether the endpoint wants events of the given type.
+func (e *WebhookEndpoint) Subscribes(eventType string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEndpointRequest creates or updates a webhook endpoint.
+type WebhookEndpointRequest struct {
+	URL    string   `json:"url" binding:"required,url" example:"https://bot.example.com/nadia/webhook"`
+	Events []string `json:"events,omitempty" example:"transaction.success,transaction.failed"`
+	Active *bool    `json:"active,omitempty" example:"true"`
+}
+
+// WebhookSecretResponse is returned when an endpoint is created. The signing
+// secret is only shown once.
+type WebhookSecretResponse struct {
+	Endpoint *WebhookEndpoint `json:"endpoint"`
+	Secret   string           `json:"secret" example:"whsec_0f1e2d3c4b5a69788796a5b4c3d2e1f0a1b2c3d4e5f60718"`
+}
+
+// WebhookEvent is the JSON body posted to webhook endpoints. ID is the same
+// for every delivery of an event, so receivers can drop duplicates.
+type WebhookEvent struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	CreatedAt time.Time         `json:"created_at"`
+	Data      TransactionChange `json:"data"`
+}
+
+// WebhookDelivery is one event queued for one endpoint. Failed attempts are
+// retried with exponential backoff until the delivery succeeds or runs out
+// of attempts.
+type WebhookDelivery struct {
+	ID             int64            `json:"id"`
+	EndpointID     int64            `json:"endpoint_id"`
+	EventID        string           `json:"event_id"`
+	EventType      string           `json:"event_type"`
+	TransactionID  string           `json:"transaction_id"`
+	Payload        string           `json:"payload"`
+	Status         string           `json:"status"`
+	Attempts       int              `json:"attempts"`
+	NextAttemptAt  *time.Time       `json:"next_attempt_at,omitempty"`
+	LastStatusCode int              `json:"last_status_code,omitempty"`
+	LastError      string           `json:"last_error,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	DeliveredAt    *time.Time       `json:"delivered_at,omitempty"`
+	AttemptLog     []WebhookAttempt `json:"attempt_log,omitempty"`
+}
+
+// WebhookAttempt logs one HTTP request of a delivery.
+type WebhookAttempt struct {
+	ID         int64     `json:"id"`
+	DeliveryID int64     `json:"delivery_id"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Stream event types sent on /api/events/stream.
+const (
+	StreamTransactionCreated = "transaction.created"
+	StreamTransactionUpdated = "transaction.updated"
+	StreamMetrics            = "metrics"
+)
+
+// StreamEvent is one Server-Sent Event. IDs increase per process, so a client
+// can resume with Last-Event-ID after a short disconnect.
+type StreamEvent struct {
+	ID        int64       `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// TransactionChange is the data of transaction webhook and stream events.
+// FromStatus is empty for newly created transactions.
+type TransactionChange struct {
+	FromStatus  string            `json:"from_status,omitempty"`
+	Transaction TransactionRecord `json:"transaction"`
+}
+
+// TransactionQuery filters and pages a transaction listing. Zero values
+// match everything. From is inclusive and To exclusive. Results are ordered
+// by created_at and ID, newest first unless Ascending is set. Cursor is the
+// cursor returned with the previous page.
+type TransactionQuery struct {
+	Statuses    []string
+	Source      string
+	PhoneNumber string
+	PackageCode string
+	ResellerID  *int64
+	From        *time.Time
+	To          *time.Time
+	MinAmount   *int
+	MaxAmount   *int
+	Ascending   bool
+	Cursor      string
+	Limit       int
+}
+
+// TransactionTotals aggregates the transactions of one group, e.g. a day or a
+// source. Pending counts transactions that are not final yet.
+type TransactionTotals struct {
+	Key        string
+	Total      int
+	Successful int
+	Failed     int
+	Pending    int
+	Revenue    int
+}
+
+// DailyStats holds the transaction counts and revenue of one day.
+type DailyStats struct {
+	Date       string `json:"date"`
+	Total      int    `json:"total"`
+	Successful int    `json:"successful"`
+	Failed     int    `json:"failed"`
+	Pending    int    `json:"pending"`
+	Revenue    int    `json:"revenue"`
+}
@@ -15,20 +15,18 @@ import (
 	"github.com/shirou/gopsutil/v3/net"
 )
 
+// requestMetrics keeps the most recent requests for the request log and the
+// realtime view. Totals since startup come from the Prometheus metrics.
 var (
 	requestMetrics    = make([]models.RequestMetrics, 0, 1000)
 	requestMetricsMux sync.RWMutex
 	securityThreats   = make([]models.SecurityThreat, 0, 100)
 	securityMutex     sync.RWMutex
 	serverStartTime   = time.Now()
-	totalRequests     int64
-	totalErrors       int64
-	unauthorizedCount int64
 	suspiciousTraffic int64
-	metricsMutex      sync.RWMutex
 )
 
-// AddRequestMetric adds a new request metric to the collection.
+// AddRequestMetric adds a new request metric to the collection of recent requests.
 func AddRequestMetric(metric models.RequestMetrics) {
 	requestMetricsMux.Lock()
 	requestMetrics = append(requestMetrics, metric)
@@ -37,13 +35,6 @@ func AddRequestMetric(metric models.RequestMetrics) {
 	}
 	requestMetricsMux.Unlock()
 
-	metricsMutex.Lock()
-	totalRequests++
-	if metric.StatusCode >= 500 {
-		totalErrors++
-	}
-	metricsMutex.Unlock()
-
 	if metric.ResponseTime > 5000 { // > 5 seconds
 		LogSecurityThreat(metric.IP, "slow_response", "low",
 			fmt.Sprintf("Slow response time: %.2fms for %s", metric.ResponseTime, metric.Path))
@@ -72,9 +63,7 @@ func LogSecurityThreat(ip, threatType, severity, details string) {
 
 // IncrementUnauthorizedCount increases the count of unauthorized attempts.
 func IncrementUnauthorizedCount() {
-	metricsMutex.Lock()
-	unauthorizedCount++
-	metricsMutex.Unlock()
+	unauthorizedTotal.Inc()
 }
 
 // CollectSystemMetrics gathers all system metrics.
@@ -121,18 +110,17 @@ func CollectSystemMetrics(dbStatus string) models.SystemMetrics {
 		}
 	}
 
-	metricsMutex.RLock()
+	totalRequests, totalErrors := requestCounts()
 	var errorRate float64
 	if totalRequests > 0 {
 		errorRate = float64(totalErrors) / float64(totalRequests) * 100
 	}
 	uptime := time.Since(serverStartTime).Seconds()
 	throughput := float64(totalRequests) / uptime
-	metricsMutex.RUnlock()
 
 	securityMutex.RLock()
 	secMetrics := models.SecurityMetrics{
-		UnauthorizedAttempts: int(unauthorizedCount),
+		UnauthorizedAttempts: int(unauthorizedCount()),
 		SuspiciousTraffic:    int(suspiciousTraffic),
 		FirewallBlocks:       0, // Placeholder
 		RecentThreats:        append([]models.SecurityThreat{}, securityThreats...),
@@ -156,7 +144,9 @@ func CollectSystemMetrics(dbStatus string) models.SystemMetrics {
 	}
 }
 
-// CalculateResponseTimeMetrics calculates various response time metrics.
+// CalculateResponseTimeMetrics calculates response time metrics of the most
+// recent requests. Use nadia_http_request_duration_seconds on /metrics for
+// percentiles over longer periods.
 func CalculateResponseTimeMetrics() models.ResponseTimeMetrics {
 	requestMetricsMux.RLock()
 	defer requestMetricsMux.RUnlock()
@@ -259,7 +249,7 @@ func GetPerformanceMetrics() map[string]interface{} {
 		cpuUsage = cpuPercent[0]
 	}
 
-	metricsMutex.RLock()
+	totalRequests, totalErrors := requestCounts()
 	uptime := time.Since(serverStartTime).Seconds()
 	throughput := 0.0
 	errorRate := 0.0
@@ -267,7 +257,6 @@ func GetPerformanceMetrics() map[string]interface{} {
 		throughput = float64(totalRequests) / uptime
 		errorRate = float64(totalErrors) / float64(totalRequests) * 100
 	}
-	metricsMutex.RUnlock()
 
 	return map[string]interface{}{
 		"response_time":  CalculateResponseTimeMetrics(),
@@ -291,18 +280,15 @@ func GetSecurityMetrics() map[string]interface{} {
 	threats := append([]models.SecurityThreat{}, securityThreats...)
 	securityMutex.RUnlock()
 
-	metricsMutex.RLock()
-	metrics := map[string]interface{}{
-		"unauthorized_attempts": unauthorizedCount,
+	totalRequests, totalErrors := requestCounts()
+	return map[string]interface{}{
+		"unauthorized_attempts": unauthorizedCount(),
 		"suspicious_traffic":    suspiciousTraffic,
 		"recent_threats":        threats,
 		"total_requests":        totalRequests,
 		"total_errors":          totalErrors,
 		"timestamp":             time.Now(),
 	}
-	metricsMutex.RUnlock()
-
-	return metrics
 }
 
 // GetApplicationLogs returns recent application request logs.
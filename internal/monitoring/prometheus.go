@@ -0,0 +1,225 @@
+package monitoring
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// registry holds the metrics exposed by MetricsHandler. It is separate from
+// the default registry so only the metrics below, the Go runtime and the
+// process are exported.
+var registry = prometheus.NewRegistry()
+
+var (
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "nadia",
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by route template, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	upstreamRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "nadia",
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Duration of requests to the Nadia API by endpoint and status code, or \"error\" when no response was received.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30},
+	}, []string{"endpoint", "status"})
+
+	upstreamRejectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nadia",
+		Name:      "upstream_rejections_total",
+		Help:      "Requests to the Nadia API failed fast without being sent, by endpoint and reason (circuit_open or concurrency_limit).",
+	}, []string{"endpoint", "reason"})
+
+	upstreamRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nadia",
+		Name:      "upstream_retries_total",
+		Help:      "Requests to the Nadia API sent again, by endpoint and status of the failed attempt (\"error\" when no response was received).",
+	}, []string{"endpoint", "status"})
+
+	upstreamCircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "nadia",
+		Name:      "upstream_circuit_state",
+		Help:      "State of the circuit breaker of a Nadia API endpoint group: 0 closed, 1 half-open, 2 open.",
+	}, []string{"group"})
+
+	purchasesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nadia",
+		Name:      "purchases_total",
+		Help:      "Purchases that entered a status, by package code, status, source (api_direct, telegram_bot, whatsapp_bot, web_interface or other) and payment method.",
+	}, []string{"package_code", "status", "source", "payment_method"})
+
+	tokenRefreshesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nadia",
+		Name:      "token_refreshes_total",
+		Help:      "Logins to the Nadia API for a new token, by result.",
+	}, []string{"result"})
+
+	otpTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nadia",
+		Name:      "otp_total",
+		Help:      "OTP requests and verifications, by stage and result.",
+	}, []string{"stage", "result"})
+
+	unauthorizedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nadia",
+		Name:      "unauthorized_requests_total",
+		Help:      "Requests rejected for missing or invalid credentials.",
+	})
+)
+
+// OTP funnel stages and results recorded by RecordOTP.
+const (
+	OTPStageRequest = "request"
+	OTPStageVerify  = "verify"
+
+	OTPResultSuccess   = "success"
+	OTPResultRejected  = "rejected"
+	OTPResultError     = "error"
+	OTPResultNoSession = "no_session"
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequestDuration,
+		upstreamRequestDuration,
+		upstreamRejectionsTotal,
+		upstreamRetriesTotal,
+		upstreamCircuitState,
+		purchasesTotal,
+		tokenRefreshesTotal,
+		otpTotal,
+		unauthorizedTotal,
+	)
+}
+
+// MetricsHandler serves the metrics in the Prometheus exposition format.
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveHTTPRequest records a served request. route is the route template,
+// e.g. /api/invoices/:id, so that IDs do not create new series.
+func ObserveHTTPRequest(route, method string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequestDuration.WithLabelValues(route, method, strconv.Itoa(status)).Observe(duration.Seconds())
+}
+
+// ObserveUpstreamRequest records a request to the Nadia API. status is 0 when
+// the request failed without a response.
+func ObserveUpstreamRequest(endpoint string, status int, duration time.Duration) {
+	label := "error"
+	if status > 0 {
+		label = strconv.Itoa(status)
+	}
+	upstreamRequestDuration.WithLabelValues(endpoint, label).Observe(duration.Seconds())
+}
+
+// RecordUpstreamRejection counts a request to the Nadia API that was failed
+// fast without being sent.
+func RecordUpstreamRejection(endpoint, reason string) {
+	upstreamRejectionsTotal.WithLabelValues(endpoint, reason).Inc()
+}
+
+// RecordUpstreamRetry counts a request to the Nadia API sent again after an
+// attempt failed with status, or 0 when no response was received.
+func RecordUpstreamRetry(endpoint string, status int) {
+	label := "error"
+	if status > 0 {
+		label = strconv.Itoa(status)
+	}
+	upstreamRetriesTotal.WithLabelValues(endpoint, label).Inc()
+}
+
+// SetUpstreamCircuitState records the state of the circuit breaker of a
+// Nadia API endpoint group: "closed", "half_open" or "open".
+func SetUpstreamCircuitState(group, state string) {
+	value := 0.0
+	switch state {
+	case "half_open":
+		value = 1
+	case "open":
+		value = 2
+	}
+	upstreamCircuitState.WithLabelValues(group).Set(value)
+}
+
+// purchaseSources are the sources counted by name in purchases_total. Clients
+// may send any source, so the rest are counted as "other" to keep the number
+// of series bounded.
+var purchaseSources = map[string]bool{
+	"api_direct":    true,
+	"telegram_bot":  true,
+	"whatsapp_bot":  true,
+	"web_interface": true,
+}
+
+// ObserveTransactionStatus counts a purchase entering a status. It has the
+// signature of a transaction status listener.
+func ObserveTransactionStatus(_ context.Context, record models.TransactionRecord, fromStatus string) {
+	source := record.Source
+	if !purchaseSources[source] {
+		source = "other"
+	}
+	purchasesTotal.WithLabelValues(record.PackageCode, record.Status, source, record.PaymentMethod).Inc()
+}
+
+// RecordTokenRefresh counts a login to the Nadia API.
+func RecordTokenRefresh(err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	tokenRefreshesTotal.WithLabelValues(result).Inc()
+}
+
+// RecordOTP counts one step of the OTP funnel.
+func RecordOTP(stage, result string) {
+	otpTotal.WithLabelValues(stage, result).Inc()
+}
+
+// requestCounts returns the number of requests recorded by ObserveHTTPRequest
+// since startup and how many of them failed with a 5xx status.
+func requestCounts() (total, errors int64) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		httpRequestDuration.Collect(metrics)
+		close(metrics)
+	}()
+
+	for metric := range metrics {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			continue
+		}
+		count := int64(m.GetHistogram().GetSampleCount())
+		total += count
+		for _, label := range m.GetLabel() {
+			if label.GetName() == "status" && label.GetValue() >= "500" {
+				errors += count
+			}
+		}
+	}
+	return total, errors
+}
+
+// unauthorizedCount returns the number of unauthorized requests since startup.
+func unauthorizedCount() int64 {
+	var m dto.Metric
+	if err := unauthorizedTotal.Write(&m); err != nil {
+		return 0
+	}
+	return int64(m.GetCounter().GetValue())
+}
@@ -0,0 +1,252 @@
+package nadia
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/monitoring"
+)
+
+// Circuit breaker states.
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half_open"
+)
+
+var (
+	// ErrCircuitOpen is matched by an UnavailableError of an endpoint whose
+	// group has failed too often recently.
+	ErrCircuitOpen = errors.New("nadia: circuit breaker open")
+	// ErrTooManyRequests is matched by an UnavailableError of an endpoint
+	// that already has its maximum of requests in flight.
+	ErrTooManyRequests = errors.New("nadia: too many concurrent requests")
+)
+
+// UnavailableError is returned without contacting the Nadia API when the
+// circuit breaker of an endpoint's group is open or the endpoint is at its
+// concurrency cap. RetryAfter is when the request is worth trying again.
+type UnavailableError struct {
+	Endpoint   string
+	Group      string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("nadia %s unavailable: %v", e.Endpoint, e.Err)
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// endpointGroups maps endpoints to the group sharing a circuit breaker.
+// Endpoints of a group fail together when their upstream backend degrades.
+var endpointGroups = map[string]string{
+	"/limited/xl/package-list-all.json":           "catalog",
+	"/limited/xl/price-list-all.json":             "catalog",
+	"/limited/xl/request-otp.json":                "otp",
+	"/limited/xl/request-login.json":              "otp",
+	"/limited/xl/beli-paket-otp.json":             "purchase",
+	"/limited/xl/status-kartu.json":               "card",
+	"/limited/xl/package-active-list.json":        "card",
+	"/limited/xl/check-transaction.json":          "transaction",
+	"/limited/xl/check-stock-package.json":        "stock",
+	"/limited/xl/check-stock-package-global.json": "stock",
+	"/wallet/balance.json":                        "account",
+	"/wallet/payment-methods.json":                "account",
+	"/invoice/list.json":                          "account",
+}
+
+// otherGroup is the group of endpoints missing from endpointGroups.
+const otherGroup = "other"
+
+func endpointGroup(endpoint string) string {
+	if group, ok := endpointGroups[endpoint]; ok {
+		return group
+	}
+	return otherGroup
+}
+
+// outcome is the effect of a finished request on its circuit breaker.
+type outcome int
+
+const (
+	// outcomeIgnored is a request abandoned by its caller, which says
+	// nothing about the health of upstream.
+	outcomeIgnored outcome = iota
+	outcomeSuccess
+	outcomeFailure
+)
+
+// breaker is the circuit breaker of an endpoint group. It opens after
+// failureThreshold consecutive failures and rejects requests for
+// openDuration; then up to halfOpenRequests trial requests are let through,
+// and the breaker closes once that many succeeded or opens again on the
+// first failure.
+type breaker struct {
+	group            string
+	failureThreshold int
+	openDuration     time.Duration
+	halfOpenRequests int
+
+	mutex     sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	trials    int
+	successes int
+}
+
+func newBreaker(group string, failureThreshold int, openDuration time.Duration, halfOpenRequests int) *breaker {
+	if failureThreshold <= 0 {
+		failureThreshold = 5
+	}
+	if openDuration <= 0 {
+		openDuration = 30 * time.Second
+	}
+	if halfOpenRequests <= 0 {
+		halfOpenRequests = 1
+	}
+	monitoring.SetUpstreamCircuitState(group, StateClosed)
+	return &breaker{
+		group:            group,
+		failureThreshold: failureThreshold,
+		openDuration:     openDuration,
+		halfOpenRequests: halfOpenRequests,
+		state:            StateClosed,
+	}
+}
+
+// allow reports whether a request may be sent now, and whether it is a trial
+// request of a half-open breaker. If not, retryAfter is how long to wait.
+func (b *breaker) allow(now time.Time) (trial bool, retryAfter time.Duration, ok bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if wait := b.openedAt.Add(b.openDuration).Sub(now); wait > 0 {
+			return false, wait, false
+		}
+		b.setState(StateHalfOpen)
+		b.trials, b.successes = 0, 0
+		fallthrough
+	case StateHalfOpen:
+		if b.trials >= b.halfOpenRequests {
+			// The outcome of the trials in flight decides soon.
+			return false, time.Second, false
+		}
+		b.trials++
+		return true, 0, true
+	}
+	return false, 0, true
+}
+
+// record applies the outcome of a request let through by allow.
+func (b *breaker) record(trial bool, result outcome, now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case StateClosed:
+		switch result {
+		case outcomeSuccess:
+			b.failures = 0
+		case outcomeFailure:
+			b.failures++
+			if b.failures >= b.failureThreshold {
+				b.trip(now)
+			}
+		}
+	case StateHalfOpen:
+		// Requests sent before the breaker opened do not decide a trial.
+		if !trial {
+			return
+		}
+		b.trials--
+		switch result {
+		case outcomeSuccess:
+			b.successes++
+			if b.successes >= b.halfOpenRequests {
+				b.failures = 0
+				b.setState(StateClosed)
+			}
+		case outcomeFailure:
+			b.trip(now)
+		}
+	}
+}
+
+func (b *breaker) trip(now time.Time) {
+	b.openedAt = now
+	b.setState(StateOpen)
+}
+
+func (b *breaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+	log.Printf("Nadia API circuit breaker %s: %s -> %s", b.group, b.state, state)
+	b.state = state
+	monitoring.SetUpstreamCircuitState(b.group, state)
+}
+
+// CircuitStatus describes the circuit breaker of an endpoint group.
+type CircuitStatus struct {
+	Group               string     `json:"group"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+}
+
+func (b *breaker) status() CircuitStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status := CircuitStatus{Group: b.group, State: b.state, ConsecutiveFailures: b.failures}
+	if b.state != StateClosed {
+		openedAt := b.openedAt
+		retryAt := openedAt.Add(b.openDuration)
+		status.OpenedAt, status.RetryAt = &openedAt, &retryAt
+	}
+	return status
+}
+
+// CircuitStatus returns the circuit breaker of every endpoint group, sorted
+// by group.
+func (c *Client) CircuitStatus() []CircuitStatus {
+	statuses := make([]CircuitStatus, 0, len(c.breakers))
+	for _, b := range c.breakers {
+		statuses = append(statuses, b.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Group < statuses[j].Group })
+	return statuses
+}
+
+// bulkhead returns the semaphore capping the requests in flight to endpoint.
+func (c *Client) bulkhead(endpoint string) chan struct{} {
+	c.bulkheadMutex.Lock()
+	defer c.bulkheadMutex.Unlock()
+
+	sem, ok := c.bulkheads[endpoint]
+	if !ok {
+		limit := c.cfg.UpstreamMaxConcurrency
+		if n, ok := c.cfg.UpstreamEndpointConcurrency[path.Base(endpoint)]; ok {
+			limit = n
+		}
+		if limit <= 0 {
+			limit = 20
+		}
+		sem = make(chan struct{}, limit)
+		c.bulkheads[endpoint] = sem
+	}
+	return sem
+}
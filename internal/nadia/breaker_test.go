@@ -0,0 +1,134 @@
+package nadia
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	// Operations of a step. Outcomes are recorded as trials if the last
+	// request let through was a trial, except for a late failure, which stands
+	// for a request sent before the breaker opened.
+	const (
+		allow       = "allow"
+		reject      = "reject"
+		success     = "success"
+		failure     = "failure"
+		ignored     = "ignored"
+		lateFailure = "late failure"
+	)
+	type step struct {
+		at        time.Duration
+		op        string
+		wantState string
+	}
+	tests := []struct {
+		name             string
+		halfOpenRequests int
+		steps            []step
+	}{
+		{"stays closed below the threshold", 1, []step{
+			{0, allow, StateClosed}, {0, failure, StateClosed},
+			{0, allow, StateClosed}, {0, failure, StateClosed},
+			{0, allow, StateClosed}, {0, success, StateClosed},
+			{0, allow, StateClosed}, {0, failure, StateClosed},
+			{0, allow, StateClosed}, {0, failure, StateClosed},
+		}},
+		{"opens at the threshold", 1, []step{
+			{0, failure, StateClosed}, {0, failure, StateClosed}, {0, failure, StateOpen},
+			{time.Second, reject, StateOpen},
+			{9 * time.Second, reject, StateOpen},
+		}},
+		{"abandoned requests do not count", 1, []step{
+			{0, failure, StateClosed}, {0, failure, StateClosed},
+			{0, ignored, StateClosed}, {0, ignored, StateClosed},
+			{0, failure, StateOpen},
+		}},
+		{"half-open trial closes", 1, []step{
+			{0, failure, StateClosed}, {0, failure, StateClosed}, {0, failure, StateOpen},
+			{10 * time.Second, allow, StateHalfOpen},
+			{10 * time.Second, reject, StateHalfOpen},
+			{11 * time.Second, success, StateClosed},
+			{11 * time.Second, allow, StateClosed}, {11 * time.Second, failure, StateClosed},
+		}},
+		{"half-open trial failure reopens", 1, []step{
+			{0, failure, StateClosed}, {0, failure, StateClosed}, {0, failure, StateOpen},
+			{10 * time.Second, allow, StateHalfOpen},
+			{11 * time.Second, failure, StateOpen},
+			{20 * time.Second, reject, StateOpen},
+			{21 * time.Second, allow, StateHalfOpen},
+		}},
+		{"half-open needs every trial to succeed", 2, []step{
+			{0, failure, StateClosed}, {0, failure, StateClosed}, {0, failure, StateOpen},
+			{10 * time.Second, allow, StateHalfOpen},
+			{10 * time.Second, success, StateHalfOpen},
+			{10 * time.Second, allow, StateHalfOpen},
+			{10 * time.Second, allow, StateHalfOpen},
+			{10 * time.Second, reject, StateHalfOpen},
+			{10 * time.Second, success, StateClosed},
+		}},
+		{"requests from before opening do not decide a trial", 1, []step{
+			{0, failure, StateClosed}, {0, failure, StateClosed}, {0, failure, StateOpen},
+			{10 * time.Second, allow, StateHalfOpen},
+			{10 * time.Second, lateFailure, StateHalfOpen},
+			{11 * time.Second, success, StateClosed},
+		}},
+		{"abandoned trial frees its slot", 1, []step{
+			{0, failure, StateClosed}, {0, failure, StateClosed}, {0, failure, StateOpen},
+			{10 * time.Second, allow, StateHalfOpen},
+			{10 * time.Second, ignored, StateHalfOpen},
+			{10 * time.Second, allow, StateHalfOpen},
+			{10 * time.Second, success, StateClosed},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker("test-"+tt.name, 3, 10*time.Second, tt.halfOpenRequests)
+			start := time.Now()
+			trial := false
+			for i, s := range tt.steps {
+				now := start.Add(s.at)
+				switch s.op {
+				case allow, reject:
+					isTrial, retryAfter, ok := b.allow(now)
+					if want := s.op == allow; ok != want {
+						t.Fatalf("step %d: allow = %v, want %v", i, ok, want)
+					}
+					if ok {
+						trial = isTrial
+					} else if retryAfter <= 0 {
+						t.Errorf("step %d: rejected with retryAfter %v", i, retryAfter)
+					}
+				case success:
+					b.record(trial, outcomeSuccess, now)
+				case failure:
+					b.record(trial, outcomeFailure, now)
+				case ignored:
+					b.record(trial, outcomeIgnored, now)
+				case lateFailure:
+					b.record(false, outcomeFailure, now)
+				}
+				if got := b.status().State; got != s.wantState {
+					t.Fatalf("step %d (%s at %v): state = %s, want %s", i, s.op, s.at, got, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestEndpointGroup(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"/limited/xl/beli-paket-otp.json", "purchase"},
+		{"/limited/xl/price-list-all.json", "catalog"},
+		{"/limited/xl/check-stock-package-global.json", "stock"},
+		{"/limited/xl/unknown.json", otherGroup},
+	}
+	for _, tt := range tests {
+		if got := endpointGroup(tt.endpoint); got != tt.want {
+			t.Errorf("endpointGroup(%s) = %s, want %s", tt.endpoint, got, tt.want)
+		}
+	}
+}
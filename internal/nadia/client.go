@@ -0,0 +1,242 @@
+// Package nadia is a typed client of the Nadia API. Each upstream endpoint
+// has a method taking its parameters and returning its decoded data; request
+// and response samples of the endpoints are kept in reqresp/.
+package nadia
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/config"
+	"github.com/nabilulilalbab/nadia/internal/monitoring"
+	"github.com/nabilulilalbab/nadia/internal/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Client sends requests to the Nadia API. Requests go through the circuit
+// breaker of their endpoint group and the concurrency cap of their endpoint,
+// so that a degraded upstream fails fast instead of tying up every caller.
+type Client struct {
+	cfg          *config.Config
+	tokenManager *TokenManager
+	client       *http.Client
+	breakers     map[string]*breaker
+
+	bulkheadMutex sync.Mutex
+	bulkheads     map[string]chan struct{}
+}
+
+// NewClient creates a new Client sending requests through transport.
+func NewClient(cfg *config.Config, tm *TokenManager, transport http.RoundTripper) *Client {
+	breakers := map[string]*breaker{}
+	for _, group := range endpointGroups {
+		if _, ok := breakers[group]; !ok {
+			breakers[group] = newBreaker(group, cfg.BreakerFailureThreshold, cfg.BreakerOpenDuration, cfg.BreakerHalfOpenRequests)
+		}
+	}
+	breakers[otherGroup] = newBreaker(otherGroup, cfg.BreakerFailureThreshold, cfg.BreakerOpenDuration, cfg.BreakerHalfOpenRequests)
+
+	return &Client{
+		cfg:          cfg,
+		tokenManager: tm,
+		client:       &http.Client{Transport: transport},
+		breakers:     breakers,
+		bulkheads:    map[string]chan struct{}{},
+	}
+}
+
+// Response is a successful response of the Nadia API.
+type Response[T any] struct {
+	StatusCode int
+	Message    string
+	Data       T
+	// Body is the raw response, kept for the transaction history.
+	Body []byte
+}
+
+// timeout returns the deadline of one request to endpoint: its entry in
+// NADIA_ENDPOINT_TIMEOUTS, keyed by file name, or the default timeout.
+func (c *Client) timeout(endpoint string) time.Duration {
+	if timeout, ok := c.cfg.UpstreamEndpointTimeouts[path.Base(endpoint)]; ok {
+		return timeout
+	}
+	return c.cfg.UpstreamTimeout
+}
+
+// cancelOnClose releases the deadline of a request once its response body
+// has been read and closed by the caller.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// call sends a request to endpoint, retried according to its retry policy,
+// and decodes the data of its response. Upstream failures are returned as
+// *Error.
+func call[T any](ctx context.Context, c *Client, method, endpoint string, payload interface{}) (*Response[T], error) {
+	status, body, err := c.doWithRetry(ctx, method, endpoint, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Some endpoints, such as payment-methods.json, omit "success".
+	var envelope struct {
+		StatusCode int             `json:"statusCode"`
+		Message    string          `json:"message"`
+		Success    *bool           `json:"success"`
+		Data       json.RawMessage `json:"data"`
+	}
+	parseErr := json.Unmarshal(body, &envelope)
+
+	if status < 200 || status > 299 || (envelope.Success != nil && !*envelope.Success) {
+		return nil, &Error{
+			Endpoint:   path.Base(endpoint),
+			HTTPStatus: status,
+			StatusCode: envelope.StatusCode,
+			Message:    envelope.Message,
+			Body:       body,
+		}
+	}
+	if parseErr != nil {
+		return nil, fmt.Errorf("failed to parse %s response: %v", path.Base(endpoint), parseErr)
+	}
+
+	result := &Response[T]{StatusCode: envelope.StatusCode, Message: envelope.Message, Body: body}
+	if len(envelope.Data) > 0 {
+		if err := json.Unmarshal(envelope.Data, &result.Data); err != nil {
+			var zero T
+			result.Data = zero
+			return result, fmt.Errorf("%w: %s: %v", ErrMalformedResponse, path.Base(endpoint), err)
+		}
+	}
+	return result, nil
+}
+
+// do sends a request to endpoint unless its circuit breaker is open or it is
+// at its concurrency cap, and returns the status and body of the response.
+func (c *Client) do(ctx context.Context, method, endpoint string, payload interface{}) (int, []byte, error) {
+	group := endpointGroup(endpoint)
+
+	sem := c.bulkhead(endpoint)
+	select {
+	case sem <- struct{}{}:
+		defer func() { <-sem }()
+	default:
+		monitoring.RecordUpstreamRejection(endpoint, "concurrency_limit")
+		return 0, nil, &UnavailableError{Endpoint: path.Base(endpoint), Group: group, RetryAfter: time.Second, Err: ErrTooManyRequests}
+	}
+
+	b := c.breakers[group]
+	trial, retryAfter, ok := b.allow(time.Now())
+	if !ok {
+		monitoring.RecordUpstreamRejection(endpoint, "circuit_open")
+		return 0, nil, &UnavailableError{Endpoint: path.Base(endpoint), Group: group, RetryAfter: retryAfter, Err: ErrCircuitOpen}
+	}
+
+	status, body, err := c.roundTrip(ctx, method, endpoint, payload)
+	b.record(trial, classify(ctx, status, err), time.Now())
+	return status, body, err
+}
+
+// classify maps the result of a request to its effect on the circuit breaker.
+// Timeouts, network errors and 5xx responses are failures; rejections such
+// as 4xx or "success": false are answers from a healthy upstream.
+func classify(ctx context.Context, status int, err error) outcome {
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return outcomeIgnored
+	case err != nil, status >= 500:
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
+
+// roundTrip sends a request and reads its response.
+func (c *Client) roundTrip(ctx context.Context, method, endpoint string, payload interface{}) (int, []byte, error) {
+	resp, err := c.send(ctx, method, endpoint, payload, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read %s response: %v", path.Base(endpoint), err)
+	}
+	return resp.StatusCode, body, nil
+}
+
+// send executes a request to the Nadia API. The request is canceled with ctx
+// and each attempt has the timeout of endpoint; the caller must close the
+// response body. Each attempt is traced as a client span of the span in ctx.
+func (c *Client) send(ctx context.Context, method, endpoint string, payload interface{}, retryCount int) (*http.Response, error) {
+	token, err := c.tokenManager.GetValidToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get valid token: %v", err)
+	}
+
+	var reqBody io.Reader
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal payload: %v", err)
+		}
+		reqBody = bytes.NewBuffer(payloadBytes)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, c.timeout(endpoint))
+	req, err := http.NewRequestWithContext(attemptCtx, method, c.cfg.NadiaApiURL+endpoint, reqBody)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-token", token)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36")
+	req.Header.Set("Referer", "https://putri-veronica.my.id/limited/tembak-paket-xl")
+	req.Header.Set("Origin", "https://putri-veronica.my.id")
+
+	_, span := tracing.StartClient(ctx, method+" "+endpoint,
+		semconv.HTTPRequestMethodKey.String(method),
+		tracing.AttrUpstreamPath.String(endpoint))
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	status := 0
+	if err == nil {
+		status = resp.StatusCode
+		span.SetAttributes(tracing.AttrUpstreamStatus.Int(status))
+		resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	} else {
+		cancel()
+	}
+	monitoring.ObserveUpstreamRequest(endpoint, status, time.Since(start))
+	tracing.End(span, err)
+
+	// Handle unauthorized response with JWT refresh
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && retryCount < 2 {
+		resp.Body.Close()
+		log.Printf("Received 401 Unauthorized, attempting JWT refresh (retry %d/2)", retryCount+1)
+		monitoring.RecordUpstreamRetry(endpoint, http.StatusUnauthorized)
+
+		c.tokenManager.invalidate()
+		return c.send(ctx, method, endpoint, payload, retryCount+1)
+	}
+
+	return resp, err
+}
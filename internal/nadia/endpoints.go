@@ -0,0 +1,141 @@
+package nadia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// PurchaseRequest is the body of beli-paket-otp.json. Phone is in the 62...
+// format.
+type PurchaseRequest struct {
+	PackageCode   string `json:"package_code"`
+	Phone         string `json:"phone"`
+	AccessToken   string `json:"access_token"`
+	PaymentMethod string `json:"payment_method"`
+}
+
+// InvoiceQuery is the body of invoice/list.json. LastCreatedAt is the cursor
+// of the previous page, or 0 for the first one.
+type InvoiceQuery struct {
+	Search        string `json:"search"`
+	LastCreatedAt int64  `json:"last_created_at"`
+	Limit         int    `json:"limit"`
+}
+
+// PackageList downloads the full package catalog from package-list-all.json.
+func (c *Client) PackageList(ctx context.Context) (*Response[[]models.Package], error) {
+	return call[[]models.Package](ctx, c, "POST", "/limited/xl/package-list-all.json", map[string]interface{}{})
+}
+
+// PriceList downloads the full price list from price-list-all.json.
+func (c *Client) PriceList(ctx context.Context) (*Response[[]models.PriceData], error) {
+	return call[[]models.PriceData](ctx, c, "POST", "/limited/xl/price-list-all.json", nil)
+}
+
+// RequestOTP sends an OTP to phone with request-otp.json.
+func (c *Client) RequestOTP(ctx context.Context, phone string) (*Response[models.OTPRequestData], error) {
+	return call[models.OTPRequestData](ctx, c, "POST", "/limited/xl/request-otp.json", map[string]string{"phone": phone})
+}
+
+// Login exchanges the OTP of an auth_id from RequestOTP for an XL access
+// token with request-login.json.
+func (c *Client) Login(ctx context.Context, phone, authID, otp string) (*Response[models.OTPLoginData], error) {
+	payload := map[string]string{"phone": phone, "auth_id": authID, "otp": otp}
+	return call[models.OTPLoginData](ctx, c, "POST", "/limited/xl/request-login.json", payload)
+}
+
+// BuyPackage purchases a package with beli-paket-otp.json. A refused purchase,
+// for example for lack of credit, is an *Error.
+func (c *Client) BuyPackage(ctx context.Context, req PurchaseRequest) (*Response[models.PurchaseResponseData], error) {
+	resp, err := call[models.PurchaseResponseData](ctx, c, "POST", "/limited/xl/beli-paket-otp.json", req)
+	if resp != nil && !resp.Data.IsQris {
+		// Upstream sends "qris_data": [] for non-QRIS purchases.
+		resp.Data.QrisData = nil
+	}
+	return resp, err
+}
+
+// CardStatus returns the status and credit of the card of accessToken from
+// status-kartu.json.
+func (c *Client) CardStatus(ctx context.Context, accessToken string) (*Response[models.CardStatusData], error) {
+	return call[models.CardStatusData](ctx, c, "POST", "/limited/xl/status-kartu.json", map[string]string{"access_token": accessToken})
+}
+
+// ActivePackages lists the packages active on the card of accessToken from
+// package-active-list.json.
+func (c *Client) ActivePackages(ctx context.Context, accessToken string) (*Response[models.ActivePackagesData], error) {
+	return call[models.ActivePackagesData](ctx, c, "POST", "/limited/xl/package-active-list.json", map[string]string{"access_token": accessToken})
+}
+
+// CheckTransaction fetches the upstream status of a transaction from check-transaction.json.
+func (c *Client) CheckTransaction(ctx context.Context, trxID string) (*Response[models.TransactionCheckData], error) {
+	return call[models.TransactionCheckData](ctx, c, "POST", "/limited/xl/check-transaction.json", map[string]string{"trx_id": trxID})
+}
+
+// CheckPackageStock returns the remaining upstream stock of a package from
+// check-stock-package.json.
+func (c *Client) CheckPackageStock(ctx context.Context, packageCode string) (*Response[models.PackageStock], error) {
+	resp, err := call[json.RawMessage](ctx, c, "POST", "/limited/xl/check-stock-package.json", map[string]string{"package_code": packageCode})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Response[models.PackageStock]{StatusCode: resp.StatusCode, Message: resp.Message, Body: resp.Body}
+	// The data is a single entry, or a list like the global stock endpoint.
+	if err := json.Unmarshal(resp.Data, &result.Data); err == nil {
+		return result, nil
+	}
+	var stocks []models.PackageStock
+	if err := json.Unmarshal(resp.Data, &stocks); err != nil {
+		result.Data = models.PackageStock{}
+		return result, fmt.Errorf("%w: check-stock-package.json: %v", ErrMalformedResponse, err)
+	}
+	result.Data.PackageID = packageCode
+	for _, entry := range stocks {
+		if entry.PackageID == packageCode {
+			result.Data = entry
+			break
+		}
+	}
+	return result, nil
+}
+
+// GlobalStock returns the stock of every limited package from
+// check-stock-package-global.json.
+func (c *Client) GlobalStock(ctx context.Context) (*Response[[]models.PackageStock], error) {
+	return call[[]models.PackageStock](ctx, c, "GET", "/limited/xl/check-stock-package-global.json", nil)
+}
+
+// Balance returns the balance of our upstream account from wallet/balance.json.
+func (c *Client) Balance(ctx context.Context) (*Response[models.AccountBalance], error) {
+	return call[models.AccountBalance](ctx, c, "GET", "/wallet/balance.json", nil)
+}
+
+// PaymentMethods lists the ways to top up our upstream account from
+// wallet/payment-methods.json.
+func (c *Client) PaymentMethods(ctx context.Context) (*Response[[]models.UpstreamPaymentMethod], error) {
+	return call[[]models.UpstreamPaymentMethod](ctx, c, "GET", "/wallet/payment-methods.json", nil)
+}
+
+// ListInvoices returns a page of the invoices of our upstream account from
+// invoice/list.json.
+func (c *Client) ListInvoices(ctx context.Context, query InvoiceQuery) (*Response[models.InvoiceList], error) {
+	resp, err := call[[]models.InvoiceRecord](ctx, c, "POST", "/invoice/list.json", query)
+	if resp == nil {
+		return nil, err
+	}
+
+	result := &Response[models.InvoiceList]{StatusCode: resp.StatusCode, Message: resp.Message, Body: resp.Body}
+	result.Data.Invoices = resp.Data
+	// The pagination is a sibling of "data" rather than part of it.
+	var page struct {
+		Pagination models.InvoicePagination `json:"pagination"`
+	}
+	if perr := json.Unmarshal(resp.Body, &page); perr == nil {
+		result.Data.Pagination = page.Pagination
+	}
+	return result, err
+}
@@ -0,0 +1,44 @@
+package nadia
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrUnauthorized is matched by an Error whose request was still refused
+	// with 401 after logging in again.
+	ErrUnauthorized = errors.New("nadia: unauthorized")
+	// ErrMalformedResponse is returned when a successful response has data
+	// that does not decode into the expected type. The request itself went
+	// through, so the response is returned alongside it with zero data.
+	ErrMalformedResponse = errors.New("nadia: malformed response data")
+)
+
+// Error is returned when the Nadia API answers a request with a non-2xx HTTP
+// status or with "success": false, such as when a purchase is refused
+// because the card has too little credit.
+type Error struct {
+	Endpoint string
+	// HTTPStatus is the status of the HTTP response, StatusCode the
+	// statusCode of the response body; they may differ.
+	HTTPStatus int
+	StatusCode int
+	Message    string
+	// Body is the raw response, kept for the transaction history.
+	Body []byte
+}
+
+func (e *Error) Error() string {
+	message := e.Message
+	if message == "" {
+		message = fmt.Sprintf("status %d", e.HTTPStatus)
+	}
+	return fmt.Sprintf("nadia %s: %s", e.Endpoint, message)
+}
+
+// Is reports whether the error matches ErrUnauthorized.
+func (e *Error) Is(target error) bool {
+	return target == ErrUnauthorized && e.HTTPStatus == http.StatusUnauthorized
+}
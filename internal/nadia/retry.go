@@ -0,0 +1,105 @@
+package nadia
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/monitoring"
+)
+
+// retryPolicy says how often a request to an endpoint is sent after a
+// timeout, network error or 5xx response, and how long to wait in between.
+// The wait doubles with every attempt up to maxDelay and is jittered, so
+// that callers failing together do not retry together.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+var (
+	// noRetry is the policy of endpoints with an effect upstream.
+	noRetry = retryPolicy{maxAttempts: 1}
+	// safeRead is the policy of reads that can be repeated freely.
+	safeRead = retryPolicy{maxAttempts: 3, baseDelay: 250 * time.Millisecond, maxDelay: 2 * time.Second}
+)
+
+// retryPolicies lists the endpoints whose failed requests are sent again.
+// Every other endpoint, notably beli-paket-otp.json, which could buy a
+// package twice, and request-otp.json, which sends an SMS, is never retried.
+var retryPolicies = map[string]retryPolicy{
+	"/limited/xl/package-list-all.json":           safeRead,
+	"/limited/xl/price-list-all.json":             safeRead,
+	"/limited/xl/status-kartu.json":               safeRead,
+	"/limited/xl/package-active-list.json":        safeRead,
+	"/limited/xl/check-transaction.json":          safeRead,
+	"/limited/xl/check-stock-package.json":        safeRead,
+	"/limited/xl/check-stock-package-global.json": safeRead,
+	"/wallet/balance.json":                        safeRead,
+	"/wallet/payment-methods.json":                safeRead,
+	"/invoice/list.json":                          safeRead,
+}
+
+func endpointRetryPolicy(endpoint string) retryPolicy {
+	if policy, ok := retryPolicies[endpoint]; ok {
+		return policy
+	}
+	return noRetry
+}
+
+// backoff returns the wait before the attempt after the given one: a random
+// duration up to baseDelay doubled attempt-1 times, capped at maxDelay.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.baseDelay << (attempt - 1)
+	if delay <= 0 || delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// doWithRetry sends a request to endpoint following its retry policy. Each
+// attempt has the full timeout of endpoint and goes through its circuit
+// breaker; once the breaker opens no further attempt is made.
+func (c *Client) doWithRetry(ctx context.Context, method, endpoint string, payload interface{}) (int, []byte, error) {
+	policy := endpointRetryPolicy(endpoint)
+	for attempt := 1; ; attempt++ {
+		status, body, err := c.do(ctx, method, endpoint, payload)
+		if attempt >= policy.maxAttempts || !retryable(ctx, status, err) {
+			return status, body, err
+		}
+
+		delay := policy.backoff(attempt)
+		monitoring.RecordUpstreamRetry(endpoint, status)
+		if err != nil {
+			log.Printf("Nadia API %s failed: %v, retrying in %v (attempt %d/%d)", endpoint, err, delay, attempt+1, policy.maxAttempts)
+		} else {
+			log.Printf("Nadia API %s returned %d, retrying in %v (attempt %d/%d)", endpoint, status, delay, attempt+1, policy.maxAttempts)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return status, body, err
+		}
+	}
+}
+
+// retryable reports whether a failed request may succeed when sent again.
+// Requests abandoned by their caller or refused by the circuit breaker or
+// concurrency cap are not retried.
+func retryable(ctx context.Context, status int, err error) bool {
+	var unavailable *UnavailableError
+	switch {
+	case errors.As(err, &unavailable):
+		return false
+	case err != nil:
+		return ctx.Err() == nil
+	}
+	return status >= 500 || status == http.StatusTooManyRequests
+}
@@ -0,0 +1,123 @@
+package nadia
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/config"
+)
+
+func TestEndpointRetryPolicy(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     int
+	}{
+		{"/limited/xl/beli-paket-otp.json", 1},
+		{"/limited/xl/request-otp.json", 1},
+		{"/limited/xl/request-login.json", 1},
+		{"/limited/xl/unknown.json", 1},
+		{"/limited/xl/check-transaction.json", 3},
+		{"/limited/xl/package-list-all.json", 3},
+		{"/wallet/balance.json", 3},
+	}
+	for _, tt := range tests {
+		if got := endpointRetryPolicy(tt.endpoint).maxAttempts; got != tt.want {
+			t.Errorf("endpointRetryPolicy(%s).maxAttempts = %d, want %d", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		status int
+		err    error
+		want   bool
+	}{
+		{"ok", context.Background(), http.StatusOK, nil, false},
+		{"bad request", context.Background(), http.StatusBadRequest, nil, false},
+		{"not found", context.Background(), http.StatusNotFound, nil, false},
+		{"too many requests", context.Background(), http.StatusTooManyRequests, nil, true},
+		{"internal error", context.Background(), http.StatusInternalServerError, nil, true},
+		{"bad gateway", context.Background(), http.StatusBadGateway, nil, true},
+		{"network error", context.Background(), 0, errors.New("connection reset"), true},
+		{"canceled by caller", canceled, 0, context.Canceled, false},
+		{"circuit open", context.Background(), 0, &UnavailableError{Err: ErrCircuitOpen}, false},
+		{"concurrency cap", context.Background(), 0, &UnavailableError{Err: ErrTooManyRequests}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.ctx, tt.status, tt.err); got != tt.want {
+				t.Errorf("retryable(%d, %v) = %v, want %v", tt.status, tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := retryPolicy{maxAttempts: 5, baseDelay: 100 * time.Millisecond, maxDelay: time.Second}
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{4, 400 * time.Millisecond, 800 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second},
+		// The shift overflows long before this; the cap still applies.
+		{70, 500 * time.Millisecond, time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if got := policy.backoff(tt.attempt); got < tt.min || got > tt.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+// TestDoWithRetry checks how often a failing endpoint is called.
+func TestDoWithRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		status   int
+		want     int32
+	}{
+		{"read retried on 5xx", "/limited/xl/check-transaction.json", http.StatusServiceUnavailable, 3},
+		{"read not retried on 4xx", "/limited/xl/check-transaction.json", http.StatusBadRequest, 1},
+		{"purchase never retried", "/limited/xl/beli-paket-otp.json", http.StatusServiceUnavailable, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			cfg := &config.Config{NadiaApiURL: server.URL, UpstreamTimeout: time.Second}
+			tm := NewTokenManager(cfg, http.DefaultTransport)
+			tm.token, tm.expiresAt = "test-token", time.Now().Add(time.Hour)
+			c := NewClient(cfg, tm, http.DefaultTransport)
+
+			status, _, err := c.doWithRetry(context.Background(), "POST", tt.endpoint, nil)
+			if err != nil || status != tt.status {
+				t.Fatalf("doWithRetry = %d, %v, want %d", status, err, tt.status)
+			}
+			if got := calls.Load(); got != tt.want {
+				t.Errorf("upstream called %d times, want %d", got, tt.want)
+			}
+		})
+	}
+}
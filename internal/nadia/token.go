@@ -0,0 +1,228 @@
+package nadia
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"sync"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/config"
+	"github.com/nabilulilalbab/nadia/internal/monitoring"
+	"github.com/nabilulilalbab/nadia/internal/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// NewTransport returns the transport shared by all requests to the Nadia API
+// and its SSO, so that connections are kept alive and reused. Deadlines are
+// set per request through its context instead.
+func NewTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   20,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// TokenManager handles the lifecycle of the API token.
+type TokenManager struct {
+	cfg       *config.Config
+	transport http.RoundTripper
+	token     string
+	expiresAt time.Time
+	mutex     sync.RWMutex
+}
+
+// NewTokenManager creates a new TokenManager logging in through transport.
+func NewTokenManager(cfg *config.Config, transport http.RoundTripper) *TokenManager {
+	return &TokenManager{
+		cfg:       cfg,
+		transport: transport,
+	}
+}
+
+// GetValidToken returns a valid token, refreshing it if necessary.
+func (tm *TokenManager) GetValidToken(ctx context.Context) (string, error) {
+	tm.mutex.RLock()
+	if tm.token != "" && time.Now().Before(tm.expiresAt) {
+		token := tm.token
+		tm.mutex.RUnlock()
+		return token, nil
+	}
+	tm.mutex.RUnlock()
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	// Double check after acquiring the lock
+	if tm.token != "" && time.Now().Before(tm.expiresAt) {
+		return tm.token, nil
+	}
+
+	newToken, err := tm.refreshToken(ctx)
+	monitoring.RecordTokenRefresh(err)
+	if err != nil {
+		return "", err
+	}
+
+	tm.token = newToken
+	tm.expiresAt = time.Now().Add(tm.cfg.TokenExpiry)
+	return newToken, nil
+}
+
+// invalidate forces the next GetValidToken to log in again.
+func (tm *TokenManager) invalidate() {
+	tm.mutex.Lock()
+	tm.token = ""
+	tm.expiresAt = time.Time{}
+	tm.mutex.Unlock()
+}
+
+// refreshToken performs the complete login flow to get a new token. The
+// token is shared by all callers waiting for it, so the login is not
+// canceled with ctx; it only has the default upstream timeout.
+func (tm *TokenManager) refreshToken(ctx context.Context) (token string, err error) {
+	ctx, span := tracing.Start(ctx, "TokenManager.refreshToken")
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tm.cfg.UpstreamTimeout)
+	defer cancel()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cookie jar: %v", err)
+	}
+	httpClient := &http.Client{Jar: jar, Transport: tm.transport}
+
+	sessionToken, err := tm.getSessionToken(ctx, httpClient)
+	if err != nil {
+		return "", fmt.Errorf("failed to get session token: %v", err)
+	}
+
+	finalJWT, err := tm.performApiLogin(ctx, httpClient, sessionToken)
+	if err != nil {
+		return "", fmt.Errorf("failed to perform login: %v", err)
+	}
+
+	return finalJWT, nil
+}
+
+// getSessionToken requests a temporary session token.
+func (tm *TokenManager) getSessionToken(ctx context.Context, client *http.Client) (token string, err error) {
+	ctx, span := tracing.StartClient(ctx, "GET /oauth/request_token", semconv.HTTPRequestMethodGet)
+	defer func() { tracing.End(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", tm.cfg.SSOApiBaseURL+"/oauth/request_token", nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36")
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	req.Header.Set("access-key", tm.cfg.SSOStaticKey)
+	req.Header.Set("Referer", "https://sso.putri-veronica.my.id/")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var sessionResp struct {
+		Success     bool   `json:"success"`
+		AccessToken string `json:"access_token"`
+		Message     string `json:"message"`
+	}
+
+	if err := json.Unmarshal(body, &sessionResp); err != nil {
+		return "", fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	if !sessionResp.Success || sessionResp.AccessToken == "" {
+		return "", fmt.Errorf("invalid session token: %s", sessionResp.Message)
+	}
+
+	return sessionResp.AccessToken, nil
+}
+
+// performApiLogin uses the session token to perform the final login.
+func (tm *TokenManager) performApiLogin(ctx context.Context, client *http.Client, sessionToken string) (token string, err error) {
+	ctx, span := tracing.StartClient(ctx, "POST /user/login", semconv.HTTPRequestMethodPost)
+	defer func() { tracing.End(span, err) }()
+
+	payload := map[string]string{
+		"username": tm.cfg.Username,
+		"password": tm.cfg.Password,
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tm.cfg.SSOApiBaseURL+"/user/login", bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36")
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("access-token", sessionToken)
+	req.Header.Set("Referer", "https://sso.putri-veronica.my.id/")
+	req.Header.Set("Origin", "https://sso.putri-veronica.my.id")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("login failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var finalResp struct {
+		Success bool   `json:"success"`
+		Token   string `json:"token"`
+		Message string `json:"message"`
+	}
+
+	if err := json.Unmarshal(body, &finalResp); err != nil {
+		return "", fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	if !finalResp.Success || finalResp.Token == "" {
+		return "", fmt.Errorf("invalid JWT token: %s", finalResp.Message)
+	}
+
+	return finalResp.Token, nil
+}
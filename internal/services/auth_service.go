@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nabilulilalbab/nadia/internal/database"
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+const (
+	jwtIssuer          = "nadia"
+	refreshTokenPrefix = "nrt_"
+	adminSubject       = "admin"
+	resellerSubjectFmt = "reseller:%d"
+)
+
+var (
+	// ErrInvalidCredentials is returned when an API key is neither the admin key nor a reseller key.
+	ErrInvalidCredentials = errors.New("invalid API key")
+	// ErrInvalidToken is returned for malformed tokens or tokens with a bad signature.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired is returned for expired access or refresh tokens.
+	ErrTokenExpired = errors.New("token has expired")
+	// ErrTokenRevoked is returned for revoked access or refresh tokens.
+	ErrTokenRevoked = errors.New("token has been revoked")
+)
+
+// AuthClaims are the claims of the access tokens issued by AuthService.
+type AuthClaims struct {
+	Role       string   `json:"role"`
+	Tier       string   `json:"tier"`
+	Name       string   `json:"name,omitempty"`
+	ResellerID int64    `json:"reseller_id,omitempty"`
+	Scopes     []string `json:"scopes,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// AuthService authenticates API keys and issues, parses and revokes HS256
+// access tokens and their refresh tokens. Revoked access token IDs are kept
+// in the database; the ones seen by this instance are cached in memory, and
+// the others are looked up so revocations by other instances apply at once.
+type AuthService struct {
+	store      database.Store
+	secret     []byte
+	adminKey   string
+	resellers  *ResellerService
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+
+	mutex   sync.RWMutex
+	revoked map[string]time.Time
+}
+
+// NewAuthService creates an AuthService and loads the revocation list.
+func NewAuthService(store database.Store, secret, adminKey string, rs *ResellerService, accessTTL, refreshTTL time.Duration) (*AuthService, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("JWT secret is required")
+	}
+	if accessTTL <= 0 {
+		accessTTL = time.Hour
+	}
+	if refreshTTL <= 0 {
+		refreshTTL = 30 * 24 * time.Hour
+	}
+
+	revoked, err := store.GetRevokedTokenIDs(context.Background(), time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load revoked tokens: %v", err)
+	}
+
+	return &AuthService{
+		store:      store,
+		secret:     []byte(secret),
+		adminKey:   adminKey,
+		resellers:  rs,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+		revoked:    revoked,
+	}, nil
+}
+
+// AuthenticateAPIKey resolves the admin key or a reseller key to a principal.
+func (s *AuthService) AuthenticateAPIKey(ctx context.Context, key string) (*models.Principal, error) {
+	if key == "" {
+		return nil, ErrInvalidCredentials
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(s.adminKey)) == 1 {
+		return adminPrincipal(), nil
+	}
+
+	reseller, err := s.resellers.Authenticate(ctx, key)
+	if errors.Is(err, ErrResellerNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resellerPrincipal(reseller), nil
+}
+
+// Login exchanges an API key for an access token and a refresh token.
+func (s *AuthService) Login(ctx context.Context, apiKey string) (*models.AuthResponse, error) {
+	principal, err := s.AuthenticateAPIKey(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.DeleteExpired(ctx, time.Now()); err != nil {
+		return nil, err
+	}
+	return s.issue(ctx, principal)
+}
+
+// Refresh exchanges a refresh token for a new token pair. The refresh token
+// is rotated: it cannot be used again. Reusing a rotated refresh token
+// revokes every refresh token of its subject, as the token was likely stolen.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*models.AuthResponse, error) {
+	stored, err := s.store.GetRefreshToken(ctx, hashSecret(refreshToken))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if stored.RevokedAt != nil {
+		// A token that was already exchanged may have been stolen; one revoked
+		// by logout or key rotation is just stale and leaves newer sessions be.
+		if stored.RotatedAt != nil {
+			if _, err := s.store.RevokeRefreshTokensBySubject(ctx, stored.Subject, now); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrTokenRevoked
+	}
+	if now.After(stored.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	var principal *models.Principal
+	switch stored.Role {
+	case models.PrincipalAdmin:
+		principal = adminPrincipal()
+	case models.PrincipalReseller:
+		if principal, err = s.currentReseller(ctx, stored.ResellerID); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrInvalidToken
+	}
+
+	if err := s.store.RotateRefreshToken(ctx, stored.ID, now); err == sql.ErrNoRows {
+		// Rotated concurrently by another request.
+		return nil, ErrTokenRevoked
+	} else if err != nil {
+		return nil, err
+	}
+	return s.issue(ctx, principal)
+}
+
+// ParseAccessToken validates an access token and returns its principal.
+// Reseller tokens are checked against the current reseller account, so
+// suspensions and scope changes apply to tokens already issued.
+func (s *AuthService) ParseAccessToken(ctx context.Context, tokenString string) (*models.Principal, error) {
+	claims := &AuthClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(*jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(jwtIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrTokenExpired
+	}
+	if err != nil || claims.ID == "" || claims.ExpiresAt == nil {
+		return nil, ErrInvalidToken
+	}
+	revoked, err := s.isRevoked(ctx, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	var principal *models.Principal
+	switch claims.Role {
+	case models.PrincipalAdmin:
+		principal = adminPrincipal()
+	case models.PrincipalReseller:
+		if principal, err = s.currentReseller(ctx, claims.ResellerID); err != nil {
+			return nil, err
+		}
+	case models.PrincipalUser:
+		principal = &models.Principal{
+			Kind:   models.PrincipalUser,
+			Name:   claims.Subject,
+			Tier:   models.PriceTierUser,
+			Scopes: []string{models.ScopeCatalog},
+		}
+	default:
+		return nil, ErrInvalidToken
+	}
+
+	expiresAt := claims.ExpiresAt.Time
+	principal.TokenID = claims.ID
+	principal.TokenExpiresAt = &expiresAt
+	return principal, nil
+}
+
+// Logout revokes the access token of a principal and, if given, a refresh token
+// belonging to the same subject.
+func (s *AuthService) Logout(ctx context.Context, principal *models.Principal, refreshToken string) error {
+	if principal.TokenID != "" && principal.TokenExpiresAt != nil {
+		if err := s.RevokeTokenID(ctx, principal.TokenID, *principal.TokenExpiresAt); err != nil {
+			return err
+		}
+	}
+	if refreshToken == "" {
+		return nil
+	}
+
+	stored, err := s.store.GetRefreshToken(ctx, hashSecret(refreshToken))
+	if err == sql.ErrNoRows || (err == nil && stored.Subject != subjectOf(principal)) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.store.RevokeRefreshToken(ctx, stored.ID, time.Now()); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	return nil
+}
+
+// RevokeTokenID adds an access token ID to the revocation list until it expires.
+// A zero expiresAt keeps the entry for the maximum access token lifetime.
+func (s *AuthService) RevokeTokenID(ctx context.Context, jti string, expiresAt time.Time) error {
+	now := time.Now()
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(s.accessTTL)
+	}
+	if err := s.store.RevokeTokenID(ctx, jti, expiresAt, now); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.revoked[jti] = expiresAt
+	s.mutex.Unlock()
+	return nil
+}
+
+// RevokeSubject revokes all refresh tokens of a subject such as "admin" or
+// "reseller:1", so no new access tokens can be obtained for it.
+func (s *AuthService) RevokeSubject(ctx context.Context, subject string) (int64, error) {
+	return s.store.RevokeRefreshTokensBySubject(ctx, subject, time.Now())
+}
+
+// RotateResellerKey issues a new API key for a reseller and revokes the
+// refresh tokens obtained with the old one, so that a leaked key cannot be
+// kept alive through /api/auth/refresh.
+func (s *AuthService) RotateResellerKey(ctx context.Context, id int64) (*models.ResellerKeyResponse, error) {
+	rotated, err := s.resellers.RotateKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.RevokeSubject(ctx, fmt.Sprintf(resellerSubjectFmt, id)); err != nil {
+		return nil, fmt.Errorf("key rotated but refresh tokens not revoked: %v", err)
+	}
+	return rotated, nil
+}
+
+// DeleteExpired removes expired revocation entries and refresh tokens.
+func (s *AuthService) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	s.mutex.Lock()
+	for jti, expiresAt := range s.revoked {
+		if now.After(expiresAt) {
+			delete(s.revoked, jti)
+		}
+	}
+	s.mutex.Unlock()
+
+	return s.store.DeleteExpiredAuthTokens(ctx, now)
+}
+
+// isRevoked reports whether an access token ID was revoked. IDs not in the
+// cache are looked up in the database, and cached if they were revoked.
+func (s *AuthService) isRevoked(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	s.mutex.RLock()
+	_, ok := s.revoked[jti]
+	s.mutex.RUnlock()
+	if ok {
+		return true, nil
+	}
+
+	revoked, err := s.store.IsTokenIDRevoked(ctx, jti)
+	if err != nil || !revoked {
+		return false, err
+	}
+	s.mutex.Lock()
+	s.revoked[jti] = expiresAt
+	s.mutex.Unlock()
+	return true, nil
+}
+
+func (s *AuthService) currentReseller(ctx context.Context, id int64) (*models.Principal, error) {
+	reseller, err := s.resellers.Get(ctx, id)
+	if errors.Is(err, ErrResellerNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reseller.Status != models.ResellerStatusActive {
+		return nil, ErrResellerSuspended
+	}
+	return resellerPrincipal(reseller), nil
+}
+
+// issue signs an access token and stores a new refresh token for a principal.
+func (s *AuthService) issue(ctx context.Context, principal *models.Principal) (*models.AuthResponse, error) {
+	now := time.Now()
+	jti, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := AuthClaims{
+		Role:       principal.Kind,
+		Tier:       principal.Tier,
+		Name:       principal.Name,
+		ResellerID: principal.ResellerID,
+		Scopes:     principal.Scopes,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Subject:   subjectOf(principal),
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.accessTTL)),
+		},
+	}
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshID, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken := refreshTokenPrefix + secret
+	stored := &models.RefreshToken{
+		ID:         refreshID,
+		TokenHash:  hashSecret(refreshToken),
+		Subject:    claims.Subject,
+		Role:       principal.Kind,
+		ResellerID: principal.ResellerID,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(s.refreshTTL),
+	}
+	if err := s.store.SaveRefreshToken(ctx, stored); err != nil {
+		return nil, err
+	}
+
+	return &models.AuthResponse{
+		Token:            accessToken,
+		TokenType:        "Bearer",
+		ExpiresAt:        claims.ExpiresAt.Time,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: stored.ExpiresAt,
+	}, nil
+}
+
+func subjectOf(principal *models.Principal) string {
+	if principal.Kind == models.PrincipalReseller {
+		return fmt.Sprintf(resellerSubjectFmt, principal.ResellerID)
+	}
+	if principal.Kind == models.PrincipalAdmin {
+		return adminSubject
+	}
+	return principal.Name
+}
+
+func adminPrincipal() *models.Principal {
+	return &models.Principal{
+		Kind:   models.PrincipalAdmin,
+		Name:   "admin",
+		Tier:   models.PriceTierReseller,
+		Scopes: models.AllScopes,
+	}
+}
+
+func resellerPrincipal(r *models.Reseller) *models.Principal {
+	return &models.Principal{
+		Kind:       models.PrincipalReseller,
+		ResellerID: r.ID,
+		Name:       r.Name,
+		Tier:       r.Tier,
+		Scopes:     r.Scopes,
+	}
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
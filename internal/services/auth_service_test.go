@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/nabilulilalbab/nadia/internal/database"
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// TestRotateResellerKeyRevokesRefreshTokens checks that a refresh token
+// obtained with a rotated key no longer mints access tokens, without taking
+// the sessions of the new key down with it.
+func TestRotateResellerKeyRevokesRefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	store, err := database.InitDatabase(database.DriverSQLite, filepath.Join(t.TempDir(), "nadia.db"))
+	if err != nil {
+		t.Fatalf("InitDatabase: %v", err)
+	}
+	defer store.Close()
+	pricing, err := NewPricingService(store)
+	if err != nil {
+		t.Fatalf("NewPricingService: %v", err)
+	}
+	resellers := NewResellerService(store, pricing)
+	auth, err := NewAuthService(store, "test-secret", "admin-key", resellers, 0, 0)
+	if err != nil {
+		t.Fatalf("NewAuthService: %v", err)
+	}
+
+	created, err := resellers.Create(ctx, models.ResellerRequest{Name: "shop"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	tokens, err := auth.Login(ctx, created.APIKey)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	rotated, err := auth.RotateResellerKey(ctx, created.Reseller.ID)
+	if err != nil {
+		t.Fatalf("RotateResellerKey: %v", err)
+	}
+	if _, err := auth.Refresh(ctx, tokens.RefreshToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Refresh with a pre-rotation refresh token = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := auth.Login(ctx, created.APIKey); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login with the rotated key = %v, want ErrInvalidCredentials", err)
+	}
+	fresh, err := auth.Login(ctx, rotated.APIKey)
+	if err != nil {
+		t.Fatalf("Login with the new key: %v", err)
+	}
+	if _, err := auth.Refresh(ctx, tokens.RefreshToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Refresh with a stale refresh token = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := auth.Refresh(ctx, fresh.RefreshToken); err != nil {
+		t.Errorf("Refresh of the new key's session after a stale refresh: %v", err)
+	}
+}
+
+// TestRefreshTokenReuseRevokesSubject checks that presenting an exchanged
+// refresh token again revokes the sessions issued from it.
+func TestRefreshTokenReuseRevokesSubject(t *testing.T) {
+	ctx := context.Background()
+	store, err := database.InitDatabase(database.DriverSQLite, filepath.Join(t.TempDir(), "nadia.db"))
+	if err != nil {
+		t.Fatalf("InitDatabase: %v", err)
+	}
+	defer store.Close()
+	pricing, err := NewPricingService(store)
+	if err != nil {
+		t.Fatalf("NewPricingService: %v", err)
+	}
+	auth, err := NewAuthService(store, "test-secret", "admin-key", NewResellerService(store, pricing), 0, 0)
+	if err != nil {
+		t.Fatalf("NewAuthService: %v", err)
+	}
+
+	first, err := auth.Login(ctx, "admin-key")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	second, err := auth.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if _, err := auth.Refresh(ctx, first.RefreshToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Refresh with a reused refresh token = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := auth.Refresh(ctx, second.RefreshToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Refresh after reuse = %v, want ErrTokenRevoked", err)
+	}
+}
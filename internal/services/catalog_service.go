@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/nadia"
+	"github.com/nabilulilalbab/nadia/internal/tracing"
+	"github.com/nabilulilalbab/nadia/internal/utils"
+)
+
+// CatalogSnapshot is an immutable, merged view of package-list-all.json and
+// price-list-all.json. Handlers must treat it as read-only.
+type CatalogSnapshot struct {
+	Version   int64
+	FetchedAt time.Time
+	Packages  []models.Package
+	Prices    map[string]models.PriceData
+
+	byCode map[string]int
+}
+
+// Package returns the package with the given code from the snapshot.
+func (cs *CatalogSnapshot) Package(code string) (models.Package, bool) {
+	idx, ok := cs.byCode[code]
+	if !ok {
+		return models.Package{}, false
+	}
+	return cs.Packages[idx], true
+}
+
+// Cost returns our upstream cost for a package code, or 0 if unknown.
+func (cs *CatalogSnapshot) Cost(code string) int {
+	return upstreamCost(cs.Prices[code])
+}
+
+// upstreamCost picks the price we pay upstream from a price-list entry.
+// Newer price lists only carry "price"; older ones carry member_price for our account.
+func upstreamCost(pd models.PriceData) int {
+	if pd.Price > 0 {
+		return pd.Price
+	}
+	if pd.MemberPrice > 0 {
+		return pd.MemberPrice
+	}
+	return pd.BasePrice
+}
+
+// CatalogService keeps an in-memory catalog snapshot refreshed in the background,
+// so that handlers never download the package and price lists per request.
+type CatalogService struct {
+	nadiaClient *nadia.Client
+	interval    time.Duration
+
+	mutex       sync.RWMutex
+	snapshot    *CatalogSnapshot
+	lastError   error
+	lastAttempt time.Time
+
+	refreshMutex sync.Mutex
+	stopCh       chan struct{}
+	stopOnce     sync.Once
+}
+
+// NewCatalogService creates a new CatalogService refreshing at the given interval.
+func NewCatalogService(nc *nadia.Client, interval time.Duration) *CatalogService {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	return &CatalogService{
+		nadiaClient: nc,
+		interval:    interval,
+		stopCh:      make(chan struct{}),
+	}
+}
+
+// Start launches the background refresh loop. The first refresh runs immediately.
+func (s *CatalogService) Start() {
+	go func() {
+		ctx := context.Background()
+		if err := s.Refresh(ctx); err != nil {
+			log.Printf("Initial catalog refresh failed: %v", err)
+		}
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Refresh(ctx); err != nil {
+					log.Printf("Catalog refresh failed, serving stale data: %v", err)
+				}
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the background refresh loop.
+func (s *CatalogService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+// Refresh downloads the package and price lists and swaps in a new snapshot.
+// On failure the previous snapshot is kept and the error is recorded.
+func (s *CatalogService) Refresh(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "CatalogService.Refresh")
+	defer func() { tracing.End(span, err) }()
+
+	s.refreshMutex.Lock()
+	defer s.refreshMutex.Unlock()
+
+	snapshot, err := s.fetchSnapshot(ctx)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastAttempt = time.Now()
+	s.lastError = err
+	if err != nil {
+		return err
+	}
+
+	if s.snapshot != nil {
+		snapshot.Version = s.snapshot.Version + 1
+	} else {
+		snapshot.Version = 1
+	}
+	s.snapshot = snapshot
+
+	log.Printf("Catalog refreshed: version %d, %d packages, %d prices",
+		snapshot.Version, len(snapshot.Packages), len(snapshot.Prices))
+	return nil
+}
+
+func (s *CatalogService) fetchSnapshot(ctx context.Context) (*CatalogSnapshot, error) {
+	packageResp, err := s.nadiaClient.PackageList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch package list: %w", err)
+	}
+	packages := packageResp.Data
+
+	priceResp, err := s.nadiaClient.PriceList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch price list: %w", err)
+	}
+	priceList := priceResp.Data
+
+	prices := make(map[string]models.PriceData, len(priceList))
+	for _, pd := range priceList {
+		prices[pd.PackageCode] = pd
+	}
+
+	byCode := make(map[string]int, len(packages))
+	for i := range packages {
+		cost := upstreamCost(prices[packages[i].PackageCode])
+		packages[i].PackagePrice = cost
+		packages[i].PackagePriceFormatted = utils.FormatRupiah(cost)
+		byCode[packages[i].PackageCode] = i
+	}
+
+	return &CatalogSnapshot{
+		FetchedAt: time.Now(),
+		Packages:  packages,
+		Prices:    prices,
+		byCode:    byCode,
+	}, nil
+}
+
+// Snapshot returns the current catalog snapshot. If no snapshot has been loaded
+// yet it performs a synchronous refresh; otherwise it never blocks on upstream.
+func (s *CatalogService) Snapshot(ctx context.Context) (*CatalogSnapshot, error) {
+	s.mutex.RLock()
+	snapshot := s.snapshot
+	s.mutex.RUnlock()
+
+	if snapshot != nil {
+		return snapshot, nil
+	}
+
+	if err := s.Refresh(ctx); err != nil {
+		// Another caller may have populated the snapshot while we waited.
+		s.mutex.RLock()
+		snapshot = s.snapshot
+		s.mutex.RUnlock()
+		if snapshot != nil {
+			return snapshot, nil
+		}
+		return nil, fmt.Errorf("catalog unavailable: %v", err)
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.snapshot, nil
+}
+
+// Status reports the age, version and health of the current snapshot.
+func (s *CatalogService) Status() models.CatalogStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	status := models.CatalogStatus{
+		LastAttempt: s.lastAttempt,
+		Stale:       s.lastError != nil,
+	}
+	if s.lastError != nil {
+		status.LastError = s.lastError.Error()
+	}
+	if s.snapshot != nil {
+		status.Version = s.snapshot.Version
+		status.PackageCount = len(s.snapshot.Packages)
+		status.PriceCount = len(s.snapshot.Prices)
+		status.FetchedAt = s.snapshot.FetchedAt
+		status.AgeSeconds = time.Since(s.snapshot.FetchedAt).Seconds()
+		if time.Since(s.snapshot.FetchedAt) > 2*s.interval {
+			status.Stale = true
+		}
+	} else {
+		status.Stale = true
+	}
+	return status
+}
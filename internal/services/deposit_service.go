@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/database"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/utils"
+)
+
+const (
+	// minDepositAmount is the smallest top-up a reseller can request.
+	minDepositAmount = 10000
+	// maxUniqueCode bounds the code added to a deposit amount, so the transfer
+	// amount stays close to the requested amount.
+	maxUniqueCode = 999
+)
+
+var (
+	// ErrDepositNotFound is returned when a deposit ID is unknown.
+	ErrDepositNotFound = errors.New("deposit not found")
+	// ErrDepositReviewed is returned when a deposit was already approved or rejected.
+	ErrDepositReviewed = errors.New("deposit was already reviewed")
+)
+
+// DepositService handles reseller top-ups by bank transfer. A reseller
+// requests a deposit and transfers the amount plus a unique code; an admin
+// matches the transfer on the bank statement and approves the deposit, which
+// credits the transfer amount to the reseller wallet.
+type DepositService struct {
+	store           database.Store
+	walletService   *WalletService
+	resellerService *ResellerService
+
+	// mutex serializes unique code allocation and reviews.
+	mutex sync.Mutex
+}
+
+// NewDepositService creates a new DepositService.
+func NewDepositService(store database.Store, ws *WalletService, rs *ResellerService) *DepositService {
+	return &DepositService{store: store, walletService: ws, resellerService: rs}
+}
+
+// Create records a pending deposit with a unique code that no other pending
+// deposit uses for the same transfer amount.
+func (s *DepositService) Create(ctx context.Context, resellerID int64, amount int, proofNote string) (*models.Deposit, error) {
+	if amount < minDepositAmount {
+		return nil, fmt.Errorf("deposit amount must be at least %d", minDepositAmount)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	code, err := s.uniqueCode(ctx, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &models.Deposit{
+		ID:             utils.GenerateDepositID(),
+		ResellerID:     resellerID,
+		Amount:         amount,
+		UniqueCode:     code,
+		TransferAmount: amount + code,
+		ProofNote:      proofNote,
+		Status:         models.DepositPending,
+		CreatedAt:      time.Now(),
+	}
+	if err := s.store.CreateDeposit(ctx, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// uniqueCode picks a random code in [1, maxUniqueCode] whose transfer amount
+// is not expected by another pending deposit.
+func (s *DepositService) uniqueCode(ctx context.Context, amount int) (int, error) {
+	for attempt := 0; attempt < 20; attempt++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(maxUniqueCode))
+		if err != nil {
+			return 0, err
+		}
+		code := int(n.Int64()) + 1
+		taken, err := s.store.PendingTransferAmountExists(ctx, amount+code)
+		if err != nil {
+			return 0, err
+		}
+		if !taken {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("no free unique code for amount %d, try a different amount", amount)
+}
+
+// Get returns a deposit by ID.
+func (s *DepositService) Get(ctx context.Context, id string) (*models.Deposit, error) {
+	d, err := s.store.GetDeposit(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrDepositNotFound
+	}
+	return d, err
+}
+
+// List returns deposits newest first. A zero resellerID or an empty status
+// matches all deposits.
+func (s *DepositService) List(ctx context.Context, resellerID int64, status string, limit int) ([]models.Deposit, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	return s.store.GetDeposits(ctx, resellerID, status, limit)
+}
+
+// Invoices returns deposits in the shape of upstream invoices.
+func (s *DepositService) Invoices(ctx context.Context, resellerID int64, status string, limit int) ([]models.InvoiceRecord, error) {
+	deposits, err := s.List(ctx, resellerID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	resellers := make(map[int64]*models.Reseller)
+	invoices := make([]models.InvoiceRecord, 0, len(deposits))
+	for i := range deposits {
+		d := &deposits[i]
+		r, ok := resellers[d.ResellerID]
+		if !ok {
+			// A deleted reseller leaves the invoice without a name.
+			r, _ = s.resellerService.Get(ctx, d.ResellerID)
+			resellers[d.ResellerID] = r
+		}
+		invoices = append(invoices, d.Invoice(r))
+	}
+	return invoices, nil
+}
+
+// Approve credits the transfer amount of a pending deposit to the reseller
+// wallet and marks the deposit approved. The wallet deposit is referenced by
+// the deposit ID, so a deposit is never credited twice.
+func (s *DepositService) Approve(ctx context.Context, id, note, reviewedBy string) (*models.Deposit, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	d, err := s.pending(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	memo := fmt.Sprintf("Deposit %s (unique code %d)", d.ID, d.UniqueCode)
+	if note != "" {
+		memo += ": " + note
+	}
+	_, err = s.walletService.Deposit(ctx, d.ResellerID, d.TransferAmount, d.ID, memo, reviewedBy)
+	if err != nil && !errors.Is(err, ErrDuplicateReference) {
+		return nil, fmt.Errorf("failed to credit wallet: %w", err)
+	}
+
+	return s.review(ctx, d, models.DepositApproved, note, reviewedBy)
+}
+
+// Reject marks a pending deposit rejected without touching the wallet.
+func (s *DepositService) Reject(ctx context.Context, id, note, reviewedBy string) (*models.Deposit, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	d, err := s.pending(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.review(ctx, d, models.DepositRejected, note, reviewedBy)
+}
+
+func (s *DepositService) pending(ctx context.Context, id string) (*models.Deposit, error) {
+	d, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if d.Status != models.DepositPending {
+		return nil, ErrDepositReviewed
+	}
+	return d, nil
+}
+
+func (s *DepositService) review(ctx context.Context, d *models.Deposit, status, note, reviewedBy string) (*models.Deposit, error) {
+	now := time.Now()
+	if err := s.store.ReviewDeposit(ctx, d.ID, status, note, reviewedBy, now); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDepositReviewed
+		}
+		return nil, err
+	}
+	d.Status = status
+	d.ReviewNote = note
+	d.ReviewedBy = reviewedBy
+	d.ReviewedAt = &now
+	return d, nil
+}
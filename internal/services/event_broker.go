@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/monitoring"
+)
+
+const (
+	// eventHistorySize is how many recent events are kept for clients that
+	// reconnect with Last-Event-ID.
+	eventHistorySize = 256
+	// eventBufferSize is how many events a slow client may lag behind before
+	// its subscription is closed.
+	eventBufferSize = 64
+)
+
+// EventFilter selects the events a stream client receives. Empty sets match
+// everything.
+type EventFilter struct {
+	// Types holds event types or their prefix before the dot, e.g.
+	// "transaction" or "metrics".
+	Types          map[string]bool
+	Statuses       map[string]bool
+	Sources        map[string]bool
+	TransactionIDs map[string]bool
+
+	// ResellerID restricts transaction events to one reseller when OwnOnly is
+	// set. Metrics are never sent to OwnOnly clients.
+	ResellerID int64
+	OwnOnly    bool
+}
+
+// ParseFilterSet splits a comma-separated query value into a set. Values are
+// upper-cased when upper is set, e.g. for transaction statuses.
+func ParseFilterSet(value string, upper bool) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if upper {
+			v = strings.ToUpper(v)
+		}
+		if v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
+
+// Match reports whether an event passes the filter.
+func (f *EventFilter) Match(e *models.StreamEvent) bool {
+	if len(f.Types) > 0 && !f.Types[e.Type] && !f.Types[strings.SplitN(e.Type, ".", 2)[0]] {
+		return false
+	}
+
+	change, ok := e.Data.(models.TransactionChange)
+	if !ok {
+		return !f.OwnOnly
+	}
+	tx := change.Transaction
+	if f.OwnOnly && tx.ResellerID != f.ResellerID {
+		return false
+	}
+	if len(f.Statuses) > 0 && !f.Statuses[tx.Status] {
+		return false
+	}
+	if len(f.Sources) > 0 && !f.Sources[tx.Source] {
+		return false
+	}
+	if len(f.TransactionIDs) > 0 && !f.TransactionIDs[tx.ID] {
+		return false
+	}
+	return true
+}
+
+// EventSubscription receives the events of one stream client. Events is
+// closed when the client falls too far behind or unsubscribes.
+type EventSubscription struct {
+	Events chan models.StreamEvent
+	filter EventFilter
+}
+
+// EventBroker fans transaction changes and periodic metric snapshots out to
+// stream clients. Publishing never blocks: a client that cannot keep up is
+// disconnected and can resume with Last-Event-ID.
+type EventBroker struct {
+	metricsInterval time.Duration
+
+	mutex       sync.Mutex
+	nextID      int64
+	history     []models.StreamEvent
+	subscribers map[*EventSubscription]struct{}
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewEventBroker creates a new EventBroker that publishes a metrics snapshot
+// every metricsInterval while a client wants metrics.
+func NewEventBroker(metricsInterval time.Duration) *EventBroker {
+	if metricsInterval <= 0 {
+		metricsInterval = 5 * time.Second
+	}
+	return &EventBroker{
+		metricsInterval: metricsInterval,
+		subscribers:     make(map[*EventSubscription]struct{}),
+		stopCh:          make(chan struct{}),
+	}
+}
+
+// Start launches the metrics snapshot loop.
+func (b *EventBroker) Start() {
+	go func() {
+		ticker := time.NewTicker(b.metricsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if b.wantsMetrics() {
+					b.Publish(models.StreamMetrics, monitoring.GetPerformanceMetrics())
+				}
+			case <-b.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the metrics snapshot loop.
+func (b *EventBroker) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.stopCh)
+	})
+}
+
+// wantsMetrics reports whether any client would receive a metrics event, so
+// snapshots are not collected for nobody.
+func (b *EventBroker) wantsMetrics() bool {
+	probe := &models.StreamEvent{Type: models.StreamMetrics}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for sub := range b.subscribers {
+		if sub.filter.Match(probe) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe registers a client. Buffered events newer than lastEventID that
+// match the filter are replayed first; pass 0 to only receive new events.
+func (b *EventBroker) Subscribe(filter EventFilter, lastEventID int64) *EventSubscription {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	sub := &EventSubscription{filter: filter}
+	replay := []models.StreamEvent{}
+	if lastEventID > 0 {
+		for i := range b.history {
+			if b.history[i].ID > lastEventID && filter.Match(&b.history[i]) {
+				replay = append(replay, b.history[i])
+			}
+		}
+	}
+	sub.Events = make(chan models.StreamEvent, eventBufferSize+len(replay))
+	for _, e := range replay {
+		sub.Events <- e
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe removes a client and closes its channel.
+func (b *EventBroker) Unsubscribe(sub *EventSubscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.remove(sub)
+}
+
+// remove drops a subscription. The caller must hold mutex.
+func (b *EventBroker) remove(sub *EventSubscription) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.Events)
+	}
+}
+
+// Publish sends an event to every matching client.
+func (b *EventBroker) Publish(eventType string, data interface{}) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.nextID++
+	event := models.StreamEvent{
+		ID:        b.nextID,
+		Type:      eventType,
+		CreatedAt: time.Now(),
+		Data:      data,
+	}
+	if len(b.history) == eventHistorySize {
+		b.history = append(b.history[:0], b.history[1:]...)
+	}
+	b.history = append(b.history, event)
+
+	for sub := range b.subscribers {
+		if !sub.filter.Match(&event) {
+			continue
+		}
+		select {
+		case sub.Events <- event:
+		default:
+			b.remove(sub)
+		}
+	}
+}
+
+// OnTransactionStatus publishes a transaction change. It is meant to be
+// registered with TransactionService.AddStatusListener.
+func (b *EventBroker) OnTransactionStatus(_ context.Context, record models.TransactionRecord, fromStatus string) {
+	eventType := models.StreamTransactionUpdated
+	if fromStatus == "" {
+		eventType = models.StreamTransactionCreated
+	}
+	b.Publish(eventType, models.TransactionChange{FromStatus: fromStatus, Transaction: record})
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/database"
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// ErrOTPSessionNotFound is returned when no OTP session matches a phone and request ID.
+var ErrOTPSessionNotFound = errors.New("OTP session not found. Please request OTP first.")
+
+// OTPSessionStore persists OTP sessions between RequestOTP and VerifyOTP.
+// Sessions belong to the reseller that requested them, 0 for the admin, and
+// are only visible to it. Phone numbers are passed in normalized form. An
+// empty requestID selects the most recent session of a phone in Get and all
+// sessions of a phone in Delete.
+type OTPSessionStore interface {
+	Save(ctx context.Context, session *models.OTPSession) error
+	Get(ctx context.Context, resellerID int64, phone, requestID string) (*models.OTPSession, error)
+	Delete(ctx context.Context, resellerID int64, phone, requestID string) error
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+// MemoryOTPSessionStore keeps OTP sessions in memory. Sessions are lost on restart.
+type MemoryOTPSessionStore struct {
+	mutex    sync.RWMutex
+	sessions map[string]*models.OTPSession
+}
+
+// NewMemoryOTPSessionStore creates an empty in-memory OTP session store.
+func NewMemoryOTPSessionStore() *MemoryOTPSessionStore {
+	return &MemoryOTPSessionStore{sessions: make(map[string]*models.OTPSession)}
+}
+
+// Save stores a session.
+func (s *MemoryOTPSessionStore) Save(_ context.Context, session *models.OTPSession) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored := *session
+	s.sessions[session.RequestID] = &stored
+	return nil
+}
+
+// Get returns a session of a phone by request ID, or its most recent session.
+func (s *MemoryOTPSessionStore) Get(_ context.Context, resellerID int64, phone, requestID string) (*models.OTPSession, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var found *models.OTPSession
+	if requestID != "" {
+		if session, exists := s.sessions[requestID]; exists && session.ResellerID == resellerID && session.PhoneNumber == phone {
+			found = session
+		}
+	} else {
+		for _, session := range s.sessions {
+			if session.ResellerID == resellerID && session.PhoneNumber == phone &&
+				(found == nil || session.CreatedAt.After(found.CreatedAt)) {
+				found = session
+			}
+		}
+	}
+
+	if found == nil {
+		return nil, ErrOTPSessionNotFound
+	}
+	session := *found
+	return &session, nil
+}
+
+// Delete removes one session of a phone, or all of them.
+func (s *MemoryOTPSessionStore) Delete(_ context.Context, resellerID int64, phone, requestID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, session := range s.sessions {
+		if session.ResellerID == resellerID && session.PhoneNumber == phone && (requestID == "" || id == requestID) {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+// DeleteExpired removes sessions that expired before now.
+func (s *MemoryOTPSessionStore) DeleteExpired(_ context.Context, now time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := 0
+	for id, session := range s.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(s.sessions, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// DatabaseOTPSessionStore keeps OTP sessions in the otp_sessions table so that
+// they survive restarts between RequestOTP and VerifyOTP.
+type DatabaseOTPSessionStore struct {
+	store database.Store
+}
+
+// NewDatabaseOTPSessionStore creates an OTP session store backed by the given database.
+func NewDatabaseOTPSessionStore(store database.Store) *DatabaseOTPSessionStore {
+	return &DatabaseOTPSessionStore{store: store}
+}
+
+// Save stores a session.
+func (s *DatabaseOTPSessionStore) Save(ctx context.Context, session *models.OTPSession) error {
+	return s.store.SaveOTPSession(ctx, session)
+}
+
+// Get returns a session of a phone by request ID, or its most recent session.
+func (s *DatabaseOTPSessionStore) Get(ctx context.Context, resellerID int64, phone, requestID string) (*models.OTPSession, error) {
+	session, err := s.store.GetOTPSession(ctx, resellerID, phone, requestID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOTPSessionNotFound
+	}
+	return session, err
+}
+
+// Delete removes one session of a phone, or all of them.
+func (s *DatabaseOTPSessionStore) Delete(ctx context.Context, resellerID int64, phone, requestID string) error {
+	return s.store.DeleteOTPSessions(ctx, resellerID, phone, requestID)
+}
+
+// DeleteExpired removes sessions that expired before now.
+func (s *DatabaseOTPSessionStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	n, err := s.store.DeleteExpiredOTPSessions(ctx, now)
+	return int(n), err
+}
+
+// OTPSessionJanitor periodically removes expired sessions from an OTPSessionStore.
+type OTPSessionJanitor struct {
+	store    OTPSessionStore
+	interval time.Duration
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewOTPSessionJanitor creates a janitor that cleans the store at the given interval.
+func NewOTPSessionJanitor(store OTPSessionStore, interval time.Duration) *OTPSessionJanitor {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &OTPSessionJanitor{
+		store:    store,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start launches the background cleanup loop.
+func (j *OTPSessionJanitor) Start() {
+	go func() {
+		ctx := context.Background()
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if n, err := j.store.DeleteExpired(ctx, time.Now()); err != nil {
+					log.Printf("Failed to delete expired OTP sessions: %v", err)
+				} else if n > 0 {
+					log.Printf("Deleted %d expired OTP sessions", n)
+				}
+			case <-j.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the background cleanup loop.
+func (j *OTPSessionJanitor) Stop() {
+	j.stopOnce.Do(func() {
+		close(j.stopCh)
+	})
+}
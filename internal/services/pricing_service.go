@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/nabilulilalbab/nadia/internal/database"
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// ErrNoUpstreamPrice is returned when a package has no cost in the upstream
+// price list, so any markup on it would sell it below cost.
+var ErrNoUpstreamPrice = errors.New("package has no upstream price")
+
+// PricingService resolves selling prices per tier from rules stored in the database.
+// Rules are cached in memory and reloaded whenever they are edited.
+type PricingService struct {
+	store database.Store
+	mutex sync.RWMutex
+	tiers map[string]models.PriceTier
+	rules map[string][]models.PriceRule
+}
+
+// NewPricingService creates a new PricingService and loads its rules.
+func NewPricingService(store database.Store) (*PricingService, error) {
+	ps := &PricingService{store: store}
+	if err := ps.Reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// Reload re-reads all tiers and rules from the database.
+func (s *PricingService) Reload(ctx context.Context) error {
+	tiers, err := s.store.GetPriceTiers(ctx)
+	if err != nil {
+		return err
+	}
+	rules, err := s.store.GetPriceRules(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	tierMap := make(map[string]models.PriceTier, len(tiers))
+	for _, t := range tiers {
+		tierMap[t.Name] = t
+	}
+	ruleMap := make(map[string][]models.PriceRule)
+	for _, r := range rules {
+		ruleMap[r.Tier] = append(ruleMap[r.Tier], r)
+	}
+
+	s.mutex.Lock()
+	s.tiers = tierMap
+	s.rules = ruleMap
+	s.mutex.Unlock()
+	return nil
+}
+
+// Tiers returns all configured tiers.
+func (s *PricingService) Tiers(ctx context.Context) ([]models.PriceTier, error) {
+	return s.store.GetPriceTiers(ctx)
+}
+
+// HasTier reports whether a tier with the given name exists.
+func (s *PricingService) HasTier(name string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.tiers[name]
+	return ok
+}
+
+// Rules returns the rules of a tier, or of all tiers when tier is empty.
+func (s *PricingService) Rules(ctx context.Context, tier string) ([]models.PriceRule, error) {
+	return s.store.GetPriceRules(ctx, tier)
+}
+
+// SaveTier creates or updates a tier.
+func (s *PricingService) SaveTier(ctx context.Context, tier *models.PriceTier) error {
+	tier.Name = strings.ToLower(strings.TrimSpace(tier.Name))
+	if tier.Name == "" {
+		return fmt.Errorf("tier name is required")
+	}
+	if err := s.store.SavePriceTier(ctx, tier); err != nil {
+		return err
+	}
+	return s.Reload(ctx)
+}
+
+// DeleteTier removes a tier and its rules. Built-in tiers and tiers assigned
+// to resellers cannot be deleted; the latter yield database.ErrTierInUse.
+func (s *PricingService) DeleteTier(ctx context.Context, name string) error {
+	if name == models.PriceTierUser || name == models.PriceTierReseller {
+		return fmt.Errorf("built-in tier %q cannot be deleted", name)
+	}
+	if err := s.store.DeletePriceTier(ctx, name); err != nil {
+		return err
+	}
+	return s.Reload(ctx)
+}
+
+// SaveRule validates and stores a rule, replacing any rule with the same tier, scope and target.
+func (s *PricingService) SaveRule(ctx context.Context, rule *models.PriceRule) error {
+	if err := s.validateRule(rule); err != nil {
+		return err
+	}
+	if err := s.store.SavePriceRule(ctx, rule); err != nil {
+		return err
+	}
+	return s.Reload(ctx)
+}
+
+// DeleteRule removes a rule by ID.
+func (s *PricingService) DeleteRule(ctx context.Context, id int64) error {
+	if err := s.store.DeletePriceRule(ctx, id); err != nil {
+		return err
+	}
+	return s.Reload(ctx)
+}
+
+func (s *PricingService) validateRule(rule *models.PriceRule) error {
+	rule.Tier = strings.ToLower(strings.TrimSpace(rule.Tier))
+	rule.Scope = strings.ToLower(strings.TrimSpace(rule.Scope))
+	rule.MarkupType = strings.ToLower(strings.TrimSpace(rule.MarkupType))
+	rule.Target = strings.TrimSpace(rule.Target)
+	if rule.BaseField == "" {
+		rule.BaseField = "price"
+	}
+
+	if !s.HasTier(rule.Tier) {
+		return fmt.Errorf("unknown tier %q", rule.Tier)
+	}
+
+	switch rule.Scope {
+	case models.PriceScopeDefault:
+		rule.Target = ""
+	case models.PriceScopeCategory:
+		rule.Target = strings.ToLower(rule.Target)
+		switch rule.Target {
+		case models.CategoryAkrab, models.CategoryCircle, models.CategoryEWallet, models.CategoryPulsa:
+		default:
+			return fmt.Errorf("unknown category %q (expected akrab, circle, ewallet or pulsa)", rule.Target)
+		}
+	case models.PriceScopePackage:
+		if rule.Target == "" {
+			return fmt.Errorf("package scope requires a package code target")
+		}
+	default:
+		return fmt.Errorf("unknown scope %q (expected default, category or package)", rule.Scope)
+	}
+
+	switch rule.BaseField {
+	case "price", "base_price", "reseller_price", "member_price":
+	default:
+		return fmt.Errorf("unknown base field %q", rule.BaseField)
+	}
+
+	switch rule.MarkupType {
+	case "flat", "percent":
+	default:
+		return fmt.Errorf("unknown markup type %q (expected flat or percent)", rule.MarkupType)
+	}
+
+	if rule.RoundTo < 0 || rule.MinPrice < 0 || rule.MaxPrice < 0 {
+		return fmt.Errorf("round_to, min_price and max_price must not be negative")
+	}
+	if rule.MaxPrice > 0 && rule.MinPrice > rule.MaxPrice {
+		return fmt.Errorf("min_price must not exceed max_price")
+	}
+	return nil
+}
+
+// PackageCategories returns the categories a package belongs to, most specific first.
+func PackageCategories(pkg models.Package) []string {
+	var categories []string
+	if pkg.IsAkrab {
+		categories = append(categories, models.CategoryAkrab)
+	}
+	if pkg.IsCircle {
+		categories = append(categories, models.CategoryCircle)
+	}
+
+	name := strings.ToLower(pkg.PackageName)
+	switch {
+	case strings.Contains(name, "e-wallet"):
+		categories = append(categories, models.CategoryEWallet)
+	case strings.Contains(name, "pulsa"):
+		categories = append(categories, models.CategoryPulsa)
+	default:
+		for _, pm := range pkg.AvailablePaymentMethods {
+			if strings.EqualFold(pm.PaymentMethod, "BALANCE") {
+				categories = append(categories, models.CategoryPulsa)
+				break
+			}
+		}
+	}
+	return categories
+}
+
+// Quote resolves the price of a package for a tier. Package rules take
+// precedence over category rules, which take precedence over the default rule.
+// Packages without an upstream cost yield ErrNoUpstreamPrice.
+func (s *PricingService) Quote(tier string, pkg models.Package, pd models.PriceData) (models.PriceQuote, error) {
+	s.mutex.RLock()
+	_, tierExists := s.tiers[tier]
+	rules := s.rules[tier]
+	s.mutex.RUnlock()
+
+	if !tierExists {
+		return models.PriceQuote{}, fmt.Errorf("unknown price tier %q", tier)
+	}
+
+	quote := models.PriceQuote{
+		Tier:        tier,
+		PackageCode: pkg.PackageCode,
+		Cost:        upstreamCost(pd),
+	}
+	if quote.Cost <= 0 {
+		return models.PriceQuote{}, fmt.Errorf("%w: %s", ErrNoUpstreamPrice, pkg.PackageCode)
+	}
+
+	rule, ok := matchRule(rules, pkg)
+	if !ok {
+		// No rule configured: sell at cost.
+		quote.Price = quote.Cost
+		return quote, nil
+	}
+
+	quote.RuleID = rule.ID
+	quote.RuleScope = rule.Scope
+	quote.Price = applyRule(rule, pd)
+	return quote, nil
+}
+
+// Price is a convenience wrapper around Quote returning only the price.
+// Unknown tiers and packages without an upstream cost yield an error rather
+// than a price.
+func (s *PricingService) Price(tier string, pkg models.Package, pd models.PriceData) (int, error) {
+	quote, err := s.Quote(tier, pkg, pd)
+	if err != nil {
+		return 0, err
+	}
+	return quote.Price, nil
+}
+
+func matchRule(rules []models.PriceRule, pkg models.Package) (models.PriceRule, bool) {
+	var def, pkgRule *models.PriceRule
+	categoryRules := make(map[string]*models.PriceRule)
+
+	for i := range rules {
+		r := &rules[i]
+		switch r.Scope {
+		case models.PriceScopePackage:
+			if r.Target == pkg.PackageCode {
+				pkgRule = r
+			}
+		case models.PriceScopeCategory:
+			categoryRules[r.Target] = r
+		case models.PriceScopeDefault:
+			def = r
+		}
+	}
+
+	if pkgRule != nil {
+		return *pkgRule, true
+	}
+	for _, category := range PackageCategories(pkg) {
+		if r, ok := categoryRules[category]; ok {
+			return *r, true
+		}
+	}
+	if def != nil {
+		return *def, true
+	}
+	return models.PriceRule{}, false
+}
+
+func applyRule(rule models.PriceRule, pd models.PriceData) int {
+	base := upstreamCost(pd)
+	switch rule.BaseField {
+	case "base_price":
+		if pd.BasePrice > 0 {
+			base = pd.BasePrice
+		}
+	case "reseller_price":
+		if pd.ResellerPrice > 0 {
+			base = pd.ResellerPrice
+		}
+	case "member_price":
+		if pd.MemberPrice > 0 {
+			base = pd.MemberPrice
+		}
+	}
+
+	price := float64(base)
+	switch rule.MarkupType {
+	case "flat":
+		price += rule.MarkupValue
+	case "percent":
+		price += float64(base) * rule.MarkupValue / 100
+	}
+
+	if rule.RoundTo > 0 {
+		price = math.Round(price/float64(rule.RoundTo)) * float64(rule.RoundTo)
+	}
+
+	result := int(math.Round(price))
+	if rule.MinPrice > 0 && result < rule.MinPrice {
+		result = rule.MinPrice
+	}
+	if rule.MaxPrice > 0 && result > rule.MaxPrice {
+		result = rule.MaxPrice
+	}
+	return result
+}
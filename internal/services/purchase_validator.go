@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/nadia"
+	"github.com/nabilulilalbab/nadia/internal/utils"
+)
+
+// PurchaseValidationError describes why a purchase was rejected before it was
+// recorded. Code is one of the models.ErrCode* constants.
+type PurchaseValidationError struct {
+	Code    string
+	Message string
+	Details map[string]interface{}
+}
+
+func (e *PurchaseValidationError) Error() string {
+	return e.Message
+}
+
+// PurchaseValidator checks a purchase against the package rules published in
+// the catalog, so that requests upstream would reject are never sent.
+type PurchaseValidator struct {
+	nadiaClient        *nadia.Client
+	transactionService *TransactionService
+	now                func() time.Time
+}
+
+// NewPurchaseValidator creates a new PurchaseValidator.
+func NewPurchaseValidator(nc *nadia.Client, ts *TransactionService) *PurchaseValidator {
+	return &PurchaseValidator{
+		nadiaClient:        nc,
+		transactionService: ts,
+		now:                time.Now,
+	}
+}
+
+// Validate returns a *PurchaseValidationError if the package cannot be bought
+// right now with the given payment method. Checks that need no upstream call
+// run first; the stock check runs last and only for packages that need it.
+func (v *PurchaseValidator) Validate(ctx context.Context, snapshot *CatalogSnapshot, packageCode, paymentMethod string) error {
+	pkg, ok := snapshot.Package(packageCode)
+	if !ok {
+		return &PurchaseValidationError{
+			Code:    models.ErrCodePackageNotFound,
+			Message: fmt.Sprintf("Package %s not found", packageCode),
+		}
+	}
+
+	if pkg.SedangGangguan {
+		return &PurchaseValidationError{
+			Code:    models.ErrCodePackageDisrupted,
+			Message: fmt.Sprintf("Package %s is currently disrupted (sedang gangguan)", packageCode),
+		}
+	}
+
+	if err := checkPaymentMethod(pkg, paymentMethod); err != nil {
+		return err
+	}
+
+	now := v.now().In(utils.WIB)
+	if err := checkCutOffTime(pkg, now); err != nil {
+		return err
+	}
+
+	if err := v.checkDailyLimit(ctx, snapshot, pkg, now); err != nil {
+		return err
+	}
+
+	if pkg.NeedCheckStock {
+		resp, err := v.nadiaClient.CheckPackageStock(ctx, packageCode)
+		if err != nil {
+			return &PurchaseValidationError{
+				Code:    models.ErrCodeStockCheckFailed,
+				Message: "Failed to check package stock: " + err.Error(),
+			}
+		}
+		if stock := resp.Data.Stok; stock <= 0 {
+			return &PurchaseValidationError{
+				Code:    models.ErrCodeOutOfStock,
+				Message: fmt.Sprintf("Package %s is out of stock", packageCode),
+				Details: map[string]interface{}{"stock": stock},
+			}
+		}
+	}
+
+	return nil
+}
+
+func checkPaymentMethod(pkg models.Package, paymentMethod string) error {
+	if len(pkg.AvailablePaymentMethods) == 0 {
+		return nil
+	}
+
+	available := make([]string, 0, len(pkg.AvailablePaymentMethods))
+	for _, pm := range pkg.AvailablePaymentMethods {
+		if strings.EqualFold(pm.PaymentMethod, paymentMethod) {
+			return nil
+		}
+		available = append(available, pm.PaymentMethod)
+	}
+
+	return &PurchaseValidationError{
+		Code:    models.ErrCodePaymentMethodInvalid,
+		Message: fmt.Sprintf("Payment method %s is not available for package %s", paymentMethod, pkg.PackageCode),
+		Details: map[string]interface{}{"available_payment_methods": available},
+	}
+}
+
+// checkCutOffTime rejects purchases inside the prohibited hour window. The
+// window may wrap midnight, e.g. 22:00-02:00.
+func checkCutOffTime(pkg models.Package, now time.Time) error {
+	if !pkg.HaveCutOffTime {
+		return nil
+	}
+
+	start, errStart := time.Parse("15:04", pkg.CutOffTime.ProhibitedHourStarttime)
+	end, errEnd := time.Parse("15:04", pkg.CutOffTime.ProhibitedHourEndtime)
+	if errStart != nil || errEnd != nil {
+		log.Printf("Ignoring invalid cut-off time %q-%q for package %s",
+			pkg.CutOffTime.ProhibitedHourStarttime, pkg.CutOffTime.ProhibitedHourEndtime, pkg.PackageCode)
+		return nil
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	var inWindow bool
+	if startMinute <= endMinute {
+		inWindow = minute >= startMinute && minute < endMinute
+	} else {
+		inWindow = minute >= startMinute || minute < endMinute
+	}
+	if !inWindow {
+		return nil
+	}
+
+	return &PurchaseValidationError{
+		Code: models.ErrCodeCutOffTime,
+		Message: fmt.Sprintf("Package %s cannot be purchased between %s and %s WIB",
+			pkg.PackageCode, pkg.CutOffTime.ProhibitedHourStarttime, pkg.CutOffTime.ProhibitedHourEndtime),
+		Details: map[string]interface{}{
+			"prohibited_hour_starttime": pkg.CutOffTime.ProhibitedHourStarttime,
+			"prohibited_hour_endtime":   pkg.CutOffTime.ProhibitedHourEndtime,
+			"timezone":                  "WIB",
+		},
+	}
+}
+
+// checkDailyLimit compares the daily limit against the count reported in the
+// catalog plus our own purchases made since the catalog was fetched. Counts
+// from a catalog fetched before midnight WIB are ignored since upstream resets them.
+func (v *PurchaseValidator) checkDailyLimit(ctx context.Context, snapshot *CatalogSnapshot, pkg models.Package, now time.Time) error {
+	if !pkg.HaveDailyLimit || pkg.DailyLimitDetails.MaxDailyTransactionLimit <= 0 {
+		return nil
+	}
+
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, utils.WIB)
+	since := snapshot.FetchedAt
+	count := pkg.DailyLimitDetails.CurrentDailyTransactionCount
+	if since.Before(startOfDay) {
+		since = startOfDay
+		count = 0
+	}
+
+	local, err := v.transactionService.CountPackagePurchasesSince(ctx, pkg.PackageCode, since)
+	if err != nil {
+		log.Printf("Failed to count purchases of %s for daily limit: %v", pkg.PackageCode, err)
+	}
+	count += local
+
+	limit := pkg.DailyLimitDetails.MaxDailyTransactionLimit
+	if count < limit {
+		return nil
+	}
+
+	return &PurchaseValidationError{
+		Code:    models.ErrCodeDailyLimitReached,
+		Message: fmt.Sprintf("Daily limit of %d transactions for package %s has been reached", limit, pkg.PackageCode),
+		Details: map[string]interface{}{
+			"max_daily_transaction_limit":     limit,
+			"current_daily_transaction_count": count,
+		},
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/database"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/utils"
+)
+
+// QuoteService issues purchase quotes that lock a tier price for a short
+// window, so a reseller can show a price and then buy at exactly that price.
+type QuoteService struct {
+	store          database.Store
+	catalogService *CatalogService
+	pricingService *PricingService
+	ttl            time.Duration
+}
+
+// NewQuoteService creates a new QuoteService whose quotes are valid for ttl.
+func NewQuoteService(store database.Store, cs *CatalogService, ps *PricingService, ttl time.Duration) *QuoteService {
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	return &QuoteService{
+		store:          store,
+		catalogService: cs,
+		pricingService: ps,
+		ttl:            ttl,
+	}
+}
+
+// CreateQuote prices a package for a tier from the current catalog snapshot and
+// stores the result for the reseller, 0 for admin callers, that may redeem it.
+// Unknown packages and payment methods are rejected with a
+// *PurchaseValidationError.
+func (s *QuoteService) CreateQuote(ctx context.Context, resellerID int64, tier, packageCode, paymentMethod string) (*models.PurchaseQuote, error) {
+	snapshot, err := s.catalogService.Snapshot(ctx)
+	if err != nil {
+		return nil, &PurchaseValidationError{Code: models.ErrCodeCatalogUnavailable, Message: err.Error()}
+	}
+
+	pkg, ok := snapshot.Package(packageCode)
+	if !ok {
+		return nil, &PurchaseValidationError{
+			Code:    models.ErrCodePackageNotFound,
+			Message: fmt.Sprintf("Package %s not found", packageCode),
+		}
+	}
+	if err := checkPaymentMethod(pkg, paymentMethod); err != nil {
+		return nil, err
+	}
+
+	priceQuote, err := s.pricingService.Quote(tier, pkg, snapshot.Prices[packageCode])
+	if errors.Is(err, ErrNoUpstreamPrice) {
+		return nil, &PurchaseValidationError{Code: models.ErrCodePriceUnavailable, Message: err.Error()}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	quote := &models.PurchaseQuote{
+		ID:             utils.GenerateQuoteID(),
+		ResellerID:     resellerID,
+		Tier:           tier,
+		PackageCode:    packageCode,
+		PackageName:    pkg.PackageName,
+		PaymentMethod:  strings.ToUpper(paymentMethod),
+		Price:          priceQuote.Price,
+		Cost:           priceQuote.Cost,
+		CatalogVersion: snapshot.Version,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(s.ttl),
+	}
+	if err := s.store.SaveQuote(ctx, quote); err != nil {
+		return nil, fmt.Errorf("failed to save quote: %v", err)
+	}
+
+	if n, err := s.store.DeleteExpiredQuotes(ctx, now.Add(-24*time.Hour)); err != nil {
+		log.Printf("Failed to delete expired quotes: %v", err)
+	} else if n > 0 {
+		log.Printf("Deleted %d expired quotes", n)
+	}
+
+	return quote, nil
+}
+
+// CheckQuote returns a quote that the reseller can still redeem for the given
+// package and payment method, or a *PurchaseValidationError explaining why
+// not. Quotes of other callers are reported as not found.
+func (s *QuoteService) CheckQuote(ctx context.Context, resellerID int64, id, packageCode, paymentMethod string) (*models.PurchaseQuote, error) {
+	quote, err := s.store.GetQuote(ctx, id)
+	if err == nil && quote.ResellerID != resellerID {
+		err = sql.ErrNoRows
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &PurchaseValidationError{
+			Code:    models.ErrCodeQuoteNotFound,
+			Message: fmt.Sprintf("Quote %s not found", id),
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if quote.UsedAt != nil {
+		return nil, &PurchaseValidationError{
+			Code:    models.ErrCodeQuoteUsed,
+			Message: fmt.Sprintf("Quote %s was already used", id),
+			Details: map[string]interface{}{"transaction_id": quote.TransactionID},
+		}
+	}
+	if time.Now().After(quote.ExpiresAt) {
+		return nil, &PurchaseValidationError{
+			Code:    models.ErrCodeQuoteExpired,
+			Message: fmt.Sprintf("Quote %s expired at %s", id, quote.ExpiresAt.Format(time.RFC3339)),
+			Details: map[string]interface{}{"expires_at": quote.ExpiresAt},
+		}
+	}
+	if quote.PackageCode != packageCode || !strings.EqualFold(quote.PaymentMethod, paymentMethod) {
+		return nil, &PurchaseValidationError{
+			Code:    models.ErrCodeQuoteMismatch,
+			Message: fmt.Sprintf("Quote %s is for %s paid with %s", id, quote.PackageCode, quote.PaymentMethod),
+			Details: map[string]interface{}{
+				"package_code":   quote.PackageCode,
+				"payment_method": quote.PaymentMethod,
+			},
+		}
+	}
+
+	return quote, nil
+}
+
+// RedeemQuote binds a quote to the transaction that uses it. A quote can only
+// be redeemed once; a second attempt yields a *PurchaseValidationError.
+func (s *QuoteService) RedeemQuote(ctx context.Context, id, transactionID string) error {
+	err := s.store.RedeemQuote(ctx, id, transactionID, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return &PurchaseValidationError{
+			Code:    models.ErrCodeQuoteUsed,
+			Message: fmt.Sprintf("Quote %s was already used", id),
+		}
+	}
+	return err
+}
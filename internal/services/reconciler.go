@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/nadia"
+	"github.com/nabilulilalbab/nadia/internal/tracing"
+)
+
+// paymentExpiryGrace is how long after its payment window an unpaid purchase
+// is expired without asking upstream, when upstream cannot be reached. It
+// leaves time for a payment made at the last moment to be reported.
+const paymentExpiryGrace = 10 * time.Minute
+
+// staleCheckInterval is how often transactions older than maxAge are polled.
+const staleCheckInterval = time.Hour
+
+// Reconciler periodically polls check-transaction.json for transactions that
+// have an upstream trx_id but no final status yet, and applies the result.
+// Unpaid QRIS transactions are expired once their payment window has passed,
+// and unpaid transactions older than maxAge in any case, so that their wallet
+// holds are released even while upstream is down. Transactions still being
+// processed after maxAge are polled hourly and logged until they settle.
+// Purchases sent upstream by a request that was cut off are moved to
+// PROCESSING, keeping their wallet hold.
+type Reconciler struct {
+	nadiaClient        *nadia.Client
+	transactionService *TransactionService
+	interval           time.Duration
+	maxAge             time.Duration
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewReconciler creates a new Reconciler. Transactions older than maxAge are
+// polled hourly instead of every interval.
+func NewReconciler(nc *nadia.Client, ts *TransactionService, interval, maxAge time.Duration) *Reconciler {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	if maxAge <= 0 {
+		maxAge = 48 * time.Hour
+	}
+	return &Reconciler{
+		nadiaClient:        nc,
+		transactionService: ts,
+		interval:           interval,
+		maxAge:             maxAge,
+		stopCh:             make(chan struct{}),
+	}
+}
+
+// Start launches the background reconciliation loop.
+func (r *Reconciler) Start() {
+	go func() {
+		ctx := context.Background()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.RunOnce(ctx)
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the background reconciliation loop.
+func (r *Reconciler) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+	})
+}
+
+// RunOnce reconciles every unsettled transaction once.
+func (r *Reconciler) RunOnce(ctx context.Context) {
+	ctx, span := tracing.Start(ctx, "Reconciler.RunOnce")
+	defer span.End()
+
+	r.takeOverAbandoned(ctx)
+
+	pending, err := r.transactionService.GetUnsettledTransactions(ctx, time.Time{})
+	if err != nil {
+		log.Printf("Reconciler: failed to load unsettled transactions: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, tx := range pending {
+		select {
+		case <-r.stopCh:
+			return
+		default:
+		}
+
+		if r.stale(tx, now) && tx.Status == models.TxStatusProcessing {
+			if tx.LastCheckedAt != nil && now.Sub(*tx.LastCheckedAt) < staleCheckInterval {
+				continue
+			}
+			log.Printf("Reconciler: transaction %s (trx_id %s) is still %s after %s, check it upstream",
+				tx.ID, tx.TrxID, tx.Status, now.Sub(tx.CreatedAt).Round(time.Minute))
+		}
+		if err := r.Reconcile(ctx, tx); err != nil {
+			log.Printf("Reconciler: transaction %s (trx_id %s): %v", tx.ID, tx.TrxID, err)
+		}
+	}
+}
+
+// takeOverAbandoned moves purchases that were sent upstream by a request that
+// never recorded their outcome to PROCESSING. They may have been bought, so
+// their wallet hold is kept; without a trx_id they cannot be polled and are
+// logged to be checked by hand and settled with POST /api/transactions/{id}/resolve.
+func (r *Reconciler) takeOverAbandoned(ctx context.Context) {
+	abandoned, err := r.transactionService.GetAbandonedSubmissions(ctx, time.Now())
+	if err != nil {
+		log.Printf("Reconciler: failed to load abandoned transactions: %v", err)
+		return
+	}
+	for _, tx := range abandoned {
+		log.Printf("Reconciler: transaction %s was sent upstream at %s but has no outcome, check it upstream and resolve it",
+			tx.ID, tx.SubmittedAt.Format(time.RFC3339))
+		if err := r.transactionService.ApplyReconciliation(ctx, tx.ID, models.TxStatusProcessing, 0,
+			"request abandoned after the purchase was sent upstream", nil); err != nil {
+			log.Printf("Reconciler: transaction %s: %v", tx.ID, err)
+		}
+	}
+}
+
+// Reconcile checks one transaction upstream and applies the mapped status.
+func (r *Reconciler) Reconcile(ctx context.Context, tx *models.TransactionRecord) (err error) {
+	ctx, span := tracing.Start(ctx, "Reconciler.Reconcile",
+		tracing.AttrTransactionID.String(tx.ID), tracing.AttrPackageCode.String(tx.PackageCode))
+	defer func() { tracing.End(span, err) }()
+
+	resp, err := r.nadiaClient.CheckTransaction(ctx, tx.TrxID)
+	if err != nil {
+		if r.paymentExpired(tx, time.Now().Add(-paymentExpiryGrace)) {
+			log.Printf("Reconciler: expiring transaction %s without upstream status: %v", tx.ID, err)
+			return r.transactionService.ApplyReconciliation(ctx, tx.ID, models.TxStatusExpired, 0, "Payment window expired", nil)
+		}
+		r.transactionService.MarkTransactionChecked(ctx, tx.ID)
+		return err
+	}
+	data := &resp.Data
+
+	status := mapUpstreamStatus(tx.Status, data)
+	message := data.RCMessage
+	if status == models.TxStatusRefunded && data.RefundReason != "" {
+		message = data.RefundReason
+	}
+
+	// Upstream keeps an unpaid QRIS/e-wallet purchase in progress indefinitely;
+	// once its payment window has passed it can no longer be paid.
+	if status == models.TxStatusPendingPayment && r.paymentExpired(tx, time.Now()) {
+		status = models.TxStatusExpired
+		message = "Payment window expired"
+	}
+
+	return r.transactionService.ApplyReconciliation(ctx, tx.ID, status, data.RefundAmount, message, data)
+}
+
+// stale reports whether a transaction is older than maxAge.
+func (r *Reconciler) stale(tx *models.TransactionRecord, now time.Time) bool {
+	return now.Sub(tx.CreatedAt) > r.maxAge
+}
+
+// paymentExpired reports whether an unpaid transaction can no longer be paid
+// at now: its payment window has passed, or it has none and is stale.
+func (r *Reconciler) paymentExpired(tx *models.TransactionRecord, now time.Time) bool {
+	if tx.Status != models.TxStatusPendingPayment {
+		return false
+	}
+	if tx.PaymentExpiredAt != nil {
+		return now.After(*tx.PaymentExpiredAt)
+	}
+	return r.stale(tx, now)
+}
+
+// mapUpstreamStatus maps check-transaction.json fields onto our status.
+// Upstream status 0 means still in progress, 1 success, 2 failed; a refund
+// flag overrides everything else.
+func mapUpstreamStatus(current string, data *models.TransactionCheckData) string {
+	if data.IsRefunded == 1 || data.HasParsialRefund {
+		return models.TxStatusRefunded
+	}
+
+	switch data.Status {
+	case 1:
+		return models.TxStatusSuccess
+	case 2:
+		return models.TxStatusFailed
+	case 0:
+		// An unpaid QRIS/e-wallet transaction stays in PENDING_PAYMENT until
+		// upstream reports an outcome; everything else is being processed.
+		if current == models.TxStatusPendingPayment {
+			return current
+		}
+		return models.TxStatusProcessing
+	default:
+		log.Printf("Reconciler: unknown upstream status %d for trx_id %s", data.Status, data.TrxID)
+		return current
+	}
+}
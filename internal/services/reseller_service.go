@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/database"
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// resellerKeyPrefix marks reseller API keys so they are recognisable in logs and configs.
+const resellerKeyPrefix = "nrk_"
+
+var (
+	// ErrResellerNotFound is returned when a reseller ID or API key is unknown.
+	ErrResellerNotFound = errors.New("reseller not found")
+	// ErrResellerSuspended is returned when a suspended reseller authenticates.
+	ErrResellerSuspended = errors.New("reseller account is suspended")
+)
+
+// ResellerService manages reseller accounts and authenticates their API keys.
+// Keys are random and high-entropy, so a plain SHA-256 hash is enough to store them.
+type ResellerService struct {
+	store          database.Store
+	pricingService *PricingService
+}
+
+// NewResellerService creates a new ResellerService.
+func NewResellerService(store database.Store, ps *PricingService) *ResellerService {
+	return &ResellerService{store: store, pricingService: ps}
+}
+
+// List returns all resellers.
+func (s *ResellerService) List(ctx context.Context) ([]models.Reseller, error) {
+	return s.store.GetResellers(ctx)
+}
+
+// Get returns a reseller by ID.
+func (s *ResellerService) Get(ctx context.Context, id int64) (*models.Reseller, error) {
+	r, err := s.store.GetReseller(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrResellerNotFound
+	}
+	return r, err
+}
+
+// Create adds a reseller and returns it together with its new API key.
+func (s *ResellerService) Create(ctx context.Context, req models.ResellerRequest) (*models.ResellerKeyResponse, error) {
+	now := time.Now()
+	r := &models.Reseller{
+		Name:      strings.TrimSpace(req.Name),
+		Tier:      models.PriceTierReseller,
+		Scopes:    models.AllScopes,
+		Status:    models.ResellerStatusActive,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.apply(r, req); err != nil {
+		return nil, err
+	}
+
+	key, err := newResellerKey(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.CreateReseller(ctx, r); err != nil {
+		return nil, err
+	}
+	return &models.ResellerKeyResponse{Reseller: r, APIKey: key}, nil
+}
+
+// Update changes the name, tier, scopes or status of a reseller.
+func (s *ResellerService) Update(ctx context.Context, id int64, req models.ResellerRequest) (*models.Reseller, error) {
+	r, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if name := strings.TrimSpace(req.Name); name != "" {
+		r.Name = name
+	}
+	if err := s.apply(r, req); err != nil {
+		return nil, err
+	}
+
+	r.UpdatedAt = time.Now()
+	if err := s.store.UpdateReseller(ctx, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// RotateKey issues a new API key for a reseller, invalidating the old one.
+// Use AuthService.RotateResellerKey to also revoke its refresh tokens.
+func (s *ResellerService) RotateKey(ctx context.Context, id int64) (*models.ResellerKeyResponse, error) {
+	r, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	key, err := newResellerKey(r)
+	if err != nil {
+		return nil, err
+	}
+
+	r.UpdatedAt = time.Now()
+	if err := s.store.UpdateReseller(ctx, r); err != nil {
+		return nil, err
+	}
+	return &models.ResellerKeyResponse{Reseller: r, APIKey: key}, nil
+}
+
+// Authenticate resolves an API key to its reseller. It returns
+// ErrResellerNotFound for unknown keys and ErrResellerSuspended for
+// suspended accounts.
+func (s *ResellerService) Authenticate(ctx context.Context, key string) (*models.Reseller, error) {
+	if !strings.HasPrefix(key, resellerKeyPrefix) {
+		return nil, ErrResellerNotFound
+	}
+	r, err := s.store.GetResellerByKeyHash(ctx, hashSecret(key))
+	if err == sql.ErrNoRows {
+		return nil, ErrResellerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if r.Status != models.ResellerStatusActive {
+		return nil, ErrResellerSuspended
+	}
+	return r, nil
+}
+
+// apply validates and copies the tier, scopes and status of req onto r.
+func (s *ResellerService) apply(r *models.Reseller, req models.ResellerRequest) error {
+	if r.Name == "" {
+		return fmt.Errorf("reseller name is required")
+	}
+
+	if tier := strings.ToLower(strings.TrimSpace(req.Tier)); tier != "" {
+		if !s.pricingService.HasTier(tier) {
+			return fmt.Errorf("unknown price tier %q", tier)
+		}
+		r.Tier = tier
+	}
+
+	if req.Scopes != nil {
+		scopes := make([]string, 0, len(req.Scopes))
+		for _, scope := range req.Scopes {
+			scope = strings.ToLower(strings.TrimSpace(scope))
+			if !validScope(scope) {
+				return fmt.Errorf("unknown scope %q (expected %s)", scope, strings.Join(models.AllScopes, ", "))
+			}
+			scopes = append(scopes, scope)
+		}
+		r.Scopes = scopes
+	}
+
+	if status := strings.ToLower(strings.TrimSpace(req.Status)); status != "" {
+		if status != models.ResellerStatusActive && status != models.ResellerStatusSuspended {
+			return fmt.Errorf("unknown status %q (expected active or suspended)", status)
+		}
+		r.Status = status
+	}
+	return nil
+}
+
+func validScope(scope string) bool {
+	for _, s := range models.AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// newResellerKey generates an API key and stores its prefix and hash on r.
+func newResellerKey(r *models.Reseller) (string, error) {
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", err
+	}
+	key := resellerKeyPrefix + secret
+	r.KeyPrefix = key[:len(resellerKeyPrefix)+8]
+	r.KeyHash = hashSecret(key)
+	return key, nil
+}
+
+// hashSecret hashes a random, high-entropy secret such as an API key or refresh token.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
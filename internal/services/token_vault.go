@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/database"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/utils"
+)
+
+var (
+	// ErrXLTokenNotFound is returned when no access token is stored for a phone.
+	ErrXLTokenNotFound = errors.New("no XL session stored for this phone number, verify an OTP first")
+	// ErrXLTokenExpired is returned when the stored access token has expired.
+	ErrXLTokenExpired = errors.New("XL session for this phone number has expired, verify a new OTP")
+)
+
+// TokenVault stores XL access tokens returned by request-login.json per
+// reseller and normalized phone number, encrypted with AES-256-GCM. A reseller
+// only sees the tokens it stored; reseller 0 is the admin. The owner and phone
+// number are bound to each ciphertext as additional data, so rows cannot be
+// swapped.
+type TokenVault struct {
+	store database.Store
+	aead  cipher.AEAD
+	ttl   time.Duration
+}
+
+// NewTokenVault creates a TokenVault. A 64 character hex key is used as is;
+// any other key is stretched to 32 bytes with SHA-256.
+func NewTokenVault(store database.Store, key string, ttl time.Duration) (*TokenVault, error) {
+	if key == "" {
+		return nil, fmt.Errorf("token vault key is required")
+	}
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+
+	keyBytes, err := hex.DecodeString(key)
+	if err != nil || len(keyBytes) != 32 {
+		sum := sha256.Sum256([]byte(key))
+		keyBytes = sum[:]
+	}
+
+	block, err := aes.NewCipher(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenVault{store: store, aead: aead, ttl: ttl}, nil
+}
+
+// Store encrypts and saves the access token of a phone for a reseller,
+// replacing any previous one.
+func (v *TokenVault) Store(ctx context.Context, resellerID int64, phone, accessToken string) (models.XLTokenStatus, error) {
+	normalized := utils.NormalizePhoneNumber(phone)
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return models.XLTokenStatus{}, err
+	}
+	ciphertext := v.aead.Seal(nil, nonce, []byte(accessToken), additionalData(resellerID, normalized))
+
+	now := time.Now()
+	status := models.XLTokenStatus{
+		PhoneNumber: normalized,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(v.ttl),
+	}
+	if err := v.store.SaveXLToken(ctx, resellerID, normalized, nonce, ciphertext, status.CreatedAt, status.ExpiresAt); err != nil {
+		return models.XLTokenStatus{}, err
+	}
+	return status, nil
+}
+
+// Lookup returns the decrypted access token a reseller stored for a phone.
+// Tokens of other resellers are reported as ErrXLTokenNotFound. Expired
+// tokens are deleted and reported as ErrXLTokenExpired.
+func (v *TokenVault) Lookup(ctx context.Context, resellerID int64, phone string) (string, error) {
+	normalized := utils.NormalizePhoneNumber(phone)
+	nonce, ciphertext, status, err := v.store.GetXLToken(ctx, resellerID, normalized)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrXLTokenNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if time.Now().After(status.ExpiresAt) {
+		v.store.DeleteXLToken(ctx, resellerID, normalized)
+		return "", ErrXLTokenExpired
+	}
+
+	plaintext, err := v.aead.Open(nil, nonce, ciphertext, additionalData(resellerID, normalized))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt XL access token (was the vault key changed?): %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// Revoke deletes the access token a reseller stored for a phone.
+func (v *TokenVault) Revoke(ctx context.Context, resellerID int64, phone string) error {
+	err := v.store.DeleteXLToken(ctx, resellerID, utils.NormalizePhoneNumber(phone))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrXLTokenNotFound
+	}
+	return err
+}
+
+// additionalData binds a ciphertext to its owner and phone. Tokens of the
+// admin are bound to the phone alone, as all tokens were before they had an
+// owner, so that those still decrypt.
+func additionalData(resellerID int64, phone string) []byte {
+	if resellerID == 0 {
+		return []byte(phone)
+	}
+	return []byte(strconv.FormatInt(resellerID, 10) + ":" + phone)
+}
@@ -1,7 +1,10 @@
 package services
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"sync"
 	"time"
@@ -11,81 +14,126 @@ import (
 	"github.com/nabilulilalbab/nadia/internal/utils"
 )
 
+// ErrIdempotencyKeyConflict is returned when an idempotency key is reused with a different request.
+var ErrIdempotencyKeyConflict = errors.New("idempotency key already used with a different request")
+
+// ErrIdempotencyKeyInUse is returned when a concurrent request claimed the same
+// idempotency key between the lookup and the insert.
+var ErrIdempotencyKeyInUse = errors.New("idempotency key is being used by a concurrent request")
+
+// ErrInvalidCursor is returned when a transaction page cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrTransactionNotFound is returned when a transaction does not exist.
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// ErrTransactionNotUnresolved is returned when resolving a transaction that is
+// not PROCESSING without a trx_id.
+var ErrTransactionNotUnresolved = errors.New("transaction is not awaiting manual resolution")
+
+// ErrOTPSessionExpired is returned when the OTP session has passed its expiry time.
+var ErrOTPSessionExpired = errors.New("OTP session expired. Please request a new OTP.")
+
+// otpSessionTTL is how long an auth_id from request-otp.json can be verified.
+const otpSessionTTL = 5 * time.Minute
+
+// idempotencyInFlightTimeout is how long a transaction created for an
+// idempotent request may stay PENDING without a stored response. Purchases
+// finish far sooner, so only requests cut off by a crash or restart reach it.
+// Their key is reclaimed by the next retry unless the purchase was already
+// sent upstream, in which case the reconciler takes the transaction over.
+const idempotencyInFlightTimeout = 5 * time.Minute
+
+// StatusListener is called after a transaction moved from fromStatus to
+// record.Status, and with an empty fromStatus after a transaction was created.
+// Listeners run in the goroutine that made the change once the transaction
+// lock is released, so they may call back into TransactionService; changes of
+// different transactions may be seen out of order. ctx is that of the request
+// or job that changed the status.
+type StatusListener func(ctx context.Context, record models.TransactionRecord, fromStatus string)
+
 // TransactionService handles business logic related to transactions and OTP sessions.
+// Transactions are read from the database on demand; transactionMutex only
+// serializes read-modify-write updates.
 type TransactionService struct {
-	db               *sql.DB
-	otpSessions      map[string]*models.OTPSession
-	sessionMutex     sync.RWMutex
-	transactions     map[string]*models.TransactionRecord
-	transactionMutex sync.RWMutex
+	store            database.Store
+	otpStore         OTPSessionStore
+	transactionMutex sync.Mutex
+	listeners        []StatusListener
+	// pending holds the status changes made under transactionMutex that the
+	// listeners have not been told about yet.
+	pending []statusChange
 }
 
-// NewTransactionService creates a new TransactionService.
-func NewTransactionService(db *sql.DB) (*TransactionService, error) {
-	ts := &TransactionService{
-		db:          db,
-		otpSessions: make(map[string]*models.OTPSession),
-	}
+// statusChange is a status change waiting for the listeners.
+type statusChange struct {
+	record     models.TransactionRecord
+	fromStatus string
+}
 
-	// Load existing transactions from the database
-	transactions, err := database.LoadTransactionsFromDB(db)
-	if err != nil {
-		return nil, err
-	}
-	ts.transactions = transactions
+// NewTransactionService creates a new TransactionService storing OTP sessions in otpStore.
+func NewTransactionService(store database.Store, otpStore OTPSessionStore) (*TransactionService, error) {
+	return &TransactionService{
+		store:    store,
+		otpStore: otpStore,
+	}, nil
+}
 
-	return ts, nil
+// AddStatusListener registers a listener for status changes. Listeners must
+// be registered before the service is used concurrently.
+func (s *TransactionService) AddStatusListener(listener StatusListener) {
+	s.listeners = append(s.listeners, listener)
 }
 
 // OTP Session Management
 
-// CreateOTPSession creates and stores a new OTP session.
-func (s *TransactionService) CreateOTPSession(phone, authID string) {
-	s.sessionMutex.Lock()
-	defer s.sessionMutex.Unlock()
-
-	normalizedPhone := utils.NormalizePhoneNumber(phone)
-	s.otpSessions[normalizedPhone] = &models.OTPSession{
-		PhoneNumber: normalizedPhone,
+// CreateOTPSession creates and stores a new OTP session of a reseller, 0 for
+// the admin. Earlier sessions of the same phone remain valid until they expire.
+func (s *TransactionService) CreateOTPSession(ctx context.Context, resellerID int64, phone, authID string) (*models.OTPSession, error) {
+	now := time.Now()
+	session := &models.OTPSession{
+		RequestID:   utils.GenerateOTPRequestID(),
+		ResellerID:  resellerID,
+		PhoneNumber: utils.NormalizePhoneNumber(phone),
 		AuthID:      authID,
-		CreatedAt:   time.Now(),
-		ExpiresAt:   time.Now().Add(5 * time.Minute),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(otpSessionTTL),
 	}
+	if err := s.otpStore.Save(ctx, session); err != nil {
+		return nil, err
+	}
+	return session, nil
 }
 
-// GetOTPSession retrieves an OTP session if it exists and is not expired.
-func (s *TransactionService) GetOTPSession(phone string) (*models.OTPSession, error) {
-	s.sessionMutex.RLock()
-	defer s.sessionMutex.RUnlock()
-
-	normalizedPhone := utils.NormalizePhoneNumber(phone)
-	session, exists := s.otpSessions[normalizedPhone]
-	if !exists {
-		return nil, log.Output(1, "OTP session not found. Please request OTP first.")
+// GetOTPSession retrieves an OTP session of a reseller if it exists and is not
+// expired. Without a request ID the most recent session of the phone is used.
+func (s *TransactionService) GetOTPSession(ctx context.Context, resellerID int64, phone, requestID string) (*models.OTPSession, error) {
+	session, err := s.otpStore.Get(ctx, resellerID, utils.NormalizePhoneNumber(phone), requestID)
+	if err != nil {
+		return nil, err
 	}
 
 	if time.Now().After(session.ExpiresAt) {
-		return nil, log.Output(1, "OTP session expired. Please request a new OTP.")
+		return nil, ErrOTPSessionExpired
 	}
 
 	return session, nil
 }
 
-// DeleteOTPSession removes an OTP session.
-func (s *TransactionService) DeleteOTPSession(phone string) {
-	s.sessionMutex.Lock()
-	defer s.sessionMutex.Unlock()
-
-	normalizedPhone := utils.NormalizePhoneNumber(phone)
-	delete(s.otpSessions, normalizedPhone)
+// DeleteOTPSession removes all OTP sessions a reseller requested for a phone.
+func (s *TransactionService) DeleteOTPSession(ctx context.Context, resellerID int64, phone string) {
+	if err := s.otpStore.Delete(ctx, resellerID, utils.NormalizePhoneNumber(phone), ""); err != nil {
+		log.Printf("Failed to delete OTP sessions: %v", err)
+	}
 }
 
 // Transaction Management
 
 // RecordTransaction creates a new transaction record and saves it.
-func (s *TransactionService) RecordTransaction(phoneNumber, packageCode, packageName, paymentMethod, source string) *models.TransactionRecord {
+// resellerID is 0 for purchases not made by a reseller.
+func (s *TransactionService) RecordTransaction(ctx context.Context, phoneNumber, packageCode, packageName, paymentMethod, source string, resellerID int64) *models.TransactionRecord {
 	s.transactionMutex.Lock()
-	defer s.transactionMutex.Unlock()
+	defer s.unlock(ctx)
 
 	record := &models.TransactionRecord{
 		ID:            utils.GenerateTransactionID(),
@@ -94,329 +142,545 @@ func (s *TransactionService) RecordTransaction(phoneNumber, packageCode, package
 		PackageName:   packageName,
 		PaymentMethod: paymentMethod,
 		Source:        source,
-		Status:        "PENDING",
+		Status:        models.TxStatusPending,
 		CreatedAt:     time.Now(),
+		ResellerID:    resellerID,
 	}
 
-	s.transactions[record.ID] = record
-
-	if err := database.SaveTransactionToDB(s.db, record); err != nil {
+	if err := s.store.SaveTransactionWithEvent(ctx, record, creationEvent(record)); err != nil {
 		log.Printf("Failed to save new transaction to database: %v", err)
+	} else {
+		s.notify(ctx, record, "")
 	}
 
 	return record
 }
 
-// UpdateTransactionStatus updates the status of an existing transaction.
-func (s *TransactionService) UpdateTransactionStatus(id, status, trxID string, amount, processingFee int, errorMsg string) {
+// RecordIdempotentTransaction creates a transaction bound to an idempotency key
+// of a reseller. If the reseller used the key before with the same request
+// hash, the original record is returned with replay set to true; a different
+// hash yields ErrIdempotencyKeyConflict. An abandoned transaction holding the
+// key is failed and the key is bound to a new transaction.
+func (s *TransactionService) RecordIdempotentTransaction(ctx context.Context, key, requestHash, phoneNumber, packageCode, packageName, paymentMethod, source string, resellerID int64) (record *models.TransactionRecord, replay bool, err error) {
 	s.transactionMutex.Lock()
-	defer s.transactionMutex.Unlock()
-
-	if record, exists := s.transactions[id]; exists {
-		record.Status = status
-		record.TrxID = trxID
-		record.Amount = amount
-		record.ProcessingFee = processingFee
-		record.ErrorMessage = errorMsg
-		now := time.Now()
-		record.CompletedAt = &now
-
-		if err := database.SaveTransactionToDB(s.db, record); err != nil {
-			log.Printf("Failed to update transaction in database: %v", err)
+	defer s.unlock(ctx)
+
+	existing, err := s.store.GetTransactionByIdempotencyKey(ctx, resellerID, key)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return nil, false, err
+	case existing.RequestHash != requestHash:
+		return existing, false, ErrIdempotencyKeyConflict
+	case !abandoned(existing, time.Now()):
+		return existing, true, nil
+	default:
+		updated := *existing
+		updated.Status = models.TxStatusFailed
+		updated.ErrorMessage = "request abandoned before completion"
+		updated.IdempotencyKey = ""
+		if err := s.commitTransaction(ctx, existing, &updated, models.TxActorSystem, updated.ErrorMessage, nil); err != nil {
+			return nil, false, fmt.Errorf("failed to reclaim idempotency key: %v", err)
 		}
+		log.Printf("Reclaimed idempotency key of abandoned transaction %s", existing.ID)
 	}
-}
 
-// UpdateTransactionPackageName updates the package name of a transaction.
-func (s *TransactionService) UpdateTransactionPackageName(id, packageName string) {
-	s.transactionMutex.Lock()
-	defer s.transactionMutex.Unlock()
+	record = &models.TransactionRecord{
+		ID:             utils.GenerateTransactionID(),
+		PhoneNumber:    phoneNumber,
+		PackageCode:    packageCode,
+		PackageName:    packageName,
+		PaymentMethod:  paymentMethod,
+		Source:         source,
+		Status:         models.TxStatusPending,
+		CreatedAt:      time.Now(),
+		IdempotencyKey: key,
+		RequestHash:    requestHash,
+		ResellerID:     resellerID,
+	}
 
-	if record, exists := s.transactions[id]; exists {
-		record.PackageName = packageName
-		if err := database.SaveTransactionToDB(s.db, record); err != nil {
-			log.Printf("Failed to update transaction package name in database: %v", err)
+	if err := s.store.InsertTransactionWithEvent(ctx, record, creationEvent(record)); err != nil {
+		if database.IsUniqueViolation(err) {
+			return nil, false, ErrIdempotencyKeyInUse
 		}
+		return nil, false, err
 	}
+
+	s.notify(ctx, record, "")
+	return record, false, nil
 }
 
-// GetSystemStats calculates and returns system-wide transaction statistics.
-func (s *TransactionService) GetSystemStats() models.SystemStats {
-	s.transactionMutex.RLock()
-	defer s.transactionMutex.RUnlock()
-
-	var total, successful, failed, revenue int
-
-	for _, tx := range s.transactions {
-		total++
-		if tx.Status == "SUCCESS" {
-			successful++
-			revenue += tx.Amount
-		} else if tx.Status == "FAILED" {
-			failed++
+// creationEvent is the first history entry of a new transaction.
+func creationEvent(record *models.TransactionRecord) *models.TransactionEvent {
+	return &models.TransactionEvent{
+		TransactionID: record.ID,
+		ToStatus:      record.Status,
+		Actor:         models.TxActorAPI,
+		Reason:        "created via " + record.Source,
+		CreatedAt:     record.CreatedAt,
+	}
+}
+
+// commitTransaction persists updated in place of record. When the status changes
+// the transition is validated and recorded in transaction_events atomically with
+// the row. record is only modified once the write succeeded. The caller must hold
+// transactionMutex.
+func (s *TransactionService) commitTransaction(ctx context.Context, record, updated *models.TransactionRecord, actor, reason string, payload interface{}) error {
+	if updated.Status == record.Status {
+		if err := s.store.SaveTransaction(ctx, updated); err != nil {
+			return err
 		}
+		*record = *updated
+		return nil
 	}
 
-	successRate := 0.0
-	if total > 0 {
-		successRate = float64(successful) / float64(total) * 100
+	if !CanTransition(record.Status, updated.Status) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, record.Status, updated.Status)
 	}
 
-	return models.SystemStats{
-		TotalTransactions:      total,
-		SuccessfulTransactions: successful,
-		FailedTransactions:     failed,
-		TotalRevenue:           revenue,
-		SuccessRate:            successRate,
-		LastUpdated:            time.Now(),
+	event, err := newTransactionEvent(record.ID, record.Status, updated.Status, actor, reason, payload)
+	if err != nil {
+		return err
+	}
+	event.CreatedAt = time.Now()
+	if IsFinalStatus(updated.Status) {
+		completedAt := event.CreatedAt
+		updated.CompletedAt = &completedAt
 	}
-}
 
-// GetSourceStats calculates and returns transaction statistics grouped by source.
-func (s *TransactionService) GetSourceStats() []models.SourceStats {
-	s.transactionMutex.RLock()
-	defer s.transactionMutex.RUnlock()
+	if err := s.store.SaveTransactionWithEvent(ctx, updated, event); err != nil {
+		return err
+	}
+	*record = *updated
 
-	sourceMap := make(map[string]*models.SourceStats)
+	s.notify(ctx, record, event.FromStatus)
+	return nil
+}
 
-	for _, tx := range s.transactions {
-		source := tx.Source
-		if source == "" {
-			source = "unknown"
-		}
+// notify queues a status change for the listeners, which are called by
+// unlock. The caller must hold transactionMutex.
+func (s *TransactionService) notify(ctx context.Context, record *models.TransactionRecord, fromStatus string) {
+	if len(s.listeners) > 0 {
+		s.pending = append(s.pending, statusChange{record: *record, fromStatus: fromStatus})
+	}
+}
 
-		if _, exists := sourceMap[source]; !exists {
-			sourceMap[source] = &models.SourceStats{Source: source}
+// unlock releases transactionMutex and then calls the status listeners for
+// the changes queued while it was held, so a slow listener does not hold up
+// other updates.
+func (s *TransactionService) unlock(ctx context.Context) {
+	changes := s.pending
+	s.pending = nil
+	s.transactionMutex.Unlock()
+
+	for _, change := range changes {
+		for _, listener := range s.listeners {
+			listener(ctx, change.record, change.fromStatus)
 		}
+	}
+}
 
-		sourceMap[source].Count++
-		if tx.Status == "SUCCESS" {
-			sourceMap[source].Revenue += tx.Amount
-		}
+// load reads a transaction for an update. The caller must hold transactionMutex.
+func (s *TransactionService) load(ctx context.Context, id string) (*models.TransactionRecord, error) {
+	record, err := s.store.GetTransaction(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrTransactionNotFound, id)
 	}
+	return record, err
+}
 
-	var result []models.SourceStats
-	for _, stats := range sourceMap {
-		if stats.Count > 0 {
-			successCount := 0
-			for _, tx := range s.transactions {
-				txSource := tx.Source
-				if txSource == "" {
-					txSource = "unknown"
-				}
-				if txSource == stats.Source && tx.Status == "SUCCESS" {
-					successCount++
-				}
-			}
-			stats.SuccessRate = float64(successCount) / float64(stats.Count) * 100
-		}
-		result = append(result, *stats)
+// GetTransactionEvents returns the status history of a transaction, oldest first.
+func (s *TransactionService) GetTransactionEvents(ctx context.Context, id string) ([]models.TransactionEvent, error) {
+	return s.store.GetTransactionEvents(ctx, id)
+}
+
+// FindIdempotentTransaction returns the transaction a reseller created with an
+// idempotency key, or nil if the key is unused or held by an abandoned
+// transaction. A key used for a different request yields
+// ErrIdempotencyKeyConflict.
+func (s *TransactionService) FindIdempotentTransaction(ctx context.Context, resellerID int64, key, requestHash string) (*models.TransactionRecord, error) {
+	existing, err := s.store.GetTransactionByIdempotencyKey(ctx, resellerID, key)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case existing.RequestHash != requestHash:
+		return nil, ErrIdempotencyKeyConflict
+	case abandoned(existing, time.Now()):
+		return nil, nil
 	}
+	return existing, nil
+}
 
-	return result
+// abandoned reports whether the request that created record with an
+// idempotency key never finished before sending the purchase upstream.
+func abandoned(record *models.TransactionRecord, now time.Time) bool {
+	return record.Status == models.TxStatusPending && record.ResponseBody == "" &&
+		record.SubmittedAt == nil && now.Sub(record.CreatedAt) > idempotencyInFlightTimeout
 }
 
-// GetRecentTransactions returns the most recent transactions.
-func (s *TransactionService) GetRecentTransactions(limit int) []models.TransactionRecord {
-	txList, err := database.GetAllTransactionsFromDB(s.db, limit)
+// MarkTransactionSubmitted records that a purchase is about to be sent
+// upstream. From then on its idempotency key is no longer reclaimed, since
+// the package may have been bought even if the request is cut off.
+func (s *TransactionService) MarkTransactionSubmitted(ctx context.Context, id string) error {
+	s.transactionMutex.Lock()
+	defer s.unlock(ctx)
+
+	record, err := s.load(ctx, id)
 	if err != nil {
-		log.Printf("Failed to get transactions from DB for recent list: %v", err)
-		// Fallback to in-memory data if DB fails
-		s.transactionMutex.RLock()
-		defer s.transactionMutex.RUnlock()
+		return err
+	}
+	now := time.Now()
+	record.SubmittedAt = &now
+	return s.store.SaveTransaction(ctx, record)
+}
 
-		var result []models.TransactionRecord
-		for _, tx := range s.transactions {
-			result = append(result, *tx)
+// GetAbandonedSubmissions returns the transactions that were sent upstream
+// but are still PENDING idempotencyInFlightTimeout after their creation, so
+// the request that sent them never recorded an outcome.
+func (s *TransactionService) GetAbandonedSubmissions(ctx context.Context, now time.Time) ([]*models.TransactionRecord, error) {
+	before := now.Add(-idempotencyInFlightTimeout)
+	q := &models.TransactionQuery{Statuses: []string{models.TxStatusPending}, To: &before, Ascending: true}
+
+	var result []*models.TransactionRecord
+	err := s.store.ForEachTransaction(ctx, q, func(record *models.TransactionRecord) error {
+		if record.SubmittedAt != nil {
+			result = append(result, record)
 		}
+		return nil
+	})
+	return result, err
+}
 
-		// Sort by created time (newest first)
-		for i := 0; i < len(result)-1; i++ {
-			for j := i + 1; j < len(result); j++ {
-				if result[i].CreatedAt.Before(result[j].CreatedAt) {
-					result[i], result[j] = result[j], result[i]
-			}
-			}
-		}
+// ResolveTransaction settles a PROCESSING transaction without a trx_id, which
+// the reconciler cannot poll. A trx_id hands it back to the reconciler; a
+// status ends it, capturing or releasing its wallet hold.
+func (s *TransactionService) ResolveTransaction(ctx context.Context, id string, req models.TransactionResolveRequest, resolvedBy string) (*models.TransactionRecord, error) {
+	s.transactionMutex.Lock()
+	defer s.unlock(ctx)
+
+	record, err := s.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status != models.TxStatusProcessing || record.TrxID != "" {
+		return nil, fmt.Errorf("%w: %s is %s", ErrTransactionNotUnresolved, id, record.Status)
+	}
 
-		if len(result) > limit {
-			result = result[:limit]
+	reason := fmt.Sprintf("resolved by %s: %s", resolvedBy, req.Note)
+	updated := *record
+	updated.TrxID = req.TrxID
+	if req.Status != "" {
+		updated.Status = req.Status
+		if req.Status != models.TxStatusSuccess {
+			updated.ErrorMessage = reason
 		}
-		return result
 	}
 
-	var result []models.TransactionRecord
-	for _, tx := range txList {
-		result = append(result, *tx)
+	if err := s.commitTransaction(ctx, record, &updated, models.TxActorAdmin, reason, nil); err != nil {
+		return nil, err
 	}
-	return result
+	log.Printf("Transaction %s %s", id, reason)
+	result := *record
+	return &result, nil
 }
 
-// GetTransactionDetail retrieves a single transaction by its ID.
-func (s *TransactionService) GetTransactionDetail(id string) (*models.TransactionRecord, bool) {
-	s.transactionMutex.RLock()
-	defer s.transactionMutex.RUnlock()
-	tx, exists := s.transactions[id]
-	return tx, exists
+// CountPackagePurchasesSince counts purchases of a package since the given time
+// that did not fail or expire.
+func (s *TransactionService) CountPackagePurchasesSince(ctx context.Context, packageCode string, since time.Time) (int, error) {
+	return s.store.CountPackagePurchasesSince(ctx, packageCode, since)
 }
 
-// GetTransactionsWithFilters retrieves transactions with optional filters
-func (s *TransactionService) GetTransactionsWithFilters(limit int, status, source, from, to string) ([]models.TransactionRecord, error) {
-	txList, err := database.GetAllTransactionsFromDB(s.db, 0) // Get all first
+// SaveTransactionResponse stores the response returned to the client so that
+// replays of an idempotent request receive exactly the same answer.
+func (s *TransactionService) SaveTransactionResponse(ctx context.Context, id string, statusCode int, body []byte) {
+	s.transactionMutex.Lock()
+	defer s.unlock(ctx)
+
+	record, err := s.load(ctx, id)
 	if err != nil {
-		log.Printf("Failed to get transactions from DB: %v", err)
-		return nil, err
+		log.Printf("Failed to load transaction %s to save its response: %v", id, err)
+		return
 	}
-
-	var filtered []models.TransactionRecord
-	
-	for _, tx := range txList {
-		// Apply filters
-		if status != "" && tx.Status != status {
-			continue
-		}
-		if source != "" && tx.Source != source {
-			continue
-		}
-		if from != "" {
-			fromTime, err := time.Parse("2006-01-02", from)
-			if err == nil && tx.CreatedAt.Before(fromTime) {
-				continue
-			}
-		}
-		if to != "" {
-			toTime, err := time.Parse("2006-01-02", to)
-			if err == nil && tx.CreatedAt.After(toTime.Add(24*time.Hour)) {
-				continue
-			}
-		}
-		
-		filtered = append(filtered, *tx)
+	record.ResponseStatus = statusCode
+	record.ResponseBody = string(body)
+	if err := s.store.SaveTransaction(ctx, record); err != nil {
+		log.Printf("Failed to save transaction response in database: %v", err)
 	}
+}
 
-	// Sort by created time (newest first)
-	for i := 0; i < len(filtered)-1; i++ {
-		for j := i + 1; j < len(filtered); j++ {
-			if filtered[i].CreatedAt.Before(filtered[j].CreatedAt) {
-				filtered[i], filtered[j] = filtered[j], filtered[i]
-			}
-		}
+// UpdateTransactionStatus moves an existing transaction to a new status. The
+// upstream payload and the error message, used as the reason, are recorded in
+// the transaction history. Transitions not allowed by the state machine are
+// rejected with ErrInvalidTransition.
+func (s *TransactionService) UpdateTransactionStatus(ctx context.Context, id, status, trxID string, amount, processingFee int, errorMsg, actor string, payload interface{}) error {
+	s.transactionMutex.Lock()
+	defer s.unlock(ctx)
+
+	record, err := s.load(ctx, id)
+	if err != nil {
+		return err
 	}
 
-	// Apply limit
-	if limit > 0 && len(filtered) > limit {
-		filtered = filtered[:limit]
+	updated := *record
+	updated.Status = status
+	updated.TrxID = trxID
+	updated.Amount = amount
+	updated.ProcessingFee = processingFee
+	updated.ErrorMessage = errorMsg
+
+	if err := s.commitTransaction(ctx, record, &updated, actor, errorMsg, payload); err != nil {
+		log.Printf("Failed to update transaction %s: %v", id, err)
+		return err
 	}
+	return nil
+}
+
+// GetUnsettledTransactions returns transactions with an upstream trx_id that are
+// still waiting for payment or processing, created after the given time.
+func (s *TransactionService) GetUnsettledTransactions(ctx context.Context, since time.Time) ([]*models.TransactionRecord, error) {
+	return s.store.GetUnsettledTransactions(ctx,
+		[]string{models.TxStatusPendingPayment, models.TxStatusProcessing}, since)
+}
+
+// MarkTransactionChecked records that a transaction was polled upstream.
+func (s *TransactionService) MarkTransactionChecked(ctx context.Context, id string) {
+	s.transactionMutex.Lock()
+	defer s.unlock(ctx)
 
-	return filtered, nil
+	record, err := s.load(ctx, id)
+	if err != nil {
+		log.Printf("Failed to load transaction %s to mark it checked: %v", id, err)
+		return
+	}
+	now := time.Now()
+	record.LastCheckedAt = &now
+	if err := s.store.SaveTransaction(ctx, record); err != nil {
+		log.Printf("Failed to update transaction check time in database: %v", err)
+	}
 }
 
-// GetDailyStats retrieves daily transaction statistics
-func (s *TransactionService) GetDailyStats() (map[string]interface{}, error) {
-	txList, err := database.GetAllTransactionsFromDB(s.db, 0)
+// ApplyReconciliation stores the status reported by check-transaction.json,
+// keeping the upstream data as the payload of the recorded transition.
+func (s *TransactionService) ApplyReconciliation(ctx context.Context, id, status string, refundAmount int, message string, payload interface{}) error {
+	s.transactionMutex.Lock()
+	defer s.unlock(ctx)
+
+	record, err := s.load(ctx, id)
 	if err != nil {
-		log.Printf("Failed to get transactions from DB: %v", err)
-		return nil, err
+		return err
 	}
 
-	dailyStats := make(map[string]map[string]interface{})
-	
-	for _, tx := range txList {
-		dateKey := tx.CreatedAt.Format("2006-01-02")
-		
-		if _, exists := dailyStats[dateKey]; !exists {
-			dailyStats[dateKey] = map[string]interface{}{
-				"date":        dateKey,
-				"total":       0,
-				"successful":  0,
-				"failed":      0,
-				"pending":     0,
-				"revenue":     0,
-			}
-		}
-		
-		dailyStats[dateKey]["total"] = dailyStats[dateKey]["total"].(int) + 1
-		
-		switch tx.Status {
-		case "SUCCESS":
-			dailyStats[dateKey]["successful"] = dailyStats[dateKey]["successful"].(int) + 1
-			dailyStats[dateKey]["revenue"] = dailyStats[dateKey]["revenue"].(int) + tx.Amount
-		case "FAILED":
-			dailyStats[dateKey]["failed"] = dailyStats[dateKey]["failed"].(int) + 1
-		case "PENDING":
-			dailyStats[dateKey]["pending"] = dailyStats[dateKey]["pending"].(int) + 1
+	now := time.Now()
+	updated := *record
+	updated.LastCheckedAt = &now
+	updated.RefundAmount = refundAmount
+	if status != record.Status {
+		log.Printf("Transaction %s reconciled: %s -> %s", id, record.Status, status)
+		updated.Status = status
+		if status != models.TxStatusSuccess {
+			updated.ErrorMessage = message
 		}
 	}
 
-	// Convert to slice for easier consumption
-	var result []map[string]interface{}
-	for _, stats := range dailyStats {
-		result = append(result, stats)
+	if err := s.commitTransaction(ctx, record, &updated, models.TxActorReconciler, message, payload); err != nil {
+		log.Printf("Failed to save reconciled transaction %s: %v", id, err)
+		return err
 	}
+	return nil
+}
 
-	return map[string]interface{}{
-		"daily_stats": result,
-		"total_days":  len(result),
-	}, nil
+// PurchaseResult is the outcome of a purchase sent upstream.
+type PurchaseResult struct {
+	Status string
+	// Amount is what the customer is charged and Cost what upstream charges us.
+	Amount  int
+	Cost    int
+	QuoteID string
+	Reason  string
+	// Data carries the trx_id, package name, processing fee and payment
+	// instructions returned upstream; Payload is the raw response.
+	Data    *models.PurchaseResponseData
+	Payload interface{}
 }
 
-// GetAllTransactions retrieves all transactions for export
-func (s *TransactionService) GetAllTransactions() ([]models.TransactionRecord, error) {
-	txList, err := database.GetAllTransactionsFromDB(s.db, 0)
+// RecordPurchaseResult saves the outcome of a purchase with its pricing and
+// payment instructions in a single update, so that status listeners, such as
+// webhooks, get the QRIS code and deeplink along with the status.
+func (s *TransactionService) RecordPurchaseResult(ctx context.Context, id string, result PurchaseResult) error {
+	s.transactionMutex.Lock()
+	defer s.unlock(ctx)
+
+	record, err := s.load(ctx, id)
 	if err != nil {
-		log.Printf("Failed to get all transactions from DB: %v", err)
-		return nil, err
+		return err
 	}
 
-	var result []models.TransactionRecord
-	for _, tx := range txList {
-		result = append(result, *tx)
+	data := result.Data
+	updated := *record
+	updated.Status = result.Status
+	updated.TrxID = data.TrxID
+	updated.Amount = result.Amount
+	updated.ProcessingFee = data.PackageProcessingFee
+	updated.ErrorMessage = result.Reason
+	updated.QuoteID = result.QuoteID
+	updated.Cost = result.Cost
+	updated.Margin = result.Amount - result.Cost
+	if data.PackageName != "" {
+		updated.PackageName = data.PackageName
 	}
-	return result, nil
+	updated.IsQris = data.IsQris
+	if data.IsQris && data.QrisData != nil {
+		updated.QrCode = data.QrisData.QrCode
+		updated.PaymentExpiredAt = data.QrisData.ExpiresAt()
+	}
+	if data.HaveDeeplink && data.DeeplinkData != nil {
+		updated.DeeplinkURL = data.DeeplinkData.DeeplinkURL
+	}
+
+	if err := s.commitTransaction(ctx, record, &updated, models.TxActorAPI, result.Reason, result.Payload); err != nil {
+		log.Printf("Failed to save purchase result of transaction %s: %v", id, err)
+		return err
+	}
+	return nil
 }
 
-// GetAllInvoices retrieves all invoices for export (using transactions as invoices)
-func (s *TransactionService) GetAllInvoices() ([]models.TransactionRecord, error) {
-	// For now, invoices are the same as transactions
-	// In a real system, you might have a separate invoices table
-	return s.GetAllTransactions()
+// UpdateTransactionPackageName updates the package name of a transaction.
+func (s *TransactionService) UpdateTransactionPackageName(ctx context.Context, id, packageName string) {
+	s.transactionMutex.Lock()
+	defer s.unlock(ctx)
+
+	record, err := s.load(ctx, id)
+	if err != nil {
+		log.Printf("Failed to load transaction %s to update its package name: %v", id, err)
+		return
+	}
+	record.PackageName = packageName
+	if err := s.store.SaveTransaction(ctx, record); err != nil {
+		log.Printf("Failed to update transaction package name in database: %v", err)
+	}
 }
 
-// GetInvoiceStats retrieves invoice statistics (using transactions as invoices)
-func (s *TransactionService) GetInvoiceStats() (models.InvoiceStats, error) {
-	txList, err := database.GetAllTransactionsFromDB(s.db, 0)
+// GetSystemStats calculates and returns system-wide transaction statistics.
+func (s *TransactionService) GetSystemStats(ctx context.Context) models.SystemStats {
+	totals, err := s.store.SumTransactions(ctx, &models.TransactionQuery{})
 	if err != nil {
-		log.Printf("Failed to get transactions from DB for invoice stats: %v", err)
-		return models.InvoiceStats{}, err
+		log.Printf("Failed to aggregate transactions: %v", err)
+	}
+
+	return models.SystemStats{
+		TotalTransactions:      totals.Total,
+		SuccessfulTransactions: totals.Successful,
+		FailedTransactions:     totals.Failed,
+		TotalRevenue:           totals.Revenue,
+		SuccessRate:            percentage(totals.Successful, totals.Total),
+		LastUpdated:            time.Now(),
+	}
+}
+
+// GetSourceStats calculates and returns transaction statistics grouped by source.
+func (s *TransactionService) GetSourceStats(ctx context.Context) []models.SourceStats {
+	bySource, err := s.store.SumTransactionsBySource(ctx, &models.TransactionQuery{})
+	if err != nil {
+		log.Printf("Failed to aggregate transactions by source: %v", err)
+	}
+
+	result := make([]models.SourceStats, 0, len(bySource))
+	for _, totals := range bySource {
+		result = append(result, models.SourceStats{
+			Source:      totals.Key,
+			Count:       totals.Total,
+			Revenue:     totals.Revenue,
+			SuccessRate: percentage(totals.Successful, totals.Total),
+		})
 	}
+	return result
+}
 
-	var totalInvoices, paidInvoices, unpaidInvoices, pendingInvoices, totalRevenue int
+// percentage returns part as a percentage of total, or 0 if total is 0.
+func percentage(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
 
-	for _, tx := range txList {
-		totalInvoices++
-		switch tx.Status {
-		case "SUCCESS":
-			paidInvoices++
-			totalRevenue += tx.Amount
-		case "FAILED":
-			unpaidInvoices++
-		case "PENDING":
-			pendingInvoices++
+// GetRecentTransactions returns the most recent transactions.
+func (s *TransactionService) GetRecentTransactions(ctx context.Context, limit int) []models.TransactionRecord {
+	result, _, err := s.store.QueryTransactions(ctx, &models.TransactionQuery{Limit: limit})
+	if err != nil {
+		log.Printf("Failed to get transactions from DB for recent list: %v", err)
+		return []models.TransactionRecord{}
+	}
+	return result
+}
+
+// GetTransactionDetail retrieves a single transaction by its ID.
+func (s *TransactionService) GetTransactionDetail(ctx context.Context, id string) (*models.TransactionRecord, bool) {
+	tx, err := s.store.GetTransaction(ctx, id)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to get transaction %s: %v", id, err)
 		}
+		return nil, false
+	}
+	return tx, true
+}
+
+// QueryTransactions returns one page of the transactions matching q and the
+// cursor of the next page, which is empty on the last page.
+func (s *TransactionService) QueryTransactions(ctx context.Context, q *models.TransactionQuery) ([]models.TransactionRecord, string, error) {
+	result, next, err := s.store.QueryTransactions(ctx, q)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		return nil, "", ErrInvalidCursor
 	}
+	return result, next, err
+}
 
-	paymentRate := 0.0
-	if totalInvoices > 0 {
-		paymentRate = float64(paidInvoices) / float64(totalInvoices) * 100
+// ForEachTransaction streams every transaction matching q to fn, e.g. for an
+// export. fn must not call back into TransactionService.
+func (s *TransactionService) ForEachTransaction(ctx context.Context, q *models.TransactionQuery, fn func(*models.TransactionRecord) error) error {
+	return s.store.ForEachTransaction(ctx, q, fn)
+}
+
+// GetDailyStats returns the transaction statistics per day of the transactions
+// matching q, newest day first.
+func (s *TransactionService) GetDailyStats(ctx context.Context, q *models.TransactionQuery) ([]models.DailyStats, error) {
+	byDay, err := s.store.SumTransactionsByDay(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]models.DailyStats, 0, len(byDay))
+	for _, totals := range byDay {
+		result = append(result, models.DailyStats{
+			Date:       totals.Key,
+			Total:      totals.Total,
+			Successful: totals.Successful,
+			Failed:     totals.Failed,
+			Pending:    totals.Pending,
+			Revenue:    totals.Revenue,
+		})
+	}
+	return result, nil
+}
+
+// GetInvoiceStats retrieves invoice statistics of the transactions matching q
+// (using transactions as invoices).
+func (s *TransactionService) GetInvoiceStats(ctx context.Context, q *models.TransactionQuery) (models.InvoiceStats, error) {
+	totals, err := s.store.SumTransactions(ctx, q)
+	if err != nil {
+		return models.InvoiceStats{}, err
 	}
 
 	return models.InvoiceStats{
-		TotalInvoices:   totalInvoices,
-		PaidInvoices:    paidInvoices,
-		UnpaidInvoices:  unpaidInvoices,
-		PendingInvoices: pendingInvoices,
-		TotalRevenue:    totalRevenue,
-		PaymentRate:     paymentRate,
+		TotalInvoices:   totals.Total,
+		PaidInvoices:    totals.Successful,
+		UnpaidInvoices:  totals.Failed,
+		PendingInvoices: totals.Pending,
+		TotalRevenue:    totals.Revenue,
+		PaymentRate:     percentage(totals.Successful, totals.Total),
 		LastUpdated:     time.Now(),
 	}, nil
 }
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// ErrInvalidTransition is returned when a status change is not allowed by the state machine.
+var ErrInvalidTransition = errors.New("invalid transaction status transition")
+
+// transactionTransitions lists the statuses each status may move to.
+//
+//	PENDING         -> PENDING_PAYMENT, PROCESSING, SUCCESS, FAILED
+//	PENDING_PAYMENT -> PROCESSING, SUCCESS, FAILED, EXPIRED, REFUNDED
+//	PROCESSING      -> SUCCESS, FAILED, REFUNDED
+//	SUCCESS         -> REFUNDED
+//
+// FAILED, REFUNDED and EXPIRED are terminal.
+var transactionTransitions = map[string][]string{
+	models.TxStatusPending: {
+		models.TxStatusPendingPayment, models.TxStatusProcessing,
+		models.TxStatusSuccess, models.TxStatusFailed,
+	},
+	models.TxStatusPendingPayment: {
+		models.TxStatusProcessing, models.TxStatusSuccess, models.TxStatusFailed,
+		models.TxStatusExpired, models.TxStatusRefunded,
+	},
+	models.TxStatusProcessing: {
+		models.TxStatusSuccess, models.TxStatusFailed, models.TxStatusRefunded,
+	},
+	models.TxStatusSuccess: {
+		models.TxStatusRefunded,
+	},
+}
+
+// CanTransition reports whether a transaction may move from one status to another.
+func CanTransition(from, to string) bool {
+	for _, next := range transactionTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinalStatus reports whether a status is an outcome rather than an intermediate state.
+func IsFinalStatus(status string) bool {
+	switch status {
+	case models.TxStatusSuccess, models.TxStatusFailed, models.TxStatusRefunded, models.TxStatusExpired:
+		return true
+	}
+	return false
+}
+
+// newTransactionEvent builds a history event for a transition. The payload is
+// stored as JSON: raw bytes are kept as-is if they are valid JSON and stored as a
+// string otherwise, such as the HTML page of an upstream 502.
+func newTransactionEvent(id, from, to, actor, reason string, payload interface{}) (*models.TransactionEvent, error) {
+	event := &models.TransactionEvent{
+		TransactionID: id,
+		FromStatus:    from,
+		ToStatus:      to,
+		Actor:         actor,
+		Reason:        reason,
+	}
+
+	if raw, ok := payload.(json.RawMessage); ok {
+		payload = []byte(raw)
+	}
+	switch p := payload.(type) {
+	case nil:
+	case []byte:
+		if len(p) == 0 {
+			break
+		}
+		if json.Valid(p) {
+			event.Payload = p
+		} else {
+			encoded, _ := json.Marshal(string(p))
+			event.Payload = encoded
+		}
+	default:
+		encoded, err := json.Marshal(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode event payload: %v", err)
+		}
+		event.Payload = encoded
+	}
+	return event, nil
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/database"
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{models.TxStatusPending, models.TxStatusPendingPayment, true},
+		{models.TxStatusPending, models.TxStatusProcessing, true},
+		{models.TxStatusPending, models.TxStatusSuccess, true},
+		{models.TxStatusPending, models.TxStatusFailed, true},
+		{models.TxStatusPending, models.TxStatusExpired, false},
+		{models.TxStatusPending, models.TxStatusRefunded, false},
+		{models.TxStatusPendingPayment, models.TxStatusProcessing, true},
+		{models.TxStatusPendingPayment, models.TxStatusExpired, true},
+		{models.TxStatusPendingPayment, models.TxStatusRefunded, true},
+		{models.TxStatusPendingPayment, models.TxStatusPending, false},
+		{models.TxStatusProcessing, models.TxStatusSuccess, true},
+		{models.TxStatusProcessing, models.TxStatusRefunded, true},
+		{models.TxStatusProcessing, models.TxStatusExpired, false},
+		{models.TxStatusProcessing, models.TxStatusPendingPayment, false},
+		{models.TxStatusSuccess, models.TxStatusRefunded, true},
+		{models.TxStatusSuccess, models.TxStatusFailed, false},
+		{models.TxStatusFailed, models.TxStatusSuccess, false},
+		{models.TxStatusRefunded, models.TxStatusSuccess, false},
+		{models.TxStatusExpired, models.TxStatusProcessing, false},
+		{models.TxStatusSuccess, models.TxStatusSuccess, false},
+		{"UNKNOWN", models.TxStatusSuccess, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			if got := CanTransition(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTransactionEventPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload interface{}
+		want    string
+	}{
+		{"nil", nil, ""},
+		{"empty bytes", []byte{}, ""},
+		{"empty raw message", json.RawMessage(nil), ""},
+		{"json bytes", []byte(`{"status":"SUCCESS"}`), `{"status":"SUCCESS"}`},
+		{"raw message", json.RawMessage(`{"ok":true}`), `{"ok":true}`},
+		{"html bytes", []byte("<html>502 Bad Gateway</html>"), `"\u003chtml\u003e502 Bad Gateway\u003c/html\u003e"`},
+		{"invalid raw message", json.RawMessage("not json"), `"not json"`},
+		{"value", map[string]int{"amount": 5000}, `{"amount":5000}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := newTransactionEvent("TXN_1", models.TxStatusPending, models.TxStatusFailed, models.TxActorSystem, "", tt.payload)
+			if err != nil {
+				t.Fatalf("newTransactionEvent: %v", err)
+			}
+			if got := string(event.Payload); got != tt.want {
+				t.Errorf("payload = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestStatusListenerCallsBack checks that listeners run after the transaction
+// lock is released, so they may update transactions themselves.
+func TestStatusListenerCallsBack(t *testing.T) {
+	store, err := database.InitDatabase(database.DriverSQLite, filepath.Join(t.TempDir(), "nadia.db"))
+	if err != nil {
+		t.Fatalf("InitDatabase: %v", err)
+	}
+	defer store.Close()
+	s, err := NewTransactionService(store, NewMemoryOTPSessionStore())
+	if err != nil {
+		t.Fatalf("NewTransactionService: %v", err)
+	}
+
+	var seen []string
+	s.AddStatusListener(func(ctx context.Context, record models.TransactionRecord, fromStatus string) {
+		seen = append(seen, fromStatus+"->"+record.Status)
+		if record.Status == models.TxStatusProcessing {
+			if err := s.UpdateTransactionStatus(ctx, record.ID, models.TxStatusSuccess, "", 0, 0, "", models.TxActorSystem, nil); err != nil {
+				t.Errorf("UpdateTransactionStatus from listener: %v", err)
+			}
+		}
+	})
+
+	ctx := context.Background()
+	record := s.RecordTransaction(ctx, "087712345678", "AKR1", "Akrab", "BALANCE", "api", 0)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.UpdateTransactionStatus(ctx, record.ID, models.TxStatusProcessing, "", 0, 0, "", models.TxActorSystem, nil)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("UpdateTransactionStatus: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("UpdateTransactionStatus deadlocked with a listener calling back")
+	}
+
+	want := []string{"->PENDING", "PENDING->PROCESSING", "PROCESSING->SUCCESS"}
+	if len(seen) != len(want) {
+		t.Fatalf("listener saw %v, want %v", seen, want)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Errorf("listener saw %v, want %v", seen, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/database"
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+// System ledger accounts on the other side of reseller wallet postings.
+const (
+	accountDeposits    = "system:deposits"
+	accountSales       = "system:sales"
+	accountAdjustments = "system:adjustments"
+)
+
+var (
+	// ErrInsufficientBalance is returned when a wallet cannot cover a hold or debit.
+	ErrInsufficientBalance = errors.New("insufficient wallet balance")
+	// ErrDuplicateReference is returned when a deposit reference was already recorded.
+	ErrDuplicateReference = errors.New("reference already recorded")
+)
+
+func availableAccount(resellerID int64) string {
+	return fmt.Sprintf("reseller:%d:available", resellerID)
+}
+
+func heldAccount(resellerID int64) string {
+	return fmt.Sprintf("reseller:%d:held", resellerID)
+}
+
+// settlementSweepInterval is how often final purchases are checked for holds
+// that their status change did not settle.
+const settlementSweepInterval = time.Minute
+
+// WalletService keeps a prepaid wallet per reseller on a double-entry ledger.
+// Purchases hold their price when they are placed; the hold is captured on
+// SUCCESS, released on FAILED or EXPIRED and refunded on REFUNDED. Holds are
+// settled by a status listener, and by a periodic sweep for status changes
+// whose settlement failed or was cut off.
+type WalletService struct {
+	store database.Store
+
+	// mutex serializes the postings of this instance. Postings that must not
+	// overdraw an account are also checked by the database, which covers
+	// instances sharing it; see postCovered.
+	mutex sync.Mutex
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewWalletService creates a new WalletService.
+func NewWalletService(store database.Store) *WalletService {
+	return &WalletService{store: store, stopCh: make(chan struct{})}
+}
+
+// Start launches the background settlement sweep.
+func (s *WalletService) Start() {
+	go func() {
+		ctx := context.Background()
+		ticker := time.NewTicker(settlementSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.Sweep(ctx)
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the background settlement sweep.
+func (s *WalletService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+// Sweep settles every final purchase whose hold is still open, and returns
+// how many it settled.
+func (s *WalletService) Sweep(ctx context.Context) int {
+	records, err := s.store.GetTransactionsAwaitingSettlement(ctx)
+	if err != nil {
+		log.Printf("Failed to load transactions awaiting settlement: %v", err)
+		return 0
+	}
+
+	settled := 0
+	for _, record := range records {
+		// Another instance may settle the same transaction; the unique
+		// journal reference makes one of them fail.
+		if err := s.Settle(ctx, *record); err != nil && !database.IsUniqueViolation(err) {
+			log.Printf("Failed to settle wallet for transaction %s (%s): %v", record.ID, record.Status, err)
+			continue
+		}
+		settled++
+	}
+	if settled > 0 {
+		log.Printf("Settled %d wallet holds missed by status changes", settled)
+	}
+	return settled
+}
+
+// Balance returns the available and held balance of a reseller.
+func (s *WalletService) Balance(ctx context.Context, resellerID int64) (models.WalletBalance, error) {
+	balance := models.WalletBalance{ResellerID: resellerID}
+	var err error
+	if balance.Available, err = s.store.AccountBalance(ctx, availableAccount(resellerID)); err != nil {
+		return balance, err
+	}
+	balance.Held, err = s.store.AccountBalance(ctx, heldAccount(resellerID))
+	return balance, err
+}
+
+// Ledger returns the most recent journals of a reseller, newest first.
+func (s *WalletService) Ledger(ctx context.Context, resellerID int64, limit int) ([]models.LedgerJournal, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	return s.store.GetLedgerJournals(ctx, resellerID, limit)
+}
+
+// Deposit credits a reseller wallet. A non-empty reference can only be deposited once.
+func (s *WalletService) Deposit(ctx context.Context, resellerID int64, amount int, reference, memo, createdBy string) (*models.LedgerJournal, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("deposit amount must be positive")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if reference != "" {
+		exists, err := s.store.JournalExists(ctx, models.LedgerDeposit, reference)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, ErrDuplicateReference
+		}
+	}
+	return s.post(ctx, resellerID, models.LedgerDeposit, reference, memo, createdBy,
+		entry(availableAccount(resellerID), amount), entry(accountDeposits, -amount))
+}
+
+// Adjust corrects a reseller wallet by a positive or negative amount. The
+// available balance may not become negative.
+func (s *WalletService) Adjust(ctx context.Context, resellerID int64, amount int, memo, createdBy string) (*models.LedgerJournal, error) {
+	if amount == 0 {
+		return nil, fmt.Errorf("adjustment amount must not be zero")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := []models.LedgerEntry{entry(availableAccount(resellerID), amount), entry(accountAdjustments, -amount)}
+	if amount < 0 {
+		return s.postCovered(ctx, availableAccount(resellerID), resellerID, models.LedgerAdjustment, "", memo, createdBy, entries...)
+	}
+	return s.post(ctx, resellerID, models.LedgerAdjustment, "", memo, createdBy, entries...)
+}
+
+// Hold reserves the price of a purchase. It returns ErrInsufficientBalance if
+// the available balance is too low. Holding a transaction twice is a no-op.
+func (s *WalletService) Hold(ctx context.Context, resellerID int64, transactionID string, amount int) error {
+	if amount <= 0 {
+		return fmt.Errorf("hold amount must be positive")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	exists, err := s.store.JournalExists(ctx, models.LedgerHold, transactionID)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = s.postCovered(ctx, availableAccount(resellerID), resellerID, models.LedgerHold, transactionID, "purchase hold", models.TxActorSystem,
+		entry(availableAccount(resellerID), -amount), entry(heldAccount(resellerID), amount))
+	if database.IsUniqueViolation(err) {
+		// Another instance held the transaction first.
+		return nil
+	}
+	return err
+}
+
+// Settle posts the ledger journals for the current status of a reseller
+// purchase. It is idempotent, so it is safe to call for every status change.
+func (s *WalletService) Settle(ctx context.Context, record models.TransactionRecord) error {
+	if record.ResellerID == 0 {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch record.Status {
+	case models.TxStatusSuccess:
+		return s.settleHold(ctx, record, models.LedgerCapture, accountSales)
+	case models.TxStatusFailed, models.TxStatusExpired:
+		return s.settleHold(ctx, record, models.LedgerRelease, availableAccount(record.ResellerID))
+	case models.TxStatusRefunded:
+		if err := s.settleHold(ctx, record, models.LedgerRelease, availableAccount(record.ResellerID)); err != nil {
+			return err
+		}
+		return s.refund(ctx, record)
+	}
+	return nil
+}
+
+// OnTransactionStatus settles a purchase after a status change. It is meant
+// to be registered with TransactionService.AddStatusListener.
+func (s *WalletService) OnTransactionStatus(ctx context.Context, record models.TransactionRecord, fromStatus string) {
+	if err := s.Settle(ctx, record); err != nil {
+		log.Printf("Failed to settle wallet for transaction %s (%s -> %s): %v", record.ID, fromStatus, record.Status, err)
+	}
+}
+
+// settleHold moves whatever is still held for a transaction to the given account.
+// The caller must hold mutex.
+func (s *WalletService) settleHold(ctx context.Context, record models.TransactionRecord, kind, toAccount string) error {
+	held, err := s.store.ReferenceBalance(ctx, heldAccount(record.ResellerID), record.ID)
+	if err != nil || held <= 0 {
+		return err
+	}
+	_, err = s.post(ctx, record.ResellerID, kind, record.ID, "purchase "+record.Status, models.TxActorSystem,
+		entry(heldAccount(record.ResellerID), -held), entry(toAccount, held))
+	return err
+}
+
+// refund returns captured money of a refunded transaction, limited to the
+// refund amount reported upstream if there is one. The caller must hold mutex.
+func (s *WalletService) refund(ctx context.Context, record models.TransactionRecord) error {
+	exists, err := s.store.JournalExists(ctx, models.LedgerRefund, record.ID)
+	if err != nil || exists {
+		return err
+	}
+	captured, err := s.store.ReferenceBalance(ctx, accountSales, record.ID)
+	if err != nil || captured <= 0 {
+		return err
+	}
+
+	amount := captured
+	if record.RefundAmount > 0 && record.RefundAmount < captured {
+		amount = record.RefundAmount
+	}
+	_, err = s.post(ctx, record.ResellerID, models.LedgerRefund, record.ID, "purchase refunded", models.TxActorSystem,
+		entry(accountSales, -amount), entry(availableAccount(record.ResellerID), amount))
+	return err
+}
+
+func (s *WalletService) post(ctx context.Context, resellerID int64, kind, reference, memo, createdBy string, entries ...models.LedgerEntry) (*models.LedgerJournal, error) {
+	journal := newJournal(resellerID, kind, reference, memo, createdBy, entries)
+	if err := s.store.PostJournal(ctx, journal); err != nil {
+		return nil, err
+	}
+	return journal, nil
+}
+
+// postCovered posts a journal that may not overdraw account. The balance check
+// and the posting are one database transaction, so it holds across instances.
+// It returns ErrInsufficientBalance if the account cannot cover the journal.
+func (s *WalletService) postCovered(ctx context.Context, account string, resellerID int64, kind, reference, memo, createdBy string, entries ...models.LedgerEntry) (*models.LedgerJournal, error) {
+	journal := newJournal(resellerID, kind, reference, memo, createdBy, entries)
+	err := s.store.PostCoveredJournal(ctx, journal, account)
+	if errors.Is(err, database.ErrOverdrawn) {
+		need := 0
+		for _, e := range entries {
+			if e.Account == account {
+				need -= e.Amount
+			}
+		}
+		available, balanceErr := s.store.AccountBalance(ctx, account)
+		if balanceErr != nil {
+			return nil, balanceErr
+		}
+		return nil, fmt.Errorf("%w: need %d, available %d", ErrInsufficientBalance, need, available)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return journal, nil
+}
+
+func newJournal(resellerID int64, kind, reference, memo, createdBy string, entries []models.LedgerEntry) *models.LedgerJournal {
+	return &models.LedgerJournal{
+		ResellerID: resellerID,
+		Kind:       kind,
+		Reference:  reference,
+		Memo:       memo,
+		CreatedBy:  createdBy,
+		CreatedAt:  time.Now(),
+		Entries:    entries,
+	}
+}
+
+func entry(account string, amount int) models.LedgerEntry {
+	return models.LedgerEntry{Account: account, Amount: amount}
+}
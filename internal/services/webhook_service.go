@@ -0,0 +1,451 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/database"
+	"github.com/nabilulilalbab/nadia/internal/models"
+	"github.com/nabilulilalbab/nadia/internal/tracing"
+)
+
+const (
+	// webhookSecretPrefix marks webhook signing secrets.
+	webhookSecretPrefix = "whsec_"
+	// webhookRetryBase is the delay before the first retry; it doubles with
+	// every failed attempt up to webhookRetryMax.
+	webhookRetryBase = time.Minute
+	webhookRetryMax  = 2 * time.Hour
+	// webhookPollInterval is how often the queue is checked for due retries.
+	webhookPollInterval = 5 * time.Second
+	// webhookBatchSize bounds the deliveries attempted per queue run.
+	webhookBatchSize = 50
+	// webhookConcurrency bounds the requests of a queue run in flight at once,
+	// and webhookEndpointConcurrency those to a single endpoint, so a slow
+	// endpoint cannot hold up the deliveries of the others.
+	webhookConcurrency         = 16
+	webhookEndpointConcurrency = 2
+)
+
+var (
+	// ErrWebhookNotFound is returned when a webhook endpoint or delivery ID is unknown.
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrInvalidWebhook is returned when an endpoint URL or event type is not valid.
+	ErrInvalidWebhook = errors.New("invalid webhook")
+)
+
+// WebhookService notifies reseller endpoints about transaction status changes.
+// Every change is queued in the database as one delivery per subscribed endpoint and
+// sent by a background worker, so events survive restarts. Failed deliveries
+// are retried with exponential backoff.
+//
+// Requests carry the headers X-Nadia-Event, X-Nadia-Delivery and
+// X-Nadia-Signature: "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">"
+// keyed with the endpoint secret.
+//
+// Endpoints must resolve to public addresses, unless allowPrivate is set for
+// local development; see newWebhookClient.
+type WebhookService struct {
+	store        database.Store
+	client       *http.Client
+	maxAttempts  int
+	allowPrivate bool
+
+	wakeCh   chan struct{}
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewWebhookService creates a new WebhookService. Requests time out after
+// timeout and a delivery is given up after maxAttempts attempts. allowPrivate
+// permits endpoints on loopback and private networks.
+func NewWebhookService(store database.Store, timeout time.Duration, maxAttempts int, allowPrivate bool) *WebhookService {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = 8
+	}
+	return &WebhookService{
+		store:        store,
+		client:       newWebhookClient(timeout, allowPrivate),
+		maxAttempts:  maxAttempts,
+		allowPrivate: allowPrivate,
+		wakeCh:       make(chan struct{}, 1),
+		stopCh:       make(chan struct{}),
+	}
+}
+
+// Start launches the background delivery loop.
+func (s *WebhookService) Start() {
+	go func() {
+		ctx := context.Background()
+		ticker := time.NewTicker(webhookPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.RunOnce(ctx)
+			case <-s.wakeCh:
+				s.RunOnce(ctx)
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates the background delivery loop.
+func (s *WebhookService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+// wake makes the delivery loop run as soon as possible.
+func (s *WebhookService) wake() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// List returns the endpoints of a reseller, or of all resellers for a negative resellerID.
+func (s *WebhookService) List(ctx context.Context, resellerID int64) ([]models.WebhookEndpoint, error) {
+	return s.store.GetWebhookEndpoints(ctx, resellerID, false)
+}
+
+// Get returns an endpoint by ID.
+func (s *WebhookService) Get(ctx context.Context, id int64) (*models.WebhookEndpoint, error) {
+	e, err := s.store.GetWebhookEndpoint(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrWebhookNotFound
+	}
+	return e, err
+}
+
+// Create registers an endpoint for a reseller and returns it with its signing secret.
+func (s *WebhookService) Create(ctx context.Context, resellerID int64, req models.WebhookEndpointRequest) (*models.WebhookSecretResponse, error) {
+	secret, err := randomHex(24)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	e := &models.WebhookEndpoint{
+		ResellerID: resellerID,
+		Secret:     webhookSecretPrefix + secret,
+		Active:     true,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := s.applyWebhookRequest(ctx, e, req); err != nil {
+		return nil, err
+	}
+	if err := s.store.CreateWebhookEndpoint(ctx, e); err != nil {
+		return nil, err
+	}
+	return &models.WebhookSecretResponse{Endpoint: e, Secret: e.Secret}, nil
+}
+
+// Update changes the URL, events and active flag of an endpoint.
+func (s *WebhookService) Update(ctx context.Context, id int64, req models.WebhookEndpointRequest) (*models.WebhookEndpoint, error) {
+	e, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applyWebhookRequest(ctx, e, req); err != nil {
+		return nil, err
+	}
+	e.UpdatedAt = time.Now()
+	if err := s.store.UpdateWebhookEndpoint(ctx, e); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	if e.Active {
+		// Deliveries queued while the endpoint was inactive are due now.
+		s.wake()
+	}
+	return e, nil
+}
+
+// Delete removes an endpoint and its delivery log.
+func (s *WebhookService) Delete(ctx context.Context, id int64) error {
+	if err := s.store.DeleteWebhookEndpoint(ctx, id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrWebhookNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *WebhookService) applyWebhookRequest(ctx context.Context, e *models.WebhookEndpoint, req models.WebhookEndpointRequest) error {
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+	if !s.allowPrivate {
+		if err := checkWebhookHost(ctx, u.Hostname()); err != nil {
+			return err
+		}
+	}
+
+	events := []string{}
+	for _, event := range req.Events {
+		if !isWebhookEventType(event) {
+			return fmt.Errorf("%w: unknown event type %q, use one of %s", ErrInvalidWebhook, event, strings.Join(models.WebhookEventTypes, ", "))
+		}
+		events = append(events, event)
+	}
+
+	e.URL = u.String()
+	e.Events = events
+	if req.Active != nil {
+		e.Active = *req.Active
+	}
+	return nil
+}
+
+func isWebhookEventType(eventType string) bool {
+	for _, t := range models.WebhookEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Deliveries returns the delivery log of an endpoint, newest first.
+func (s *WebhookService) Deliveries(ctx context.Context, endpointID int64, limit int) ([]models.WebhookDelivery, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	return s.store.GetWebhookDeliveries(ctx, endpointID, limit)
+}
+
+// Delivery returns a delivery with its attempt log.
+func (s *WebhookService) Delivery(ctx context.Context, id int64) (*models.WebhookDelivery, error) {
+	d, err := s.store.GetWebhookDelivery(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrWebhookNotFound
+	}
+	return d, err
+}
+
+// Redeliver queues a delivery again with a fresh set of attempts, whatever
+// its current status.
+func (s *WebhookService) Redeliver(ctx context.Context, id int64) (*models.WebhookDelivery, error) {
+	if err := s.store.ResetWebhookDelivery(ctx, id, time.Now()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	s.wake()
+	return s.Delivery(ctx, id)
+}
+
+// OnTransactionStatus queues the status change of a transaction for every
+// active endpoint of its reseller that subscribes to the event. It is meant
+// to be registered as a transaction StatusListener, so it only writes to the
+// queue and leaves the HTTP requests to the delivery loop.
+func (s *WebhookService) OnTransactionStatus(ctx context.Context, record models.TransactionRecord, fromStatus string) {
+	endpoints, err := s.store.GetWebhookEndpoints(ctx, record.ResellerID, true)
+	if err != nil {
+		log.Printf("Webhooks: failed to load endpoints for transaction %s: %v", record.ID, err)
+		return
+	}
+
+	eventType := models.WebhookEventPrefix + strings.ToLower(record.Status)
+	event := models.WebhookEvent{
+		ID:        record.ID + "." + strings.ToLower(record.Status),
+		Type:      eventType,
+		CreatedAt: time.Now(),
+		Data:      models.TransactionChange{FromStatus: fromStatus, Transaction: record.Redacted()},
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Webhooks: failed to encode event %s: %v", event.ID, err)
+		return
+	}
+
+	queued := false
+	for i := range endpoints {
+		if !endpoints[i].Subscribes(eventType) {
+			continue
+		}
+		d := &models.WebhookDelivery{
+			EndpointID:    endpoints[i].ID,
+			EventID:       event.ID,
+			EventType:     eventType,
+			TransactionID: record.ID,
+			Payload:       string(payload),
+			Status:        models.WebhookDeliveryPending,
+			NextAttemptAt: &event.CreatedAt,
+			CreatedAt:     event.CreatedAt,
+		}
+		if err := s.store.EnqueueWebhookDelivery(ctx, d); err != nil {
+			log.Printf("Webhooks: failed to queue event %s for endpoint %d: %v", event.ID, endpoints[i].ID, err)
+			continue
+		}
+		queued = true
+	}
+	if queued {
+		s.wake()
+	}
+}
+
+// RunOnce attempts every due delivery once.
+func (s *WebhookService) RunOnce(ctx context.Context) {
+	ctx, span := tracing.Start(ctx, "WebhookService.RunOnce")
+	defer span.End()
+
+	due, err := s.store.GetDueWebhookDeliveries(ctx, time.Now(), webhookBatchSize)
+	if err != nil {
+		log.Printf("Webhooks: failed to load due deliveries: %v", err)
+		return
+	}
+
+	// Deliveries are sent concurrently, at most webhookEndpointConcurrency
+	// at a time to each endpoint, oldest first.
+	queues := make(map[int64]chan *models.WebhookDelivery)
+	var order []int64
+	for i := range due {
+		d := &due[i]
+		if _, ok := queues[d.EndpointID]; !ok {
+			queues[d.EndpointID] = make(chan *models.WebhookDelivery, len(due))
+			order = append(order, d.EndpointID)
+		}
+		queues[d.EndpointID] <- d
+	}
+
+	slots := make(chan struct{}, webhookConcurrency)
+	var wg sync.WaitGroup
+	for _, endpointID := range order {
+		queue := queues[endpointID]
+		close(queue)
+		e, err := s.Get(ctx, endpointID)
+		if err != nil {
+			log.Printf("Webhooks: endpoint %d: %v", endpointID, err)
+			continue
+		}
+		for w := 0; w < webhookEndpointConcurrency && w < len(queue); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for d := range queue {
+					select {
+					case <-s.stopCh:
+						return
+					case slots <- struct{}{}:
+					}
+					if err := s.attempt(ctx, e, d); err != nil {
+						log.Printf("Webhooks: delivery %d: %v", d.ID, err)
+					}
+					<-slots
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// attempt sends a delivery once and records the outcome, scheduling a retry
+// or giving up when the request fails.
+func (s *WebhookService) attempt(ctx context.Context, e *models.WebhookEndpoint, d *models.WebhookDelivery) error {
+	start := time.Now()
+	statusCode, sendErr := s.send(ctx, e, d, start)
+
+	d.Attempts++
+	a := &models.WebhookAttempt{
+		DeliveryID: d.ID,
+		Attempt:    d.Attempts,
+		StatusCode: statusCode,
+		DurationMs: time.Since(start).Milliseconds(),
+		CreatedAt:  start,
+	}
+	d.LastStatusCode = statusCode
+	d.LastError = ""
+
+	switch {
+	case sendErr == nil:
+		d.Status = models.WebhookDeliveryDelivered
+		d.NextAttemptAt = nil
+		d.DeliveredAt = &start
+	case d.Attempts >= s.maxAttempts:
+		a.Error, d.LastError = sendErr.Error(), sendErr.Error()
+		d.Status = models.WebhookDeliveryFailed
+		d.NextAttemptAt = nil
+	default:
+		a.Error, d.LastError = sendErr.Error(), sendErr.Error()
+		next := time.Now().Add(webhookBackoff(d.Attempts))
+		d.NextAttemptAt = &next
+	}
+	return s.store.RecordWebhookAttempt(ctx, d, a)
+}
+
+// send posts the delivery payload and returns the response status code. Any
+// non-2xx response is an error.
+func (s *WebhookService) send(ctx context.Context, e *models.WebhookEndpoint, d *models.WebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewBufferString(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Nadia-Webhooks/1.0")
+	req.Header.Set("X-Nadia-Event", d.EventType)
+	req.Header.Set("X-Nadia-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Nadia-Signature", "t="+timestamp+",v1="+SignWebhookPayload(e.Secret, timestamp, d.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhookPayload returns the hex HMAC-SHA256 of "<timestamp>.<payload>"
+// keyed with secret, as sent in the v1 part of X-Nadia-Signature.
+func SignWebhookPayload(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the delay after the given number of failed attempts.
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookRetryBase
+	for i := 1; i < attempts && delay < webhookRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > webhookRetryMax {
+		delay = webhookRetryMax
+	}
+	return delay
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/nabilulilalbab/nadia/internal/models"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	const payload = `{"id":"TXN_1.success"}`
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		payload   string
+		want      string
+	}{
+		{"payload", "whsec_test", "1700000000", payload, "883caee72207051cb035adcb55cadf322e212cfd6fd7ec93a41c364e34e4ba41"},
+		{"other timestamp", "whsec_test", "1700000001", payload, "8acb334ec3954b4f9b1436cca29a10e84d9719bb2cb912fadc3015414bf4cfef"},
+		{"other secret", "whsec_other", "1700000000", payload, "3a66d2d3ba534b7e5de9f9a5de7f7aef71c17cfd8a88a0ff159447daa6665529"},
+		{"empty payload", "whsec_test", "1700000000", "", "5967f3c560522fa40cf2876ebc3c3a08551dd6959aaade3b413460591895bdcc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SignWebhookPayload(tt.secret, tt.timestamp, tt.payload); got != tt.want {
+				t.Errorf("SignWebhookPayload(%q, %q, %q) = %s, want %s", tt.secret, tt.timestamp, tt.payload, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"203.0.113.10", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := isPublicAddress(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("isPublicAddress(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyWebhookRequest(t *testing.T) {
+	tests := []struct {
+		name         string
+		url          string
+		allowPrivate bool
+		wantErr      bool
+	}{
+		{"public address", "https://203.0.113.10/hook", false, false},
+		{"not http", "ftp://203.0.113.10/hook", false, true},
+		{"no host", "https:///hook", false, true},
+		{"loopback", "http://127.0.0.1:8080/hook", false, true},
+		{"metadata service", "http://169.254.169.254/latest/meta-data", false, true},
+		{"private network", "http://10.0.0.5/hook", false, true},
+		{"ipv6 loopback", "http://[::1]/hook", false, true},
+		{"loopback allowed", "http://127.0.0.1:8080/hook", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &WebhookService{allowPrivate: tt.allowPrivate}
+			var e models.WebhookEndpoint
+			err := s.applyWebhookRequest(context.Background(), &e, models.WebhookEndpointRequest{URL: tt.url})
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidWebhook) {
+					t.Errorf("applyWebhookRequest(%s) = %v, want ErrInvalidWebhook", tt.url, err)
+				}
+			} else if err != nil {
+				t.Errorf("applyWebhookRequest(%s) = %v, want no error", tt.url, err)
+			}
+		})
+	}
+}
+
+// TestWebhookClientRefusesPrivateAddresses checks the dial-time check, which
+// catches hosts that resolved to a public address when they were registered.
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	if _, err := newWebhookClient(time.Second, false).Post(server.URL, "application/json", nil); err == nil {
+		t.Error("webhook client connected to a loopback address")
+	}
+	resp, err := newWebhookClient(time.Second, true).Post(server.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("webhook client allowing private networks: %v", err)
+	}
+	resp.Body.Close()
+}
@@ -1,12 +1,17 @@
 package utils
 
 import (
+	"crypto/rand"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// WIB is Western Indonesian Time (UTC+7), the zone upstream uses for cut-off
+// windows and daily limits. Indonesia has no daylight saving time.
+var WIB = time.FixedZone("WIB", 7*60*60)
+
 // NormalizePhoneNumber removes common characters and country codes from a phone number.
 func NormalizePhoneNumber(phone string) string {
 	// Remove spaces and special characters
@@ -44,6 +49,22 @@ func GenerateTransactionID() string {
 	return fmt.Sprintf("TXN_%d", time.Now().UnixNano())
 }
 
+// GenerateQuoteID creates a unique purchase quote ID. Quote IDs lock a price,
+// so they are random rather than derived from the time.
+func GenerateQuoteID() string {
+	return "QUO_" + rand.Text()
+}
+
+// GenerateOTPRequestID creates a unique OTP session ID.
+func GenerateOTPRequestID() string {
+	return fmt.Sprintf("OTP_%d", time.Now().UnixNano())
+}
+
+// GenerateDepositID generates a unique deposit ID.
+func GenerateDepositID() string {
+	return fmt.Sprintf("DEP_%d", time.Now().UnixNano())
+}
+
 // ParseTimestamp converts a string timestamp to an int64.
 func ParseTimestamp(timestampStr string) int64 {
 	if timestamp, err := strconv.ParseInt(timestampStr, 10, 64); err == nil {
@@ -51,3 +72,28 @@ func ParseTimestamp(timestampStr string) int64 {
 	}
 	return 0
 }
+
+// FormatRupiah formats an amount as Indonesian Rupiah, e.g. "Rp. 15.000,00".
+func FormatRupiah(amount int) string {
+	if amount == 0 {
+		return "Rp. 0,00"
+	}
+
+	// Convert to string and add thousand separators
+	str := strconv.Itoa(amount)
+	n := len(str)
+	if n <= 3 {
+		return fmt.Sprintf("Rp. %s,00", str)
+	}
+
+	// Add dots for thousands
+	var result strings.Builder
+	for i, digit := range str {
+		if i > 0 && (n-i)%3 == 0 {
+			result.WriteString(".")
+		}
+		result.WriteRune(digit)
+	}
+
+	return fmt.Sprintf("Rp. %s,00", result.String())
+}